@@ -1,15 +1,29 @@
 package main
 
 import (
+	"context"
+	"crypto/x509"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"time"
 
 	"github.com/manab-pr/nebulo/config"
+	"github.com/manab-pr/nebulo/internal/device_server/agent"
 	"github.com/manab-pr/nebulo/internal/device_server/handlers"
 	"github.com/manab-pr/nebulo/internal/device_server/routes"
+	"github.com/manab-pr/nebulo/internal/device_server/signing"
+	"github.com/manab-pr/nebulo/internal/pki"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	storageDirPerm    = 0750
+	readHeaderTimeout = 30 * time.Second
+)
+
 func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
@@ -22,6 +36,33 @@ func main() {
 		}
 	}()
 
+	// Create the storage directory up front so disk usage can be reported before the first file arrives
+	if err := os.MkdirAll(cfg.Storage.Path, storageDirPerm); err != nil {
+		logger.Sugar().Fatalf("Failed to create storage directory: %v", err)
+	}
+
+	// Requests to the internal API must be signed with this secret; enrolling replaces it
+	secret := signing.NewSecretHolder(cfg.Agent.Secret)
+
+	// With mutual TLS, the device serves the certificate the agent gets for it at enrollment
+	identity := pki.NewIdentity()
+	if cfg.TLS.Enabled && cfg.Agent.ServerURL == "" {
+		logger.Sugar().Fatal("TLS_ENABLED requires NEBULO_SERVER_URL, the device gets its certificate by enrolling")
+	}
+
+	// Enroll with the main server and keep sending heartbeats, when it is configured
+	agentCtx, stopAgent := context.WithCancel(context.Background())
+	defer stopAgent()
+	if cfg.Agent.ServerURL != "" {
+		roots, err := loadRoots(cfg.TLS.CACertFile)
+		if err != nil {
+			logger.Sugar().Fatalf("Failed to load CA certificate: %v", err)
+		}
+		go agent.New(cfg, secret, identity, roots, logger).Run(agentCtx, cfg.Device.HeartbeatInterval)
+	} else {
+		logger.Info("NEBULO_SERVER_URL is not set, the device must be registered by hand")
+	}
+
 	// Set gin mode
 	if cfg.Server.Env == "production" {
 		gin.SetMode(gin.ReleaseMode)
@@ -31,14 +72,50 @@ func main() {
 	router := gin.Default()
 
 	// Initialize handlers
-	deviceHandler := handlers.NewInternalDeviceHandler(cfg.Storage.Path)
+	deviceHandler := handlers.NewInternalDeviceHandler(cfg.Storage.Path, secret)
 
 	// Setup routes
 	routes.SetupInternalRoutes(router, deviceHandler)
 
 	// Start device server
-	logger.Sugar().Infof("Starting device server on port %s", cfg.Device.ServerPort)
-	if err := router.Run(":" + cfg.Device.ServerPort); err != nil {
+	if !cfg.TLS.Enabled {
+		logger.Sugar().Infof("Starting device server on port %s", cfg.Device.ServerPort)
+		if err := router.Run(":" + cfg.Device.ServerPort); err != nil {
+			logger.Sugar().Fatalf("Device server failed to start: %v", err)
+		}
+		return
+	}
+
+	server := &http.Server{
+		Addr:              ":" + cfg.Device.ServerPort,
+		Handler:           router,
+		TLSConfig:         pki.DeviceServerTLSConfig(identity),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	// Handshakes fail until the agent has enrolled and installed the device's certificate
+	logger.Sugar().Infof("Starting device server on port %s with mutual TLS", cfg.Device.ServerPort)
+	if err := server.ListenAndServeTLS("", ""); err != nil {
 		logger.Sugar().Fatalf("Device server failed to start: %v", err)
 	}
 }
+
+// loadRoots reads the CA certificate the main server is checked against; without one, the
+// system's roots are used
+func loadRoots(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	// #nosec G304 - the path comes from the device's own configuration
+	caPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificate found in " + path)
+	}
+	return roots, nil
+}
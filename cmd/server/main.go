@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"log"
 
 	"github.com/manab-pr/nebulo/config"
 	"github.com/manab-pr/nebulo/container"
+	"github.com/manab-pr/nebulo/internal/pki"
 	"github.com/manab-pr/nebulo/internal/server"
 )
 
@@ -32,8 +34,27 @@ func main() {
 		logger.Sugar().Warn("Redis connection failed, continuing without Redis")
 	}
 
+	// Load the CA behind mutual TLS with the device servers and issue the server its certificate
+	var serverIdentity *pki.ServerIdentity
+	if cfg.TLS.Enabled {
+		ca, caErr := pki.LoadOrCreateCA(cfg.TLS.CADir)
+		if caErr != nil {
+			logger.Sugar().Fatalf("Failed to load certificate authority: %v", caErr)
+		}
+
+		serverIdentity, err = pki.NewServerIdentity(ca, cfg.TLS.ServerHosts, cfg.TLS.CertValidity, cfg.TLS.RenewBefore)
+		if err != nil {
+			logger.Sugar().Fatalf("Failed to issue server certificate: %v", err)
+		}
+	}
+
 	// Initialize app container
-	appContainer := container.NewAppContainer(db, redis, cfg, logger)
+	appContainer := container.NewAppContainer(db, redis, cfg, serverIdentity, logger)
+
+	// Run background workers for as long as the server is up
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	appContainer.StartWorkers(workerCtx)
 
 	// Initialize server
 	srv := server.NewServer(cfg, logger, appContainer)
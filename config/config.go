@@ -3,7 +3,9 @@ package config
 import (
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -15,6 +17,28 @@ const (
 	bytesPerMB          = 1024 * 1024
 	bytesPerGB          = 1024 * 1024 * 1024
 	defaultFileSizeMB   = 100
+
+	defaultHeartbeatInterval         = 30 * time.Second
+	defaultMissedHeartbeats          = 3
+	defaultDeviceFailedAfter         = 24 * time.Hour
+	defaultAvailabilityCheckInterval = time.Minute
+	defaultCertValidity              = 30 * 24 * time.Hour
+	defaultCertRenewBefore           = 7 * 24 * time.Hour
+	defaultPairingCodeTTL            = 10 * time.Minute
+	defaultDeviceTokenTTL            = 30 * 24 * time.Hour
+	defaultDeviceDrainInterval       = time.Minute
+	defaultPlacementStrategy         = "most_free_space"
+	defaultReservationTTL            = time.Hour
+	defaultUploadExpiry              = 24 * time.Hour
+	defaultRebalanceThreshold        = 0.1
+	defaultRebalanceBandwidth        = "10MB"
+	defaultRebalanceMaxMoves         = 20
+	defaultTransferWorkers           = 4
+	defaultTransferPollInterval      = 5 * time.Second
+	defaultTransferRetryBackoff      = 30 * time.Second
+	defaultTransferMaxRetries        = 5
+	defaultTransferTimeout           = 5 * time.Minute
+	fallbackRenewalDivisor           = 2 // Renew halfway through when TLS_RENEW_BEFORE does not fit the validity
 )
 
 type Config struct {
@@ -24,6 +48,9 @@ type Config struct {
 	JWT      JWTConfig
 	Storage  StorageConfig
 	Device   DeviceConfig
+	Agent    AgentConfig
+	TLS      TLSConfig
+	Transfer TransferConfig
 }
 
 type ServerConfig struct {
@@ -51,12 +78,77 @@ type JWTConfig struct {
 type StorageConfig struct {
 	Path        string
 	MaxFileSize int64
+	// AvailabilityCheckInterval is how often file placements are checked against device health
+	AvailabilityCheckInterval time.Duration
+	// PlacementStrategy picks devices for uploads when neither the upload nor its user names a strategy
+	PlacementStrategy string
+	// ReservationTTL is how long space reserved on a device for a copy is held when neither its
+	// upload nor its transfer settles it; the device's next heartbeat then drops it
+	ReservationTTL time.Duration
+	// UploadExpiry is how long a resumable upload may go without a chunk before it is discarded
+	// along with the data staged for it
+	UploadExpiry time.Duration
 }
 
 type DeviceConfig struct {
 	ServerPort        string
 	HeartbeatInterval time.Duration
-	TransferTimeout   time.Duration
+	// TransferTimeout is how long a transfer stays leased to whoever writes it without being renewed;
+	// once it runs out, the transfer goes back to the queue
+	TransferTimeout time.Duration
+	// A device is marked offline after missing MissedHeartbeats heartbeats in a row, and failed once it
+	// has been silent for FailedAfter
+	MissedHeartbeats int
+	FailedAfter      time.Duration
+	PairingCodeTTL   time.Duration // How long a pairing code can be redeemed for
+	TokenTTL         time.Duration // How long a device token is good for; agents renew theirs before then
+	DrainInterval    time.Duration // How often files are moved off draining devices, and finished rebalancing moves settled
+	// Rebalancing moves files until every device is within RebalanceThreshold of its total storage
+	// of the average utilization, at most RebalanceMaxMoves at a time, each written at no more than
+	// RebalanceBandwidth bytes per second. It runs every RebalanceSchedule, or only on demand when zero.
+	RebalanceSchedule  time.Duration
+	RebalanceThreshold float64
+	RebalanceBandwidth int64
+	RebalanceMaxMoves  int
+}
+
+// AgentConfig drives the agent inside the device server that enrolls the device with the main
+// server and sends its heartbeats. The agent only runs when ServerURL is set.
+type AgentConfig struct {
+	ServerURL   string // Base URL of the main server, e.g. http://nebulo:8080
+	EnrollToken string // User token the device enrolls with on first start
+	PairingCode string // One-time code the device enrolls with instead, without holding a user token
+	DeviceName  string
+	DeviceType  string
+	AdvertiseIP string // Address the main server reaches this device on; empty uses the address requests come from
+	StateFile   string // Keeps the device ID, token and secret between restarts
+	Secret      string // Shared secret of a device registered by hand; enrolled devices are given theirs
+}
+
+// TLSConfig turns on mutual TLS between the main server and the device servers. The main server
+// keeps its CA in CADir and issues certificates from it; a device's agent trusts CACertFile.
+type TLSConfig struct {
+	Enabled      bool
+	CADir        string
+	ServerHosts  []string      // Names and addresses the main server's certificate is valid for
+	CACertFile   string        // CA certificate a device's agent trusts; empty trusts the system's roots
+	CertValidity time.Duration // Lifetime of the certificates issued to the main server and devices
+	RenewBefore  time.Duration // How long before expiry certificates are rotated
+}
+
+// Transfer queues TransferConfig.Queue selects from
+const (
+	TransferQueueMongo = "mongo" // Polls the transfers collection
+	TransferQueueRedis = "redis" // Keeps the queue in Redis, with Mongo as the history
+)
+
+// TransferConfig drives the dispatcher that writes queued copies of files to their devices
+type TransferConfig struct {
+	Queue        string        // TransferQueueMongo or TransferQueueRedis
+	Workers      int           // How many transfers are written at the same time
+	PollInterval time.Duration // How often the queue is checked for transfers that are due
+	RetryBackoff time.Duration // Wait before the first retry of a failed transfer; it doubles with every retry after that
+	MaxRetries   int           // Retries after the first attempt before a transfer is marked failed
 }
 
 func LoadConfig() *Config {
@@ -66,10 +158,47 @@ func LoadConfig() *Config {
 
 	redisDB, _ := strconv.Atoi(getEnv("REDIS_DB", "0"))
 	jwtExpiresIn, _ := time.ParseDuration(getEnv("JWT_EXPIRES_IN", "24h"))
-	heartbeatInterval, _ := time.ParseDuration(getEnv("HEARTBEAT_INTERVAL", "30s"))
-	transferTimeout, _ := time.ParseDuration(getEnv("TRANSFER_TIMEOUT", "300s"))
+
+	// These drive background tickers, which cannot run without a positive period
+	heartbeatInterval := getPositiveDuration("HEARTBEAT_INTERVAL", defaultHeartbeatInterval)
+	availabilityCheckInterval := getPositiveDuration("AVAILABILITY_CHECK_INTERVAL", defaultAvailabilityCheckInterval)
+	deviceFailedAfter := getPositiveDuration("DEVICE_FAILED_AFTER", defaultDeviceFailedAfter)
+	pairingCodeTTL := getPositiveDuration("PAIRING_CODE_TTL", defaultPairingCodeTTL)
+	deviceTokenTTL := getPositiveDuration("DEVICE_TOKEN_TTL", defaultDeviceTokenTTL)
+	drainInterval := getPositiveDuration("DEVICE_DRAIN_INTERVAL", defaultDeviceDrainInterval)
+	transferTimeout := getPositiveDuration("TRANSFER_TIMEOUT", defaultTransferTimeout)
+	rebalanceSchedule := getPositiveDuration("REBALANCE_SCHEDULE", 0)
+
+	rebalanceThreshold, err := strconv.ParseFloat(getEnv("REBALANCE_THRESHOLD", ""), 64)
+	if err != nil || rebalanceThreshold <= 0 || rebalanceThreshold >= 1 {
+		rebalanceThreshold = defaultRebalanceThreshold
+	}
+
+	missedHeartbeats, err := strconv.Atoi(getEnv("MISSED_HEARTBEATS", strconv.Itoa(defaultMissedHeartbeats)))
+	if err != nil || missedHeartbeats < 1 {
+		missedHeartbeats = defaultMissedHeartbeats
+	}
+
+	transferWorkers := getIntAtLeast("TRANSFER_WORKERS", defaultTransferWorkers, 1)
+	transferMaxRetries := getIntAtLeast("TRANSFER_MAX_RETRIES", defaultTransferMaxRetries, 0)
+	transferQueue := getEnv("TRANSFER_QUEUE", TransferQueueMongo)
+	if transferQueue != TransferQueueRedis {
+		transferQueue = TransferQueueMongo
+	}
 
 	maxFileSize := parseFileSize(getEnv("MAX_FILE_SIZE", "100MB"))
+	storagePath := getEnv("STORAGE_PATH", "./storage")
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "nebulo-device"
+	}
+
+	certValidity := getPositiveDuration("TLS_CERT_VALIDITY", defaultCertValidity)
+	certRenewBefore := getPositiveDuration("TLS_RENEW_BEFORE", defaultCertRenewBefore)
+	if certRenewBefore >= certValidity {
+		certRenewBefore = certValidity / fallbackRenewalDivisor
+	}
 
 	return &Config{
 		Server: ServerConfig{
@@ -91,13 +220,53 @@ func LoadConfig() *Config {
 			ExpiresIn: jwtExpiresIn,
 		},
 		Storage: StorageConfig{
-			Path:        getEnv("STORAGE_PATH", "./storage"),
-			MaxFileSize: maxFileSize,
+			Path:                      storagePath,
+			MaxFileSize:               maxFileSize,
+			AvailabilityCheckInterval: availabilityCheckInterval,
+			PlacementStrategy:         getEnv("PLACEMENT_STRATEGY", defaultPlacementStrategy),
+			ReservationTTL:            getPositiveDuration("STORAGE_RESERVATION_TTL", defaultReservationTTL),
+			UploadExpiry:              getPositiveDuration("UPLOAD_EXPIRY", defaultUploadExpiry),
 		},
 		Device: DeviceConfig{
 			ServerPort:        getEnv("DEVICE_SERVER_PORT", "8081"),
 			HeartbeatInterval: heartbeatInterval,
 			TransferTimeout:   transferTimeout,
+			MissedHeartbeats:  missedHeartbeats,
+			FailedAfter:       deviceFailedAfter,
+			PairingCodeTTL:    pairingCodeTTL,
+			TokenTTL:          deviceTokenTTL,
+			DrainInterval:     drainInterval,
+
+			RebalanceSchedule:  rebalanceSchedule,
+			RebalanceThreshold: rebalanceThreshold,
+			RebalanceBandwidth: parseFileSize(getEnv("REBALANCE_BANDWIDTH", defaultRebalanceBandwidth)),
+			RebalanceMaxMoves:  getIntAtLeast("REBALANCE_MAX_MOVES", defaultRebalanceMaxMoves, 1),
+		},
+		Agent: AgentConfig{
+			ServerURL:   getEnv("NEBULO_SERVER_URL", ""),
+			EnrollToken: getEnv("DEVICE_ENROLL_TOKEN", ""),
+			PairingCode: getEnv("DEVICE_PAIRING_CODE", ""),
+			DeviceName:  getEnv("DEVICE_NAME", hostname),
+			DeviceType:  getEnv("DEVICE_TYPE", "server"),
+			AdvertiseIP: getEnv("DEVICE_ADVERTISE_IP", ""),
+			// Kept in a subdirectory, where the device server's file endpoints cannot reach it
+			StateFile: getEnv("DEVICE_STATE_FILE", filepath.Join(storagePath, ".agent", "device.json")),
+			Secret:    getEnv("DEVICE_SECRET", ""),
+		},
+		TLS: TLSConfig{
+			Enabled:      getEnv("TLS_ENABLED", "false") == "true",
+			CADir:        getEnv("TLS_CA_DIR", filepath.Join(storagePath, "pki")),
+			ServerHosts:  strings.Split(getEnv("TLS_SERVER_HOSTS", "localhost,127.0.0.1,"+hostname), ","),
+			CACertFile:   getEnv("NEBULO_CA_CERT", ""),
+			CertValidity: certValidity,
+			RenewBefore:  certRenewBefore,
+		},
+		Transfer: TransferConfig{
+			Workers:      transferWorkers,
+			PollInterval: getPositiveDuration("TRANSFER_POLL_INTERVAL", defaultTransferPollInterval),
+			RetryBackoff: getPositiveDuration("TRANSFER_RETRY_BACKOFF", defaultTransferRetryBackoff),
+			MaxRetries:   transferMaxRetries,
+			Queue:        transferQueue,
 		},
 	}
 }
@@ -109,6 +278,24 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getPositiveDuration reads a duration such as "30s", falling back when it is unset, invalid or not positive
+func getPositiveDuration(key string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || duration <= 0 {
+		return fallback
+	}
+	return duration
+}
+
+// getIntAtLeast reads an integer, falling back when it is unset, invalid or below minimum
+func getIntAtLeast(key string, fallback, minimum int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value < minimum {
+		return fallback
+	}
+	return value
+}
+
 func parseFileSize(sizeStr string) int64 {
 	// Simple parser for sizes like "100MB", "1GB", etc.
 	if len(sizeStr) < minSizeStringLength {
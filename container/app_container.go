@@ -1,13 +1,28 @@
 package container
 
 import (
+	"context"
+	"crypto/tls"
+	"path/filepath"
+
 	"github.com/manab-pr/nebulo/config"
+	deviceClient "github.com/manab-pr/nebulo/internal/device_server/client"
+	"github.com/manab-pr/nebulo/internal/pki"
 
+	availabilityUseCases "github.com/manab-pr/nebulo/modules/availability/domain/usecases"
+	availabilityHandlers "github.com/manab-pr/nebulo/modules/availability/presentation/http/handlers"
+	deviceMongoRepo "github.com/manab-pr/nebulo/modules/devices/data/mongodb/repository"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	deviceUseCases "github.com/manab-pr/nebulo/modules/devices/domain/usecases"
 	deviceHandlers "github.com/manab-pr/nebulo/modules/devices/presentation/http/handlers"
 	fileHandlers "github.com/manab-pr/nebulo/modules/files/presentation/http/handlers"
 	searchHandlers "github.com/manab-pr/nebulo/modules/search/presentation/http/handlers"
 	storageHandlers "github.com/manab-pr/nebulo/modules/storage/presentation/http/handlers"
+	transferUseCases "github.com/manab-pr/nebulo/modules/transfers/domain/usecases"
 	transferHandlers "github.com/manab-pr/nebulo/modules/transfers/presentation/http/handlers"
+	"github.com/manab-pr/nebulo/modules/uploads/data/staging"
+	uploadUseCases "github.com/manab-pr/nebulo/modules/uploads/domain/usecases"
+	uploadHandlers "github.com/manab-pr/nebulo/modules/uploads/presentation/http/handlers"
 	userHandlers "github.com/manab-pr/nebulo/modules/users/presentation/http/handlers"
 
 	"github.com/go-redis/redis/v8"
@@ -15,6 +30,15 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// uploadStagingDir holds partially received resumable uploads, relative to the storage path
+	uploadStagingDir = "uploads"
+	// queuedContentDir holds files waiting for offline devices while no device has a copy, relative to the storage path
+	queuedContentDir = "queued"
+	// uploadExpirySweeps is how many times idle uploads are looked for within UPLOAD_EXPIRY
+	uploadExpirySweeps = 4
+)
+
 type AppContainer struct {
 	// Configuration and infrastructure
 	Config *config.Config
@@ -22,31 +46,80 @@ type AppContainer struct {
 	DB     *mongo.Database
 	Redis  *redis.Client
 
+	// ServerIdentity is the main server's certificate for mutual TLS, nil while it is off
+	ServerIdentity *pki.ServerIdentity
+
+	// DeviceTokens tells the device token middleware whether a token is still current
+	DeviceTokens *deviceUseCases.CheckDeviceTokenUseCase
+
 	// Handlers
-	DeviceHandler   *deviceHandlers.DeviceHandler
-	FileHandler     *fileHandlers.FileHandler
-	TransferHandler *transferHandlers.TransferHandler
-	StorageHandler  *storageHandlers.StorageHandler
-	SearchHandler   *searchHandlers.SearchHandler
-	UserHandler     *userHandlers.UserHandler
+	DeviceHandler       *deviceHandlers.DeviceHandler
+	FileHandler         *fileHandlers.FileHandler
+	TransferHandler     *transferHandlers.TransferHandler
+	StorageHandler      *storageHandlers.StorageHandler
+	SearchHandler       *searchHandlers.SearchHandler
+	UserHandler         *userHandlers.UserHandler
+	UploadHandler       *uploadHandlers.TusHandler
+	AvailabilityHandler *availabilityHandlers.AvailabilityHandler
+
+	// Background workers
+	HeartbeatSweeper    *deviceUseCases.SweepHeartbeatsUseCase
+	DrainMigrator       *deviceUseCases.MigrateDrainingFilesUseCase
+	Rebalancer          *deviceUseCases.RebalanceDevicesUseCase
+	AvailabilityTracker *availabilityUseCases.TrackAvailabilityUseCase
+	TransferDispatcher  *transferUseCases.DispatchTransfersUseCase
+	TransferReaper      *transferUseCases.ReapTransferLeasesUseCase
+	UploadExpirer       *uploadUseCases.ExpireUploadsUseCase
 }
 
-func NewAppContainer(db *mongo.Database, redis *redis.Client, cfg *config.Config, logger *zap.Logger) *AppContainer {
+func NewAppContainer(
+	db *mongo.Database, redis *redis.Client, cfg *config.Config, serverIdentity *pki.ServerIdentity, logger *zap.Logger,
+) *AppContainer {
 	container := &AppContainer{
-		Config: cfg,
-		Logger: logger,
-		DB:     db,
-		Redis:  redis,
+		Config:         cfg,
+		Logger:         logger,
+		DB:             db,
+		Redis:          redis,
+		ServerIdentity: serverIdentity,
+	}
+
+	// Devices get certificates and are called over mutual TLS only when it is on
+	var authority deviceRepository.CertificateAuthority
+	var deviceTLS *tls.Config
+	if serverIdentity != nil {
+		authority = pki.NewDeviceAuthority(serverIdentity.CA(), cfg.TLS.CertValidity)
+		deviceTLS = pki.DeviceClientTLSConfig(serverIdentity)
 	}
 
 	// Initialize repositories
 	userContainer := NewUserContainer(db)
-	deviceContainer := NewDeviceContainer(db)
+	deviceEvents := NewDeviceEventBus(redis)
+	transferContainer := NewTransferContainer(db, redis, cfg.Transfer, cfg.Device.TransferTimeout, deviceEvents, logger)
+	// Devices are only called over connections checked against the revoked certificates
+	deviceStorage := deviceClient.NewClient(cfg.Device.ServerPort, deviceTLS, deviceMongoRepo.NewMongoCertificateRepository(db))
 	fileContainer := NewFileContainer(db)
-	fileContainer.InitializeWithDeviceRepo(deviceContainer.Repository)
-	transferContainer := NewTransferContainer(db)
+	deviceContainer := NewDeviceContainer(
+		db, deviceEvents, cfg.Device, authority, fileContainer.Repository, deviceStorage, transferContainer.EnqueueUseCase,
+		cfg.Storage.ReservationTTL, logger, transferContainer.EventsUseCase,
+	)
+	queuedContent := staging.NewFilesystemStaging(filepath.Join(cfg.Storage.Path, queuedContentDir))
+	fileContainer.InitializeWithDeviceRepo(
+		deviceContainer.Repository, userContainer.Repository, deviceStorage, queuedContent,
+		transferContainer.EnqueueUseCase, transferContainer.DeletionUseCase,
+		cfg.Storage.MaxFileSize, cfg.Storage.ReservationTTL, cfg.Storage.PlacementStrategy,
+	)
+	transferContainer.InitializeWithFileRepo(
+		fileContainer.Repository, deviceContainer.Repository, deviceStorage, queuedContent, cfg.Storage.ReservationTTL, logger,
+	)
+	uploadContainer := NewUploadContainer(
+		db, filepath.Join(cfg.Storage.Path, uploadStagingDir), cfg.Storage.MaxFileSize, cfg.Storage.UploadExpiry,
+		fileContainer.StoreUseCase, logger,
+	)
 	storageContainer := NewStorageContainer(db, deviceContainer.Repository, fileContainer.Repository)
 	searchContainer := NewSearchContainer(fileContainer.Repository, deviceContainer.Repository)
+	availabilityContainer := NewAvailabilityContainer(fileContainer.Repository, deviceContainer.Repository, deviceStorage, logger)
+
+	container.DeviceTokens = deviceContainer.CheckTokenUseCase
 
 	// Set handlers
 	container.UserHandler = userContainer.UserHandler
@@ -55,6 +128,28 @@ func NewAppContainer(db *mongo.Database, redis *redis.Client, cfg *config.Config
 	container.TransferHandler = transferContainer.Handler
 	container.StorageHandler = storageContainer.Handler
 	container.SearchHandler = searchContainer.Handler
+	container.UploadHandler = uploadContainer.Handler
+	container.AvailabilityHandler = availabilityContainer.Handler
+
+	// Set background workers
+	container.HeartbeatSweeper = deviceContainer.SweepUseCase
+	container.DrainMigrator = deviceContainer.MigrateUseCase
+	container.Rebalancer = deviceContainer.RebalanceUseCase
+	container.AvailabilityTracker = availabilityContainer.TrackUseCase
+	container.TransferDispatcher = transferContainer.DispatchUseCase
+	container.TransferReaper = transferContainer.ReapUseCase
+	container.UploadExpirer = uploadContainer.ExpireUseCase
 
 	return container
 }
+
+// StartWorkers runs the background workers until ctx is cancelled
+func (c *AppContainer) StartWorkers(ctx context.Context) {
+	go c.HeartbeatSweeper.Run(ctx, c.Config.Device.HeartbeatInterval)
+	go c.DrainMigrator.Run(ctx, c.Config.Device.DrainInterval)
+	go c.Rebalancer.Run(ctx, c.Config.Device.DrainInterval)
+	go c.AvailabilityTracker.Run(ctx, c.Config.Storage.AvailabilityCheckInterval)
+	go c.TransferDispatcher.Run(ctx, c.Config.Transfer.PollInterval)
+	go c.TransferReaper.Run(ctx, c.Config.Transfer.PollInterval)
+	go c.UploadExpirer.Run(ctx, c.Config.Storage.UploadExpiry/uploadExpirySweeps)
+}
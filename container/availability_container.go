@@ -0,0 +1,36 @@
+package container
+
+import (
+	availabilityUseCases "github.com/manab-pr/nebulo/modules/availability/domain/usecases"
+	availabilityHandlers "github.com/manab-pr/nebulo/modules/availability/presentation/http/handlers"
+	deviceRepo "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileRepo "github.com/manab-pr/nebulo/modules/files/domain/repository"
+
+	"go.uber.org/zap"
+)
+
+type AvailabilityContainer struct {
+	TrackUseCase  *availabilityUseCases.TrackAvailabilityUseCase
+	AtRiskUseCase *availabilityUseCases.GetAtRiskFilesUseCase
+	Handler       *availabilityHandlers.AvailabilityHandler
+}
+
+func NewAvailabilityContainer(
+	fileRepo fileRepo.FileRepository,
+	deviceRepo deviceRepo.DeviceRepository,
+	deviceStorage fileRepo.DeviceStorageRepository,
+	logger *zap.Logger,
+) *AvailabilityContainer {
+	// Initialize use cases
+	trackUseCase := availabilityUseCases.NewTrackAvailabilityUseCase(fileRepo, deviceRepo, deviceStorage, logger)
+	atRiskUseCase := availabilityUseCases.NewGetAtRiskFilesUseCase(fileRepo, deviceRepo)
+
+	// Initialize handler
+	handler := availabilityHandlers.NewAvailabilityHandler(atRiskUseCase)
+
+	return &AvailabilityContainer{
+		TrackUseCase:  trackUseCase,
+		AtRiskUseCase: atRiskUseCase,
+		Handler:       handler,
+	}
+}
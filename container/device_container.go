@@ -1,47 +1,139 @@
 package container
 
 import (
+	"time"
+
+	"github.com/manab-pr/nebulo/config"
+	deviceMemoryRepo "github.com/manab-pr/nebulo/modules/devices/data/memory/repository"
 	deviceRepo "github.com/manab-pr/nebulo/modules/devices/data/mongodb/repository"
+	deviceRedisRepo "github.com/manab-pr/nebulo/modules/devices/data/redis/repository"
 	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
 	deviceUseCases "github.com/manab-pr/nebulo/modules/devices/domain/usecases"
 	deviceHandlers "github.com/manab-pr/nebulo/modules/devices/presentation/http/handlers"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	transferRepository "github.com/manab-pr/nebulo/modules/transfers/domain/repository"
 
+	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
 )
 
 type DeviceContainer struct {
-	Repository          deviceRepository.DeviceRepository
-	RegisterUseCase     *deviceUseCases.RegisterDeviceUseCase
-	HeartbeatUseCase    *deviceUseCases.HeartbeatUseCase
-	ListDevicesUseCase  *deviceUseCases.ListDevicesUseCase
-	DeleteDeviceUseCase *deviceUseCases.DeleteDeviceUseCase
-	Handler             *deviceHandlers.DeviceHandler
+	Repository              deviceRepository.DeviceRepository
+	CertificateRepository   deviceRepository.CertificateRepository
+	RegisterUseCase         *deviceUseCases.RegisterDeviceUseCase
+	EnrollUseCase           *deviceUseCases.EnrollDeviceUseCase
+	HeartbeatUseCase        *deviceUseCases.HeartbeatUseCase
+	ListDevicesUseCase      *deviceUseCases.ListDevicesUseCase
+	DeleteDeviceUseCase     *deviceUseCases.DeleteDeviceUseCase
+	RenewCertificateUseCase *deviceUseCases.RenewCertificateUseCase
+	CheckTokenUseCase       *deviceUseCases.CheckDeviceTokenUseCase
+	PairingCodeRepository   deviceRepository.PairingCodeRepository
+	SweepUseCase            *deviceUseCases.SweepHeartbeatsUseCase
+	StreamEventsUseCase     *deviceUseCases.StreamDeviceEventsUseCase
+	DrainUseCase            *deviceUseCases.DrainDeviceUseCase
+	MigrateUseCase          *deviceUseCases.MigrateDrainingFilesUseCase
+	RebalanceUseCase        *deviceUseCases.RebalanceDevicesUseCase
+	Handler                 *deviceHandlers.DeviceHandler
+}
+
+// NewDeviceEventBus shares device events between main servers through Redis when it is
+// connected, and keeps them to this server otherwise
+func NewDeviceEventBus(rdb *redis.Client) deviceRepository.DeviceEventBus {
+	if rdb == nil {
+		return deviceMemoryRepo.NewMemoryDeviceEventBus()
+	}
+	return deviceRedisRepo.NewRedisDeviceEventBus(rdb)
+}
+
+// DeviceTransferQueue queues the transfers that move files between a user's devices
+type DeviceTransferQueue interface {
+	transferRepository.TransferEnqueuer
+	transferRepository.BackgroundTransferEnqueuer
 }
 
-func NewDeviceContainer(db *mongo.Database) *DeviceContainer {
-	// Initialize repository
+// NewDeviceContainer wires the devices module. authority is nil while mutual TLS is off. Files on
+// draining devices, and those moved to rebalance storage, are moved through transfers. Devices connecting to their control channel
+// get the events of replayers first.
+func NewDeviceContainer(
+	db *mongo.Database,
+	events deviceRepository.DeviceEventBus,
+	cfg config.DeviceConfig,
+	authority deviceRepository.CertificateAuthority,
+	fileRepo fileRepository.FileRepository,
+	deviceStorage fileRepository.DeviceStorageRepository,
+	transfers DeviceTransferQueue,
+	reservationTTL time.Duration,
+	logger *zap.Logger,
+	replayers ...deviceUseCases.EventReplayer,
+) *DeviceContainer {
+	// Initialize repositories
 	repo := deviceRepo.NewMongoDeviceRepository(db)
+	certificateRepo := deviceRepo.NewMongoCertificateRepository(db)
+	pairingRepo := deviceRepo.NewMongoPairingCodeRepository(db)
 
 	// Initialize use cases
 	registerUseCase := deviceUseCases.NewRegisterDeviceUseCase(repo)
+	enrollUseCase := deviceUseCases.NewEnrollDeviceUseCase(repo, authority, certificateRepo)
 	heartbeatUseCase := deviceUseCases.NewHeartbeatUseCase(repo)
 	listDevicesUseCase := deviceUseCases.NewListDevicesUseCase(repo)
-	deleteDeviceUseCase := deviceUseCases.NewDeleteDeviceUseCase(repo)
+	deleteDeviceUseCase := deviceUseCases.NewDeleteDeviceUseCase(repo, certificateRepo, fileRepo, logger)
+	renewCertificateUseCase := deviceUseCases.NewRenewCertificateUseCase(repo, authority, certificateRepo)
+	checkCertificateUseCase := deviceUseCases.NewCheckDeviceCertificateUseCase(certificateRepo)
+	checkTokenUseCase := deviceUseCases.NewCheckDeviceTokenUseCase(repo)
+	createPairingUseCase := deviceUseCases.NewCreatePairingCodeUseCase(pairingRepo, cfg.PairingCodeTTL)
+	pairUseCase := deviceUseCases.NewPairDeviceUseCase(pairingRepo, enrollUseCase)
+	sweepUseCase := deviceUseCases.NewSweepHeartbeatsUseCase(
+		repo, cfg.HeartbeatInterval*time.Duration(cfg.MissedHeartbeats), cfg.FailedAfter, logger,
+	)
+
+	drainUseCase := deviceUseCases.NewDrainDeviceUseCase(repo, fileRepo)
+	setLabelsUseCase := deviceUseCases.NewSetDeviceLabelsUseCase(repo)
+	migrateUseCase := deviceUseCases.NewMigrateDrainingFilesUseCase(
+		repo, fileRepo, deviceStorage, transfers, reservationTTL, logger,
+	)
+	rebalanceUseCase := deviceUseCases.NewRebalanceDevicesUseCase(
+		repo, fileRepo, deviceStorage, transfers,
+		cfg.RebalanceThreshold, cfg.RebalanceBandwidth, cfg.RebalanceMaxMoves, cfg.RebalanceSchedule, reservationTTL, logger,
+	)
+
+	streamEventsUseCase := deviceUseCases.NewStreamDeviceEventsUseCase(
+		repo, events, cfg.HeartbeatInterval, cfg.TransferTimeout, replayers...,
+	)
 
 	// Initialize handler
 	handler := deviceHandlers.NewDeviceHandler(
 		registerUseCase,
+		enrollUseCase,
 		heartbeatUseCase,
 		listDevicesUseCase,
 		deleteDeviceUseCase,
+		renewCertificateUseCase,
+		checkCertificateUseCase,
+		createPairingUseCase,
+		pairUseCase,
+		streamEventsUseCase,
+		drainUseCase,
+		setLabelsUseCase,
+		rebalanceUseCase,
 	)
 
 	return &DeviceContainer{
-		Repository:          repo,
-		RegisterUseCase:     registerUseCase,
-		HeartbeatUseCase:    heartbeatUseCase,
-		ListDevicesUseCase:  listDevicesUseCase,
-		DeleteDeviceUseCase: deleteDeviceUseCase,
-		Handler:             handler,
+		Repository:              repo,
+		CertificateRepository:   certificateRepo,
+		RegisterUseCase:         registerUseCase,
+		EnrollUseCase:           enrollUseCase,
+		HeartbeatUseCase:        heartbeatUseCase,
+		ListDevicesUseCase:      listDevicesUseCase,
+		DeleteDeviceUseCase:     deleteDeviceUseCase,
+		RenewCertificateUseCase: renewCertificateUseCase,
+		CheckTokenUseCase:       checkTokenUseCase,
+		PairingCodeRepository:   pairingRepo,
+		SweepUseCase:            sweepUseCase,
+		StreamEventsUseCase:     streamEventsUseCase,
+		DrainUseCase:            drainUseCase,
+		MigrateUseCase:          migrateUseCase,
+		RebalanceUseCase:        rebalanceUseCase,
+		Handler:                 handler,
 	}
 }
@@ -1,21 +1,27 @@
 package container
 
 import (
+	"time"
+
+	deviceClient "github.com/manab-pr/nebulo/internal/device_server/client"
 	deviceRepo "github.com/manab-pr/nebulo/modules/devices/domain/repository"
 	fileRepo "github.com/manab-pr/nebulo/modules/files/data/mongodb/repository"
 	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
 	fileUseCases "github.com/manab-pr/nebulo/modules/files/domain/usecases"
 	fileHandlers "github.com/manab-pr/nebulo/modules/files/presentation/http/handlers"
+	transferRepository "github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+	userRepository "github.com/manab-pr/nebulo/modules/users/domain/repository"
 
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type FileContainer struct {
-	Repository    fileRepository.FileRepository
-	StoreUseCase  *fileUseCases.StoreFileUseCase
-	GetUseCase    *fileUseCases.GetFileUseCase
-	DeleteUseCase *fileUseCases.DeleteFileUseCase
-	Handler       *fileHandlers.FileHandler
+	Repository      fileRepository.FileRepository
+	StoreUseCase    *fileUseCases.StoreFileUseCase
+	GetUseCase      *fileUseCases.GetFileUseCase
+	DownloadUseCase *fileUseCases.DownloadFileUseCase
+	DeleteUseCase   *fileUseCases.DeleteFileUseCase
+	Handler         *fileHandlers.FileHandler
 }
 
 func NewFileContainer(db *mongo.Database) *FileContainer {
@@ -29,21 +35,37 @@ func NewFileContainer(db *mongo.Database) *FileContainer {
 	}
 }
 
-func (c *FileContainer) InitializeWithDeviceRepo(deviceRepo deviceRepo.DeviceRepository) {
+func (c *FileContainer) InitializeWithDeviceRepo(
+	deviceRepo deviceRepo.DeviceRepository,
+	userRepo userRepository.UserRepository,
+	deviceClient *deviceClient.Client,
+	staging fileRepository.ContentStagingRepository,
+	transfers transferRepository.TransferEnqueuer,
+	deletions transferRepository.DeletionEnqueuer,
+	maxFileSize int64,
+	reservationTTL time.Duration,
+	defaultPlacement string,
+) {
 	// Initialize use cases with dependencies
-	storeUseCase := fileUseCases.NewStoreFileUseCase(c.Repository, deviceRepo)
+	storeUseCase := fileUseCases.NewStoreFileUseCase(
+		c.Repository, deviceRepo, userRepo, deviceClient, staging, transfers, maxFileSize, reservationTTL,
+		fileUseCases.NewPlacementStrategies(), defaultPlacement,
+	)
 	getUseCase := fileUseCases.NewGetFileUseCase(c.Repository)
-	deleteUseCase := fileUseCases.NewDeleteFileUseCase(c.Repository)
+	downloadUseCase := fileUseCases.NewDownloadFileUseCase(c.Repository, deviceRepo, deviceClient)
+	deleteUseCase := fileUseCases.NewDeleteFileUseCase(c.Repository, deviceRepo, deviceClient, staging, deletions)
 
 	// Initialize handler
 	handler := fileHandlers.NewFileHandler(
 		storeUseCase,
 		getUseCase,
+		downloadUseCase,
 		deleteUseCase,
 	)
 
 	c.StoreUseCase = storeUseCase
 	c.GetUseCase = getUseCase
+	c.DownloadUseCase = downloadUseCase
 	c.DeleteUseCase = deleteUseCase
 	c.Handler = handler
 }
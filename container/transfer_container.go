@@ -1,43 +1,125 @@
 package container
 
 import (
+	"time"
+
+	"github.com/manab-pr/nebulo/config"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
 	transferRepo "github.com/manab-pr/nebulo/modules/transfers/data/mongodb/repository"
+	transferRedisRepo "github.com/manab-pr/nebulo/modules/transfers/data/redis/repository"
 	transferRepository "github.com/manab-pr/nebulo/modules/transfers/domain/repository"
 	transferUseCases "github.com/manab-pr/nebulo/modules/transfers/domain/usecases"
 	transferHandlers "github.com/manab-pr/nebulo/modules/transfers/presentation/http/handlers"
 
+	"github.com/go-redis/redis/v8"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
 )
 
 type TransferContainer struct {
 	Repository        transferRepository.TransferRepository
 	GetPendingUseCase *transferUseCases.GetPendingTransfersUseCase
+	ClaimUseCase      *transferUseCases.ClaimTransfersUseCase
+	GetUseCase        *transferUseCases.GetTransferUseCase
+	ProgressUseCase   *transferUseCases.ReportTransferProgressUseCase
 	CompleteUseCase   *transferUseCases.CompleteTransferUseCase
 	CancelUseCase     *transferUseCases.CancelTransferUseCase
+	ListUseCase       *transferUseCases.ListTransfersUseCase
+	RequeueUseCase    *transferUseCases.RequeueTransfersUseCase
+	DiscardUseCase    *transferUseCases.DiscardTransfersUseCase
+	EnqueueUseCase    *transferUseCases.EnqueueTransferUseCase
+	DeletionUseCase   *transferUseCases.EnqueueDeletionUseCase
+	EventsUseCase     *transferUseCases.TransferEventsUseCase
+	DispatchUseCase   *transferUseCases.DispatchTransfersUseCase
+	ReapUseCase       *transferUseCases.ReapTransferLeasesUseCase
 	Handler           *transferHandlers.TransferHandler
+	config            config.TransferConfig
+	lease             time.Duration
+	events            deviceRepository.DeviceEventBus
 }
 
-func NewTransferContainer(db *mongo.Database) *TransferContainer {
+// NewTransferContainer sets up the transfer queue, in Redis when cfg selects it and rdb is
+// connected. lease is how long a claimed transfer is held without being renewed. Devices are told
+// about the transfers queued for them through events.
+func NewTransferContainer(
+	db *mongo.Database,
+	rdb *redis.Client,
+	cfg config.TransferConfig,
+	lease time.Duration,
+	events deviceRepository.DeviceEventBus,
+	logger *zap.Logger,
+) *TransferContainer {
 	// Initialize repository
-	repo := transferRepo.NewMongoTransferRepository(db)
+	history := transferRepo.NewMongoTransferRepository(db)
+	var repo transferRepository.TransferRepository = history
+	if cfg.Queue == config.TransferQueueRedis {
+		if rdb != nil {
+			repo = transferRedisRepo.NewRedisTransferRepository(history, rdb)
+		} else {
+			logger.Warn("Redis is unavailable, keeping the transfer queue in MongoDB")
+		}
+	}
 
 	// Initialize use cases
 	getPendingUseCase := transferUseCases.NewGetPendingTransfersUseCase(repo)
-	completeUseCase := transferUseCases.NewCompleteTransferUseCase(repo)
-	cancelUseCase := transferUseCases.NewCancelTransferUseCase(repo)
-
-	// Initialize handler
-	handler := transferHandlers.NewTransferHandler(
-		getPendingUseCase,
-		completeUseCase,
-		cancelUseCase,
-	)
+	claimUseCase := transferUseCases.NewClaimTransfersUseCase(repo, lease)
+	getUseCase := transferUseCases.NewGetTransferUseCase(repo)
+	progressUseCase := transferUseCases.NewReportTransferProgressUseCase(repo, lease)
+	listUseCase := transferUseCases.NewListTransfersUseCase(repo)
+	discardUseCase := transferUseCases.NewDiscardTransfersUseCase(repo)
+	enqueueUseCase := transferUseCases.NewEnqueueTransferUseCase(repo, events, cfg.MaxRetries)
+	deletionUseCase := transferUseCases.NewEnqueueDeletionUseCase(repo, events, cfg.MaxRetries)
+	eventsUseCase := transferUseCases.NewTransferEventsUseCase(repo)
 
 	return &TransferContainer{
 		Repository:        repo,
 		GetPendingUseCase: getPendingUseCase,
-		CompleteUseCase:   completeUseCase,
-		CancelUseCase:     cancelUseCase,
-		Handler:           handler,
+		ClaimUseCase:      claimUseCase,
+		GetUseCase:        getUseCase,
+		ProgressUseCase:   progressUseCase,
+		ListUseCase:       listUseCase,
+		DiscardUseCase:    discardUseCase,
+		EnqueueUseCase:    enqueueUseCase,
+		DeletionUseCase:   deletionUseCase,
+		EventsUseCase:     eventsUseCase,
+		config:            cfg,
+		lease:             lease,
+		events:            events,
 	}
 }
+
+// InitializeWithFileRepo sets up the worker that writes queued transfers to the devices, and the
+// handler, once the modules they read files and devices from exist
+func (c *TransferContainer) InitializeWithFileRepo(
+	fileRepo fileRepository.FileRepository,
+	deviceRepo deviceRepository.DeviceRepository,
+	deviceStorage fileRepository.DeviceStorageRepository,
+	staging fileRepository.ContentStagingRepository,
+	reservationTTL time.Duration,
+	logger *zap.Logger,
+) {
+	c.DispatchUseCase = transferUseCases.NewDispatchTransfersUseCase(
+		c.Repository, fileRepo, deviceRepo, deviceStorage, staging, c.events, c.config.Workers, c.config.RetryBackoff, c.lease,
+		reservationTTL, logger,
+	)
+	c.CompleteUseCase = transferUseCases.NewCompleteTransferUseCase(
+		c.Repository, fileRepo, deviceRepo, deviceStorage, staging, logger,
+	)
+	c.CancelUseCase = transferUseCases.NewCancelTransferUseCase(c.Repository, fileRepo, deviceRepo, staging, logger)
+	c.ReapUseCase = transferUseCases.NewReapTransferLeasesUseCase(c.Repository, logger)
+	c.RequeueUseCase = transferUseCases.NewRequeueTransfersUseCase(c.Repository, fileRepo, deviceRepo, c.events)
+
+	// Initialize handler
+	c.Handler = transferHandlers.NewTransferHandler(
+		c.GetPendingUseCase,
+		c.ClaimUseCase,
+		c.GetUseCase,
+		c.ListUseCase,
+		c.ProgressUseCase,
+		c.CompleteUseCase,
+		c.CancelUseCase,
+		c.RequeueUseCase,
+		c.DiscardUseCase,
+	)
+}
@@ -0,0 +1,68 @@
+package container
+
+import (
+	"time"
+
+	fileUseCases "github.com/manab-pr/nebulo/modules/files/domain/usecases"
+	uploadRepo "github.com/manab-pr/nebulo/modules/uploads/data/mongodb/repository"
+	"github.com/manab-pr/nebulo/modules/uploads/data/staging"
+	uploadRepository "github.com/manab-pr/nebulo/modules/uploads/domain/repository"
+	uploadUseCases "github.com/manab-pr/nebulo/modules/uploads/domain/usecases"
+	uploadHandlers "github.com/manab-pr/nebulo/modules/uploads/presentation/http/handlers"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+type UploadContainer struct {
+	Repository       uploadRepository.UploadRepository
+	Staging          uploadRepository.StagingRepository
+	CreateUseCase    *uploadUseCases.CreateUploadUseCase
+	GetUseCase       *uploadUseCases.GetUploadUseCase
+	AppendUseCase    *uploadUseCases.AppendUploadUseCase
+	TerminateUseCase *uploadUseCases.TerminateUploadUseCase
+	ExpireUseCase    *uploadUseCases.ExpireUploadsUseCase
+	Handler          *uploadHandlers.TusHandler
+}
+
+func NewUploadContainer(
+	db *mongo.Database,
+	stagingDir string,
+	maxFileSize int64,
+	expiry time.Duration,
+	storeUseCase *fileUseCases.StoreFileUseCase,
+	logger *zap.Logger,
+) *UploadContainer {
+	// Initialize repositories
+	repo := uploadRepo.NewMongoUploadRepository(db)
+	stagingRepo := staging.NewFilesystemStaging(stagingDir)
+
+	// Initialize use cases; finished uploads are stored through the regular file upload path
+	createUseCase := uploadUseCases.NewCreateUploadUseCase(repo, stagingRepo, maxFileSize)
+	getUseCase := uploadUseCases.NewGetUploadUseCase(repo)
+	locks := uploadUseCases.NewUploadLocks()
+	appendUseCase := uploadUseCases.NewAppendUploadUseCase(repo, stagingRepo, storeUseCase, locks)
+	terminateUseCase := uploadUseCases.NewTerminateUploadUseCase(repo, stagingRepo, locks)
+	expireUseCase := uploadUseCases.NewExpireUploadsUseCase(repo, stagingRepo, locks, expiry, logger)
+
+	// Initialize handler
+	handler := uploadHandlers.NewTusHandler(
+		createUseCase,
+		getUseCase,
+		appendUseCase,
+		terminateUseCase,
+		maxFileSize,
+		expiry,
+	)
+
+	return &UploadContainer{
+		Repository:       repo,
+		Staging:          stagingRepo,
+		CreateUseCase:    createUseCase,
+		GetUseCase:       getUseCase,
+		AppendUseCase:    appendUseCase,
+		TerminateUseCase: terminateUseCase,
+		ExpireUseCase:    expireUseCase,
+		Handler:          handler,
+	}
+}
@@ -4,27 +4,31 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	userRepo "github.com/manab-pr/nebulo/modules/users/data/mongodb/repository"
+	userRepository "github.com/manab-pr/nebulo/modules/users/domain/repository"
 	"github.com/manab-pr/nebulo/modules/users/domain/usecases"
 	"github.com/manab-pr/nebulo/modules/users/presentation/http/handlers"
 )
 
 type UserContainer struct {
+	Repository            userRepository.UserRepository
 	RegisterUseCase       *usecases.RegisterUserUseCase
 	LoginUseCase          *usecases.LoginUserUseCase
 	VerifyOTPUseCase      *usecases.VerifyOTPUseCase
 	GetUserProfileUseCase *usecases.GetUserProfileUseCase
+	UpdateSettingsUseCase *usecases.UpdateUserSettingsUseCase
 	UserHandler           *handlers.UserHandler
 }
 
 func NewUserContainer(db *mongo.Database) *UserContainer {
 	// Repository
-	userRepository := userRepo.NewUserRepository(db)
+	repository := userRepo.NewUserRepository(db)
 
 	// Use cases
-	registerUseCase := usecases.NewRegisterUserUseCase(userRepository)
-	loginUseCase := usecases.NewLoginUserUseCase(userRepository)
-	verifyOTPUseCase := usecases.NewVerifyOTPUseCase(userRepository)
-	getUserProfileUseCase := usecases.NewGetUserProfileUseCase(userRepository)
+	registerUseCase := usecases.NewRegisterUserUseCase(repository)
+	loginUseCase := usecases.NewLoginUserUseCase(repository)
+	verifyOTPUseCase := usecases.NewVerifyOTPUseCase(repository)
+	getUserProfileUseCase := usecases.NewGetUserProfileUseCase(repository)
+	updateSettingsUseCase := usecases.NewUpdateUserSettingsUseCase(repository)
 
 	// Handler
 	userHandler := handlers.NewUserHandler(
@@ -32,13 +36,16 @@ func NewUserContainer(db *mongo.Database) *UserContainer {
 		loginUseCase,
 		verifyOTPUseCase,
 		getUserProfileUseCase,
+		updateSettingsUseCase,
 	)
 
 	return &UserContainer{
+		Repository:            repository,
 		RegisterUseCase:       registerUseCase,
 		LoginUseCase:          loginUseCase,
 		VerifyOTPUseCase:      verifyOTPUseCase,
 		GetUserProfileUseCase: getUserProfileUseCase,
+		UpdateSettingsUseCase: updateSettingsUseCase,
 		UserHandler:           userHandler,
 	}
 }
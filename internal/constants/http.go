@@ -9,19 +9,27 @@ const (
 
 	// User profile routes
 	ProfileRoute                    = "/profile"
+	SettingsRoute                   = "/settings"
 )
 
 const (
 	TransferBaseRoute               = "/transfers"
 	GetPendingTransfersRoute        = "/pending/:deviceId"
+	ClaimTransfersRoute             = "/pending/:deviceId/claim"
 	CompleteTransferRoute           = "/complete"
 	CancelTransferRoute             = "/:id"
+	GetTransferRoute                = "/:id"
+	TransferProgressRoute           = "/:id/progress"
+	GetAllTransfersRoute            = ""
+	RequeueTransfersRoute           = "/requeue"
+	DiscardTransfersRoute           = "/discard"
 )
 
 const (
 	FileBaseRoute                   = "/files"
 	StoreFileRoute                  = "/store"
 	GetFileRoute                    = "/:fileId"
+	GetFileContentRoute             = "/:fileId/content"
 	GetAllFilesRoute                = ""
 	DeleteFileRoute                 = "/:fileId"
 )
@@ -29,9 +37,18 @@ const (
 const (
 	DeviceBaseRoute                 = "/devices"
 	RegisterDeviceRoute             = "/register"
+	EnrollDeviceRoute               = "/enroll"
+	CreatePairingCodeRoute          = "/pairing"
+	RedeemPairingCodeRoute          = "/pairing/redeem"
+	RenewCertificateRoute           = "/:id/certificate"
+	RenewDeviceTokenRoute           = "/:id/token"
 	HeartbeatRoute                  = "/heartbeat"
 	GetDevicesRoute                 = ""
 	DeleteDeviceRoute               = "/:id"
+	DeviceEventsRoute               = "/:id/events"
+	DrainDeviceRoute                = "/:id/drain"
+	DeviceLabelsRoute               = "/:id/labels"
+	RebalanceDevicesRoute           = "/rebalance"
 )
 
 const (
@@ -46,3 +63,14 @@ const (
 	GetFileLocationRoute            = "/location/:fileId"
 )
 
+
+const (
+	UploadBaseRoute                 = "/uploads"
+	CreateUploadRoute               = ""
+	UploadRoute                     = "/:id"
+)
+
+const (
+	AvailabilityBaseRoute           = "/files"
+	GetAtRiskFilesRoute             = "/at-risk"
+)
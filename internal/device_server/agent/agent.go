@@ -0,0 +1,428 @@
+// Package agent runs inside the device server and connects it to the main server: it enrolls the
+// device on first start, with its user's token or a one-time pairing code, remembers the device
+// ID, token and secret it gets back, and keeps the device online with heartbeats carrying the real
+// disk numbers. It renews the device token halfway through its lifetime. With mutual TLS, it also gets the device its certificate at enrollment and rotates
+// it before it expires. Once enrolled, it stays connected to the main server's control channel
+// for the settings and events the server pushes, and carries out the deletions queued for the
+// device, polling for them while the channel is down.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/manab-pr/nebulo/config"
+	"github.com/manab-pr/nebulo/internal/device_server/disk"
+	"github.com/manab-pr/nebulo/internal/device_server/signing"
+	"github.com/manab-pr/nebulo/internal/pki"
+
+	"go.uber.org/zap"
+)
+
+const (
+	enrollEndpoint      = "/api/v1/devices/enroll"
+	pairEndpoint        = "/api/v1/devices/pairing/redeem"
+	heartbeatEndpoint   = "/api/v1/devices/heartbeat"
+	certificateEndpoint = "/api/v1/devices/%s/certificate"
+	tokenEndpoint       = "/api/v1/devices/%s/token"
+	requestTimeout      = 30 * time.Second
+	errorBodyLimit      = 4096
+)
+
+// errForgotten means the server no longer accepts the device's identity, for instance because the
+// device was deleted, so the agent has to enroll again
+var errForgotten = errors.New("server no longer knows this device")
+
+// issuedCertificate is how the main server returns a certificate
+type issuedCertificate struct {
+	Certificate   string `json:"certificate"`
+	CACertificate string `json:"ca_certificate"`
+}
+
+type Agent struct {
+	config      config.AgentConfig
+	tls         config.TLSConfig
+	storagePath string
+	httpClient  *http.Client
+	// streamClient has no overall timeout, as the control channel stays open
+	streamClient *http.Client
+	logger       *zap.Logger
+	state        *state
+	secret       *signing.Secret // Shared with the internal API handlers
+	identity     *pki.Identity   // Shared with the device server's TLS listener
+	control      *control
+	intervals    chan time.Duration // Heartbeat intervals set by the main server
+	deletions    chan struct{}      // Signalled when the main server queues a deletion for the device
+}
+
+// New creates the agent. roots are the CAs the main server's certificate is checked against; nil
+// uses the system's.
+func New(
+	cfg *config.Config, secret *signing.Secret, identity *pki.Identity, roots *x509.CertPool, logger *zap.Logger,
+) *Agent {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = pki.AgentTLSConfig(identity, roots)
+
+	return &Agent{
+		config:       cfg.Agent,
+		tls:          cfg.TLS,
+		storagePath:  cfg.Storage.Path,
+		httpClient:   &http.Client{Timeout: requestTimeout, Transport: transport},
+		streamClient: &http.Client{Transport: transport},
+		logger:       logger,
+		secret:       secret,
+		identity:     identity,
+		intervals:    make(chan time.Duration, 1),
+		deletions:    make(chan struct{}, 1),
+	}
+}
+
+// Run sends a heartbeat every interval, or the interval the main server sets, until ctx is
+// cancelled, enrolling the device first if needed. Deletions are carried out as the main server
+// announces them or, while the control channel is down, with every heartbeat.
+func (a *Agent) Run(ctx context.Context, interval time.Duration) {
+	a.restoreState()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := a.beat(ctx); err != nil && ctx.Err() == nil {
+			a.logger.Warn("Device heartbeat failed", zap.Error(err))
+		}
+		if !a.listening() {
+			a.carryOutDeletions(ctx)
+		}
+		a.keepListening(ctx)
+
+		for waiting := true; waiting; {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				waiting = false
+			case <-a.deletions:
+				a.carryOutDeletions(ctx)
+			case set := <-a.intervals:
+				if set != interval {
+					a.logger.Info("Heartbeat interval set by the main server", zap.Duration("interval", set))
+					interval = set
+					ticker.Reset(interval)
+				}
+			}
+		}
+	}
+}
+
+// restoreState picks up the identity saved by an earlier run against the same server
+func (a *Agent) restoreState() {
+	saved, err := loadState(a.config.StateFile)
+	if err != nil {
+		a.logger.Warn("Ignoring unreadable device state, enrolling again", zap.Error(err))
+		return
+	}
+
+	if saved == nil || saved.ServerURL != a.config.ServerURL {
+		return
+	}
+	if saved.Secret == "" {
+		a.logger.Info("Saved device state has no secret, enrolling again")
+		return
+	}
+
+	a.state = saved
+	a.secret.Set(saved.Secret)
+
+	// A missing or unusable certificate is replaced by the next rotation
+	if a.tls.Enabled && saved.Certificate != "" {
+		setErr := a.identity.Set([]byte(saved.Certificate), []byte(saved.PrivateKey), []byte(saved.CACertificate))
+		if setErr != nil {
+			a.logger.Warn("Ignoring unusable saved certificate", zap.Error(setErr))
+		}
+	}
+}
+
+func (a *Agent) beat(ctx context.Context) error {
+	usage, err := disk.UsageOf(a.storagePath)
+	if err != nil {
+		return fmt.Errorf("reading disk usage: %w", err)
+	}
+
+	if a.state == nil {
+		if err = a.enroll(ctx, usage); err != nil {
+			return err
+		}
+	}
+
+	if a.tls.Enabled && a.identity.NeedsRenewal(a.tls.RenewBefore) {
+		// The current certificate, if still valid, keeps working while this is retried
+		if renewErr := a.renewCertificate(ctx); renewErr != nil {
+			a.logger.Warn("Certificate rotation failed", zap.Error(renewErr))
+		}
+	}
+
+	if a.state.tokenNeedsRenewal(time.Now()) {
+		// The current token keeps working until it expires, after which the device enrolls again
+		if renewErr := a.renewToken(ctx); renewErr != nil {
+			a.logger.Warn("Device token renewal failed", zap.Error(renewErr))
+		}
+	}
+
+	err = a.heartbeat(ctx, usage)
+	if !errors.Is(err, errForgotten) {
+		return err
+	}
+
+	a.logger.Info("Server no longer knows this device, enrolling again", zap.String("device_id", a.state.DeviceID))
+	a.state = nil
+	if err = a.enroll(ctx, usage); err != nil {
+		return err
+	}
+	return a.heartbeat(ctx, usage)
+}
+
+func (a *Agent) enroll(ctx context.Context, usage disk.Usage) error {
+	endpoint, token := enrollEndpoint, a.config.EnrollToken
+	if token == "" {
+		if a.config.PairingCode == "" {
+			return errors.New("device is not enrolled and neither DEVICE_ENROLL_TOKEN nor DEVICE_PAIRING_CODE is set")
+		}
+		endpoint, token = pairEndpoint, ""
+	}
+
+	request := map[string]any{
+		"name":              a.config.DeviceName,
+		"ip_address":        a.config.AdvertiseIP,
+		"type":              a.config.DeviceType,
+		"total_storage":     usage.Total,
+		"available_storage": usage.Available,
+		"used_storage":      usage.Used,
+	}
+
+	if endpoint == pairEndpoint {
+		request["code"] = a.config.PairingCode
+	}
+
+	var keyPEM []byte
+	if a.tls.Enabled {
+		csr, newKeyPEM, err := newCertificateRequest(a.config.DeviceName)
+		if err != nil {
+			return err
+		}
+		request["csr"] = string(csr)
+		keyPEM = newKeyPEM
+	}
+
+	var response struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+		DeviceToken          string             `json:"device_token"`
+		DeviceTokenExpiresAt time.Time          `json:"device_token_expires_at"`
+		DeviceSecret         string             `json:"device_secret"`
+		Certificate          *issuedCertificate `json:"certificate"`
+	}
+
+	if err := a.post(ctx, endpoint, token, request, &response); err != nil {
+		// Pairing codes work once, so a device that has to enroll again needs a new one
+		var respErr *responseError
+		if endpoint == pairEndpoint && errors.As(err, &respErr) && respErr.status == http.StatusUnauthorized {
+			return fmt.Errorf("pairing device: %w; request a new pairing code", err)
+		}
+		return fmt.Errorf("enrolling device: %w", err)
+	}
+
+	now := time.Now()
+	a.state = &state{
+		ServerURL:            a.config.ServerURL,
+		DeviceID:             response.Data.ID,
+		DeviceToken:          response.DeviceToken,
+		DeviceTokenIssuedAt:  now,
+		DeviceTokenExpiresAt: response.DeviceTokenExpiresAt,
+		Secret:               response.DeviceSecret,
+		EnrolledAt:           now,
+	}
+	a.secret.Set(a.state.Secret)
+	a.logger.Info("Device enrolled", zap.String("device_id", a.state.DeviceID))
+
+	if a.tls.Enabled {
+		if response.Certificate == nil {
+			return errors.New("server issued no certificate; is TLS enabled on the main server?")
+		}
+		return a.installCertificate(response.Certificate, keyPEM)
+	}
+
+	a.saveState()
+	return nil
+}
+
+// renewCertificate rotates the device's certificate onto a new key
+func (a *Agent) renewCertificate(ctx context.Context) error {
+	csr, keyPEM, err := newCertificateRequest(a.config.DeviceName)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Data *issuedCertificate `json:"data"`
+	}
+
+	endpoint := fmt.Sprintf(certificateEndpoint, a.state.DeviceID)
+	if err = a.post(ctx, endpoint, a.state.DeviceToken, map[string]any{"csr": string(csr)}, &response); err != nil {
+		return err
+	}
+	if response.Data == nil {
+		return errors.New("server returned no certificate")
+	}
+
+	a.logger.Info("Device certificate rotated", zap.String("device_id", a.state.DeviceID))
+	return a.installCertificate(response.Data, keyPEM)
+}
+
+// renewToken trades the device token for a fresh one before it expires
+func (a *Agent) renewToken(ctx context.Context) error {
+	var response struct {
+		Data struct {
+			DeviceToken string    `json:"device_token"`
+			ExpiresAt   time.Time `json:"expires_at"`
+		} `json:"data"`
+	}
+
+	endpoint := fmt.Sprintf(tokenEndpoint, a.state.DeviceID)
+	if err := a.post(ctx, endpoint, a.state.DeviceToken, map[string]any{}, &response); err != nil {
+		return err
+	}
+	if response.Data.DeviceToken == "" {
+		return errors.New("server returned no device token")
+	}
+
+	a.state.DeviceToken = response.Data.DeviceToken
+	a.state.DeviceTokenIssuedAt = time.Now()
+	a.state.DeviceTokenExpiresAt = response.Data.ExpiresAt
+	a.saveState()
+
+	a.logger.Info("Device token renewed", zap.String("device_id", a.state.DeviceID))
+	return nil
+}
+
+// installCertificate starts serving and presenting the certificate and remembers it
+func (a *Agent) installCertificate(issued *issuedCertificate, keyPEM []byte) error {
+	err := a.identity.Set([]byte(issued.Certificate), keyPEM, []byte(issued.CACertificate))
+	if err != nil {
+		return fmt.Errorf("installing certificate: %w", err)
+	}
+
+	a.state.Certificate = issued.Certificate
+	a.state.PrivateKey = string(keyPEM)
+	a.state.CACertificate = issued.CACertificate
+	a.saveState()
+	return nil
+}
+
+// saveState persists the state. The agent keeps working from memory if that fails; it only has to
+// enroll again after a restart.
+func (a *Agent) saveState() {
+	if err := saveState(a.config.StateFile, a.state); err != nil {
+		a.logger.Warn("Failed to save device state", zap.String("path", a.config.StateFile), zap.Error(err))
+	}
+}
+
+// newCertificateRequest creates a key and a certificate signing request for it, returning both PEM-encoded
+func newCertificateRequest(commonName string) (csr, keyPEM []byte, err error) {
+	key, err := pki.NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr, err = pki.NewCSR(key, commonName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err = pki.EncodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return csr, keyPEM, nil
+}
+
+func (a *Agent) heartbeat(ctx context.Context, usage disk.Usage) error {
+	request := map[string]any{
+		"device_id":         a.state.DeviceID,
+		"available_storage": usage.Available,
+		"used_storage":      usage.Used,
+	}
+
+	err := a.post(ctx, heartbeatEndpoint, a.state.DeviceToken, request, nil)
+
+	var respErr *responseError
+	if errors.As(err, &respErr) && (respErr.status == http.StatusNotFound || respErr.status == http.StatusUnauthorized) {
+		return fmt.Errorf("%w: %v", errForgotten, err)
+	}
+	return err
+}
+
+// post sends a JSON request to the main server and decodes the response into result, if given
+func (a *Agent) post(ctx context.Context, endpoint, token string, request, result any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(a.config.ServerURL, "/") + endpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("server unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return newResponseError(resp)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// responseError is a non-2xx answer from the main server
+type responseError struct {
+	status  int
+	message string
+}
+
+func newResponseError(resp *http.Response) *responseError {
+	var payload struct {
+		Error string `json:"error"`
+	}
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+	_ = json.Unmarshal(data, &payload)
+
+	return &responseError{status: resp.StatusCode, message: payload.Error}
+}
+
+func (e *responseError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("server responded with %d", e.status)
+	}
+	return fmt.Sprintf("server responded with %d: %s", e.status, e.message)
+}
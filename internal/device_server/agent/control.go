@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	eventsEndpoint = "/api/v1/devices/%s/events"
+	// streamIdleTimeout is how long the control channel may stay silent before it is taken for dead.
+	// The main server writes to it at least every 15 seconds.
+	streamIdleTimeout = time.Minute
+	// The control channel is reconnected after streamRetryDelay, doubling with every failed attempt
+	// up to maxStreamRetryDelay
+	streamRetryDelay    = 5 * time.Second
+	maxStreamRetryDelay = 5 * time.Minute
+)
+
+// control is the agent's connection to the main server's control channel, for one device identity
+type control struct {
+	deviceID  string
+	token     string
+	cancel    context.CancelFunc
+	done      chan struct{}
+	connected atomic.Bool // While events are being read from the channel
+}
+
+// serverEvent is an event pushed by the main server
+type serverEvent struct {
+	name string
+	data string
+}
+
+// keepListening makes sure the control channel is open for the device's current identity,
+// replacing the one opened for an earlier identity
+func (a *Agent) keepListening(ctx context.Context) {
+	if a.state == nil {
+		return
+	}
+
+	if a.control != nil {
+		select {
+		case <-a.control.done:
+		default:
+			if a.control.deviceID == a.state.DeviceID && a.control.token == a.state.DeviceToken {
+				return
+			}
+			a.control.cancel()
+		}
+	}
+
+	listenCtx, cancel := context.WithCancel(ctx)
+	a.control = &control{
+		deviceID: a.state.DeviceID,
+		token:    a.state.DeviceToken,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go func(current *control) {
+		defer close(current.done)
+		a.listen(listenCtx, current)
+	}(a.control)
+}
+
+// listening reports whether the control channel is connected, so events reach the agent as they happen
+func (a *Agent) listening() bool {
+	return a.control != nil && a.control.connected.Load()
+}
+
+// listen keeps the control channel connected until ctx is cancelled or the server stops
+// accepting the device's identity. While it is down, the agent polls for deletions with its
+// heartbeats and picks up what it missed when it reconnects.
+func (a *Agent) listen(ctx context.Context, current *control) {
+	delay := streamRetryDelay
+	for {
+		connected, err := a.stream(ctx, current)
+		if ctx.Err() != nil {
+			return
+		}
+
+		var respErr *responseError
+		if errors.As(err, &respErr) && respErr.status >= http.StatusBadRequest && respErr.status < http.StatusInternalServerError {
+			// Enrolling again, which the heartbeats see to, gives the channel a new identity
+			a.logger.Warn("Control channel refused", zap.Error(err))
+			return
+		}
+
+		if connected {
+			delay = streamRetryDelay
+		}
+		a.logger.Warn("Control channel lost, retrying", zap.Duration("retry_in", delay), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxStreamRetryDelay)
+	}
+}
+
+// stream reads events from the control channel until it breaks, and reports whether it got
+// connected at all
+func (a *Agent) stream(ctx context.Context, current *control) (bool, error) {
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	url := strings.TrimSuffix(a.config.ServerURL, "/") + fmt.Sprintf(eventsEndpoint, current.deviceID)
+	req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+current.token)
+
+	resp, err := a.streamClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("server unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, newResponseError(resp)
+	}
+	a.logger.Info("Control channel connected", zap.String("device_id", current.deviceID))
+	current.connected.Store(true)
+	defer current.connected.Store(false)
+
+	// Reading blocks, so a silent connection is cut from here
+	idle := time.AfterFunc(streamIdleTimeout, cancel)
+	defer idle.Stop()
+
+	var event serverEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		idle.Reset(streamIdleTimeout)
+
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if event.name != "" {
+				a.handleEvent(event)
+			}
+			event = serverEvent{}
+		case strings.HasPrefix(line, ":"): // Keep-alive
+		case strings.HasPrefix(line, "event:"):
+			event.name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			event.data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+
+	if err = scanner.Err(); err == nil {
+		err = errors.New("server closed the control channel")
+	}
+	return true, err
+}
+
+// handleEvent acts on an event from the main server. Copies are written to the device by the main
+// server, so transfers are only noted; deletions are carried out by the agent itself, which
+// claims them, removes the file's objects and reports back.
+func (a *Agent) handleEvent(event serverEvent) {
+	switch event.name {
+	case "config":
+		var settings struct {
+			HeartbeatIntervalSeconds int64 `json:"heartbeat_interval_seconds"`
+		}
+		if err := json.Unmarshal([]byte(event.data), &settings); err != nil {
+			a.logger.Warn("Ignoring unreadable device settings", zap.Error(err))
+			return
+		}
+		if settings.HeartbeatIntervalSeconds > 0 {
+			a.setInterval(time.Duration(settings.HeartbeatIntervalSeconds) * time.Second)
+		}
+	case "delete":
+		select {
+		case a.deletions <- struct{}{}:
+		default: // Already due to be carried out
+		}
+	default:
+		a.logger.Debug("Event from the main server", zap.String("event", event.name), zap.String("data", event.data))
+	}
+}
+
+// setInterval has Run send heartbeats every interval from now on
+func (a *Agent) setInterval(interval time.Duration) {
+	for {
+		select {
+		case a.intervals <- interval:
+			return
+		default:
+			select {
+			case <-a.intervals: // Not picked up yet, and replaced by the newer one
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/zap"
+)
+
+const (
+	claimDeletionsEndpoint = "/api/v1/transfers/pending/%s/claim?kind=delete"
+	completeEndpoint       = "/api/v1/transfers/complete"
+	fileIDBytes            = 12
+)
+
+// carryOutDeletions claims the deletions queued for the device, removes the objects of each file
+// from its storage and reports how that went. Deletions the main server's dispatcher claimed first
+// are left to it.
+func (a *Agent) carryOutDeletions(ctx context.Context) {
+	if a.state == nil || ctx.Err() != nil {
+		return
+	}
+
+	var response struct {
+		Data []struct {
+			ID     string `json:"id"`
+			FileID string `json:"file_id"`
+		} `json:"data"`
+	}
+
+	endpoint := fmt.Sprintf(claimDeletionsEndpoint, a.state.DeviceID)
+	if err := a.post(ctx, endpoint, a.state.DeviceToken, map[string]any{}, &response); err != nil {
+		a.logger.Warn("Failed to claim queued deletions", zap.Error(err))
+		return
+	}
+
+	for _, deletion := range response.Data {
+		report := map[string]any{"transfer_id": deletion.ID, "success": true}
+		if err := removeObjects(a.storagePath, deletion.FileID); err != nil {
+			a.logger.Warn("Failed to delete file", zap.String("file_id", deletion.FileID), zap.Error(err))
+			report["success"] = false
+			report["error_msg"] = err.Error()
+		}
+
+		// A deletion left unreported goes back to the queue once its lease runs out
+		if err := a.post(ctx, completeEndpoint, a.state.DeviceToken, report, nil); err != nil {
+			a.logger.Warn("Failed to report deletion", zap.String("transfer_id", deletion.ID), zap.Error(err))
+		}
+	}
+}
+
+// removeObjects deletes everything the device server keeps for a file. Its replica, shards and
+// their checksums are all named after the file's ID, which is checked first so that nothing
+// outside the file's objects can match.
+func removeObjects(storagePath, fileID string) error {
+	if id, err := hex.DecodeString(fileID); err != nil || len(id) != fileIDBytes {
+		return errors.New("invalid file ID")
+	}
+
+	paths, err := filepath.Glob(filepath.Join(storagePath, fileID+".*"))
+	if err != nil {
+		return err
+	}
+	paths = append(paths, filepath.Join(storagePath, fileID))
+
+	for _, path := range paths {
+		if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
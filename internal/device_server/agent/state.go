@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const stateDirPerm = 0700
+
+// state is what the agent remembers between restarts
+type state struct {
+	ServerURL   string    `json:"server_url"`
+	DeviceID    string    `json:"device_id"`
+	DeviceToken string    `json:"device_token"`
+	Secret      string    `json:"secret"`
+	EnrolledAt  time.Time `json:"enrolled_at"`
+
+	// When the device token was issued and stops working; it is renewed halfway through
+	DeviceTokenIssuedAt  time.Time `json:"device_token_issued_at"`
+	DeviceTokenExpiresAt time.Time `json:"device_token_expires_at"`
+
+	// PEM-encoded TLS credentials, when mutual TLS is enabled
+	Certificate   string `json:"certificate,omitempty"`
+	PrivateKey    string `json:"private_key,omitempty"`
+	CACertificate string `json:"ca_certificate,omitempty"`
+}
+
+// tokenNeedsRenewal reports whether more than half of the device token's lifetime has passed. A
+// token saved without an expiry is left alone; the server rejects it and the device enrolls again.
+func (s *state) tokenNeedsRenewal(now time.Time) bool {
+	if s.DeviceTokenExpiresAt.IsZero() {
+		return false
+	}
+	halfway := s.DeviceTokenIssuedAt.Add(s.DeviceTokenExpiresAt.Sub(s.DeviceTokenIssuedAt) / 2)
+	return now.After(halfway)
+}
+
+// loadState reads the saved state, returning nil if the device has not been enrolled yet
+func loadState(path string) (*state, error) {
+	// #nosec G304 - the path comes from the device's own configuration
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved state
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	return &saved, nil
+}
+
+// saveState writes the state to a temporary file first and renames it into place, so a crash
+// never leaves a truncated file behind. Temporary files are created readable by the owner only,
+// which keeps the device token private.
+func saveState(path string, current *state) error {
+	data, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, stateDirPerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,289 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/manab-pr/nebulo/internal/device_server/signing"
+	"github.com/manab-pr/nebulo/internal/pki"
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+)
+
+const (
+	storeEndpoint   = "/internal/store"
+	confirmEndpoint = "/internal/confirm/"
+	filesEndpoint   = "/internal/files/"
+	errorBodyLimit  = 4096
+)
+
+// errNoSecret is returned for devices registered before devices were given secrets. They have to
+// enroll again before the main server can talk to them.
+var errNoSecret = errors.New("device has no shared secret, enroll it again")
+
+// Client talks to the internal API served by a device server, signing every request with the
+// device's secret
+type Client struct {
+	httpClient *http.Client
+	port       string
+	scheme     string
+
+	// With mutual TLS, every device is called through its own client, whose connections were
+	// checked to reach that device
+	tlsConfig   *tls.Config
+	revocations pki.RevocationChecker
+	devices     sync.Map // Device ID to *http.Client
+}
+
+// NewClient returns a client for device servers listening on port. With a TLS configuration, it
+// talks to them over mutual TLS, only to peers holding the unrevoked certificate of the device it calls.
+func NewClient(port string, tlsConfig *tls.Config, revocations pki.RevocationChecker) *Client {
+	client := &Client{
+		httpClient:  &http.Client{},
+		port:        port,
+		scheme:      "http",
+		tlsConfig:   tlsConfig,
+		revocations: revocations,
+	}
+
+	if tlsConfig != nil {
+		client.scheme = "https"
+	}
+	return client
+}
+
+// httpClientFor returns the client that calls the device. Connections are pooled per device, so
+// one checked for a device is never reused for another claiming the same address.
+func (c *Client) httpClientFor(device *deviceEntities.Device) *http.Client {
+	if c.tlsConfig == nil {
+		return c.httpClient
+	}
+
+	key := device.ID.Hex()
+	if client, ok := c.devices.Load(key); ok {
+		return client.(*http.Client)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = pki.ForDevice(c.tlsConfig, key, c.revocations)
+	client, _ := c.devices.LoadOrStore(key, &http.Client{Transport: transport})
+	return client.(*http.Client)
+}
+
+func (c *Client) baseURL(device *deviceEntities.Device) string {
+	return c.scheme + "://" + net.JoinHostPort(device.IPAddress, c.port)
+}
+
+// StoreFile streams content to the device's /internal/store endpoint under the given object ID.
+// The checksum of the content is signed into the request's trailers once it has all been sent.
+func (c *Client) StoreFile(ctx context.Context, device *deviceEntities.Device, objectID string, content io.Reader) error {
+	if device.Secret == "" {
+		return errNoSecret
+	}
+
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL(device)+storeEndpoint, body)
+	if err != nil {
+		body.Close()
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	timestamp, err := signing.SignRequest(req, device.Secret, objectID, "")
+	if err != nil {
+		body.Close()
+		return err
+	}
+	signing.DeclareContentTrailers(req)
+
+	go func() {
+		hasher := sha256.New()
+		formErr := writeStoreForm(form, objectID, io.TeeReader(content, hasher))
+		if formErr == nil {
+			signing.SignContent(req, device.Secret, objectID, hex.EncodeToString(hasher.Sum(nil)), timestamp)
+		}
+		writer.CloseWithError(formErr)
+	}()
+
+	return c.do(device, req)
+}
+
+// ConfirmFile asks the device to confirm it holds the object with the given ID and, when checksum
+// is not empty, that the object has that checksum
+func (c *Client) ConfirmFile(ctx context.Context, device *deviceEntities.Device, objectID, checksum string) error {
+	req, err := c.newRequest(ctx, http.MethodPost, device, confirmEndpoint+objectID, objectID, checksum)
+	if err != nil {
+		return err
+	}
+
+	return c.do(device, req)
+}
+
+// OpenFile starts streaming the object with the given ID from the device, limited to byteRange
+// when it is not nil. The caller must close the reader.
+func (c *Client) OpenFile(
+	ctx context.Context, device *deviceEntities.Device, objectID string, byteRange *fileEntities.ByteRange,
+) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, device, filesEndpoint+objectID, objectID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	expectedStatus := http.StatusOK
+	if byteRange != nil {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", byteRange.Start, byteRange.End))
+		expectedStatus = http.StatusPartialContent
+	}
+
+	resp, err := c.httpClientFor(device).Do(req)
+	if err != nil {
+		return nil, unreachable(req, err)
+	}
+
+	if resp.StatusCode != expectedStatus {
+		defer resp.Body.Close()
+		return nil, responseError(resp)
+	}
+
+	return resp.Body, nil
+}
+
+// StatFile asks the device for the size and checksum of the object with the given ID. The
+// checksum comes from the ETag the device serves, so it is empty for objects stored without one.
+func (c *Client) StatFile(ctx context.Context, device *deviceEntities.Device, objectID string) (*fileEntities.StoredObject, error) {
+	req, err := c.newRequest(ctx, http.MethodHead, device, filesEndpoint+objectID, objectID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClientFor(device).Do(req)
+	if err != nil {
+		return nil, unreachable(req, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fileRepository.ErrObjectNotFound
+	default:
+		return nil, fmt.Errorf("device responded with %d", resp.StatusCode)
+	}
+
+	return &fileEntities.StoredObject{
+		Size:     resp.ContentLength,
+		Checksum: strings.Trim(resp.Header.Get("ETag"), `"`),
+	}, nil
+}
+
+// DeleteFile asks the device to delete the object with the given ID, along with its checksum
+func (c *Client) DeleteFile(ctx context.Context, device *deviceEntities.Device, objectID string) error {
+	req, err := c.newRequest(ctx, http.MethodDelete, device, filesEndpoint+objectID, objectID, "")
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClientFor(device).Do(req)
+	if err != nil {
+		return unreachable(req, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return nil
+	case http.StatusNotFound:
+		return fileRepository.ErrObjectNotFound
+	}
+	return responseError(resp)
+}
+
+// newRequest builds a body-less request to the device, signed for objectID and checksum
+func (c *Client) newRequest(
+	ctx context.Context, method string, device *deviceEntities.Device, endpoint, objectID, checksum string,
+) (*http.Request, error) {
+	if device.Secret == "" {
+		return nil, errNoSecret
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL(device)+endpoint, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = signing.SignRequest(req, device.Secret, objectID, checksum); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func (c *Client) do(device *deviceEntities.Device, req *http.Request) error {
+	resp, err := c.httpClientFor(device).Do(req)
+	if err != nil {
+		return unreachable(req, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
+		return nil
+	}
+
+	return responseError(resp)
+}
+
+func writeStoreForm(form *multipart.Writer, objectID string, content io.Reader) error {
+	if err := form.WriteField("filename", objectID); err != nil {
+		return err
+	}
+
+	part, err := form.CreateFormFile("file", objectID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(part, content); err != nil {
+		return err
+	}
+
+	return form.Close()
+}
+
+// unreachable wraps the error of a request that never got a response from the device
+func unreachable(req *http.Request, err error) error {
+	return fmt.Errorf("%w: %s: %w", fileRepository.ErrDeviceUnreachable, req.URL.Host, err)
+}
+
+// responseError turns a non-2xx device response into an error carrying the device's message,
+// wrapping the matching repository error for the responses callers tell apart
+func responseError(resp *http.Response) error {
+	var payload struct {
+		Error string `json:"error"`
+	}
+
+	err := fmt.Errorf("device responded with %d", resp.StatusCode)
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, errorBodyLimit))
+	if json.Unmarshal(data, &payload) == nil && payload.Error != "" {
+		err = fmt.Errorf("device responded with %d: %s", resp.StatusCode, payload.Error)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusConflict:
+		return fmt.Errorf("%w: %w", fileRepository.ErrChecksumMismatch, err)
+	case http.StatusInsufficientStorage:
+		return fmt.Errorf("%w: %w", fileRepository.ErrDeviceFull, err)
+	}
+	return err
+}
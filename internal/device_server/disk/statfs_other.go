@@ -0,0 +1,15 @@
+//go:build !(linux || darwin || freebsd)
+
+package disk
+
+const assumedTotalSpace = 100 * 1024 * 1024 * 1024
+
+// filesystemSpace cannot query the filesystem on this platform, so it assumes a fixed total with
+// whatever the storage directory does not use counted as available
+func filesystemSpace(path string) (total, available int64, err error) {
+	used, err := directorySize(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return assumedTotalSpace, assumedTotalSpace - used, nil
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+package disk
+
+import "syscall"
+
+func filesystemSpace(path string) (total, available int64, err error) {
+	var stat syscall.Statfs_t
+	if err = syscall.Statfs(path, &stat); err != nil {
+		return 0, 0, err
+	}
+
+	// #nosec G115 - block counts and sizes of a real filesystem fit in an int64
+	blockSize := int64(stat.Bsize)
+	// #nosec G115
+	return int64(stat.Blocks) * blockSize, int64(stat.Bavail) * blockSize, nil
+}
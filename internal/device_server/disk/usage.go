@@ -0,0 +1,44 @@
+// Package disk reports how much space a device server has for Nebulo's files.
+package disk
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Usage describes the filesystem holding the storage directory. Used counts only the files under
+// the storage directory; Available is what is still free on the filesystem.
+type Usage struct {
+	Total     int64
+	Used      int64
+	Available int64
+}
+
+// UsageOf reports the space on the filesystem holding path and how much of it the files under path take up
+func UsageOf(path string) (Usage, error) {
+	used, err := directorySize(path)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	total, available, err := filesystemSpace(path)
+	if err != nil {
+		return Usage{}, err
+	}
+
+	return Usage{Total: total, Used: used, Available: available}, nil
+}
+
+func directorySize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil // Skip files with errors
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
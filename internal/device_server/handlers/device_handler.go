@@ -1,31 +1,43 @@
 package handlers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+
+	"github.com/manab-pr/nebulo/internal/device_server/disk"
+	"github.com/manab-pr/nebulo/internal/device_server/signing"
 
 	"github.com/gin-gonic/gin"
 )
 
 const (
-	maxMemoryMB         = 32
-	storageDirPerm      = 0750
-	defaultTotalSpaceGB = 100
-	bytesPerMB          = 1024 * 1024
-	bytesPerGB          = 1024 * 1024 * 1024
-	mbShift             = 20 // 1 << 20 = 1MB
+	maxFieldBytes    = 1024 // Upper bound for non-file multipart fields
+	storageDirPerm   = 0750
+	bytesPerMB       = 1024 * 1024
+	tempFileSuffix   = ".*.part"
+	checksumSuffix   = ".sha256"
+	checksumFilePerm = 0640
 )
 
 type InternalDeviceHandler struct {
 	storagePath string
+	secret      *signing.Secret
+	nonces      *signing.Nonces // Of the signed requests received, so none is served twice
 }
 
-func NewInternalDeviceHandler(storagePath string) *InternalDeviceHandler {
+func NewInternalDeviceHandler(storagePath string, secret *signing.Secret) *InternalDeviceHandler {
 	return &InternalDeviceHandler{
 		storagePath: storagePath,
+		secret:      secret,
+		nonces:      signing.NewNonces(),
 	}
 }
 
@@ -43,21 +55,25 @@ func sanitizeFileName(filename string) string {
 	return clean
 }
 
-// StoreFile handles incoming file storage requests from backend
+// StoreFile handles incoming file storage requests from backend. The body is streamed straight
+// to disk, so the "filename" field must come before the "file" part. The file is only kept if the
+// checksum signed into the request's trailers matches what arrived.
 func (h *InternalDeviceHandler) StoreFile(c *gin.Context) {
-	// Parse multipart form
-	err := c.Request.ParseMultipartForm(maxMemoryMB << mbShift) // 32 MB max memory
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
 		return
 	}
 
-	// Get file from form
-	file, header, err := c.Request.FormFile("file")
-	if err != nil {
+	fileName, file, err := nextFilePart(reader)
+	if err == io.EOF {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
+		return
+	}
 	defer file.Close()
 
 	// Create storage directory if it doesn't exist
@@ -68,24 +84,54 @@ func (h *InternalDeviceHandler) StoreFile(c *gin.Context) {
 	}
 
 	// Create destination file with sanitized filename
-	fileName := c.PostForm("filename")
 	if fileName == "" {
-		fileName = header.Filename
+		fileName = file.FileName()
+	}
+
+	if !signedFor(c, fileName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Request is not signed for this file"})
+		return
 	}
 
 	// Sanitize the filename to prevent path traversal attacks
 	safeFileName := sanitizeFileName(fileName)
 
+	// Stream into a temporary file and rename it into place once complete, so an
+	// interrupted upload never leaves a truncated file under the final name
+	dst, err := os.CreateTemp(h.storagePath, safeFileName+tempFileSuffix)
+	if err != nil {
+		writeFailed(c, err, "Failed to create file")
+		return
+	}
+	defer os.Remove(dst.Name())
+
+	// Hash while writing so the checksum can be served as the file's ETag
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(dst, hasher), file)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		writeFailed(c, err, "Failed to save file")
+		return
+	}
+
+	checksum := hex.EncodeToString(hasher.Sum(nil))
+	if verifyErr := h.verifyContent(c, checksum); verifyErr != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": verifyErr.Error()})
+		return
+	}
+
+	filePath := filepath.Join(h.storagePath, safeFileName)
+
 	// #nosec G304 - filename is sanitized above to prevent path traversal
-	dst, err := os.Create(filepath.Join(h.storagePath, safeFileName))
+	err = os.WriteFile(filePath+checksumSuffix, []byte(checksum), checksumFilePerm)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create file"})
+		writeFailed(c, err, "Failed to save file checksum")
 		return
 	}
-	defer dst.Close()
 
-	// Copy file contents
-	_, err = io.Copy(dst, file)
+	err = os.Rename(dst.Name(), filePath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
 		return
@@ -94,79 +140,166 @@ func (h *InternalDeviceHandler) StoreFile(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "File stored successfully",
 		"filename": fileName,
+		"checksum": checksum,
 	})
 }
 
-// GetFile serves a file stored on this device
+// GetFile serves a file stored on this device, honouring Range, If-Range and If-None-Match
+// against the SHA-256 checksum recorded when the file was stored
 func (h *InternalDeviceHandler) GetFile(c *gin.Context) {
 	fileID := c.Param("id")
 	if fileID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "File ID is required"})
 		return
 	}
+	if !signedFor(c, fileID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Request is not signed for this file"})
+		return
+	}
 
-	// Find file by ID (in practice, you'd have a mapping of ID to filename)
-	// For now, we'll use the ID as filename
-	filePath := filepath.Join(h.storagePath, fileID)
+	// The ID is the name the file was stored under
+	filePath := filepath.Join(h.storagePath, sanitizeFileName(fileID))
 
-	// Check if file exists
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+	// #nosec G304 - filename is sanitized above to prevent path traversal
+	file, err := os.Open(filePath)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
+	defer file.Close()
 
-	c.File(filePath)
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	// Files stored before checksums were recorded are served without an ETag
+	if checksum, readErr := os.ReadFile(filePath + checksumSuffix); readErr == nil {
+		c.Header("ETag", `"`+string(checksum)+`"`)
+	}
+
+	http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), file)
 }
 
-// GetStorageInfo reports current available storage
-func (h *InternalDeviceHandler) GetStorageInfo(c *gin.Context) {
-	// Get storage info for the storage path
-	// This is a simplified version - in practice you'd want to get disk usage stats
-	totalSpace := int64(defaultTotalSpaceGB * bytesPerGB)
-	usedSpace := int64(0)
+// DeleteFile removes a file stored on this device along with its recorded checksum. A file that
+// is not there is reported as not found, which the main server takes as deleted all the same.
+func (h *InternalDeviceHandler) DeleteFile(c *gin.Context) {
+	fileID := c.Param("id")
+	if fileID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File ID is required"})
+		return
+	}
+	if !signedFor(c, fileID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Request is not signed for this file"})
+		return
+	}
 
-	// Walk through storage directory to calculate used space
-	err := filepath.Walk(h.storagePath, func(_ string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // Skip files with errors
-		}
-		if !info.IsDir() {
-			usedSpace += info.Size()
-		}
-		return nil
+	filePath := filepath.Join(h.storagePath, sanitizeFileName(fileID))
+
+	err := os.Remove(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file"})
+		return
+	}
+	missing := os.IsNotExist(err)
+
+	// Also cleared when the file itself is already gone, so a half-finished deletion can be repeated
+	if removeErr := os.Remove(filePath + checksumSuffix); removeErr != nil && !os.IsNotExist(removeErr) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete file checksum"})
+		return
+	}
+
+	if missing {
+		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "File deleted successfully",
+		"file_id": fileID,
 	})
+}
 
+// GetStorageInfo reports the space on the device's disk and how much of it stored files use
+func (h *InternalDeviceHandler) GetStorageInfo(c *gin.Context) {
+	usage, err := disk.UsageOf(h.storagePath)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate storage usage"})
 		return
 	}
 
-	availableSpace := totalSpace - usedSpace
-
 	c.JSON(http.StatusOK, gin.H{
-		"total_storage":     totalSpace,
-		"used_storage":      usedSpace,
-		"available_storage": availableSpace,
+		"total_storage":     usage.Total,
+		"used_storage":      usage.Used,
+		"available_storage": usage.Available,
 	})
 }
 
-// ConfirmFile confirms file successfully received and stored
+// writeFailed answers a store request whose file could not be written. A full disk gets its own
+// status, so the main server can tell it apart.
+func writeFailed(c *gin.Context, err error, message string) {
+	if errors.Is(err, syscall.ENOSPC) {
+		c.JSON(http.StatusInsufficientStorage, gin.H{"error": "Not enough space left on device"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// ConfirmFile confirms file successfully received and stored and, when the request carries a
+// signed checksum, that the stored file has it
 func (h *InternalDeviceHandler) ConfirmFile(c *gin.Context) {
 	fileID := c.Param("fileId")
 	if fileID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "File ID is required"})
 		return
 	}
+	if !signedFor(c, fileID) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Request is not signed for this file"})
+		return
+	}
 
 	// Check if file exists
-	filePath := filepath.Join(h.storagePath, fileID)
+	filePath := filepath.Join(h.storagePath, sanitizeFileName(fileID))
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
 		return
 	}
 
+	if expected := c.GetHeader(signing.ChecksumHeader); expected != "" {
+		// #nosec G304 - filename is sanitized above to prevent path traversal
+		checksum, err := os.ReadFile(filePath + checksumSuffix)
+		if err != nil || string(checksum) != expected {
+			c.JSON(http.StatusConflict, gin.H{"error": "Stored file does not match the expected checksum"})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "File confirmed successfully",
 		"file_id": fileID,
 	})
 }
+
+// nextFilePart reads the form up to its "file" part and returns that part, along with the
+// "filename" field if it came first
+func nextFilePart(reader *multipart.Reader) (string, *multipart.Part, error) {
+	var fileName string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch part.FormName() {
+		case "file":
+			return fileName, part, nil
+		case "filename":
+			value, readErr := io.ReadAll(io.LimitReader(part, maxFieldBytes))
+			if readErr != nil {
+				return "", nil, readErr
+			}
+			fileName = string(value)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/manab-pr/nebulo/internal/device_server/signing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signedAtKey holds the timestamp the request was signed with, which the upload trailers reuse
+const signedAtKey = "signed_at"
+
+var errContentMismatch = errors.New("file content does not match its signed checksum")
+
+// VerifySignature rejects requests that were not signed with this device's secret. Handlers must
+// still check with signedFor that the signature covers the file they act on.
+func (h *InternalDeviceHandler) VerifySignature(c *gin.Context) {
+	secret := h.secret.Get()
+	if secret == "" {
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Device has no secret yet"})
+		return
+	}
+
+	timestamp, err := signing.Verify(c.Request, secret, h.nonces, time.Now())
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Set(signedAtKey, timestamp)
+	c.Next()
+}
+
+// signedFor reports whether the request's signature covers the file with the given ID
+func signedFor(c *gin.Context, fileID string) bool {
+	return fileID != "" && c.GetHeader(signing.ObjectHeader) == fileID
+}
+
+// verifyContent checks the upload's trailer signature against the checksum of what was received.
+// Trailers only arrive at the end of the body, so whatever is left of it is read first.
+func (h *InternalDeviceHandler) verifyContent(c *gin.Context, checksum string) error {
+	if _, err := io.Copy(io.Discard, c.Request.Body); err != nil {
+		return err
+	}
+
+	signed, err := signing.VerifyContent(c.Request, h.secret.Get(), c.GetInt64(signedAtKey))
+	if err != nil {
+		return err
+	}
+	if signed != checksum {
+		return errContentMismatch
+	}
+	return nil
+}
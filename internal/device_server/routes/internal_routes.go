@@ -8,8 +8,15 @@ import (
 
 func SetupInternalRoutes(router *gin.Engine, handler *handlers.InternalDeviceHandler) {
 	internal := router.Group("/internal")
-	internal.POST("/store", handler.StoreFile)
-	internal.GET("/files/:id", handler.GetFile)
+
+	// Only reports capacity, and is used for health checks
 	internal.GET("/storage", handler.GetStorageInfo)
-	internal.POST("/confirm/:fileId", handler.ConfirmFile)
+
+	// Everything touching stored files must be signed by the main server
+	signed := internal.Group("", handler.VerifySignature)
+	signed.POST("/store", handler.StoreFile)
+	signed.GET("/files/:id", handler.GetFile)
+	signed.HEAD("/files/:id", handler.GetFile)
+	signed.DELETE("/files/:id", handler.DeleteFile)
+	signed.POST("/confirm/:fileId", handler.ConfirmFile)
 }
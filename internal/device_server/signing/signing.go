@@ -0,0 +1,207 @@
+// Package signing authenticates the main server's requests to a device server's internal API.
+// Each device shares a secret with the main server; every request carries an HMAC-SHA256 signature
+// over the method, path, the object it is about, the object's checksum, a timestamp and a random
+// nonce, so a signature is only good for that one object and expires after MaxSkew. Devices
+// remember the nonces they have seen until then, so a captured request cannot be replayed either.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-Nebulo-Timestamp"
+	NonceHeader     = "X-Nebulo-Nonce"
+	ObjectHeader    = "X-Nebulo-Object"
+	ChecksumHeader  = "X-Nebulo-Checksum"
+	SignatureHeader = "X-Nebulo-Signature"
+
+	// Uploads are signed twice: the headers authorize the upload and these trailers, sent once the
+	// body is through, bind the checksum of what was actually sent
+	ContentChecksumTrailer  = "X-Nebulo-Content-Checksum"
+	ContentSignatureTrailer = "X-Nebulo-Content-Signature"
+
+	// MaxSkew is how far a request's timestamp may be from the device's clock
+	MaxSkew = 5 * time.Minute
+
+	secretBytes = 32
+	nonceBytes  = 16
+	version     = "nebulo-v1"
+)
+
+var (
+	ErrUnsigned         = errors.New("request is not signed")
+	ErrExpired          = errors.New("request signature has expired")
+	ErrInvalidSignature = errors.New("request signature is invalid")
+	ErrReplayed         = errors.New("request has already been received")
+)
+
+// NewSecret returns a random secret to share with a device
+func NewSecret() (string, error) {
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// Sign computes the signature of a request for objectID with the given checksum, which may be empty
+func Sign(secret, method, path, objectID, checksum string, timestamp int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{
+		version, method, path, objectID, checksum, strconv.FormatInt(timestamp, 10), nonce,
+	}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest adds the signature headers for objectID and checksum to req, with a fresh nonce,
+// returning the timestamp it signed with
+func SignRequest(req *http.Request, secret, objectID, checksum string) (int64, error) {
+	nonce := make([]byte, nonceBytes)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(NonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(ObjectHeader, objectID)
+	if checksum != "" {
+		req.Header.Set(ChecksumHeader, checksum)
+	}
+	req.Header.Set(SignatureHeader, Sign(
+		secret, req.Method, req.URL.Path, objectID, checksum, timestamp, req.Header.Get(NonceHeader),
+	))
+	return timestamp, nil
+}
+
+// DeclareContentTrailers announces the upload trailers on req, before it is sent
+func DeclareContentTrailers(req *http.Request) {
+	req.Trailer = http.Header{ContentChecksumTrailer: nil, ContentSignatureTrailer: nil}
+}
+
+// SignContent fills in the upload trailers once the whole body has been produced. It must be
+// called before the body returns io.EOF.
+func SignContent(req *http.Request, secret, objectID, checksum string, timestamp int64) {
+	req.Trailer.Set(ContentChecksumTrailer, checksum)
+	req.Trailer.Set(ContentSignatureTrailer, Sign(
+		secret, req.Method, req.URL.Path, objectID, checksum, timestamp, req.Header.Get(NonceHeader),
+	))
+}
+
+// Verify checks the signature headers of req, returning the timestamp they were signed with. A
+// request whose nonce is already in seen is rejected; otherwise its nonce is added.
+func Verify(req *http.Request, secret string, seen *Nonces, now time.Time) (int64, error) {
+	signature := req.Header.Get(SignatureHeader)
+	rawTimestamp := req.Header.Get(TimestampHeader)
+	nonce := req.Header.Get(NonceHeader)
+	if signature == "" || rawTimestamp == "" || nonce == "" {
+		return 0, ErrUnsigned
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidSignature
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	if now.Sub(signedAt) > MaxSkew || signedAt.Sub(now) > MaxSkew {
+		return 0, ErrExpired
+	}
+
+	expected := Sign(
+		secret, req.Method, req.URL.Path, req.Header.Get(ObjectHeader), req.Header.Get(ChecksumHeader), timestamp, nonce,
+	)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return 0, ErrInvalidSignature
+	}
+
+	// Only nonces of genuine requests are kept, so nobody else can fill the set
+	if !seen.add(nonce, signedAt.Add(MaxSkew), now) {
+		return 0, ErrReplayed
+	}
+	return timestamp, nil
+}
+
+// VerifyContent checks the trailer signature of an upload whose body has been read completely,
+// returning the checksum it vouches for
+func VerifyContent(req *http.Request, secret string, timestamp int64) (string, error) {
+	checksum := req.Trailer.Get(ContentChecksumTrailer)
+	signature := req.Trailer.Get(ContentSignatureTrailer)
+	if checksum == "" || signature == "" {
+		return "", ErrUnsigned
+	}
+
+	expected := Sign(
+		secret, req.Method, req.URL.Path, req.Header.Get(ObjectHeader), checksum, timestamp, req.Header.Get(NonceHeader),
+	)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return "", ErrInvalidSignature
+	}
+	return checksum, nil
+}
+
+// Secret holds the device's current secret. It can change while the device server runs, when the
+// agent enrolls again.
+type Secret struct {
+	mu    sync.RWMutex
+	value string
+}
+
+func NewSecretHolder(value string) *Secret {
+	return &Secret{value: value}
+}
+
+func (s *Secret) Get() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+func (s *Secret) Set(value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.value = value
+}
+
+// Nonces remembers the nonces of the requests a device accepted, each until its signature would
+// have expired anyway
+type Nonces struct {
+	mu       sync.Mutex
+	expiries map[string]time.Time
+	pruned   time.Time
+}
+
+func NewNonces() *Nonces {
+	return &Nonces{expiries: make(map[string]time.Time)}
+}
+
+// add records nonce until expiresAt, reporting false if it was already recorded
+func (n *Nonces) add(nonce string, expiresAt, now time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if now.Sub(n.pruned) > MaxSkew {
+		for seen, expiry := range n.expiries {
+			if expiry.Before(now) {
+				delete(n.expiries, seen)
+			}
+		}
+		n.pruned = now
+	}
+
+	if expiry, ok := n.expiries[nonce]; ok && !expiry.Before(now) {
+		return false
+	}
+	n.expiries[nonce] = expiresAt
+	return true
+}
@@ -0,0 +1,71 @@
+package erasure
+
+// Arithmetic in GF(2^8) using the primitive polynomial x^8 + x^4 + x^3 + x^2 + 1 (0x11d)
+// with generator 2, the field used by most Reed-Solomon implementations.
+
+const (
+	fieldSize           = 256
+	fieldOrder          = fieldSize - 1
+	primitivePolynomial = 0x11d
+)
+
+var (
+	expTable [2 * fieldOrder]byte
+	logTable [fieldSize]byte
+	mulTable [fieldSize][fieldSize]byte
+)
+
+func init() {
+	x := 1
+	for i := 0; i < fieldOrder; i++ {
+		expTable[i] = byte(x)
+		expTable[i+fieldOrder] = byte(x)
+		logTable[x] = byte(i)
+
+		x <<= 1
+		if x >= fieldSize {
+			x ^= primitivePolynomial
+		}
+	}
+
+	for a := 0; a < fieldSize; a++ {
+		for b := 0; b < fieldSize; b++ {
+			mulTable[a][b] = galMul(byte(a), byte(b))
+		}
+	}
+}
+
+func galMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+// galInverse returns the multiplicative inverse of a, which must not be zero
+func galInverse(a byte) byte {
+	return expTable[fieldOrder-int(logTable[a])]
+}
+
+// galExp raises a to the power n
+func galExp(a byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if a == 0 {
+		return 0
+	}
+	return expTable[(int(logTable[a])*n)%fieldOrder]
+}
+
+// mulAddSlice adds c*in to out, element by element. Addition in GF(2^8) is XOR.
+func mulAddSlice(c byte, in, out []byte) {
+	if c == 0 {
+		return
+	}
+
+	table := &mulTable[c]
+	for i, v := range in {
+		out[i] ^= table[v]
+	}
+}
@@ -0,0 +1,85 @@
+package erasure
+
+import "errors"
+
+var errSingularMatrix = errors.New("erasure: matrix is singular")
+
+// matrix is a row-major matrix over GF(2^8)
+type matrix [][]byte
+
+func newMatrix(rows, cols int) matrix {
+	m := make(matrix, rows)
+	for r := range m {
+		m[r] = make([]byte, cols)
+	}
+	return m
+}
+
+// vandermonde returns the rows x cols matrix with element r^c in row r, column c. Any cols of its
+// rows are linearly independent as long as rows does not exceed the field size.
+func vandermonde(rows, cols int) matrix {
+	m := newMatrix(rows, cols)
+	for r := range m {
+		for c := range m[r] {
+			m[r][c] = galExp(byte(r), c)
+		}
+	}
+	return m
+}
+
+func (m matrix) multiply(other matrix) matrix {
+	result := newMatrix(len(m), len(other[0]))
+	for r := range result {
+		for c := range result[r] {
+			var value byte
+			for i := range other {
+				value ^= galMul(m[r][i], other[i][c])
+			}
+			result[r][c] = value
+		}
+	}
+	return result
+}
+
+// invert returns the inverse of a square matrix using Gauss-Jordan elimination
+func (m matrix) invert() (matrix, error) {
+	size := len(m)
+
+	// Work on [m | I] and reduce the left half to the identity
+	work := newMatrix(size, 2*size)
+	for r := range m {
+		copy(work[r], m[r])
+		work[r][size+r] = 1
+	}
+
+	for col := 0; col < size; col++ {
+		pivot := col
+		for pivot < size && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == size {
+			return nil, errSingularMatrix
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		scale := galInverse(work[col][col])
+		for c := range work[col] {
+			work[col][c] = galMul(work[col][c], scale)
+		}
+
+		for r := 0; r < size; r++ {
+			if r != col && work[r][col] != 0 {
+				factor := work[r][col]
+				for c := range work[r] {
+					work[r][c] ^= galMul(factor, work[col][c])
+				}
+			}
+		}
+	}
+
+	inverse := newMatrix(size, size)
+	for r := range inverse {
+		copy(inverse[r], work[r][size:])
+	}
+	return inverse, nil
+}
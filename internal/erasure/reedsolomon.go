@@ -0,0 +1,152 @@
+// Package erasure implements systematic Reed-Solomon erasure coding over GF(2^8).
+//
+// Data is split into k data shards, from which m parity shards are computed. Any k of the
+// k+m shards are enough to rebuild the data, so up to m shards may be lost.
+package erasure
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxShards is the largest total shard count the field supports
+const MaxShards = fieldSize
+
+var (
+	ErrTooFewShards      = errors.New("erasure: not enough shards to reconstruct the data")
+	ErrShardSizeMismatch = errors.New("erasure: shards must all have the same size")
+)
+
+// Codec encodes and reconstructs shards for a fixed k+m layout. It is safe for concurrent use.
+type Codec struct {
+	dataShards   int
+	parityShards int
+	encoding     matrix // (k+m) x k; the top k rows are the identity, so data shards are stored as-is
+}
+
+func NewCodec(dataShards, parityShards int) (*Codec, error) {
+	if dataShards < 1 || parityShards < 1 {
+		return nil, errors.New("erasure: at least one data and one parity shard are required")
+	}
+
+	total := dataShards + parityShards
+	if total > MaxShards {
+		return nil, fmt.Errorf("erasure: at most %d shards are supported", MaxShards)
+	}
+
+	// Turning the top of a Vandermonde matrix into the identity keeps every k rows independent
+	vm := vandermonde(total, dataShards)
+	top, err := vm[:dataShards].invert()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Codec{
+		dataShards:   dataShards,
+		parityShards: parityShards,
+		encoding:     vm.multiply(top),
+	}, nil
+}
+
+func (c *Codec) DataShards() int {
+	return c.dataShards
+}
+
+func (c *Codec) ParityShards() int {
+	return c.parityShards
+}
+
+func (c *Codec) TotalShards() int {
+	return c.dataShards + c.parityShards
+}
+
+// Encode computes the parity shards from the data shards. shards holds k data shards followed by
+// m parity shards, all of the same size; the parity shards are overwritten.
+func (c *Codec) Encode(shards [][]byte) error {
+	if len(shards) != c.TotalShards() {
+		return fmt.Errorf("erasure: expected %d shards, got %d", c.TotalShards(), len(shards))
+	}
+
+	size := len(shards[0])
+	for _, shard := range shards {
+		if len(shard) != size {
+			return ErrShardSizeMismatch
+		}
+	}
+
+	for p := 0; p < c.parityShards; p++ {
+		parity := shards[c.dataShards+p]
+		clear(parity)
+
+		row := c.encoding[c.dataShards+p]
+		for d := 0; d < c.dataShards; d++ {
+			mulAddSlice(row[d], shards[d], parity)
+		}
+	}
+
+	return nil
+}
+
+// ReconstructData rebuilds missing data shards in place. Missing shards are nil; the rebuilt ones
+// are allocated. Parity shards are used as needed but not rebuilt.
+func (c *Codec) ReconstructData(shards [][]byte) error {
+	if len(shards) != c.TotalShards() {
+		return fmt.Errorf("erasure: expected %d shards, got %d", c.TotalShards(), len(shards))
+	}
+
+	missingData := false
+	for d := 0; d < c.dataShards; d++ {
+		if shards[d] == nil {
+			missingData = true
+			break
+		}
+	}
+	if !missingData {
+		return nil
+	}
+
+	// Take the first k shards that are present, along with their rows of the encoding matrix
+	present := make([][]byte, 0, c.dataShards)
+	rows := make(matrix, 0, c.dataShards)
+	size := -1
+	for i, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		if size == -1 {
+			size = len(shard)
+		} else if len(shard) != size {
+			return ErrShardSizeMismatch
+		}
+
+		present = append(present, shard)
+		rows = append(rows, c.encoding[i])
+		if len(present) == c.dataShards {
+			break
+		}
+	}
+
+	if len(present) < c.dataShards {
+		return ErrTooFewShards
+	}
+
+	// Those rows map the data onto the present shards, so their inverse maps the shards back
+	decode, err := rows.invert()
+	if err != nil {
+		return err
+	}
+
+	for d := 0; d < c.dataShards; d++ {
+		if shards[d] != nil {
+			continue
+		}
+
+		rebuilt := make([]byte, size)
+		for i, shard := range present {
+			mulAddSlice(decode[d][i], shard, rebuilt)
+		}
+		shards[d] = rebuilt
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package erasure
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"math/rand"
+	"testing"
+)
+
+// encoded returns k data shards of random content, each size bytes, followed by their m parity shards
+func encoded(t *testing.T, codec *Codec, size int, rng *rand.Rand) [][]byte {
+	t.Helper()
+
+	shards := make([][]byte, codec.TotalShards())
+	for i := range shards {
+		shards[i] = make([]byte, size)
+		if i < codec.DataShards() {
+			rng.Read(shards[i])
+		}
+	}
+	if err := codec.Encode(shards); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return shards
+}
+
+// without returns a copy of shards with the shards whose bit is set in lost replaced by nil
+func without(shards [][]byte, lost uint) [][]byte {
+	damaged := make([][]byte, len(shards))
+	for i, shard := range shards {
+		if lost&(1<<i) == 0 {
+			damaged[i] = append([]byte(nil), shard...)
+		}
+	}
+	return damaged
+}
+
+func TestReconstructDataAfterAnyLoss(t *testing.T) {
+	layouts := []struct{ data, parity int }{
+		{1, 1}, {2, 1}, {2, 2}, {3, 2}, {4, 2}, {5, 3}, {6, 3}, {8, 4}, {10, 4},
+	}
+
+	for _, layout := range layouts {
+		t.Run(fmt.Sprintf("%d+%d", layout.data, layout.parity), func(t *testing.T) {
+			codec, err := NewCodec(layout.data, layout.parity)
+			if err != nil {
+				t.Fatalf("NewCodec: %v", err)
+			}
+
+			rng := rand.New(rand.NewSource(int64(layout.data*100 + layout.parity)))
+			shards := encoded(t, codec, 97, rng)
+			total := codec.TotalShards()
+
+			// Every pattern of lost shards, up to and including losing exactly m of them
+			for lost := uint(0); lost < 1<<total; lost++ {
+				losses := bits.OnesCount(lost)
+				if losses > codec.ParityShards() {
+					continue
+				}
+
+				damaged := without(shards, lost)
+				if err = codec.ReconstructData(damaged); err != nil {
+					t.Fatalf("lost %b: ReconstructData: %v", lost, err)
+				}
+				for d := 0; d < codec.DataShards(); d++ {
+					if !bytes.Equal(damaged[d], shards[d]) {
+						t.Fatalf("lost %b: data shard %d differs after reconstruction", lost, d)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReconstructDataWithTooFewShards(t *testing.T) {
+	codec, err := NewCodec(4, 2)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	shards := encoded(t, codec, 64, rand.New(rand.NewSource(1)))
+
+	// Losing one shard more than there is parity for
+	for _, lost := range []uint{0b000111, 0b111000, 0b101010, 0b001111} {
+		if err = codec.ReconstructData(without(shards, lost)); !errors.Is(err, ErrTooFewShards) {
+			t.Errorf("lost %b: got %v, want ErrTooFewShards", lost, err)
+		}
+	}
+}
+
+func TestReconstructDataWithoutLossLeavesShardsAlone(t *testing.T) {
+	codec, err := NewCodec(3, 2)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+	shards := encoded(t, codec, 10, rand.New(rand.NewSource(2)))
+
+	// Parity shards are not rebuilt, so losing only those needs no work
+	damaged := without(shards, 0b11000)
+	if err = codec.ReconstructData(damaged); err != nil {
+		t.Fatalf("ReconstructData: %v", err)
+	}
+	if damaged[3] != nil || damaged[4] != nil {
+		t.Error("parity shards were rebuilt")
+	}
+}
+
+func TestEncodeIsSystematic(t *testing.T) {
+	codec, err := NewCodec(3, 2)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+
+	data := [][]byte{[]byte("abcd"), []byte("efgh"), []byte("ijkl")}
+	shards := [][]byte{
+		append([]byte(nil), data[0]...), append([]byte(nil), data[1]...), append([]byte(nil), data[2]...),
+		make([]byte, 4), make([]byte, 4),
+	}
+	if err = codec.Encode(shards); err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	for i := range data {
+		if !bytes.Equal(shards[i], data[i]) {
+			t.Errorf("data shard %d changed by Encode", i)
+		}
+	}
+}
+
+func TestEncodeRejectsBadShards(t *testing.T) {
+	codec, err := NewCodec(2, 1)
+	if err != nil {
+		t.Fatalf("NewCodec: %v", err)
+	}
+
+	if err = codec.Encode([][]byte{make([]byte, 4), make([]byte, 4)}); err == nil {
+		t.Error("too few shards: got no error")
+	}
+	if err = codec.Encode([][]byte{make([]byte, 4), make([]byte, 3), make([]byte, 4)}); !errors.Is(err, ErrShardSizeMismatch) {
+		t.Errorf("mismatched sizes: got %v, want ErrShardSizeMismatch", err)
+	}
+}
+
+func TestNewCodecRejectsBadLayouts(t *testing.T) {
+	tests := []struct {
+		name         string
+		data, parity int
+	}{
+		{"no data", 0, 2},
+		{"no parity", 3, 0},
+		{"too many shards", MaxShards, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCodec(tt.data, tt.parity); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
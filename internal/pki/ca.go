@@ -0,0 +1,286 @@
+// Package pki is the small certificate authority behind mutual TLS between the main server and the
+// device servers. The main server holds the CA, issues itself a certificate, and signs one for
+// every device when it enrolls. Certificates name their role in the organizational unit, so a
+// device certificate cannot pass for the main server.
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	RoleServer = "nebulo-server"
+	RoleDevice = "nebulo-device"
+
+	caCommonName   = "Nebulo CA"
+	caValidity     = 10 * 365 * 24 * time.Hour
+	caCertFileName = "ca.crt"
+	caKeyFileName  = "ca.key"
+	caDirPerm      = 0700
+	caKeyFilePerm  = 0600
+	caCertFilePerm = 0644
+	serialBits     = 128
+	backdate       = 5 * time.Minute // Tolerates clocks slightly behind the CA's
+)
+
+var errInvalidPEM = errors.New("no PEM data found")
+
+// CA signs the certificates of the main server and the devices
+type CA struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+}
+
+// LoadOrCreateCA loads the CA kept in dir, creating a new one there on first use
+func LoadOrCreateCA(dir string) (*CA, error) {
+	certPath := filepath.Join(dir, caCertFileName)
+	keyPath := filepath.Join(dir, caKeyFileName)
+
+	// #nosec G304 - the directory comes from the server's own configuration
+	certPEM, certErr := os.ReadFile(certPath)
+	// #nosec G304 - the directory comes from the server's own configuration
+	keyPEM, keyErr := os.ReadFile(keyPath)
+	switch {
+	case certErr == nil && keyErr == nil:
+		return parseCA(certPEM, keyPEM)
+	case errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist):
+		return createCA(certPath, keyPath)
+	case certErr != nil:
+		return nil, certErr
+	default:
+		return nil, keyErr
+	}
+}
+
+func createCA(certPath, keyPath string) (*CA, error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: caCommonName},
+		NotBefore:             now.Add(-backdate),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := EncodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	if err = os.MkdirAll(filepath.Dir(certPath), caDirPerm); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(keyPath, keyPEM, caKeyFilePerm); err != nil {
+		return nil, err
+	}
+	if err = os.WriteFile(certPath, certPEM, caCertFilePerm); err != nil {
+		return nil, err
+	}
+
+	return parseCA(certPEM, keyPEM)
+}
+
+func parseCA(certPEM, keyPEM []byte) (*CA, error) {
+	cert, err := ParseCertificate(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA certificate: %w", err)
+	}
+
+	key, err := ParseKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA key: %w", err)
+	}
+
+	return &CA{cert: cert, key: key, certPEM: certPEM}, nil
+}
+
+// CertificatePEM returns the CA's own certificate, which both sides trust
+func (ca *CA) CertificatePEM() []byte {
+	return ca.certPEM
+}
+
+// Pool returns a certificate pool holding only the CA
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// Subject describes whom a certificate is issued to
+type Subject struct {
+	CommonName string
+	Role       string
+	IPs        []net.IP
+	DNSNames   []string
+}
+
+// Sign issues a certificate for the key in the PEM-encoded certificate signing request. Only the
+// CSR's key is used; the subject and names come from subject.
+func (ca *CA) Sign(csrPEM []byte, subject Subject, validity time.Duration) (*x509.Certificate, []byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, errors.New("invalid certificate signing request")
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate signing request: %w", err)
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("invalid certificate signing request: %w", err)
+	}
+
+	return ca.issue(csr.PublicKey, subject, validity)
+}
+
+// Issue creates a key and a certificate for it, returning both PEM-encoded
+func (ca *CA) Issue(subject Subject, validity time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := NewKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, certPEM, err = ca.issue(key.Public(), subject, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err = EncodeKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certPEM, keyPEM, nil
+}
+
+func (ca *CA) issue(publicKey any, subject Subject, validity time.Duration) (*x509.Certificate, []byte, error) {
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(ca.cert.NotAfter) {
+		notAfter = ca.cert.NotAfter
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:         subject.CommonName,
+			OrganizationalUnit: []string{subject.Role},
+		},
+		NotBefore:   now.Add(-backdate),
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses: subject.IPs,
+		DNSNames:    subject.DNSNames,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+func newSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), serialBits))
+}
+
+// NewKey creates a private key for a certificate
+func NewKey() (*ecdsa.PrivateKey, error) {
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// NewCSR creates a PEM-encoded certificate signing request for key
+func NewCSR(key crypto.Signer, commonName string) ([]byte, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// EncodeKey PEM-encodes a private key
+func EncodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// ParseKey reads a PEM-encoded private key
+func ParseKey(keyPEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errInvalidPEM
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported private key type")
+	}
+	return signer, nil
+}
+
+// ParseCertificate reads a PEM-encoded certificate
+func ParseCertificate(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errInvalidPEM
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// SerialOf formats a certificate's serial number the way it is recorded and revoked
+func SerialOf(cert *x509.Certificate) string {
+	return cert.SerialNumber.Text(16)
+}
@@ -0,0 +1,36 @@
+package pki
+
+import (
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+)
+
+// DeviceAuthority issues device certificates from the CA
+type DeviceAuthority struct {
+	ca       *CA
+	validity time.Duration
+}
+
+func NewDeviceAuthority(ca *CA, validity time.Duration) *DeviceAuthority {
+	return &DeviceAuthority{
+		ca:       ca,
+		validity: validity,
+	}
+}
+
+// IssueDeviceCertificate names the certificate after the device's ID and makes it valid for the
+// address the main server reaches the device on
+func (a *DeviceAuthority) IssueDeviceCertificate(device *entities.Device, csrPEM []byte) (*entities.DeviceCertificate, error) {
+	cert, certPEM, err := a.ca.Sign(csrPEM, DeviceSubject(device.ID.Hex(), device.IPAddress), a.validity)
+	if err != nil {
+		return nil, err
+	}
+
+	return &entities.DeviceCertificate{
+		Serial:           SerialOf(cert),
+		CertificatePEM:   string(certPEM),
+		CACertificatePEM: string(a.ca.CertificatePEM()),
+		ExpiresAt:        cert.NotAfter,
+	}, nil
+}
@@ -0,0 +1,155 @@
+package pki
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"time"
+)
+
+var errNoCertificate = errors.New("no certificate issued yet")
+
+// Identity holds a certificate, and the CA it chains to, that can be replaced while connections
+// are being served. Devices get theirs at enrollment and replace it on every rotation.
+type Identity struct {
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	leaf *x509.Certificate
+	pool *x509.CertPool
+}
+
+func NewIdentity() *Identity {
+	return &Identity{}
+}
+
+// Set installs a PEM-encoded certificate with its key and the CA certificate it was issued by
+func (i *Identity) Set(certPEM, keyPEM, caPEM []byte) error {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return errors.New("invalid CA certificate")
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.cert, i.leaf, i.pool = &cert, leaf, pool
+	return nil
+}
+
+// Leaf returns the current certificate, or nil if there is none yet
+func (i *Identity) Leaf() *x509.Certificate {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.leaf
+}
+
+// Pool returns the CA pool peers are verified against, or nil if there is none yet
+func (i *Identity) Pool() *x509.CertPool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.pool
+}
+
+// NeedsRenewal reports whether there is no usable certificate, or it expires within renewBefore
+func (i *Identity) NeedsRenewal(renewBefore time.Duration) bool {
+	leaf := i.Leaf()
+	return leaf == nil || time.Now().Add(renewBefore).After(leaf.NotAfter)
+}
+
+func (i *Identity) certificate() (*tls.Certificate, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if i.cert == nil {
+		return nil, errNoCertificate
+	}
+	return i.cert, nil
+}
+
+// GetCertificate serves the current certificate to TLS clients
+func (i *Identity) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return i.certificate()
+}
+
+// GetClientCertificate presents the current certificate to TLS servers. An expired certificate is
+// left out, so the server can still be reached to renew it.
+func (i *Identity) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, err := i.certificate()
+	if err != nil || time.Now().After(cert.Leaf.NotAfter) {
+		return &tls.Certificate{}, nil
+	}
+	return cert, nil
+}
+
+// ServerIdentity is the main server's own identity. The main server holds the CA, so it reissues
+// its certificate itself whenever the current one gets within renewBefore of expiring.
+type ServerIdentity struct {
+	*Identity
+	ca          *CA
+	subject     Subject
+	validity    time.Duration
+	renewBefore time.Duration
+	renewMu     sync.Mutex
+}
+
+func NewServerIdentity(ca *CA, hosts []string, validity, renewBefore time.Duration) (*ServerIdentity, error) {
+	identity := &ServerIdentity{
+		Identity:    NewIdentity(),
+		ca:          ca,
+		subject:     hostSubject(RoleServer, RoleServer, hosts),
+		validity:    validity,
+		renewBefore: renewBefore,
+	}
+
+	if err := identity.renew(); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// CA returns the authority the identity is issued from
+func (s *ServerIdentity) CA() *CA {
+	return s.ca
+}
+
+func (s *ServerIdentity) renew() error {
+	certPEM, keyPEM, err := s.ca.Issue(s.subject, s.validity)
+	if err != nil {
+		return err
+	}
+	return s.Set(certPEM, keyPEM, s.ca.CertificatePEM())
+}
+
+// current rotates the certificate first if it is due
+func (s *ServerIdentity) current() (*tls.Certificate, error) {
+	if s.NeedsRenewal(s.renewBefore) {
+		s.renewMu.Lock()
+		// Another connection may have renewed it while this one waited
+		if s.NeedsRenewal(s.renewBefore) {
+			if err := s.renew(); err != nil {
+				s.renewMu.Unlock()
+				return nil, err
+			}
+		}
+		s.renewMu.Unlock()
+	}
+	return s.certificate()
+}
+
+func (s *ServerIdentity) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.current()
+}
+
+func (s *ServerIdentity) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return s.current()
+}
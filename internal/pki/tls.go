@@ -0,0 +1,153 @@
+package pki
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// revocationCheckTimeout bounds how long checking a peer certificate against the revoked ones may take
+const revocationCheckTimeout = 5 * time.Second
+
+var errRevoked = errors.New("peer certificate has been revoked")
+
+// RevocationChecker reports whether the certificate with the given serial has been revoked
+type RevocationChecker interface {
+	IsRevoked(ctx context.Context, serial string) (bool, error)
+}
+
+// MainServerTLSConfig serves the main server's API. Users connect without a client certificate;
+// devices present theirs, and the routes they call check it.
+func MainServerTLSConfig(identity *ServerIdentity) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: identity.GetCertificate,
+		ClientCAs:      identity.Pool(),
+		ClientAuth:     tls.VerifyClientCertIfGiven,
+	}
+}
+
+// DeviceClientTLSConfig is used by the main server to call device servers. It only talks to
+// peers holding a device certificate for the address it dialled; ForDevice narrows it down to
+// the one device being called.
+func DeviceClientTLSConfig(identity *ServerIdentity) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		RootCAs:              identity.Pool(),
+		GetClientCertificate: identity.GetClientCertificate,
+		VerifyConnection:     requireRole(RoleDevice),
+	}
+}
+
+// ForDevice returns a copy of config that, on every connection, also requires the peer's
+// certificate to be issued to the device with the given ID and not to have been revoked. Device
+// certificates are valid for the address the device claimed when it enrolled, so the address alone
+// does not tell one device from another claiming the same address.
+func ForDevice(config *tls.Config, deviceID string, revocations RevocationChecker) *tls.Config {
+	narrowed := config.Clone()
+	verifyRole := config.VerifyConnection
+	narrowed.VerifyConnection = func(state tls.ConnectionState) error {
+		if verifyRole != nil {
+			if err := verifyRole(state); err != nil {
+				return err
+			}
+		}
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("peer presented no certificate, expected device %s", deviceID)
+		}
+
+		leaf := state.PeerCertificates[0]
+		if leaf.Subject.CommonName != deviceID {
+			return fmt.Errorf("peer certificate belongs to device %s, expected %s", leaf.Subject.CommonName, deviceID)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), revocationCheckTimeout)
+		defer cancel()
+		revoked, err := revocations.IsRevoked(ctx, SerialOf(leaf))
+		if err != nil {
+			return fmt.Errorf("could not check the peer certificate against the revoked ones: %w", err)
+		}
+		if revoked {
+			return errRevoked
+		}
+		return nil
+	}
+	return narrowed
+}
+
+// DeviceServerTLSConfig serves a device server's internal API, to the main server only. The
+// certificate and CA are read per connection, as the device gets them at enrollment and replaces
+// the certificate on rotation.
+func DeviceServerTLSConfig(identity *Identity) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			pool := identity.Pool()
+			if pool == nil {
+				return nil, errNoCertificate
+			}
+
+			return &tls.Config{
+				MinVersion:       tls.VersionTLS12,
+				GetCertificate:   identity.GetCertificate,
+				ClientCAs:        pool,
+				ClientAuth:       tls.RequireAndVerifyClientCert,
+				VerifyConnection: requireRole(RoleServer),
+			}, nil
+		},
+	}
+}
+
+// AgentTLSConfig is used by a device's agent to call the main server, trusting roots, or the
+// system's roots when that is nil
+func AgentTLSConfig(identity *Identity, roots *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		MinVersion:           tls.VersionTLS12,
+		RootCAs:              roots,
+		GetClientCertificate: identity.GetClientCertificate,
+	}
+}
+
+// requireRole rejects peers whose verified certificate was issued for another role
+func requireRole(role string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("peer presented no certificate, expected %s", role)
+		}
+		if !slices.Contains(state.PeerCertificates[0].Subject.OrganizationalUnit, role) {
+			return fmt.Errorf("peer certificate is not a %s certificate", role)
+		}
+		return nil
+	}
+}
+
+// RoleOf returns the role a certificate was issued for
+func RoleOf(cert *x509.Certificate) string {
+	if len(cert.Subject.OrganizationalUnit) == 0 {
+		return ""
+	}
+	return cert.Subject.OrganizationalUnit[0]
+}
+
+// hostSubject builds a subject whose names cover hosts, which may be IP addresses or DNS names
+func hostSubject(commonName, role string, hosts []string) Subject {
+	subject := Subject{CommonName: commonName, Role: role}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			subject.IPs = append(subject.IPs, ip)
+		} else if host != "" {
+			subject.DNSNames = append(subject.DNSNames, host)
+		}
+	}
+	return subject
+}
+
+// DeviceSubject is the subject of a device's certificate, reachable at host
+func DeviceSubject(deviceID, host string) Subject {
+	return hostSubject(deviceID, RoleDevice, []string{host})
+}
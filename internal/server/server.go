@@ -2,20 +2,27 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/manab-pr/nebulo/config"
 	"github.com/manab-pr/nebulo/container"
+	"github.com/manab-pr/nebulo/internal/pki"
+	availabilityRoutes "github.com/manab-pr/nebulo/modules/availability/presentation/http/routes"
 	deviceRoutes "github.com/manab-pr/nebulo/modules/devices/presentation/http/routes"
 	fileRoutes "github.com/manab-pr/nebulo/modules/files/presentation/http/routes"
 	searchRoutes "github.com/manab-pr/nebulo/modules/search/presentation/http/routes"
 	storageRoutes "github.com/manab-pr/nebulo/modules/storage/presentation/http/routes"
 	transferRoutes "github.com/manab-pr/nebulo/modules/transfers/presentation/http/routes"
+	uploadRoutes "github.com/manab-pr/nebulo/modules/uploads/presentation/http/routes"
 	userRoutes "github.com/manab-pr/nebulo/modules/users/presentation/http/routes"
 
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
 
+// readHeaderTimeout bounds how long a client may take to send its request headers
+const readHeaderTimeout = 30 * time.Second
+
 type Server struct {
 	router    *gin.Engine
 	config    *config.Config
@@ -56,14 +63,28 @@ func (s *Server) SetupRoutes() {
 	v1 := s.router.Group("/api/v1")
 	// Setup module routes
 	userRoutes.SetupUserRoutes(v1, s.container.UserHandler)
-	deviceRoutes.SetupDeviceRoutes(v1, s.container.DeviceHandler)
+	deviceRoutes.SetupDeviceRoutes(v1, s.container.DeviceHandler, s.container.DeviceTokens)
 	fileRoutes.SetupFileRoutes(v1, s.container.FileHandler)
-	transferRoutes.SetupTransferRoutes(v1, s.container.TransferHandler)
+	transferRoutes.SetupTransferRoutes(v1, s.container.TransferHandler, s.container.DeviceTokens)
 	storageRoutes.SetupStorageRoutes(v1, s.container.StorageHandler)
 	searchRoutes.SetupSearchRoutes(v1, s.container.SearchHandler)
+	uploadRoutes.SetupUploadRoutes(v1, s.container.UploadHandler)
+	availabilityRoutes.SetupAvailabilityRoutes(v1, s.container.AvailabilityHandler)
 }
 
 func (s *Server) Run() error {
-	s.logger.Sugar().Infof("Starting server on port %s", s.config.Server.Port)
-	return s.router.Run(":" + s.config.Server.Port)
+	if s.container.ServerIdentity == nil {
+		s.logger.Sugar().Infof("Starting server on port %s", s.config.Server.Port)
+		return s.router.Run(":" + s.config.Server.Port)
+	}
+
+	httpServer := &http.Server{
+		Addr:              ":" + s.config.Server.Port,
+		Handler:           s.router,
+		TLSConfig:         pki.MainServerTLSConfig(s.container.ServerIdentity),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	s.logger.Sugar().Infof("Starting server on port %s with TLS", s.config.Server.Port)
+	return httpServer.ListenAndServeTLS("", "")
 }
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"strings"
 	"time"
@@ -14,14 +15,25 @@ import (
 type Claims struct {
 	UserID      string `json:"user_id"`
 	PhoneNumber string `json:"phone_number"`
+	DeviceID    string `json:"device_id,omitempty"` // Set on tokens issued to a device agent
+	// The device enrollment a device token was issued for; enrolling again makes older tokens stale
+	TokenGeneration int `json:"token_generation,omitempty"`
 	jwt.RegisteredClaims
 }
 
 const (
 	TokenKey             = "user_id"
+	DeviceTokenKey       = "device_id"
+	TokenGenerationKey   = "token_generation"
 	TokenExpirationHours = 24
 )
 
+// DeviceTokenValidator reports whether a device token is still current: whether its device still
+// exists and the token was issued for the device's latest enrollment
+type DeviceTokenValidator interface {
+	Execute(ctx context.Context, userID, deviceID string, generation int) (bool, error)
+}
+
 var cfg *config.Config
 
 func loadConfig() *config.Config {
@@ -33,41 +45,111 @@ func loadConfig() *config.Config {
 
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenString := c.GetHeader("Authorization")
-		if tokenString == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+		claims, ok := authenticate(c)
+		if !ok {
 			return
 		}
 
-		// Remove "Bearer " prefix
-		tokenString = strings.TrimPrefix(tokenString, "Bearer ")
-
-		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(_ *jwt.Token) (interface{}, error) {
-			return []byte(loadConfig().JWT.Secret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		// Device tokens outlive user tokens, so they are kept away from everything but the device's own calls
+		if claims.DeviceID != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Device tokens are not accepted here"})
 			c.Abort()
 			return
 		}
 
-		// Check if token is expired
-		if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
-			c.Abort()
+		// Set user information in context
+		c.Set(TokenKey, claims.UserID)
+		c.Set("phone_number", claims.PhoneNumber)
+		c.Next()
+	}
+}
+
+// DeviceAuthMiddleware accepts either a user token or a device agent's token. Device tokens must
+// carry an expiry and be current according to devices. For device tokens the device ID is
+// available through GetDeviceIDFromContext.
+func DeviceAuthMiddleware(devices DeviceTokenValidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := authenticate(c)
+		if !ok {
 			return
 		}
 
-		// Set user information in context
 		c.Set(TokenKey, claims.UserID)
-		c.Set("phone_number", claims.PhoneNumber)
+		if claims.DeviceID != "" {
+			// Tokens issued before device tokens expired are not accepted anymore
+			if claims.ExpiresAt == nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Device token has no expiry, enroll the device again"})
+				c.Abort()
+				return
+			}
+			current, err := devices.Execute(c.Request.Context(), claims.UserID, claims.DeviceID, claims.TokenGeneration)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check device token"})
+				c.Abort()
+				return
+			}
+			if !current {
+				// Agents take this as their cue to enroll again
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Device token is no longer valid"})
+				c.Abort()
+				return
+			}
+
+			c.Set(DeviceTokenKey, claims.DeviceID)
+			c.Set(TokenGenerationKey, claims.TokenGeneration)
+		} else {
+			c.Set("phone_number", claims.PhoneNumber)
+		}
+		c.Next()
+	}
+}
+
+// RequireOwnDevice keeps device tokens to routes about their own device, named by the param path
+// parameter. It runs after DeviceAuthMiddleware; user tokens pass through.
+func RequireOwnDevice(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if deviceID, isDevice := GetDeviceIDFromContext(c); isDevice && deviceID != c.Param(param) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Device token does not belong to this device"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
 
+// authenticate validates the request's bearer token, aborting with 401 when it is missing or invalid
+func authenticate(c *gin.Context) (*Claims, bool) {
+	tokenString := c.GetHeader("Authorization")
+	if tokenString == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+		c.Abort()
+		return nil, false
+	}
+
+	// Remove "Bearer " prefix
+	tokenString = strings.TrimPrefix(tokenString, "Bearer ")
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(_ *jwt.Token) (interface{}, error) {
+		return []byte(loadConfig().JWT.Secret), nil
+	})
+
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return nil, false
+	}
+
+	// Check if token is expired
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token expired"})
+		c.Abort()
+		return nil, false
+	}
+
+	return claims, true
+}
+
 func GenerateToken(userID, phoneNumber string) (tokenString string, expiresAt int64, err error) {
 	expirationTime := time.Now().Add(TokenExpirationHours * time.Hour)
 	claims := &Claims{
@@ -88,6 +170,30 @@ func GenerateToken(userID, phoneNumber string) (tokenString string, expiresAt in
 	return tokenString, expirationTime.Unix(), nil
 }
 
+// GenerateDeviceToken issues the credential a device agent uses for its heartbeats, for the
+// device's enrollment generation. It expires after the configured device token TTL, and stops
+// working before then once the device is deleted or enrolls again.
+func GenerateDeviceToken(userID, deviceID string, generation int) (tokenString string, expiresAt time.Time, err error) {
+	now := time.Now()
+	expiresAt = now.Add(loadConfig().Device.TokenTTL)
+	claims := &Claims{
+		UserID:          userID,
+		DeviceID:        deviceID,
+		TokenGeneration: generation,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err = token.SignedString([]byte(loadConfig().JWT.Secret))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return tokenString, expiresAt, nil
+}
+
 func GetUserIDFromContext(c *gin.Context) (string, bool) {
 	userID, exists := c.Get(TokenKey)
 	if !exists {
@@ -95,3 +201,22 @@ func GetUserIDFromContext(c *gin.Context) (string, bool) {
 	}
 	return userID.(string), true
 }
+
+// GetTokenGenerationFromContext returns the enrollment generation of the device token a request was
+// authenticated with, if it used one
+func GetTokenGenerationFromContext(c *gin.Context) (int, bool) {
+	generation, exists := c.Get(TokenGenerationKey)
+	if !exists {
+		return 0, false
+	}
+	return generation.(int), true
+}
+
+// GetDeviceIDFromContext returns the device a request was authenticated as, if it used a device token
+func GetDeviceIDFromContext(c *gin.Context) (string, bool) {
+	deviceID, exists := c.Get(DeviceTokenKey)
+	if !exists {
+		return "", false
+	}
+	return deviceID.(string), true
+}
@@ -0,0 +1,50 @@
+package entities
+
+import "time"
+
+// Risk is how close a file is to becoming unreadable
+type Risk string
+
+const (
+	RiskDegraded    Risk = "degraded"    // Readable, but with fewer healthy copies than it was stored with
+	RiskUnavailable Risk = "unavailable" // Not readable until an offline or failed device comes back
+	RiskLost        Risk = "lost"        // Too few copies remain to ever read it again
+)
+
+// AtRiskReport lists a user's files that have lost some or all of their redundancy
+type AtRiskReport struct {
+	DegradedFiles    int           `json:"degraded_files"`
+	UnavailableFiles int           `json:"unavailable_files"`
+	LostFiles        int           `json:"lost_files"`
+	Files            []*AtRiskFile `json:"files"`
+}
+
+type AtRiskFile struct {
+	FileID          string     `json:"file_id"`
+	Name            string     `json:"name"`
+	Size            int64      `json:"size"`
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"status_reason,omitempty"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+	Risk            Risk       `json:"risk"`
+
+	// Healthy copies are stored on online devices; Required of them are needed to read the file
+	// and Total is how many it was stored with. Tolerance is how many more devices can go away
+	// before the file becomes unreadable, negative once it already is.
+	Required   int                `json:"required"`
+	Healthy    int                `json:"healthy"`
+	Total      int                `json:"total"`
+	Tolerance  int                `json:"tolerance"`
+	Placements []*PlacementHealth `json:"placements"`
+}
+
+// PlacementHealth is the state of one replica or shard and of the device holding it
+type PlacementHealth struct {
+	Index        *int      `json:"index,omitempty"` // Shard index, for erasure-coded files
+	DeviceID     string    `json:"device_id"`
+	DeviceName   string    `json:"device_name,omitempty"`
+	DeviceStatus string    `json:"device_status"` // "removed" once the device has been deleted
+	Status       string    `json:"status"`
+	StatusReason string    `json:"status_reason,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
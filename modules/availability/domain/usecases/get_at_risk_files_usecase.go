@@ -0,0 +1,172 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/manab-pr/nebulo/modules/availability/domain/entities"
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// removedDeviceStatus is reported for placements whose device no longer exists
+const removedDeviceStatus = "removed"
+
+// riskOrder sorts the report with the worst-off files first
+var riskOrder = map[entities.Risk]int{
+	entities.RiskLost:        0,
+	entities.RiskUnavailable: 1,
+	entities.RiskDegraded:    2,
+}
+
+type GetAtRiskFilesUseCase struct {
+	fileRepo   fileRepository.FileRepository
+	deviceRepo deviceRepository.DeviceRepository
+}
+
+func NewGetAtRiskFilesUseCase(
+	fileRepo fileRepository.FileRepository, deviceRepo deviceRepository.DeviceRepository,
+) *GetAtRiskFilesUseCase {
+	return &GetAtRiskFilesUseCase{
+		fileRepo:   fileRepo,
+		deviceRepo: deviceRepo,
+	}
+}
+
+// Execute reports the user's files that have lost some or all of their redundancy. Device states
+// are read live, so a device that just went away shows up before the next availability sweep.
+func (uc *GetAtRiskFilesUseCase) Execute(ctx context.Context, userID string) (*entities.AtRiskReport, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	files, err := uc.fileRepo.GetAllByUser(ctx, userObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := uc.deviceRepo.GetAllByUser(ctx, userObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	devicesByID := make(map[primitive.ObjectID]*deviceEntities.Device, len(devices))
+	for _, device := range devices {
+		devicesByID[device.ID] = device
+	}
+
+	report := &entities.AtRiskReport{Files: []*entities.AtRiskFile{}}
+	for _, file := range files {
+		switch file.Status {
+		case fileEntities.FileStatusStored, fileEntities.FileStatusUnavailable, fileEntities.FileStatusLost:
+		default:
+			continue
+		}
+
+		atRisk := assessFile(file, devicesByID)
+		if atRisk == nil {
+			continue
+		}
+
+		switch atRisk.Risk {
+		case entities.RiskDegraded:
+			report.DegradedFiles++
+		case entities.RiskUnavailable:
+			report.UnavailableFiles++
+		case entities.RiskLost:
+			report.LostFiles++
+		}
+		report.Files = append(report.Files, atRisk)
+	}
+
+	sort.SliceStable(report.Files, func(i, j int) bool {
+		a, b := report.Files[i], report.Files[j]
+		if riskOrder[a.Risk] != riskOrder[b.Risk] {
+			return riskOrder[a.Risk] < riskOrder[b.Risk]
+		}
+		return a.Tolerance < b.Tolerance
+	})
+
+	return report, nil
+}
+
+// assessFile returns the file's entry in the report, or nil when all of its copies are healthy
+func assessFile(file *fileEntities.File, devices map[primitive.ObjectID]*deviceEntities.Device) *entities.AtRiskFile {
+	placements := placementsOf(file)
+
+	atRisk := &entities.AtRiskFile{
+		FileID:       file.ID.Hex(),
+		Name:         file.OriginalName,
+		Size:         file.Size,
+		Status:       string(file.Status),
+		StatusReason: file.StatusReason,
+		Required:     requiredPlacements(file),
+		Total:        len(placements),
+		Placements:   make([]*entities.PlacementHealth, 0, len(placements)),
+	}
+	if !file.StatusChangedAt.IsZero() {
+		atRisk.StatusChangedAt = &file.StatusChangedAt
+	}
+
+	recoverable := 0
+	for _, p := range placements {
+		device := devices[p.deviceID]
+		status, reason := *p.status, *p.reason
+
+		// Reflect device changes the tracker has not picked up yet
+		if followsDevice(status) {
+			if health, healthReason := deviceHealth(device); health != fileEntities.ReplicaStatusStored {
+				status, reason = health, healthReason
+			}
+		}
+
+		switch status {
+		case fileEntities.ReplicaStatusStored:
+			atRisk.Healthy++
+			recoverable++
+		case fileEntities.ReplicaStatusUnavailable:
+			recoverable++
+		}
+
+		atRisk.Placements = append(atRisk.Placements, placementHealth(p, device, status, reason))
+	}
+
+	atRisk.Tolerance = atRisk.Healthy - atRisk.Required
+	switch {
+	case file.Status == fileEntities.FileStatusLost || recoverable < atRisk.Required:
+		atRisk.Risk = entities.RiskLost
+	case atRisk.Healthy < atRisk.Required:
+		atRisk.Risk = entities.RiskUnavailable
+	case atRisk.Healthy < atRisk.Total:
+		atRisk.Risk = entities.RiskDegraded
+	default:
+		return nil
+	}
+
+	return atRisk
+}
+
+func placementHealth(
+	p placement, device *deviceEntities.Device, status fileEntities.ReplicaStatus, reason string,
+) *entities.PlacementHealth {
+	health := &entities.PlacementHealth{
+		Index:        p.index,
+		DeviceID:     p.deviceID.Hex(),
+		DeviceStatus: removedDeviceStatus,
+		Status:       string(status),
+		StatusReason: reason,
+		UpdatedAt:    *p.updatedAt,
+	}
+
+	if device != nil {
+		health.DeviceName = device.Name
+		health.DeviceStatus = string(device.Status)
+	}
+	return health
+}
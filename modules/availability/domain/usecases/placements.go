@@ -0,0 +1,130 @@
+package usecases
+
+import (
+	"fmt"
+	"time"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// placement is one replica or shard of a file. It points into the file record, so setting its
+// state updates the file in place.
+type placement struct {
+	index     *int // Shard index, nil for replicas
+	deviceID  primitive.ObjectID
+	objectID  string // Name of the object on the device
+	size      int64  // Expected size of the object
+	checksum  string // Expected checksum of the object, empty when unknown
+	status    *fileEntities.ReplicaStatus
+	reason    *string
+	updatedAt *time.Time
+}
+
+// set moves the placement to a new state and reports whether anything changed
+func (p placement) set(status fileEntities.ReplicaStatus, reason string) bool {
+	if *p.status == status && *p.reason == reason {
+		return false
+	}
+
+	*p.status = status
+	*p.reason = reason
+	*p.updatedAt = time.Now()
+	return true
+}
+
+// placementsOf returns the file's shards, or its replicas. The implicit replica of a file stored
+// before replication was tracked is written into Replicas so its state can be recorded.
+func placementsOf(file *fileEntities.File) []placement {
+	var placements []placement
+
+	if file.Erasure != nil {
+		for i := range file.Shards {
+			shard := &file.Shards[i]
+			placements = append(placements, placement{
+				index:     &shard.Index,
+				deviceID:  shard.DeviceID,
+				objectID:  file.ShardObjectID(shard.Index),
+				size:      file.Erasure.ShardSize,
+				status:    &shard.Status,
+				reason:    &shard.StatusReason,
+				updatedAt: &shard.UpdatedAt,
+			})
+		}
+		return placements
+	}
+
+	file.Replicas = file.AllReplicas()
+	for i := range file.Replicas {
+		replica := &file.Replicas[i]
+		placements = append(placements, placement{
+			deviceID:  replica.DeviceID,
+			objectID:  file.ID.Hex(),
+			size:      file.Size,
+			checksum:  file.Checksum,
+			status:    &replica.Status,
+			reason:    &replica.StatusReason,
+			updatedAt: &replica.UpdatedAt,
+		})
+	}
+	return placements
+}
+
+// requiredPlacements is how many stored placements it takes to read the file
+func requiredPlacements(file *fileEntities.File) int {
+	if file.Erasure != nil {
+		return file.Erasure.DataShards
+	}
+	return 1
+}
+
+// followsDevice reports whether a placement's state tracks the health of its device. Pending,
+// failed, corrupted and lost copies are not expected to come back by themselves.
+func followsDevice(status fileEntities.ReplicaStatus) bool {
+	return status == fileEntities.ReplicaStatusStored || status == fileEntities.ReplicaStatusUnavailable
+}
+
+// deviceHealth returns the state the copies on a device should be in, and why when they cannot
+// be read. device is nil once it has been removed.
+func deviceHealth(device *deviceEntities.Device) (fileEntities.ReplicaStatus, string) {
+	switch {
+	case device == nil:
+		return fileEntities.ReplicaStatusLost, "device was removed"
+	case device.Status == deviceEntities.DeviceStatusFailed:
+		return fileEntities.ReplicaStatusUnavailable, "device failed"
+	case device.Status != deviceEntities.DeviceStatusOnline:
+		return fileEntities.ReplicaStatusUnavailable, fmt.Sprintf("device is %s", device.Status)
+	}
+	return fileEntities.ReplicaStatusStored, ""
+}
+
+// fileAvailability derives a file's status from the states of its placements
+func fileAvailability(file *fileEntities.File, placements []placement) (fileEntities.FileStatus, string) {
+	var stored, unavailable int
+	for _, p := range placements {
+		switch *p.status {
+		case fileEntities.ReplicaStatusStored:
+			stored++
+		case fileEntities.ReplicaStatusUnavailable:
+			unavailable++
+		}
+	}
+
+	kind := "replicas"
+	if file.Erasure != nil {
+		kind = "shards"
+	}
+
+	required := requiredPlacements(file)
+	switch {
+	case stored >= required:
+		return fileEntities.FileStatusStored, ""
+	case stored+unavailable >= required:
+		return fileEntities.FileStatusUnavailable, fmt.Sprintf(
+			"%d of the %d %s needed are reachable, %d more are on offline or failed devices", stored, required, kind, unavailable,
+		)
+	}
+	return fileEntities.FileStatusLost, fmt.Sprintf("only %d of the %d %s needed remain", stored+unavailable, required, kind)
+}
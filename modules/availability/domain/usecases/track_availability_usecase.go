@@ -0,0 +1,196 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// verifyTimeout bounds how long re-verifying one copy may take, so a device that accepts
+	// connections but never answers cannot stall a sweep
+	verifyTimeout = 10 * time.Second
+	// sweepPageSize is how many files a sweep loads at a time
+	sweepPageSize = 500
+)
+
+// TrackAvailabilityUseCase keeps files in step with the health of the devices holding them.
+// Copies on offline or failed devices become unavailable and copies on removed devices are lost;
+// the file follows once too few copies remain readable. When a device comes back, its copies are
+// re-verified on the device before they count as stored again.
+type TrackAvailabilityUseCase struct {
+	fileRepo      fileRepository.FileRepository
+	deviceRepo    deviceRepository.DeviceRepository
+	deviceStorage fileRepository.DeviceStorageRepository
+	logger        *zap.Logger
+}
+
+func NewTrackAvailabilityUseCase(
+	fileRepo fileRepository.FileRepository,
+	deviceRepo deviceRepository.DeviceRepository,
+	deviceStorage fileRepository.DeviceStorageRepository,
+	logger *zap.Logger,
+) *TrackAvailabilityUseCase {
+	return &TrackAvailabilityUseCase{
+		fileRepo:      fileRepo,
+		deviceRepo:    deviceRepo,
+		deviceStorage: deviceStorage,
+		logger:        logger,
+	}
+}
+
+// Run sweeps every interval until ctx is cancelled
+func (uc *TrackAvailabilityUseCase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := uc.Execute(ctx); err != nil && ctx.Err() == nil {
+			uc.logger.Warn("Availability sweep incomplete", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Execute checks every stored or unavailable file once against the current device states. Files
+// are paged through one user after another, so only one page and one user's devices are held at a time.
+func (uc *TrackAvailabilityUseCase) Execute(ctx context.Context) error {
+	var afterUserID, afterID primitive.ObjectID
+	var devices map[primitive.ObjectID]*deviceEntities.Device
+	var devicesOf primitive.ObjectID // The user devices belongs to
+
+	var checked, failed int
+	var firstErr error
+	for {
+		files, err := uc.fileRepo.GetPageByStatus(
+			ctx, afterUserID, afterID, sweepPageSize, fileEntities.FileStatusStored, fileEntities.FileStatusUnavailable,
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, file := range files {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			afterUserID, afterID = file.UserID, file.ID
+			checked++
+
+			var checkErr error
+			if devices == nil || devicesOf != file.UserID {
+				devices, checkErr = uc.userDevices(ctx, file.UserID)
+				devicesOf = file.UserID
+			}
+
+			if checkErr == nil {
+				checkErr = uc.checkFile(ctx, file, devices)
+			}
+			if checkErr != nil {
+				failed++
+				if firstErr == nil {
+					firstErr = checkErr
+				}
+			}
+		}
+
+		if len(files) < sweepPageSize {
+			break
+		}
+	}
+
+	if firstErr != nil {
+		return fmt.Errorf("%d of %d files could not be checked: %w", failed, checked, firstErr)
+	}
+	return nil
+}
+
+func (uc *TrackAvailabilityUseCase) userDevices(
+	ctx context.Context, userID primitive.ObjectID,
+) (map[primitive.ObjectID]*deviceEntities.Device, error) {
+	devices, err := uc.deviceRepo.GetAllByUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]*deviceEntities.Device, len(devices))
+	for _, device := range devices {
+		byID[device.ID] = device
+	}
+	return byID, nil
+}
+
+// checkFile brings the file's placements and status up to date and saves them if they changed
+func (uc *TrackAvailabilityUseCase) checkFile(
+	ctx context.Context, file *fileEntities.File, devices map[primitive.ObjectID]*deviceEntities.Device,
+) error {
+	placements := placementsOf(file)
+
+	changed := false
+	for _, p := range placements {
+		if !followsDevice(*p.status) {
+			continue
+		}
+
+		device := devices[p.deviceID]
+		status, reason := deviceHealth(device)
+		if status == fileEntities.ReplicaStatusStored && *p.status == fileEntities.ReplicaStatusUnavailable {
+			status, reason = uc.verify(ctx, device, p)
+		}
+
+		if p.set(status, reason) {
+			changed = true
+		}
+	}
+
+	status, reason := fileAvailability(file, placements)
+	if status != file.Status {
+		file.StatusChangedAt = time.Now()
+		changed = true
+	}
+	if !changed && reason == file.StatusReason {
+		return nil
+	}
+
+	file.Status = status
+	file.StatusReason = reason
+
+	// A file that changed in the meantime is skipped; the next sweep looks at it again
+	_, err := uc.fileRepo.UpdatePlacements(ctx, file)
+	return err
+}
+
+// verify checks that a copy on a device that has come back is still there and unchanged
+func (uc *TrackAvailabilityUseCase) verify(
+	ctx context.Context, device *deviceEntities.Device, p placement,
+) (fileEntities.ReplicaStatus, string) {
+	verifyCtx, cancel := context.WithTimeout(ctx, verifyTimeout)
+	defer cancel()
+
+	object, err := uc.deviceStorage.StatFile(verifyCtx, device, p.objectID)
+	switch {
+	case errors.Is(err, fileRepository.ErrObjectNotFound):
+		return fileEntities.ReplicaStatusLost, "missing from the device when re-verified"
+	case err != nil:
+		return fileEntities.ReplicaStatusUnavailable, fmt.Sprintf("device is back but could not be re-verified: %v", err)
+	case object.Size >= 0 && object.Size != p.size:
+		return fileEntities.ReplicaStatusCorrupted, fmt.Sprintf("device holds %d bytes, expected %d", object.Size, p.size)
+	case p.checksum != "" && object.Checksum != "" && object.Checksum != p.checksum:
+		return fileEntities.ReplicaStatusCorrupted, "checksum on the device does not match the file"
+	}
+
+	return fileEntities.ReplicaStatusStored, ""
+}
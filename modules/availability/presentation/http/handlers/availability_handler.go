@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/manab-pr/nebulo/modules/auth/middleware"
+	"github.com/manab-pr/nebulo/modules/availability/domain/usecases"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AvailabilityHandler struct {
+	getAtRiskFilesUseCase *usecases.GetAtRiskFilesUseCase
+}
+
+func NewAvailabilityHandler(getAtRiskFilesUseCase *usecases.GetAtRiskFilesUseCase) *AvailabilityHandler {
+	return &AvailabilityHandler{
+		getAtRiskFilesUseCase: getAtRiskFilesUseCase,
+	}
+}
+
+// GetAtRiskFiles handles the report of files with reduced or no redundancy left
+func (h *AvailabilityHandler) GetAtRiskFiles(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	report, err := h.getAtRiskFilesUseCase.Execute(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "At-risk files retrieved successfully",
+		"data":    report,
+	})
+}
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"github.com/manab-pr/nebulo/internal/constants"
+	"github.com/manab-pr/nebulo/modules/auth/middleware"
+	"github.com/manab-pr/nebulo/modules/availability/presentation/http/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupAvailabilityRoutes(router *gin.RouterGroup, handler *handlers.AvailabilityHandler) {
+	files := router.Group(constants.AvailabilityBaseRoute)
+	files.Use(middleware.AuthMiddleware())
+	files.GET(constants.GetAtRiskFilesRoute, handler.GetAtRiskFiles)
+}
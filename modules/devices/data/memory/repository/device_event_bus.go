@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"context"
+	"sync"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind by before it misses some
+const subscriberBuffer = 32
+
+// MemoryDeviceEventBus delivers events to the devices connected to this main server only. It is
+// used when there is no Redis to share events between main servers.
+type MemoryDeviceEventBus struct {
+	mu          sync.Mutex
+	subscribers map[primitive.ObjectID]map[chan *entities.DeviceEvent]struct{}
+}
+
+func NewMemoryDeviceEventBus() *MemoryDeviceEventBus {
+	return &MemoryDeviceEventBus{
+		subscribers: make(map[primitive.ObjectID]map[chan *entities.DeviceEvent]struct{}),
+	}
+}
+
+func (b *MemoryDeviceEventBus) Publish(_ context.Context, event *entities.DeviceEvent) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subscribers[event.DeviceID] {
+		select {
+		case events <- event:
+		default: // Too far behind; it catches up when it reconnects
+		}
+	}
+	return nil
+}
+
+func (b *MemoryDeviceEventBus) Subscribe(ctx context.Context, deviceID primitive.ObjectID) (<-chan *entities.DeviceEvent, error) {
+	events := make(chan *entities.DeviceEvent, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[deviceID] == nil {
+		b.subscribers[deviceID] = make(map[chan *entities.DeviceEvent]struct{})
+	}
+	b.subscribers[deviceID][events] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers[deviceID], events)
+		if len(b.subscribers[deviceID]) == 0 {
+			delete(b.subscribers, deviceID)
+		}
+		close(events)
+	}()
+	return events, nil
+}
@@ -17,10 +17,28 @@ type DeviceModel struct {
 	TotalStorage     int64              `bson:"total_storage"`
 	AvailableStorage int64              `bson:"available_storage"`
 	UsedStorage      int64              `bson:"used_storage"`
+	ReservedStorage  int64              `bson:"reserved_storage"`
+	Secret           string             `bson:"secret,omitempty"`
+	TokenGeneration  int                `bson:"token_generation"`
 	Status           string             `bson:"status"`
+	StatusChangedAt  time.Time          `bson:"status_changed_at,omitempty"`
 	LastHeartbeat    time.Time          `bson:"last_heartbeat"`
+	DrainingSince    *time.Time         `bson:"draining_since,omitempty"`
+	Labels           map[string]string  `bson:"labels,omitempty"`
+	LastPlacedAt     time.Time          `bson:"last_placed_at,omitempty"`
 	CreatedAt        time.Time          `bson:"created_at"`
 	UpdatedAt        time.Time          `bson:"updated_at"`
+
+	CertificateSerial    string    `bson:"certificate_serial,omitempty"`
+	CertificateExpiresAt time.Time `bson:"certificate_expires_at,omitempty"`
+
+	Reservations []ReservationModel `bson:"reservations,omitempty"`
+}
+
+type ReservationModel struct {
+	FileID    primitive.ObjectID `bson:"file_id"`
+	Size      int64              `bson:"size"`
+	ExpiresAt time.Time          `bson:"expires_at"`
 }
 
 func (d *DeviceModel) ToEntity() *entities.Device {
@@ -33,10 +51,22 @@ func (d *DeviceModel) ToEntity() *entities.Device {
 		TotalStorage:     d.TotalStorage,
 		AvailableStorage: d.AvailableStorage,
 		UsedStorage:      d.UsedStorage,
+		ReservedStorage:  d.ReservedStorage,
+		Secret:           d.Secret,
+		TokenGeneration:  d.TokenGeneration,
 		Status:           entities.DeviceStatus(d.Status),
+		StatusChangedAt:  d.StatusChangedAt,
 		LastHeartbeat:    d.LastHeartbeat,
+		DrainingSince:    d.DrainingSince,
+		Labels:           d.Labels,
+		LastPlacedAt:     d.LastPlacedAt,
 		CreatedAt:        d.CreatedAt,
 		UpdatedAt:        d.UpdatedAt,
+
+		CertificateSerial:    d.CertificateSerial,
+		CertificateExpiresAt: d.CertificateExpiresAt,
+
+		Reservations: reservationsToEntity(d.Reservations),
 	}
 }
 
@@ -50,9 +80,53 @@ func FromEntity(device *entities.Device) *DeviceModel {
 		TotalStorage:     device.TotalStorage,
 		AvailableStorage: device.AvailableStorage,
 		UsedStorage:      device.UsedStorage,
+		ReservedStorage:  device.ReservedStorage,
+		Secret:           device.Secret,
+		TokenGeneration:  device.TokenGeneration,
 		Status:           string(device.Status),
+		StatusChangedAt:  device.StatusChangedAt,
 		LastHeartbeat:    device.LastHeartbeat,
+		DrainingSince:    device.DrainingSince,
+		Labels:           device.Labels,
+		LastPlacedAt:     device.LastPlacedAt,
 		CreatedAt:        device.CreatedAt,
 		UpdatedAt:        device.UpdatedAt,
+
+		CertificateSerial:    device.CertificateSerial,
+		CertificateExpiresAt: device.CertificateExpiresAt,
+
+		Reservations: reservationsFromEntity(device.Reservations),
+	}
+}
+
+func reservationsFromEntity(reservations []entities.StorageReservation) []ReservationModel {
+	if reservations == nil {
+		return nil
+	}
+
+	models := make([]ReservationModel, len(reservations))
+	for i, reservation := range reservations {
+		models[i] = ReservationModel{
+			FileID:    reservation.FileID,
+			Size:      reservation.Size,
+			ExpiresAt: reservation.ExpiresAt,
+		}
+	}
+	return models
+}
+
+func reservationsToEntity(models []ReservationModel) []entities.StorageReservation {
+	if models == nil {
+		return nil
+	}
+
+	reservations := make([]entities.StorageReservation, len(models))
+	for i, reservation := range models {
+		reservations[i] = entities.StorageReservation{
+			FileID:    reservation.FileID,
+			Size:      reservation.Size,
+			ExpiresAt: reservation.ExpiresAt,
+		}
 	}
+	return reservations
 }
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoCertificateRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoCertificateRepository(db *mongo.Database) *MongoCertificateRepository {
+	return &MongoCertificateRepository{
+		collection: db.Collection("revoked_certificates"),
+	}
+}
+
+// Revoke records the revocation; revoking a certificate twice keeps the first record
+func (r *MongoCertificateRepository) Revoke(ctx context.Context, revoked *entities.RevokedCertificate) error {
+	_, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": revoked.Serial},
+		bson.M{"$setOnInsert": revoked},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *MongoCertificateRepository) IsRevoked(ctx context.Context, serial string) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"_id": serial}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
@@ -108,35 +108,255 @@ func (r *MongoDeviceRepository) Update(ctx context.Context, device *entities.Dev
 	return err
 }
 
+func (r *MongoDeviceRepository) Reenroll(ctx context.Context, device *entities.Device) error {
+	update := bson.M{
+		"$set": bson.M{
+			"name":                   device.Name,
+			"type":                   device.Type,
+			"total_storage":          device.TotalStorage,
+			"labels":                 device.Labels,
+			"secret":                 device.Secret,
+			"token_generation":       device.TokenGeneration,
+			"status":                 string(device.Status),
+			"status_changed_at":      device.StatusChangedAt,
+			"certificate_serial":     device.CertificateSerial,
+			"certificate_expires_at": device.CertificateExpiresAt,
+			"updated_at":             time.Now(),
+		},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": device.ID, "user_id": device.UserID}, update)
+	return err
+}
+
 func (r *MongoDeviceRepository) UpdateHeartbeat(
 	ctx context.Context, userID, deviceID primitive.ObjectID, availableStorage, usedStorage int64,
 ) error {
-	update := bson.M{
-		"$set": bson.M{
-			"available_storage": availableStorage,
+	now := time.Now()
+	// Runs as a pipeline so the reservations are reconciled in the same write they are read in
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"reservations": bson.M{"$filter": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$reservations", bson.A{}}},
+				"cond":  bson.M{"$gt": bson.A{"$$this.expires_at", now}},
+			}},
+		}},
+		bson.M{"$set": bson.M{
+			"reserved_storage": bson.M{"$sum": "$reservations.size"},
+		}},
+		bson.M{"$set": bson.M{
+			"available_storage": bson.M{"$max": bson.A{bson.M{"$subtract": bson.A{availableStorage, "$reserved_storage"}}, 0}},
 			"used_storage":      usedStorage,
-			"last_heartbeat":    time.Now(),
-			"updated_at":        time.Now(),
-		},
+			"last_heartbeat":    now,
+			"updated_at":        now,
+		}},
 	}
 
 	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": deviceID, "user_id": userID}, update)
 	return err
 }
 
+func (r *MongoDeviceRepository) Reserve(
+	ctx context.Context, userID, deviceID, fileID primitive.ObjectID, size int64, expiresAt time.Time,
+) (bool, error) {
+	filter := bson.M{
+		"_id":                  deviceID,
+		"user_id":              userID,
+		"available_storage":    bson.M{"$gte": size},
+		"reservations.file_id": bson.M{"$ne": fileID},
+	}
+	update := bson.M{
+		"$inc":  bson.M{"available_storage": -size, "reserved_storage": size},
+		"$push": bson.M{"reservations": model.ReservationModel{FileID: fileID, Size: size, ExpiresAt: expiresAt}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *MongoDeviceRepository) ExtendReservation(
+	ctx context.Context, userID, deviceID, fileID primitive.ObjectID, expiresAt time.Time,
+) (bool, error) {
+	filter := bson.M{"_id": deviceID, "user_id": userID, "reservations.file_id": fileID}
+	update := bson.M{
+		"$set": bson.M{"reservations.$.expires_at": expiresAt, "updated_at": time.Now()},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *MongoDeviceRepository) ReleaseReservation(ctx context.Context, userID, deviceID, fileID primitive.ObjectID) error {
+	return r.settleReservation(ctx, userID, deviceID, fileID, "available_storage")
+}
+
+func (r *MongoDeviceRepository) ConfirmReservation(ctx context.Context, userID, deviceID, fileID primitive.ObjectID) error {
+	return r.settleReservation(ctx, userID, deviceID, fileID, "used_storage")
+}
+
+// settleReservation drops the file's reservation on the device, moving the space it held from
+// reserved_storage to field. A file without a reservation on the device is left alone.
+func (r *MongoDeviceRepository) settleReservation(
+	ctx context.Context, userID, deviceID, fileID primitive.ObjectID, field string,
+) error {
+	filter := bson.M{"_id": deviceID, "user_id": userID, "reservations.file_id": fileID}
+	isFile := bson.M{"$eq": bson.A{"$$this.file_id", fileID}}
+	update := bson.A{
+		bson.M{"$set": bson.M{
+			"settled": bson.M{"$sum": bson.M{"$map": bson.M{
+				"input": bson.M{"$filter": bson.M{"input": "$reservations", "cond": isFile}},
+				"in":    "$$this.size",
+			}}},
+		}},
+		bson.M{"$set": bson.M{
+			field:              bson.M{"$add": bson.A{"$" + field, "$settled"}},
+			"reserved_storage": bson.M{"$subtract": bson.A{"$reserved_storage", "$settled"}},
+			"reservations":     bson.M{"$filter": bson.M{"input": "$reservations", "cond": bson.M{"$not": bson.A{isFile}}}},
+			"updated_at":       time.Now(),
+		}},
+		bson.M{"$unset": "settled"},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
 func (r *MongoDeviceRepository) Delete(ctx context.Context, userID, deviceID primitive.ObjectID) error {
 	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": deviceID, "user_id": userID})
 	return err
 }
 
 func (r *MongoDeviceRepository) UpdateStatus(ctx context.Context, userID, deviceID primitive.ObjectID, status entities.DeviceStatus) error {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"status":            string(status),
+			"status_changed_at": now,
+			"updated_at":        now,
+		},
+	}
+
+	// Only a real change moves status_changed_at, so repeated heartbeats leave it alone
+	filter := bson.M{"_id": deviceID, "user_id": userID, "status": bson.M{"$ne": string(status)}}
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *MongoDeviceRepository) MarkUnresponsive(
+	ctx context.Context, from []entities.DeviceStatus, to entities.DeviceStatus, lastHeartbeatBefore time.Time,
+) (int64, error) {
+	statuses := make(bson.A, len(from))
+	for i, status := range from {
+		statuses[i] = string(status)
+	}
+
+	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
-			"status":     string(status),
-			"updated_at": time.Now(),
+			"status":            string(to),
+			"status_changed_at": now,
+			"updated_at":        now,
 		},
 	}
 
+	// Filtering on last_heartbeat in the same update keeps a heartbeat that just arrived from being overridden
+	filter := bson.M{"status": bson.M{"$in": statuses}, "last_heartbeat": bson.M{"$lt": lastHeartbeatBefore}}
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
+
+func (r *MongoDeviceRepository) UpdateCertificate(
+	ctx context.Context, userID, deviceID primitive.ObjectID, serial string, expiresAt time.Time,
+) error {
+	update := bson.M{
+		"$set": bson.M{
+			"certificate_serial":     serial,
+			"certificate_expires_at": expiresAt,
+			"updated_at":             time.Now(),
+		},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": deviceID, "user_id": userID}, update)
+	return err
+}
+
+func (r *MongoDeviceRepository) SetDraining(ctx context.Context, userID, deviceID primitive.ObjectID, since *time.Time) error {
+	update := bson.M{
+		"$set": bson.M{"draining_since": since, "updated_at": time.Now()},
+	}
+	if since == nil {
+		update = bson.M{
+			"$set":   bson.M{"updated_at": time.Now()},
+			"$unset": bson.M{"draining_since": ""},
+		}
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": deviceID, "user_id": userID}, update)
+	return err
+}
+
+func (r *MongoDeviceRepository) GetAllDraining(ctx context.Context) ([]*entities.Device, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"draining_since": bson.M{"$ne": nil}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var devices []*entities.Device
+	for cursor.Next(ctx) {
+		var deviceModel model.DeviceModel
+		if err := cursor.Decode(&deviceModel); err != nil {
+			continue
+		}
+		devices = append(devices, deviceModel.ToEntity())
+	}
+
+	return devices, nil
+}
+
+func (r *MongoDeviceRepository) GetUserIDs(ctx context.Context) ([]primitive.ObjectID, error) {
+	values, err := r.collection.Distinct(ctx, "user_id", bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	userIDs := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if userID, ok := value.(primitive.ObjectID); ok {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs, nil
+}
+
+func (r *MongoDeviceRepository) SetLabels(ctx context.Context, userID, deviceID primitive.ObjectID, labels map[string]string) error {
+	update := bson.M{
+		"$set": bson.M{"labels": labels, "updated_at": time.Now()},
+	}
+
 	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": deviceID, "user_id": userID}, update)
 	return err
 }
+
+func (r *MongoDeviceRepository) MarkPlaced(
+	ctx context.Context, userID primitive.ObjectID, deviceIDs []primitive.ObjectID, at time.Time,
+) error {
+	filter := bson.M{"_id": bson.M{"$in": deviceIDs}, "user_id": userID}
+	update := bson.M{
+		"$max": bson.M{"last_placed_at": at}, // A slower upload finishing later does not move it back
+	}
+
+	_, err := r.collection.UpdateMany(ctx, filter, update)
+	return err
+}
@@ -0,0 +1,51 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoPairingCodeRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoPairingCodeRepository(db *mongo.Database) *MongoPairingCodeRepository {
+	return &MongoPairingCodeRepository{
+		collection: db.Collection("pairing_codes"),
+	}
+}
+
+func (r *MongoPairingCodeRepository) Create(ctx context.Context, code *entities.PairingCode) error {
+	_, err := r.collection.InsertOne(ctx, code)
+	return err
+}
+
+func (r *MongoPairingCodeRepository) Redeem(
+	ctx context.Context, codeHash string, now time.Time,
+) (*entities.PairingCode, error) {
+	filter := bson.M{
+		"code_hash":   codeHash,
+		"redeemed_at": bson.M{"$exists": false},
+		"expires_at":  bson.M{"$gt": now},
+	}
+	update := bson.M{"$set": bson.M{"redeemed_at": now}}
+
+	var code entities.PairingCode
+	err := r.collection.FindOneAndUpdate(
+		ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&code)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &code, nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind by before it misses some
+const subscriberBuffer = 32
+
+// RedisDeviceEventBus shares events between main servers over Redis pub/sub, so a device gets
+// them whichever main server it is connected to
+type RedisDeviceEventBus struct {
+	client *redis.Client
+}
+
+func NewRedisDeviceEventBus(client *redis.Client) *RedisDeviceEventBus {
+	return &RedisDeviceEventBus{
+		client: client,
+	}
+}
+
+func (b *RedisDeviceEventBus) Publish(ctx context.Context, event *entities.DeviceEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, eventChannel(event.DeviceID), payload).Err()
+}
+
+func (b *RedisDeviceEventBus) Subscribe(ctx context.Context, deviceID primitive.ObjectID) (<-chan *entities.DeviceEvent, error) {
+	pubsub := b.client.Subscribe(ctx, eventChannel(deviceID))
+	// Events published once the subscription is confirmed are not missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	messages := pubsub.Channel()
+	events := make(chan *entities.DeviceEvent, subscriberBuffer)
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+	go func() {
+		defer close(events)
+		for message := range messages {
+			var event entities.DeviceEvent
+			if json.Unmarshal([]byte(message.Payload), &event) != nil {
+				continue
+			}
+
+			select {
+			case events <- &event:
+			default: // Too far behind; it catches up when it reconnects
+			}
+		}
+	}()
+	return events, nil
+}
+
+func eventChannel(deviceID primitive.ObjectID) string {
+	return "nebulo:devices:" + deviceID.Hex() + ":events"
+}
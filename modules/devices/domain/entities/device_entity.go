@@ -15,25 +15,178 @@ type Device struct {
 	TotalStorage     int64              `bson:"total_storage"`
 	AvailableStorage int64              `bson:"available_storage"`
 	UsedStorage      int64              `bson:"used_storage"`
+	ReservedStorage  int64              `bson:"reserved_storage"` // Taken off AvailableStorage for copies on their way to the device
+	Secret           string             `bson:"secret,omitempty"` // Signs the main server's requests to the device server
+	TokenGeneration  int                `bson:"token_generation"` // Bumped on every enrollment; only tokens of the current one work
 	Status           DeviceStatus       `bson:"status"`
+	StatusChangedAt  time.Time          `bson:"status_changed_at,omitempty"` // When Status last changed
 	LastHeartbeat    time.Time          `bson:"last_heartbeat"`
+	DrainingSince    *time.Time         `bson:"draining_since,omitempty"` // Set while the device is being emptied to be removed
+	Labels           map[string]string  `bson:"labels,omitempty"`         // Describe the device to label-constrained placement
+	LastPlacedAt     time.Time          `bson:"last_placed_at,omitempty"` // When a file was last placed on the device
 	CreatedAt        time.Time          `bson:"created_at"`
 	UpdatedAt        time.Time          `bson:"updated_at"`
+
+	// The device's current TLS certificate, when mutual TLS is enabled
+	CertificateSerial    string    `bson:"certificate_serial,omitempty"`
+	CertificateExpiresAt time.Time `bson:"certificate_expires_at,omitempty"`
+
+	Reservations []StorageReservation `bson:"reservations,omitempty"` // Make up ReservedStorage
+}
+
+// StorageReservation holds space on a device for a copy of a file, or one of its shards, on its
+// way to the device, until the copy is stored or given up on. A reservation that is neither by
+// ExpiresAt no longer holds the space.
+type StorageReservation struct {
+	FileID    primitive.ObjectID `bson:"file_id"`
+	Size      int64              `bson:"size"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// ReconcileStorage takes the storage the device reported, dropping the reservations that expired
+// by now. The rest stay off its available storage, as their copies are not on the device yet.
+func (d *Device) ReconcileStorage(available, used int64, now time.Time) {
+	live := d.Reservations[:0]
+	d.ReservedStorage = 0
+	for _, reservation := range d.Reservations {
+		if reservation.ExpiresAt.After(now) {
+			live = append(live, reservation)
+			d.ReservedStorage += reservation.Size
+		}
+	}
+	d.Reservations = live
+
+	d.AvailableStorage = max(available-d.ReservedStorage, 0)
+	d.UsedStorage = used
+}
+
+// Draining reports whether the device is being emptied to be removed. Draining devices are not
+// given new files, whatever their status.
+func (d *Device) Draining() bool {
+	return d.DrainingSince != nil
+}
+
+// DrainProgress is how far emptying a device has got
+type DrainProgress struct {
+	DeviceID       primitive.ObjectID
+	DrainingSince  *time.Time
+	RemainingFiles int   // Files that still have a replica or shard on the device
+	RemainingBytes int64 // Space those replicas and shards take up on the device
+	MovingFiles    int   // Files whose copy is on its way to another device
+}
+
+// Empty reports whether the device holds nothing anymore and can be removed
+func (p *DrainProgress) Empty() bool {
+	return p.RemainingFiles == 0
+}
+
+// RebalancePlan is the set of file moves that brings a user's devices close to the same
+// utilization, the share of their total storage in use
+type RebalancePlan struct {
+	TargetUtilization float64 // Utilization of the devices taken together
+	Devices           []DeviceBalance
+	Moves             []RebalanceMove
+	MovingFiles       int // Files whose move, planned earlier, is still on its way
+}
+
+// DeviceBalance is how full a device is, and how full it will be once the planned moves and the
+// ones on their way are done
+type DeviceBalance struct {
+	DeviceID           primitive.ObjectID
+	Name               string
+	TotalStorage       int64
+	UsedStorage        int64
+	Utilization        float64
+	PlannedUtilization float64
+}
+
+// RebalanceMove moves a file's replica from one device to another
+type RebalanceMove struct {
+	FileID       primitive.ObjectID
+	FileName     string
+	Size         int64
+	FromDeviceID primitive.ObjectID
+	ToDeviceID   primitive.ObjectID
+}
+
+// DeviceCertificate is a TLS certificate issued to a device
+type DeviceCertificate struct {
+	Serial           string
+	CertificatePEM   string
+	CACertificatePEM string
+	ExpiresAt        time.Time
+}
+
+// PresentedCertificate is the device certificate a client authenticated with over TLS
+type PresentedCertificate struct {
+	DeviceID  string
+	Serial    string
+	ExpiresAt time.Time
+}
+
+// RevokedCertificate is a device certificate the main server no longer accepts although it has
+// not expired yet
+type RevokedCertificate struct {
+	Serial    string             `bson:"_id"`
+	DeviceID  primitive.ObjectID `bson:"device_id"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Reason    string             `bson:"reason"`
+	RevokedAt time.Time          `bson:"revoked_at"`
+	ExpiresAt time.Time          `bson:"expires_at"`
+}
+
+// PairingCode is a short-lived code a user hands to a device so it can enroll without the user's
+// token. Only the code's hash is stored, and it can be redeemed once.
+type PairingCode struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `bson:"user_id"`
+	CodeHash   string             `bson:"code_hash"`
+	ExpiresAt  time.Time          `bson:"expires_at"`
+	CreatedAt  time.Time          `bson:"created_at"`
+	RedeemedAt *time.Time         `bson:"redeemed_at,omitempty"`
 }
 
 type DeviceStatus string
 
 const (
 	DeviceStatusOnline  DeviceStatus = "online"
-	DeviceStatusOffline DeviceStatus = "offline"
-	DeviceStatusFailed  DeviceStatus = "failed"
+	DeviceStatusOffline DeviceStatus = "offline" // Missed several heartbeats in a row
+	DeviceStatusFailed  DeviceStatus = "failed"  // Silent for longer than the failure grace period
+)
+
+// DeviceEvent is pushed to a device server over its control channel
+type DeviceEvent struct {
+	Type     DeviceEventType    `json:"type"`
+	DeviceID primitive.ObjectID `json:"device_id"`
+	Data     map[string]any     `json:"data,omitempty"`
+}
+
+type DeviceEventType string
+
+const (
+	DeviceEventConfig   DeviceEventType = "config"   // The device's settings, sent on connecting and whenever they change
+	DeviceEventTransfer DeviceEventType = "transfer" // A transfer was queued for the device
+	DeviceEventDelete   DeviceEventType = "delete"   // A deletion of a file was queued for the device
 )
 
 type DeviceRegistrationRequest struct {
-	Name         string `json:"name" validate:"required"`
-	IPAddress    string `json:"ip_address" validate:"required,ip"`
-	Type         string `json:"type" validate:"required"`
-	TotalStorage int64  `json:"total_storage" validate:"required,min=1"`
+	Name         string            `json:"name" validate:"required"`
+	IPAddress    string            `json:"ip_address" validate:"required,ip"`
+	Type         string            `json:"type" validate:"required"`
+	TotalStorage int64             `json:"total_storage" validate:"required,min=1"`
+	Labels       map[string]string `json:"labels,omitempty"`
+}
+
+// DeviceEnrollmentRequest is sent by a device agent registering itself
+type DeviceEnrollmentRequest struct {
+	Name             string            `json:"name" validate:"required"`
+	IPAddress        string            `json:"ip_address" validate:"required,ip"`
+	Type             string            `json:"type" validate:"required"`
+	TotalStorage     int64             `json:"total_storage" validate:"required,min=1"`
+	AvailableStorage int64             `json:"available_storage" validate:"min=0"`
+	UsedStorage      int64             `json:"used_storage" validate:"min=0"`
+	CSR              string            `json:"csr"` // PEM certificate signing request; required when mutual TLS is enabled
+	Labels           map[string]string `json:"labels,omitempty"`
 }
 
 type DeviceHeartbeatRequest struct {
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+)
+
+// CertificateAuthority issues TLS certificates to devices
+type CertificateAuthority interface {
+	// IssueDeviceCertificate signs the PEM certificate signing request for the device, naming it by
+	// its ID and IP address
+	IssueDeviceCertificate(device *entities.Device, csrPEM []byte) (*entities.DeviceCertificate, error)
+}
+
+// CertificateRepository keeps the device certificates that were revoked before they expired
+type CertificateRepository interface {
+	Revoke(ctx context.Context, revoked *entities.RevokedCertificate) error
+	IsRevoked(ctx context.Context, serial string) (bool, error)
+}
@@ -0,0 +1,19 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DeviceEventBus carries events to the device servers connected to the main server over their
+// control channel. Delivery is best effort: a device that is not connected, or too slow to keep
+// up, misses events and catches up from the events replayed when it connects.
+type DeviceEventBus interface {
+	Publish(ctx context.Context, event *entities.DeviceEvent) error
+	// Subscribe returns the events published for the device from now on. The channel is closed once
+	// ctx is done.
+	Subscribe(ctx context.Context, deviceID primitive.ObjectID) (<-chan *entities.DeviceEvent, error)
+}
@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
 
@@ -15,7 +16,40 @@ type DeviceRepository interface {
 	GetAllByUser(ctx context.Context, userID primitive.ObjectID) ([]*entities.Device, error)
 	GetOnlineDevicesByUser(ctx context.Context, userID primitive.ObjectID) ([]*entities.Device, error)
 	Update(ctx context.Context, device *entities.Device) error
+	// Reenroll saves what enrolling again changes on the device: its name, type, total storage,
+	// labels, secret, token generation, status and certificate. Its storage numbers and
+	// reservations are left to UpdateHeartbeat.
+	Reenroll(ctx context.Context, device *entities.Device) error
+	// UpdateHeartbeat records the storage the device reported, reconciled with its reservations as
+	// Device.ReconcileStorage does
 	UpdateHeartbeat(ctx context.Context, userID, deviceID primitive.ObjectID, availableStorage, usedStorage int64) error
+	// Reserve atomically takes size bytes off the device's available storage for a copy of the file
+	// until expiresAt. It reports false, reserving nothing, when less is available or the file
+	// already has a reservation on the device.
+	Reserve(ctx context.Context, userID, deviceID, fileID primitive.ObjectID, size int64, expiresAt time.Time) (bool, error)
+	// ExtendReservation moves the expiry of the file's reservation on the device to expiresAt. It
+	// reports false when the file has no reservation on the device, for instance because it ran out.
+	ExtendReservation(ctx context.Context, userID, deviceID, fileID primitive.ObjectID, expiresAt time.Time) (bool, error)
+	// ReleaseReservation gives the space reserved for the file on the device back to its available storage
+	ReleaseReservation(ctx context.Context, userID, deviceID, fileID primitive.ObjectID) error
+	// ConfirmReservation counts the space reserved for the file on the device as used, now that its copy is stored
+	ConfirmReservation(ctx context.Context, userID, deviceID, fileID primitive.ObjectID) error
 	Delete(ctx context.Context, userID, deviceID primitive.ObjectID) error
+	// UpdateStatus moves the device to status, recording when it changed; it is a no-op if the device already has it
 	UpdateStatus(ctx context.Context, userID, deviceID primitive.ObjectID, status entities.DeviceStatus) error
+	// MarkUnresponsive moves every user's devices that are in one of the from statuses and have not sent a
+	// heartbeat since lastHeartbeatBefore to status to, and returns how many it moved
+	MarkUnresponsive(ctx context.Context, from []entities.DeviceStatus, to entities.DeviceStatus, lastHeartbeatBefore time.Time) (int64, error)
+	// SetDraining marks the device as draining since the given time, or no longer draining when since is nil
+	SetDraining(ctx context.Context, userID, deviceID primitive.ObjectID, since *time.Time) error
+	// GetAllDraining returns every user's draining devices, for background jobs
+	GetAllDraining(ctx context.Context) ([]*entities.Device, error)
+	// GetUserIDs returns the IDs of every user that has a device, for background jobs
+	GetUserIDs(ctx context.Context) ([]primitive.ObjectID, error)
+	// SetLabels replaces the device's labels
+	SetLabels(ctx context.Context, userID, deviceID primitive.ObjectID, labels map[string]string) error
+	// MarkPlaced records that files were placed on the devices at the given time
+	MarkPlaced(ctx context.Context, userID primitive.ObjectID, deviceIDs []primitive.ObjectID, at time.Time) error
+	// UpdateCertificate records the certificate the device was issued last
+	UpdateCertificate(ctx context.Context, userID, deviceID primitive.ObjectID, serial string, expiresAt time.Time) error
 }
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+)
+
+// PairingCodeRepository keeps the pairing codes users hand out to devices
+type PairingCodeRepository interface {
+	Create(ctx context.Context, code *entities.PairingCode) error
+	// Redeem marks the unexpired, unredeemed code with the given hash as redeemed at now and returns
+	// it, or nil if there is none. A code can only be redeemed once, however many devices race for it.
+	Redeem(ctx context.Context, codeHash string, now time.Time) (*entities.PairingCode, error)
+}
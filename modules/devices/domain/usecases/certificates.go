@@ -0,0 +1,56 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+)
+
+// Reasons recorded with revoked certificates
+const (
+	revokedSuperseded    = "superseded"
+	revokedDeviceRemoved = "device removed"
+)
+
+// revokeCertificate revokes the device's current certificate, if it has one that has not expired
+func revokeCertificate(
+	ctx context.Context, certificates repository.CertificateRepository, device *entities.Device, reason string,
+) error {
+	if device.CertificateSerial == "" || time.Now().After(device.CertificateExpiresAt) {
+		return nil
+	}
+
+	return certificates.Revoke(ctx, &entities.RevokedCertificate{
+		Serial:    device.CertificateSerial,
+		DeviceID:  device.ID,
+		UserID:    device.UserID,
+		Reason:    reason,
+		RevokedAt: time.Now(),
+		ExpiresAt: device.CertificateExpiresAt,
+	})
+}
+
+// issueCertificate signs csr for the device and records the certificate on it. It returns nil
+// without a certificate authority, that is while mutual TLS is off.
+func issueCertificate(
+	authority repository.CertificateAuthority, device *entities.Device, csr string,
+) (*entities.DeviceCertificate, error) {
+	if authority == nil {
+		return nil, nil
+	}
+	if csr == "" {
+		return nil, ErrCSRRequired
+	}
+
+	certificate, err := authority.IssueDeviceCertificate(device, []byte(csr))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCSR, err)
+	}
+
+	device.CertificateSerial = certificate.Serial
+	device.CertificateExpiresAt = certificate.ExpiresAt
+	return certificate, nil
+}
@@ -0,0 +1,43 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+)
+
+// CheckDeviceCertificateUseCase decides whether a TLS client certificate may speak for a device.
+// The TLS handshake has already checked it was issued by the CA and has not expired.
+type CheckDeviceCertificateUseCase struct {
+	certificates repository.CertificateRepository
+}
+
+func NewCheckDeviceCertificateUseCase(certificates repository.CertificateRepository) *CheckDeviceCertificateUseCase {
+	return &CheckDeviceCertificateUseCase{
+		certificates: certificates,
+	}
+}
+
+// Execute returns ErrCertificateRejected unless the presented certificate was issued to deviceID
+// and has not been revoked. A nil certificate, for requests that presented none, is rejected.
+func (uc *CheckDeviceCertificateUseCase) Execute(
+	ctx context.Context, deviceID string, presented *entities.PresentedCertificate,
+) error {
+	if presented == nil || presented.DeviceID != deviceID {
+		return ErrCertificateRejected
+	}
+	if time.Now().After(presented.ExpiresAt) {
+		return ErrCertificateRejected
+	}
+
+	revoked, err := uc.certificates.IsRevoked(ctx, presented.Serial)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return ErrCertificateRejected
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package usecases
+
+import (
+	"context"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// CheckDeviceTokenUseCase decides whether a device token, whose signature and expiry have already
+// been checked, may still speak for its device. Deleting a device or enrolling it again retires
+// every token issued for it before.
+type CheckDeviceTokenUseCase struct {
+	deviceRepo repository.DeviceRepository
+}
+
+func NewCheckDeviceTokenUseCase(deviceRepo repository.DeviceRepository) *CheckDeviceTokenUseCase {
+	return &CheckDeviceTokenUseCase{
+		deviceRepo: deviceRepo,
+	}
+}
+
+// Execute reports whether the user's device still exists and is on the enrollment generation the
+// token was issued for
+func (uc *CheckDeviceTokenUseCase) Execute(ctx context.Context, userID, deviceID string, generation int) (bool, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return false, nil
+	}
+	deviceObjectID, err := primitive.ObjectIDFromHex(deviceID)
+	if err != nil {
+		return false, nil
+	}
+
+	device, err := uc.deviceRepo.GetByID(ctx, userObjectID, deviceObjectID)
+	if err != nil {
+		return false, err
+	}
+	return device != nil && device.TokenGeneration == generation, nil
+}
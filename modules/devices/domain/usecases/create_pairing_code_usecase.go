@@ -0,0 +1,97 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// Pairing codes are typed in by hand, so the alphabet leaves out characters that are easily
+	// confused, like 0 and O or 1 and I
+	pairingCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	pairingCodeLength   = 8
+	pairingCodeGroup    = 4 // Characters between dashes in the displayed code
+)
+
+// CreatePairingCodeUseCase hands a user a one-time code to enroll a device with
+type CreatePairingCodeUseCase struct {
+	pairingRepo repository.PairingCodeRepository
+	ttl         time.Duration
+}
+
+func NewCreatePairingCodeUseCase(pairingRepo repository.PairingCodeRepository, ttl time.Duration) *CreatePairingCodeUseCase {
+	return &CreatePairingCodeUseCase{
+		pairingRepo: pairingRepo,
+		ttl:         ttl,
+	}
+}
+
+// Execute returns the code as it is shown to the user, e.g. "7KQD-M3XP", and the stored record
+func (uc *CreatePairingCodeUseCase) Execute(ctx context.Context, userID string) (string, *entities.PairingCode, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return "", nil, errors.New("invalid user ID")
+	}
+
+	code, err := newPairingCode()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	pairing := &entities.PairingCode{
+		ID:        primitive.NewObjectID(),
+		UserID:    userObjectID,
+		CodeHash:  hashPairingCode(code),
+		ExpiresAt: now.Add(uc.ttl),
+		CreatedAt: now,
+	}
+
+	if err = uc.pairingRepo.Create(ctx, pairing); err != nil {
+		return "", nil, err
+	}
+	return code, pairing, nil
+}
+
+func newPairingCode() (string, error) {
+	alphabetSize := big.NewInt(int64(len(pairingCodeAlphabet)))
+
+	var code strings.Builder
+	for i := 0; i < pairingCodeLength; i++ {
+		if i > 0 && i%pairingCodeGroup == 0 {
+			code.WriteByte('-')
+		}
+
+		index, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(pairingCodeAlphabet[index.Int64()])
+	}
+	return code.String(), nil
+}
+
+// hashPairingCode hashes a code the way it is stored. Case, dashes and spaces do not matter, so a
+// code can be typed in however it was read off the screen.
+func hashPairingCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
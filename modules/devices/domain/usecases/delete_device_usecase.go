@@ -3,23 +3,44 @@ package usecases
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
 	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 )
 
+// removedReason is the status reason of copies that went with their device when it was removed
+const removedReason = "device was removed"
+
+// DeleteDeviceUseCase removes a device. A device that still holds files is only removed when
+// forced, and their copies on it are then lost; draining it first moves them to other devices.
 type DeleteDeviceUseCase struct {
-	deviceRepo repository.DeviceRepository
+	deviceRepo   repository.DeviceRepository
+	certificates repository.CertificateRepository
+	fileRepo     fileRepository.FileRepository
+	logger       *zap.Logger
 }
 
-func NewDeleteDeviceUseCase(deviceRepo repository.DeviceRepository) *DeleteDeviceUseCase {
+func NewDeleteDeviceUseCase(
+	deviceRepo repository.DeviceRepository,
+	certificates repository.CertificateRepository,
+	fileRepo fileRepository.FileRepository,
+	logger *zap.Logger,
+) *DeleteDeviceUseCase {
 	return &DeleteDeviceUseCase{
-		deviceRepo: deviceRepo,
+		deviceRepo:   deviceRepo,
+		certificates: certificates,
+		fileRepo:     fileRepo,
+		logger:       logger,
 	}
 }
 
-func (uc *DeleteDeviceUseCase) Execute(ctx context.Context, userID, deviceID string) error {
+func (uc *DeleteDeviceUseCase) Execute(ctx context.Context, userID, deviceID string, force bool) error {
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return errors.New("invalid user ID")
@@ -36,11 +57,64 @@ func (uc *DeleteDeviceUseCase) Execute(ctx context.Context, userID, deviceID str
 		return errors.New("device not found or does not belong to you")
 	}
 
+	files, err := heldFiles(ctx, uc.fileRepo, device)
+	if err != nil {
+		return err
+	}
+	if len(files) > 0 && !force {
+		return fmt.Errorf("%w: %d files are still on it", ErrDeviceNotEmpty, len(files))
+	}
+
+	// Revoke its certificate first, so a device that is gone cannot come back with it
+	err = revokeCertificate(ctx, uc.certificates, device, revokedDeviceRemoved)
+	if err != nil {
+		return err
+	}
+
 	// Delete device
 	err = uc.deviceRepo.Delete(ctx, userObjectID, deviceObjectID)
 	if err != nil {
 		return err
 	}
 
+	for _, file := range files {
+		if err = uc.loseCopies(ctx, file, deviceObjectID); err != nil {
+			// The availability tracker marks them lost on its next round
+			uc.logger.Warn("Failed to record copies lost with a removed device", zap.String("file_id", file.ID.Hex()), zap.Error(err))
+		}
+	}
+
 	return nil
 }
+
+// loseCopies marks the file's replicas and shards on the removed device as lost, and the file as
+// well if it cannot be read without them
+func (uc *DeleteDeviceUseCase) loseCopies(ctx context.Context, file *fileEntities.File, deviceID primitive.ObjectID) error {
+	now := time.Now()
+	file.Replicas = file.AllReplicas()
+	for i := range file.Replicas {
+		if file.Replicas[i].DeviceID == deviceID && onDevice(file.Replicas[i].Status) {
+			file.Replicas[i].Status = fileEntities.ReplicaStatusLost
+			file.Replicas[i].StatusReason = removedReason
+			file.Replicas[i].UpdatedAt = now
+		}
+	}
+	for i := range file.Shards {
+		if file.Shards[i].DeviceID == deviceID && onDevice(file.Shards[i].Status) {
+			file.Shards[i].Status = fileEntities.ReplicaStatusLost
+			file.Shards[i].StatusReason = removedReason
+			file.Shards[i].UpdatedAt = now
+		}
+	}
+
+	// Files still on their way to their devices are settled by their transfers
+	readable := file.Status == fileEntities.FileStatusStored || file.Status == fileEntities.FileStatusUnavailable
+	if readable && !file.Recoverable() {
+		file.Status = fileEntities.FileStatusLost
+		file.StatusReason = "too few copies remain after their device was removed"
+		file.StatusChangedAt = now
+	}
+
+	_, err := uc.fileRepo.UpdatePlacements(ctx, file)
+	return err
+}
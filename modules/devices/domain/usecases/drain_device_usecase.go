@@ -0,0 +1,172 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DrainDeviceUseCase empties a device so that it can be removed without losing files. A draining
+// device is given no new files, and MigrateDrainingFilesUseCase moves the ones it holds to the
+// user's other devices. Cancelling a drain keeps the copies already moved.
+type DrainDeviceUseCase struct {
+	deviceRepo repository.DeviceRepository
+	fileRepo   fileRepository.FileRepository
+}
+
+func NewDrainDeviceUseCase(deviceRepo repository.DeviceRepository, fileRepo fileRepository.FileRepository) *DrainDeviceUseCase {
+	return &DrainDeviceUseCase{
+		deviceRepo: deviceRepo,
+		fileRepo:   fileRepo,
+	}
+}
+
+// Start marks the device as draining and returns what it still holds. Draining a device that is
+// already draining only reports its progress.
+func (uc *DrainDeviceUseCase) Start(ctx context.Context, userID, deviceID string) (*entities.DrainProgress, error) {
+	device, err := uc.find(ctx, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !device.Draining() {
+		now := time.Now()
+		if err = uc.deviceRepo.SetDraining(ctx, device.UserID, device.ID, &now); err != nil {
+			return nil, err
+		}
+		device.DrainingSince = &now
+	}
+
+	return drainProgress(ctx, uc.fileRepo, device)
+}
+
+// Cancel stops draining the device, which takes new files again
+func (uc *DrainDeviceUseCase) Cancel(ctx context.Context, userID, deviceID string) error {
+	device, err := uc.find(ctx, userID, deviceID)
+	if err != nil {
+		return err
+	}
+	if !device.Draining() {
+		return nil
+	}
+	return uc.deviceRepo.SetDraining(ctx, device.UserID, device.ID, nil)
+}
+
+// Progress returns what the device still holds, whether it is draining or not
+func (uc *DrainDeviceUseCase) Progress(ctx context.Context, userID, deviceID string) (*entities.DrainProgress, error) {
+	device, err := uc.find(ctx, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return drainProgress(ctx, uc.fileRepo, device)
+}
+
+func (uc *DrainDeviceUseCase) find(ctx context.Context, userID, deviceID string) (*entities.Device, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	deviceObjectID, err := primitive.ObjectIDFromHex(deviceID)
+	if err != nil {
+		return nil, errors.New("invalid device ID")
+	}
+
+	device, err := uc.deviceRepo.GetByID(ctx, userObjectID, deviceObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, ErrDeviceNotFound
+	}
+	return device, nil
+}
+
+// drainProgress counts the files the device still holds a replica or shard of. Files being
+// deleted do not count, as their content goes with the device.
+func drainProgress(ctx context.Context, fileRepo fileRepository.FileRepository, device *entities.Device) (*entities.DrainProgress, error) {
+	files, err := heldFiles(ctx, fileRepo, device)
+	if err != nil {
+		return nil, err
+	}
+
+	progress := &entities.DrainProgress{DeviceID: device.ID, DrainingSince: device.DrainingSince}
+	for _, file := range files {
+		progress.RemainingFiles++
+		progress.RemainingBytes += heldBytes(file, device.ID)
+		if movingOff(file, device.ID) {
+			progress.MovingFiles++
+		}
+	}
+	return progress, nil
+}
+
+// heldFiles returns the files that still have content on the device
+func heldFiles(ctx context.Context, fileRepo fileRepository.FileRepository, device *entities.Device) ([]*fileEntities.File, error) {
+	files, err := fileRepo.GetByUserAndDeviceID(ctx, device.UserID, device.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	held := make([]*fileEntities.File, 0, len(files))
+	for _, file := range files {
+		if !file.Removed() && holds(file, device.ID) {
+			held = append(held, file)
+		}
+	}
+	return held, nil
+}
+
+// holds reports whether the file has a replica or shard on the device, leaving out the ones that
+// never made it there or are known to be gone
+func holds(file *fileEntities.File, deviceID primitive.ObjectID) bool {
+	for _, replica := range file.AllReplicas() {
+		if replica.DeviceID == deviceID && onDevice(replica.Status) {
+			return true
+		}
+	}
+	for _, shard := range file.Shards {
+		if shard.DeviceID == deviceID && onDevice(shard.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// heldBytes is the space the file's replicas and shards that count as held take up on the device
+func heldBytes(file *fileEntities.File, deviceID primitive.ObjectID) int64 {
+	var bytes int64
+	for _, replica := range file.AllReplicas() {
+		if replica.DeviceID == deviceID && onDevice(replica.Status) {
+			bytes += file.Size
+		}
+	}
+	for _, shard := range file.Shards {
+		if shard.DeviceID == deviceID && onDevice(shard.Status) && file.Erasure != nil {
+			bytes += file.Erasure.ShardSize
+		}
+	}
+	return bytes
+}
+
+// movingOff reports whether a copy of the file is on its way to another device in place of the one on deviceID
+func movingOff(file *fileEntities.File, deviceID primitive.ObjectID) bool {
+	for _, replica := range file.Replicas {
+		if replica.Replaces == deviceID && replica.Status == fileEntities.ReplicaStatusPending {
+			return true
+		}
+	}
+	return false
+}
+
+// onDevice reports whether a replica or shard in status takes up space on its device
+func onDevice(status fileEntities.ReplicaStatus) bool {
+	return status != fileEntities.ReplicaStatusFailed && status != fileEntities.ReplicaStatusLost
+}
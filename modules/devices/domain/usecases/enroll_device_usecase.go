@@ -0,0 +1,137 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manab-pr/nebulo/internal/device_server/signing"
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EnrollDeviceUseCase registers a device on behalf of the agent running on it. Unlike a manual
+// registration, enrolling again from an address the user already has a device on takes that
+// device over, so an agent that lost its local state keeps its device and the files on it. Every
+// enrollment issues the device a new secret and, when mutual TLS is enabled, a new certificate
+// for the key in its certificate signing request; the certificate it had before is revoked, as
+// are the device tokens issued for earlier enrollments.
+type EnrollDeviceUseCase struct {
+	deviceRepo   repository.DeviceRepository
+	authority    repository.CertificateAuthority // nil while mutual TLS is off
+	certificates repository.CertificateRepository
+}
+
+func NewEnrollDeviceUseCase(
+	deviceRepo repository.DeviceRepository,
+	authority repository.CertificateAuthority,
+	certificates repository.CertificateRepository,
+) *EnrollDeviceUseCase {
+	return &EnrollDeviceUseCase{
+		deviceRepo:   deviceRepo,
+		authority:    authority,
+		certificates: certificates,
+	}
+}
+
+// Execute returns the enrolled device, and its certificate when mutual TLS is enabled
+func (uc *EnrollDeviceUseCase) Execute(
+	ctx context.Context, userID string, req entities.DeviceEnrollmentRequest,
+) (*entities.Device, *entities.DeviceCertificate, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, nil, errors.New("invalid user ID")
+	}
+	if uc.authority != nil && req.CSR == "" {
+		return nil, nil, ErrCSRRequired
+	}
+
+	existingDevice, err := uc.deviceRepo.GetByIPAddress(ctx, userObjectID, req.IPAddress)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	secret, err := signing.NewSecret()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	if existingDevice != nil {
+		existingDevice.Name = req.Name
+		existingDevice.Type = req.Type
+		existingDevice.TotalStorage = req.TotalStorage
+		existingDevice.ReconcileStorage(req.AvailableStorage, req.UsedStorage, now)
+		existingDevice.Secret = secret
+		existingDevice.TokenGeneration++
+		existingDevice.LastHeartbeat = now
+		if req.Labels != nil {
+			existingDevice.Labels = req.Labels // Labels set by the user stay unless the agent brings its own
+		}
+		if existingDevice.Status != entities.DeviceStatusOnline {
+			existingDevice.Status = entities.DeviceStatusOnline
+			existingDevice.StatusChangedAt = now
+		}
+
+		return uc.takeOver(ctx, existingDevice, req)
+	}
+
+	device := &entities.Device{
+		ID:               primitive.NewObjectID(),
+		UserID:           userObjectID,
+		Name:             req.Name,
+		IPAddress:        req.IPAddress,
+		Type:             req.Type,
+		TotalStorage:     req.TotalStorage,
+		AvailableStorage: req.AvailableStorage,
+		UsedStorage:      req.UsedStorage,
+		Labels:           req.Labels,
+		Secret:           secret,
+		TokenGeneration:  1,
+		Status:           entities.DeviceStatusOnline,
+		StatusChangedAt:  now,
+		LastHeartbeat:    now,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+
+	certificate, err := issueCertificate(uc.authority, device, req.CSR)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	createdDevice, err := uc.deviceRepo.Create(ctx, device)
+	if err != nil {
+		return nil, nil, err
+	}
+	return createdDevice, certificate, nil
+}
+
+// takeOver saves the re-enrolled device with a new certificate. The old certificate is revoked
+// first, so it stops working even if saving fails. Only what enrolling changes is written, with
+// the storage the agent reported reconciled as heartbeats are, so reservations taken meanwhile
+// are kept.
+func (uc *EnrollDeviceUseCase) takeOver(
+	ctx context.Context, device *entities.Device, req entities.DeviceEnrollmentRequest,
+) (*entities.Device, *entities.DeviceCertificate, error) {
+	if uc.authority != nil {
+		if err := revokeCertificate(ctx, uc.certificates, device, revokedSuperseded); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	certificate, err := issueCertificate(uc.authority, device, req.CSR)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err = uc.deviceRepo.Reenroll(ctx, device); err != nil {
+		return nil, nil, err
+	}
+	if err = uc.deviceRepo.UpdateHeartbeat(ctx, device.UserID, device.ID, req.AvailableStorage, req.UsedStorage); err != nil {
+		return nil, nil, err
+	}
+	return device, certificate, nil
+}
@@ -0,0 +1,25 @@
+package usecases
+
+import "errors"
+
+var (
+	ErrDeviceNotFound = errors.New("device not found or does not belong to you")
+
+	// ErrTLSDisabled is returned for certificate requests while mutual TLS is off
+	ErrTLSDisabled = errors.New("mutual TLS is not enabled on this server")
+	// ErrCSRRequired is returned when a device enrolls without a certificate signing request while mutual TLS is on
+	ErrCSRRequired = errors.New("a certificate signing request is required")
+	// ErrInvalidCSR is returned for certificate signing requests that cannot be signed
+	ErrInvalidCSR = errors.New("invalid certificate signing request")
+	// ErrCertificateRejected is returned for device requests without a valid, unrevoked certificate of their own
+	ErrCertificateRejected = errors.New("device certificate rejected")
+
+	// ErrInvalidPairingCode is returned for pairing codes that do not exist, have expired or were already used
+	ErrInvalidPairingCode = errors.New("invalid or expired pairing code")
+
+	// ErrDeviceNotEmpty is returned when removing a device that still holds files, without forcing it
+	ErrDeviceNotEmpty = errors.New("device still holds files")
+
+	// ErrInvalidLabels is returned for device labels with an empty key
+	ErrInvalidLabels = errors.New("device labels must have a key")
+)
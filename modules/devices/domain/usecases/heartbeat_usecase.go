@@ -33,8 +33,11 @@ func (uc *HeartbeatUseCase) Execute(ctx context.Context, userID string, req enti
 
 	// Check if device exists and belongs to user
 	device, err := uc.deviceRepo.GetByID(ctx, userObjectID, deviceID)
-	if err != nil || device == nil {
-		return errors.New("device not found or does not belong to you")
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return ErrDeviceNotFound
 	}
 
 	// Update heartbeat and storage info
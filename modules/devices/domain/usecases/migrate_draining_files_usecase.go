@@ -0,0 +1,472 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	transferRepository "github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// migrateAttempts is how many times moving a file is retried when its record changes under it
+	migrateAttempts = 3
+	// movingReason is the status reason of a copy on its way to take over from a draining device
+	movingReason = "moving off a draining device"
+)
+
+// errNoDrainTarget is returned for files that no other device has room for
+var errNoDrainTarget = errors.New("no other device can take it")
+
+// MigrateDrainingFilesUseCase moves the files on draining devices to the user's other devices.
+// A replica gets a successor on the device with the most free space, queued as a transfer, and is
+// deleted from the draining device once its successor is stored. Shards are copied straight from
+// the draining device while it is online, as they are not queued as transfers. Copies that never
+// made it onto the draining device, or are gone from it, are dropped. Space for every successor is
+// reserved on its device, as it is for uploads, until its transfer settles.
+type MigrateDrainingFilesUseCase struct {
+	deviceRepo     repository.DeviceRepository
+	fileRepo       fileRepository.FileRepository
+	deviceStorage  fileRepository.DeviceStorageRepository
+	transfers      transferRepository.TransferEnqueuer
+	reservationTTL time.Duration // How long space reserved on a successor's device is held
+	logger         *zap.Logger
+}
+
+func NewMigrateDrainingFilesUseCase(
+	deviceRepo repository.DeviceRepository,
+	fileRepo fileRepository.FileRepository,
+	deviceStorage fileRepository.DeviceStorageRepository,
+	transfers transferRepository.TransferEnqueuer,
+	reservationTTL time.Duration,
+	logger *zap.Logger,
+) *MigrateDrainingFilesUseCase {
+	return &MigrateDrainingFilesUseCase{
+		deviceRepo:     deviceRepo,
+		fileRepo:       fileRepo,
+		deviceStorage:  deviceStorage,
+		transfers:      transfers,
+		reservationTTL: reservationTTL,
+		logger:         logger,
+	}
+}
+
+// Run moves files off draining devices every interval until ctx is cancelled
+func (uc *MigrateDrainingFilesUseCase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := uc.Execute(ctx); err != nil && ctx.Err() == nil {
+			uc.logger.Warn("Device drain incomplete", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Execute takes every draining device one step further towards holding nothing
+func (uc *MigrateDrainingFilesUseCase) Execute(ctx context.Context) error {
+	devices, err := uc.deviceRepo.GetAllDraining(ctx)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, device := range devices {
+		if err = uc.drain(ctx, device); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("device %s: %w", device.ID.Hex(), err)
+		}
+	}
+	return firstErr
+}
+
+// drain moves what it can of the device's files
+func (uc *MigrateDrainingFilesUseCase) drain(ctx context.Context, device *entities.Device) error {
+	files, err := uc.fileRepo.GetByUserAndDeviceID(ctx, device.UserID, device.ID)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	// The space planned for moves this round is taken off these, so they are not overfilled
+	targets, err := uc.deviceRepo.GetAllByUser(ctx, device.UserID)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if file.Removed() {
+			continue // Its content goes with the device
+		}
+
+		var moveErr error
+		if file.Erasure != nil {
+			moveErr = uc.moveShards(ctx, file, device, targets)
+		} else {
+			moveErr = retryPlacements(ctx, uc.fileRepo, file, func(file *fileEntities.File) (bool, error) {
+				return uc.moveReplica(ctx, file, device, targets)
+			})
+		}
+		if moveErr != nil && firstErr == nil {
+			firstErr = fmt.Errorf("file %s: %w", file.ID.Hex(), moveErr)
+		}
+	}
+	return firstErr
+}
+
+// retryPlacements runs step on the file until it reports its update was applied, reading the
+// file again every time it changed in the meantime
+func retryPlacements(
+	ctx context.Context, fileRepo fileRepository.FileRepository, file *fileEntities.File,
+	step func(file *fileEntities.File) (bool, error),
+) error {
+	for attempt := 0; attempt < migrateAttempts; attempt++ {
+		if attempt > 0 {
+			var err error
+			if file, err = fileRepo.GetByID(ctx, file.UserID, file.ID); err != nil {
+				return err
+			}
+			if file == nil || file.Removed() {
+				return nil
+			}
+		}
+
+		applied, err := step(file)
+		if err != nil || applied {
+			return err
+		}
+	}
+	return errors.New("file kept changing while it was moved")
+}
+
+// moveReplica takes the file's replica on the draining device one step further. It reports false
+// if the file changed before its update could be applied.
+func (uc *MigrateDrainingFilesUseCase) moveReplica(
+	ctx context.Context, file *fileEntities.File, device *entities.Device, targets []*entities.Device,
+) (bool, error) {
+	file.Replicas = file.AllReplicas()
+	own, successor := -1, -1
+	for i, replica := range file.Replicas {
+		switch {
+		case replica.DeviceID == device.ID:
+			own = i
+		case replica.Replaces == device.ID:
+			successor = i
+		}
+	}
+
+	switch {
+	case own < 0 || file.Replicas[own].Status == fileEntities.ReplicaStatusPending:
+		// A copy still on its way to the device is moved by the transfer dispatcher
+		return true, nil
+	case !onDevice(file.Replicas[own].Status) || file.Replicas[own].Status == fileEntities.ReplicaStatusCorrupted:
+		return uc.dropReplica(ctx, file, device, own, -1)
+	case successor < 0:
+		return uc.queueSuccessor(ctx, file, device, targets)
+	}
+
+	switch file.Replicas[successor].Status {
+	case fileEntities.ReplicaStatusStored:
+		return uc.dropReplica(ctx, file, device, own, successor)
+	case fileEntities.ReplicaStatusFailed, fileEntities.ReplicaStatusLost, fileEntities.ReplicaStatusCorrupted:
+		// Given up on, so another device is tried
+		file.Replicas = append(file.Replicas[:successor], file.Replicas[successor+1:]...)
+		return uc.queueSuccessor(ctx, file, device, targets)
+	default:
+		return true, nil // Still on its way
+	}
+}
+
+// queueSuccessor adds a pending replica on the device best placed to take over from the draining
+// one, with its space reserved, and queues the transfer that writes it. The transfer settles the
+// reservation once it is done.
+func (uc *MigrateDrainingFilesUseCase) queueSuccessor(
+	ctx context.Context, file *fileEntities.File, device *entities.Device, targets []*entities.Device,
+) (bool, error) {
+	target, err := uc.reserveTarget(ctx, file, device, targets, file.Size)
+	if err != nil {
+		return false, err
+	}
+
+	file.Replicas = append(file.Replicas, fileEntities.Replica{
+		DeviceID:     target.ID,
+		Status:       fileEntities.ReplicaStatusPending,
+		StatusReason: movingReason,
+		Replaces:     device.ID,
+		UpdatedAt:    time.Now(),
+	})
+	updated, err := uc.fileRepo.UpdatePlacements(ctx, file)
+	if err != nil || !updated {
+		uc.releaseReservation(ctx, file, target, file.Size)
+		return false, err
+	}
+
+	if err = uc.transfers.Execute(ctx, file.UserID, file.ID, target.ID); err != nil {
+		reason := fmt.Sprintf("could not be queued: %v", err)
+		if updateErr := uc.fileRepo.UpdateReplicaStatus(
+			ctx, file.UserID, file.ID, target.ID, fileEntities.ReplicaStatusFailed, reason,
+		); updateErr != nil {
+			uc.logger.Warn("Failed to update replica", zap.String("file_id", file.ID.Hex()), zap.Error(updateErr))
+		}
+		uc.releaseReservation(ctx, file, target, file.Size)
+		return false, err
+	}
+
+	uc.logger.Info(
+		"Moving file off a draining device",
+		zap.String("file_id", file.ID.Hex()),
+		zap.String("from_device_id", device.ID.Hex()),
+		zap.String("to_device_id", target.ID.Hex()),
+	)
+	return true, nil
+}
+
+// dropReplica deletes the file's replica at index own from the draining device, if it is online,
+// and from the file's record. The replica at index successor, if any, takes over from it.
+func (uc *MigrateDrainingFilesUseCase) dropReplica(
+	ctx context.Context, file *fileEntities.File, device *entities.Device, own, successor int,
+) (bool, error) {
+	if device.Status == entities.DeviceStatusOnline {
+		if err := deleteObject(ctx, uc.deviceStorage, device, file.ID.Hex()); err != nil {
+			return false, err
+		}
+	}
+
+	takeOver(file, own, successor)
+	return uc.fileRepo.UpdatePlacements(ctx, file)
+}
+
+// takeOver drops the file's replica at index own, and has the replica at index successor, if
+// any, take over from it
+func takeOver(file *fileEntities.File, own, successor int) {
+	if successor >= 0 {
+		file.Replicas[successor].Replaces = primitive.NilObjectID
+		if file.StoredOn == file.Replicas[own].DeviceID {
+			file.StoredOn = file.Replicas[successor].DeviceID
+		}
+	}
+	file.Replicas = append(file.Replicas[:own], file.Replicas[own+1:]...)
+}
+
+// moveShards copies the file's shards on the draining device to other devices, one at a time
+func (uc *MigrateDrainingFilesUseCase) moveShards(
+	ctx context.Context, file *fileEntities.File, device *entities.Device, targets []*entities.Device,
+) error {
+	for _, shard := range file.Shards {
+		if shard.DeviceID != device.ID {
+			continue
+		}
+
+		var err error
+		switch {
+		case shard.Status == fileEntities.ReplicaStatusPending:
+			continue // Still being written by its upload
+		case !onDevice(shard.Status) || shard.Status == fileEntities.ReplicaStatusCorrupted:
+			err = uc.dropShard(ctx, file, device, shard.Index)
+		case device.Status != entities.DeviceStatusOnline:
+			continue // Shards are copied from the device itself, once it is back
+		default:
+			err = uc.moveShard(ctx, file, device, shard.Index, targets)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveShard copies a shard from the draining device to another device, points the file's record
+// to the copy and deletes the original. A copy the record could not be pointed to is deleted from
+// the target again, and the space reserved for it given back.
+func (uc *MigrateDrainingFilesUseCase) moveShard(
+	ctx context.Context, file *fileEntities.File, device *entities.Device, index int, targets []*entities.Device,
+) error {
+	target, err := uc.reserveTarget(ctx, file, device, targets, file.Erasure.ShardSize)
+	if err != nil {
+		return err
+	}
+
+	objectID := file.ShardObjectID(index)
+	if err = uc.copyShard(ctx, device, target, objectID); err != nil {
+		uc.releaseReservation(ctx, file, target, file.Erasure.ShardSize)
+		return err
+	}
+
+	moved := false
+	err = retryPlacements(ctx, uc.fileRepo, file, func(file *fileEntities.File) (bool, error) {
+		for i := range file.Shards {
+			if file.Shards[i].Index == index && file.Shards[i].DeviceID == device.ID {
+				file.Shards[i].DeviceID = target.ID
+				file.Shards[i].Status = fileEntities.ReplicaStatusStored
+				file.Shards[i].StatusReason = ""
+				file.Shards[i].UpdatedAt = time.Now()
+				applied, updateErr := uc.fileRepo.UpdatePlacements(ctx, file)
+				moved = applied && updateErr == nil
+				return applied, updateErr
+			}
+		}
+		// Moved or dropped in the meantime, possibly to the same target
+		moved = slices.ContainsFunc(file.Shards, func(shard fileEntities.Shard) bool {
+			return shard.Index == index && shard.DeviceID == target.ID
+		})
+		return true, nil
+	})
+	if err != nil || !moved {
+		// The record does not point to the copy, so it is of no use
+		if deleteErr := deleteObject(ctx, uc.deviceStorage, target, objectID); deleteErr != nil {
+			uc.logger.Warn(
+				"Failed to delete unused shard copy",
+				zap.String("file_id", file.ID.Hex()), zap.String("device_id", target.ID.Hex()), zap.Error(deleteErr),
+			)
+		}
+		uc.releaseReservation(ctx, file, target, file.Erasure.ShardSize)
+		return err
+	}
+
+	if err = uc.deviceRepo.ConfirmReservation(ctx, file.UserID, target.ID, file.ID); err != nil {
+		uc.logger.Warn("Failed to confirm reservation", zap.String("file_id", file.ID.Hex()), zap.Error(err))
+	}
+
+	uc.logger.Info(
+		"Moved shard off a draining device",
+		zap.String("file_id", file.ID.Hex()),
+		zap.Int("index", index),
+		zap.String("from_device_id", device.ID.Hex()),
+		zap.String("to_device_id", target.ID.Hex()),
+	)
+	return deleteObject(ctx, uc.deviceStorage, device, objectID)
+}
+
+// copyShard copies the object from the draining device to the target and has the target confirm it
+func (uc *MigrateDrainingFilesUseCase) copyShard(ctx context.Context, device, target *entities.Device, objectID string) error {
+	content, err := uc.deviceStorage.OpenFile(ctx, device, objectID, nil)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if err = uc.deviceStorage.StoreFile(ctx, target, objectID, content); err != nil {
+		return fmt.Errorf("upload to device failed: %w", err)
+	}
+	if err = uc.deviceStorage.ConfirmFile(ctx, target, objectID, ""); err != nil {
+		return fmt.Errorf("device did not confirm shard: %w", err)
+	}
+	return nil
+}
+
+// reserveTarget picks the drain target for size bytes of the file, as drainTarget does, and
+// reserves the space on it. Targets that turn out to have less room than they last reported are
+// passed over for the next best one.
+func (uc *MigrateDrainingFilesUseCase) reserveTarget(
+	ctx context.Context, file *fileEntities.File, draining *entities.Device, targets []*entities.Device, size int64,
+) (*entities.Device, error) {
+	for {
+		target := drainTarget(file, draining, targets, size)
+		if target == nil {
+			return nil, errNoDrainTarget
+		}
+
+		reserved, err := uc.deviceRepo.Reserve(ctx, file.UserID, target.ID, file.ID, size, time.Now().Add(uc.reservationTTL))
+		if err != nil {
+			return nil, err
+		}
+		if reserved {
+			// Taken off here too, so the rest of the round does not count on it
+			target.AvailableStorage -= size
+			return target, nil
+		}
+		targets = slices.DeleteFunc(slices.Clone(targets), func(device *entities.Device) bool { return device == target })
+	}
+}
+
+// releaseReservation gives the size bytes reserved for the file on the target back, for a move
+// that did not come about. Should this fail, the reservation still runs out by itself.
+func (uc *MigrateDrainingFilesUseCase) releaseReservation(
+	ctx context.Context, file *fileEntities.File, target *entities.Device, size int64,
+) {
+	if err := uc.deviceRepo.ReleaseReservation(ctx, file.UserID, target.ID, file.ID); err != nil {
+		uc.logger.Warn("Failed to release reservation", zap.String("file_id", file.ID.Hex()), zap.Error(err))
+		return
+	}
+	target.AvailableStorage += size
+}
+
+// dropShard deletes a shard that is of no use from the draining device, if it is online, and from
+// the file's record
+func (uc *MigrateDrainingFilesUseCase) dropShard(
+	ctx context.Context, file *fileEntities.File, device *entities.Device, index int,
+) error {
+	if device.Status == entities.DeviceStatusOnline {
+		if err := deleteObject(ctx, uc.deviceStorage, device, file.ShardObjectID(index)); err != nil {
+			return err
+		}
+	}
+
+	return retryPlacements(ctx, uc.fileRepo, file, func(file *fileEntities.File) (bool, error) {
+		for i := range file.Shards {
+			if file.Shards[i].Index == index && file.Shards[i].DeviceID == device.ID {
+				file.Shards = append(file.Shards[:i], file.Shards[i+1:]...)
+				return uc.fileRepo.UpdatePlacements(ctx, file)
+			}
+		}
+		return true, nil
+	})
+}
+
+// deleteObject deletes the object from the device. An object it does not hold counts as deleted.
+func deleteObject(
+	ctx context.Context, deviceStorage fileRepository.DeviceStorageRepository, device *entities.Device, objectID string,
+) error {
+	err := deviceStorage.DeleteFile(ctx, device, objectID)
+	if err != nil && !errors.Is(err, fileRepository.ErrObjectNotFound) {
+		return err
+	}
+	return nil
+}
+
+// drainTarget picks the device with the most free space that can take size bytes of the file off
+// the draining device, or nil if none can. A target must not be draining or failed, or hold any
+// of the file already. Shards can only be copied to a device that is online.
+func drainTarget(file *fileEntities.File, draining *entities.Device, targets []*entities.Device, size int64) *entities.Device {
+	placed := make(map[primitive.ObjectID]bool)
+	for _, deviceID := range file.PlacementDevices() {
+		placed[deviceID] = true
+	}
+
+	var best *entities.Device
+	for _, device := range targets {
+		if device.ID == draining.ID || device.Draining() || placed[device.ID] || device.AvailableStorage < size {
+			continue
+		}
+		if device.Status == entities.DeviceStatusFailed {
+			continue
+		}
+		if file.Erasure != nil && device.Status != entities.DeviceStatusOnline {
+			continue
+		}
+		if best == nil || device.AvailableStorage > best.AvailableStorage {
+			best = device
+		}
+	}
+	return best
+}
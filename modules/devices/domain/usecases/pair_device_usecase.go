@@ -0,0 +1,49 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+)
+
+// PairDeviceUseCase enrolls a device that presents a pairing code, on behalf of the user who
+// created the code. The code is spent even if the enrollment then fails.
+type PairDeviceUseCase struct {
+	pairingRepo   repository.PairingCodeRepository
+	enrollUseCase *EnrollDeviceUseCase
+}
+
+func NewPairDeviceUseCase(pairingRepo repository.PairingCodeRepository, enrollUseCase *EnrollDeviceUseCase) *PairDeviceUseCase {
+	return &PairDeviceUseCase{
+		pairingRepo:   pairingRepo,
+		enrollUseCase: enrollUseCase,
+	}
+}
+
+// Execute returns the enrolled device, its certificate when mutual TLS is enabled, and the ID of
+// the user it was enrolled for
+func (uc *PairDeviceUseCase) Execute(
+	ctx context.Context, code string, req entities.DeviceEnrollmentRequest,
+) (*entities.Device, *entities.DeviceCertificate, string, error) {
+	// Checked before the code is spent, so a device without its CSR can try again
+	if uc.enrollUseCase.authority != nil && req.CSR == "" {
+		return nil, nil, "", ErrCSRRequired
+	}
+
+	pairing, err := uc.pairingRepo.Redeem(ctx, hashPairingCode(code), time.Now())
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if pairing == nil {
+		return nil, nil, "", ErrInvalidPairingCode
+	}
+
+	userID := pairing.UserID.Hex()
+	device, certificate, err := uc.enrollUseCase.Execute(ctx, userID, req)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return device, certificate, userID, nil
+}
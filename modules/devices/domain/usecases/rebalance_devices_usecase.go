@@ -0,0 +1,356 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	transferRepository "github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// rebalancingReason is the status reason of a copy on its way to even out how full devices are
+const rebalancingReason = "moving to rebalance storage"
+
+// RebalanceDevicesUseCase evens out how full a user's devices are. Each online device that is not
+// draining should be at the utilization of all of them taken together, give or take threshold of
+// its total storage. The plan moves as few replicas as it takes to bring the devices outside of
+// that back in, largest files first, without taking any other device out of it.
+//
+// Moves are queued as transfers behind the ones uploads are waiting on, each written at no more
+// than bandwidthLimit bytes per second, and at most maxMoves are on their way at a time. A moved
+// replica gets a successor on the emptier device, with its space reserved there until its transfer
+// settles, and is deleted from the fuller one once its successor is stored. Erasure-coded files stay
+// where they are, as shards are not queued as transfers.
+type RebalanceDevicesUseCase struct {
+	deviceRepo     repository.DeviceRepository
+	fileRepo       fileRepository.FileRepository
+	deviceStorage  fileRepository.DeviceStorageRepository
+	transfers      transferRepository.BackgroundTransferEnqueuer
+	threshold      float64
+	bandwidthLimit int64
+	maxMoves       int
+	schedule       time.Duration // How often every user's devices are rebalanced; zero only does so on demand
+	reservationTTL time.Duration // How long space reserved on a successor's device is held
+	logger         *zap.Logger
+}
+
+func NewRebalanceDevicesUseCase(
+	deviceRepo repository.DeviceRepository,
+	fileRepo fileRepository.FileRepository,
+	deviceStorage fileRepository.DeviceStorageRepository,
+	transfers transferRepository.BackgroundTransferEnqueuer,
+	threshold float64,
+	bandwidthLimit int64,
+	maxMoves int,
+	schedule time.Duration,
+	reservationTTL time.Duration,
+	logger *zap.Logger,
+) *RebalanceDevicesUseCase {
+	return &RebalanceDevicesUseCase{
+		deviceRepo:     deviceRepo,
+		fileRepo:       fileRepo,
+		deviceStorage:  deviceStorage,
+		transfers:      transfers,
+		threshold:      threshold,
+		bandwidthLimit: bandwidthLimit,
+		maxMoves:       maxMoves,
+		schedule:       schedule,
+		reservationTTL: reservationTTL,
+		logger:         logger,
+	}
+}
+
+// Plan works out the moves that would rebalance the user's devices, without making them
+func (uc *RebalanceDevicesUseCase) Plan(ctx context.Context, userID string) (*entities.RebalancePlan, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	plan, _, err := uc.plan(ctx, userObjectID)
+	return plan, err
+}
+
+// Start queues the moves that rebalance the user's devices, and returns the plan they were queued for
+func (uc *RebalanceDevicesUseCase) Start(ctx context.Context, userID string) (*entities.RebalancePlan, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+	return uc.rebalance(ctx, userObjectID)
+}
+
+// Run settles the moves that are done every interval until ctx is cancelled and, with a schedule,
+// rebalances every user's devices each time it comes round
+func (uc *RebalanceDevicesUseCase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastRebalanced := time.Now()
+	for {
+		due := uc.schedule > 0 && time.Since(lastRebalanced) >= uc.schedule
+		if err := uc.Execute(ctx, due); err != nil && ctx.Err() == nil {
+			uc.logger.Warn("Storage rebalancing incomplete", zap.Error(err))
+		}
+		if due {
+			lastRebalanced = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Execute settles the moves that are done and, if rebalance is set, queues new ones for every user
+func (uc *RebalanceDevicesUseCase) Execute(ctx context.Context, rebalance bool) error {
+	firstErr := uc.settle(ctx)
+	if !rebalance {
+		return firstErr
+	}
+
+	userIDs, err := uc.deviceRepo.GetUserIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, userID := range userIDs {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, err = uc.rebalance(ctx, userID); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("user %s: %w", userID.Hex(), err)
+		}
+	}
+	return firstErr
+}
+
+// plan works out the user's rebalance plan, returning the files it considered by ID along with it
+func (uc *RebalanceDevicesUseCase) plan(
+	ctx context.Context, userID primitive.ObjectID,
+) (*entities.RebalancePlan, map[primitive.ObjectID]*fileEntities.File, error) {
+	devices, err := uc.deviceRepo.GetAllByUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	files, err := uc.fileRepo.GetAllByUser(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	planner := newRebalancePlanner(devices, files, uc.threshold)
+	plan := &entities.RebalancePlan{
+		TargetUtilization: planner.target,
+		MovingFiles:       planner.moving,
+	}
+	plan.Moves = planner.plan(uc.maxMoves)
+	plan.Devices = planner.balances()
+
+	byID := make(map[primitive.ObjectID]*fileEntities.File, len(files))
+	for _, file := range files {
+		byID[file.ID] = file
+	}
+	return plan, byID, nil
+}
+
+// rebalance plans the user's moves and queues them, leaving out the ones whose file changed in the meantime
+func (uc *RebalanceDevicesUseCase) rebalance(ctx context.Context, userID primitive.ObjectID) (*entities.RebalancePlan, error) {
+	plan, files, err := uc.plan(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	moves := plan.Moves
+	plan.Moves = make([]entities.RebalanceMove, 0, len(moves))
+	for _, move := range moves {
+		queued, queueErr := uc.queueMove(ctx, files[move.FileID], move)
+		if queueErr != nil {
+			return plan, fmt.Errorf("file %s: %w", move.FileID.Hex(), queueErr)
+		}
+		if queued {
+			plan.Moves = append(plan.Moves, move)
+		}
+	}
+	return plan, nil
+}
+
+// queueMove reserves space for the file on the move's target, adds a pending replica there that takes
+// over from the one on its source, and queues the transfer that writes it; the transfer settles the
+// reservation once it is done. It reports false if the file changed so that the move no longer
+// applies, or the target no longer has room for it.
+func (uc *RebalanceDevicesUseCase) queueMove(ctx context.Context, file *fileEntities.File, move entities.RebalanceMove) (bool, error) {
+	reserved, err := uc.deviceRepo.Reserve(
+		ctx, file.UserID, move.ToDeviceID, file.ID, file.Size, time.Now().Add(uc.reservationTTL),
+	)
+	if err != nil || !reserved {
+		return false, err
+	}
+
+	added := false
+	err = retryPlacements(ctx, uc.fileRepo, file, func(file *fileEntities.File) (bool, error) {
+		if !canMove(file, move) {
+			return true, nil
+		}
+
+		file.Replicas = append(file.AllReplicas(), fileEntities.Replica{
+			DeviceID:     move.ToDeviceID,
+			Status:       fileEntities.ReplicaStatusPending,
+			StatusReason: rebalancingReason,
+			Replaces:     move.FromDeviceID,
+			UpdatedAt:    time.Now(),
+		})
+		var updateErr error
+		added, updateErr = uc.fileRepo.UpdatePlacements(ctx, file)
+		return added, updateErr
+	})
+	if err != nil || !added {
+		uc.releaseReservation(ctx, file, move)
+		return false, err
+	}
+
+	if err = uc.transfers.ExecuteBackground(ctx, file.UserID, file.ID, move.ToDeviceID, uc.bandwidthLimit); err != nil {
+		reason := fmt.Sprintf("could not be queued: %v", err)
+		if updateErr := uc.fileRepo.UpdateReplicaStatus(
+			ctx, file.UserID, file.ID, move.ToDeviceID, fileEntities.ReplicaStatusFailed, reason,
+		); updateErr != nil {
+			uc.logger.Warn("Failed to update replica", zap.String("file_id", file.ID.Hex()), zap.Error(updateErr))
+		}
+		uc.releaseReservation(ctx, file, move)
+		return false, err
+	}
+
+	uc.logger.Info(
+		"Moving file to rebalance storage",
+		zap.String("file_id", file.ID.Hex()),
+		zap.String("from_device_id", move.FromDeviceID.Hex()),
+		zap.String("to_device_id", move.ToDeviceID.Hex()),
+	)
+	return true, nil
+}
+
+// releaseReservation gives the space reserved for the file on the move's target back, for a move
+// that did not come about. Should this fail, the reservation still runs out by itself.
+func (uc *RebalanceDevicesUseCase) releaseReservation(ctx context.Context, file *fileEntities.File, move entities.RebalanceMove) {
+	if err := uc.deviceRepo.ReleaseReservation(ctx, file.UserID, move.ToDeviceID, file.ID); err != nil {
+		uc.logger.Warn("Failed to release reservation", zap.String("file_id", file.ID.Hex()), zap.Error(err))
+	}
+}
+
+// settle takes every move that is on its way one step further. Moves off draining devices are
+// left to MigrateDrainingFilesUseCase.
+func (uc *RebalanceDevicesUseCase) settle(ctx context.Context) error {
+	files, err := uc.fileRepo.GetAllMoving(ctx)
+	if err != nil {
+		return err
+	}
+
+	devices := make(map[primitive.ObjectID]*entities.Device)
+	var firstErr error
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if file.Removed() {
+			continue
+		}
+
+		settleErr := retryPlacements(ctx, uc.fileRepo, file, func(file *fileEntities.File) (bool, error) {
+			return uc.settleMove(ctx, file, devices)
+		})
+		if settleErr != nil && firstErr == nil {
+			firstErr = fmt.Errorf("file %s: %w", file.ID.Hex(), settleErr)
+		}
+	}
+	return firstErr
+}
+
+// settleMove deletes the replica a stored successor takes over from, once its device is online,
+// and drops a successor that was given up on. It reports false if the file changed before its
+// update could be applied.
+func (uc *RebalanceDevicesUseCase) settleMove(
+	ctx context.Context, file *fileEntities.File, devices map[primitive.ObjectID]*entities.Device,
+) (bool, error) {
+	file.Replicas = file.AllReplicas()
+	for successor, replica := range file.Replicas {
+		if replica.Replaces.IsZero() || replica.Status == fileEntities.ReplicaStatusPending {
+			continue
+		}
+
+		source, err := uc.device(ctx, file.UserID, replica.Replaces, devices)
+		if err != nil {
+			return false, err
+		}
+		if source != nil && source.Draining() {
+			continue
+		}
+
+		own := -1
+		for i := range file.Replicas {
+			if file.Replicas[i].DeviceID == replica.Replaces {
+				own = i
+			}
+		}
+
+		switch {
+		case replica.Status != fileEntities.ReplicaStatusStored:
+			// Given up on, so the file stays where it was until it is planned again
+			file.Replicas = append(file.Replicas[:successor], file.Replicas[successor+1:]...)
+		case own < 0 || source == nil:
+			// The copy it takes over from is already gone
+			file.Replicas[successor].Replaces = primitive.NilObjectID
+		case source.Status != entities.DeviceStatusOnline:
+			continue // Deleted once its device is back
+		default:
+			if err = deleteObject(ctx, uc.deviceStorage, source, file.ID.Hex()); err != nil {
+				return false, err
+			}
+			takeOver(file, own, successor)
+		}
+		return uc.fileRepo.UpdatePlacements(ctx, file)
+	}
+	return true, nil
+}
+
+// device returns the user's device, nil once it is removed, looking it up once per round
+func (uc *RebalanceDevicesUseCase) device(
+	ctx context.Context, userID, deviceID primitive.ObjectID, devices map[primitive.ObjectID]*entities.Device,
+) (*entities.Device, error) {
+	if device, ok := devices[deviceID]; ok {
+		return device, nil
+	}
+
+	device, err := uc.deviceRepo.GetByID(ctx, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	devices[deviceID] = device
+	return device, nil
+}
+
+// canMove reports whether the move still applies to the file: it is stored, with a stored replica
+// on the move's source, none on its target and no other move on its way
+func canMove(file *fileEntities.File, move entities.RebalanceMove) bool {
+	if file.Removed() || file.Status != fileEntities.FileStatusStored || file.Erasure != nil {
+		return false
+	}
+
+	onSource := false
+	for _, replica := range file.AllReplicas() {
+		switch {
+		case !replica.Replaces.IsZero(), replica.DeviceID == move.ToDeviceID:
+			return false
+		case replica.DeviceID == move.FromDeviceID && replica.Status == fileEntities.ReplicaStatusStored:
+			onSource = true
+		}
+	}
+	return onSource
+}
@@ -0,0 +1,213 @@
+package usecases
+
+import (
+	"sort"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// rebalancePlanner works out the moves that rebalance one user's devices, one at a time. Only
+// online devices that are not draining take part, as the space the others report may be out of date.
+type rebalancePlanner struct {
+	devices   []*entities.Device
+	target    float64 // Utilization every device should be at
+	threshold float64 // Share of its total storage a device may be off target by
+	moving    int     // Files whose move is on its way
+
+	used     map[primitive.ObjectID]int64                // Space in use once the moves on their way and planned are done
+	incoming map[primitive.ObjectID]int64                // Space the moves on their way and planned take up on their target
+	movable  map[primitive.ObjectID][]*fileEntities.File // Files with a stored replica on a device, largest first
+	moved    map[primitive.ObjectID]bool                 // Files with a move on its way or planned
+}
+
+func newRebalancePlanner(devices []*entities.Device, files []*fileEntities.File, threshold float64) *rebalancePlanner {
+	p := &rebalancePlanner{
+		threshold: threshold,
+		used:      make(map[primitive.ObjectID]int64),
+		incoming:  make(map[primitive.ObjectID]int64),
+		movable:   make(map[primitive.ObjectID][]*fileEntities.File),
+		moved:     make(map[primitive.ObjectID]bool),
+	}
+
+	var total, used int64
+	for _, device := range devices {
+		if device.Status != entities.DeviceStatusOnline || device.Draining() || device.TotalStorage <= 0 {
+			continue
+		}
+		p.devices = append(p.devices, device)
+		p.used[device.ID] = device.UsedStorage
+		total += device.TotalStorage
+		used += device.UsedStorage
+	}
+	if total > 0 {
+		p.target = float64(used) / float64(total)
+	}
+
+	for _, file := range files {
+		if !file.Removed() {
+			p.track(file)
+		}
+	}
+	for _, candidates := range p.movable {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].Size > candidates[j].Size
+		})
+	}
+	return p
+}
+
+// track counts the file's move if one is on its way, or lists it as movable off the devices it is stored on
+func (p *rebalancePlanner) track(file *fileEntities.File) {
+	replicas := file.AllReplicas()
+	for _, replica := range replicas {
+		if replica.Replaces.IsZero() {
+			continue
+		}
+
+		p.moved[file.ID] = true
+		if replica.Status == fileEntities.ReplicaStatusPending {
+			p.moving++
+			p.shift(replica.Replaces, replica.DeviceID, file.Size)
+		}
+	}
+
+	if p.moved[file.ID] || file.Erasure != nil || file.Status != fileEntities.FileStatusStored || file.Size <= 0 {
+		return
+	}
+	for _, replica := range replicas {
+		if _, ok := p.used[replica.DeviceID]; ok && replica.Status == fileEntities.ReplicaStatusStored {
+			p.movable[replica.DeviceID] = append(p.movable[replica.DeviceID], file)
+		}
+	}
+}
+
+// plan works out the moves to make now, keeping the moves on their way and planned to maxMoves
+func (p *rebalancePlanner) plan(maxMoves int) []entities.RebalanceMove {
+	var moves []entities.RebalanceMove
+	for len(moves) < maxMoves-p.moving {
+		move, ok := p.next()
+		if !ok {
+			break
+		}
+		p.apply(move)
+		moves = append(moves, move)
+	}
+	return moves
+}
+
+// next picks the largest file on the fullest device that fits on the emptiest device, trying
+// emptier sources and fuller targets after that. A move is only made when its source or its
+// target is outside the threshold, and it brings both closer to target without taking either
+// outside of it.
+func (p *rebalancePlanner) next() (entities.RebalanceMove, bool) {
+	sources := p.ordered(func(deviation float64) bool { return deviation > 0 })
+	targets := p.ordered(func(deviation float64) bool { return deviation < 0 })
+
+	for _, source := range sources {
+		excess := p.deviation(source)
+		for i := len(targets) - 1; i >= 0; i-- {
+			target := targets[i]
+			deficit := -p.deviation(target)
+			if excess <= p.band(source) && deficit <= p.band(target) {
+				continue
+			}
+
+			limit := min(excess+p.band(source), deficit+p.band(target), excess+deficit-1)
+			limit = min(limit, float64(target.AvailableStorage-p.incoming[target.ID]))
+			if file := p.largestFitting(source, target, limit); file != nil {
+				return entities.RebalanceMove{
+					FileID:       file.ID,
+					FileName:     file.Name,
+					Size:         file.Size,
+					FromDeviceID: source.ID,
+					ToDeviceID:   target.ID,
+				}, true
+			}
+		}
+	}
+	return entities.RebalanceMove{}, false
+}
+
+// apply takes a planned move into account
+func (p *rebalancePlanner) apply(move entities.RebalanceMove) {
+	p.moved[move.FileID] = true
+	p.shift(move.FromDeviceID, move.ToDeviceID, move.Size)
+}
+
+// balances returns how full each device is now and once the moves are done
+func (p *rebalancePlanner) balances() []entities.DeviceBalance {
+	balances := make([]entities.DeviceBalance, 0, len(p.devices))
+	for _, device := range p.devices {
+		balances = append(balances, entities.DeviceBalance{
+			DeviceID:           device.ID,
+			Name:               device.Name,
+			TotalStorage:       device.TotalStorage,
+			UsedStorage:        device.UsedStorage,
+			Utilization:        float64(device.UsedStorage) / float64(device.TotalStorage),
+			PlannedUtilization: float64(p.used[device.ID]) / float64(device.TotalStorage),
+		})
+	}
+	return balances
+}
+
+// shift moves size bytes from one device to another, leaving out devices that take no part
+func (p *rebalancePlanner) shift(from, to primitive.ObjectID, size int64) {
+	if _, ok := p.used[from]; ok {
+		p.used[from] -= size
+	}
+	if _, ok := p.used[to]; ok {
+		p.used[to] += size
+		p.incoming[to] += size
+	}
+}
+
+// largestFitting returns the largest file on source, not moved yet and not on target, that is
+// no larger than limit
+func (p *rebalancePlanner) largestFitting(source, target *entities.Device, limit float64) *fileEntities.File {
+	for _, file := range p.movable[source.ID] {
+		if p.moved[file.ID] || float64(file.Size) > limit {
+			continue
+		}
+		if !onAnyReplica(file, target.ID) {
+			return file
+		}
+	}
+	return nil
+}
+
+// ordered returns the devices whose deviation from target keep accepts, fullest first
+func (p *rebalancePlanner) ordered(keep func(deviation float64) bool) []*entities.Device {
+	var devices []*entities.Device
+	for _, device := range p.devices {
+		if keep(p.deviation(device)) {
+			devices = append(devices, device)
+		}
+	}
+	sort.SliceStable(devices, func(i, j int) bool {
+		return p.deviation(devices[i]) > p.deviation(devices[j])
+	})
+	return devices
+}
+
+// deviation is how many bytes more than target the device will hold
+func (p *rebalancePlanner) deviation(device *entities.Device) float64 {
+	return float64(p.used[device.ID]) - p.target*float64(device.TotalStorage)
+}
+
+// band is how many bytes the device may be off target by
+func (p *rebalancePlanner) band(device *entities.Device) float64 {
+	return p.threshold * float64(device.TotalStorage)
+}
+
+// onAnyReplica reports whether the file has a replica on the device, whatever its status
+func onAnyReplica(file *fileEntities.File, deviceID primitive.ObjectID) bool {
+	for _, replica := range file.AllReplicas() {
+		if replica.DeviceID == deviceID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package usecases
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// device returns an online device of 100 bytes with used of them in use
+func device(name string, used int64) *entities.Device {
+	return &entities.Device{
+		ID:               primitive.NewObjectID(),
+		Name:             name,
+		Status:           entities.DeviceStatusOnline,
+		TotalStorage:     100,
+		UsedStorage:      used,
+		AvailableStorage: 100 - used,
+	}
+}
+
+// storedFile returns a stored file of size bytes with a stored replica on each of the devices
+func storedFile(name string, size int64, devices ...*entities.Device) *fileEntities.File {
+	file := &fileEntities.File{
+		ID:     primitive.NewObjectID(),
+		Name:   name,
+		Size:   size,
+		Status: fileEntities.FileStatusStored,
+	}
+	for _, d := range devices {
+		file.Replicas = append(file.Replicas, fileEntities.Replica{DeviceID: d.ID, Status: fileEntities.ReplicaStatusStored})
+	}
+	return file
+}
+
+// describe returns the moves as "file:from->to", by device name
+func describe(moves []entities.RebalanceMove, devices []*entities.Device) []string {
+	named := make(map[primitive.ObjectID]string, len(devices))
+	for _, d := range devices {
+		named[d.ID] = d.Name
+	}
+
+	described := make([]string, len(moves))
+	for i, move := range moves {
+		described[i] = fmt.Sprintf("%s:%s->%s", move.FileName, named[move.FromDeviceID], named[move.ToDeviceID])
+	}
+	return described
+}
+
+func TestRebalancePlanner(t *testing.T) {
+	even1, even2 := device("even-1", 50), device("even-2", 50)
+
+	full, half1, half2 := device("full", 90), device("half-1", 30), device("half-2", 30)
+	onFull := []*fileEntities.File{
+		storedFile("small", 5, full), storedFile("large", 30, full),
+		storedFile("medium", 20, full), storedFile("smaller", 15, full),
+	}
+
+	over, under := device("over", 60), device("under", 40)
+	offTarget := []*fileEntities.File{storedFile("twenty", 20, over), storedFile("ten", 10, over)}
+
+	busy, idle := device("busy", 90), device("idle", 50)
+	draining, offline := device("draining", 10), device("offline", 10)
+	draining.DrainingSince = &time.Time{}
+	offline.Status = entities.DeviceStatusOffline
+
+	// A move from full to half-1 is on its way
+	moving := storedFile("moving", 10, full)
+	moving.Replicas = append(moving.Replicas, fileEntities.Replica{
+		DeviceID: half1.ID, Status: fileEntities.ReplicaStatusPending, Replaces: full.ID,
+	})
+
+	tests := []struct {
+		name      string
+		devices   []*entities.Device
+		files     []*fileEntities.File
+		threshold float64
+		maxMoves  int
+		want      []string
+	}{
+		{
+			name:    "already balanced",
+			devices: []*entities.Device{even1, even2}, files: []*fileEntities.File{storedFile("file", 10, even1)},
+			threshold: 0.1, maxMoves: 10, want: []string{},
+		},
+		{
+			name:    "one overfull device, largest first",
+			devices: []*entities.Device{full, half1, half2}, files: onFull,
+			threshold: 0.1, maxMoves: 10, want: []string{"large:full->half-2", "medium:full->half-1"},
+		},
+		{
+			name:    "off target by exactly the threshold",
+			devices: []*entities.Device{over, under}, files: offTarget,
+			threshold: 0.1, maxMoves: 10, want: []string{},
+		},
+		{
+			name:    "just past the threshold, without overshooting",
+			devices: []*entities.Device{over, under}, files: offTarget,
+			threshold: 0.09, maxMoves: 10, want: []string{"ten:over->under"},
+		},
+		{
+			name:    "draining and offline devices left out",
+			devices: []*entities.Device{busy, draining, offline, idle},
+			files: []*fileEntities.File{
+				storedFile("busy-file", 25, busy), storedFile("draining-file", 10, draining),
+				storedFile("offline-file", 10, offline),
+			},
+			threshold: 0.1, maxMoves: 10, want: []string{"busy-file:busy->idle"},
+		},
+		{
+			name:    "capped at maxMoves",
+			devices: []*entities.Device{full, half1, half2}, files: onFull,
+			threshold: 0.1, maxMoves: 1, want: []string{"large:full->half-2"},
+		},
+		{
+			name:    "moves on their way count towards maxMoves",
+			devices: []*entities.Device{full, half1, half2}, files: append(slices.Clone(onFull), moving),
+			threshold: 0.1, maxMoves: 2, want: []string{"large:full->half-2"},
+		},
+		{
+			name:    "no moves left once those on their way reach maxMoves",
+			devices: []*entities.Device{full, half1, half2}, files: append(slices.Clone(onFull), moving),
+			threshold: 0.1, maxMoves: 1, want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			planner := newRebalancePlanner(tt.devices, tt.files, tt.threshold)
+			got := describe(planner.plan(tt.maxMoves), tt.devices)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("plan() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRebalancePlannerBalances(t *testing.T) {
+	busy, idle := device("busy", 90), device("idle", 50)
+	draining, offline := device("draining", 10), device("offline", 10)
+	draining.DrainingSince = &time.Time{}
+	offline.Status = entities.DeviceStatusOffline
+
+	planner := newRebalancePlanner(
+		[]*entities.Device{busy, draining, offline, idle}, []*fileEntities.File{storedFile("file", 25, busy)}, 0.1,
+	)
+	if planner.target != 0.7 {
+		t.Errorf("target = %v, want 0.7 from the online devices that are not draining", planner.target)
+	}
+	planner.plan(10)
+
+	balances := planner.balances()
+	if len(balances) != 2 {
+		t.Fatalf("balances() has %d devices, want busy and idle", len(balances))
+	}
+	for i, want := range []struct {
+		name             string
+		now, whenPlanned float64
+	}{{"busy", 0.9, 0.65}, {"idle", 0.5, 0.75}} {
+		got := balances[i]
+		if got.Name != want.name || got.Utilization != want.now || got.PlannedUtilization != want.whenPlanned {
+			t.Errorf(
+				"balances()[%d] = %s at %v, %v planned; want %s at %v, %v planned",
+				i, got.Name, got.Utilization, got.PlannedUtilization, want.name, want.now, want.whenPlanned,
+			)
+		}
+	}
+}
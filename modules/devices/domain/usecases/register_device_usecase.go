@@ -5,6 +5,7 @@ import (
 	"errors"
 	"time"
 
+	"github.com/manab-pr/nebulo/internal/device_server/signing"
 	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
 	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
 
@@ -35,7 +36,13 @@ func (uc *RegisterDeviceUseCase) Execute(
 		return nil, errors.New("device with this IP address already exists in your account")
 	}
 
+	secret, err := signing.NewSecret()
+	if err != nil {
+		return nil, err
+	}
+
 	// Create new device
+	now := time.Now()
 	device := &entities.Device{
 		ID:               primitive.NewObjectID(),
 		UserID:           userObjectID,
@@ -45,10 +52,13 @@ func (uc *RegisterDeviceUseCase) Execute(
 		TotalStorage:     req.TotalStorage,
 		AvailableStorage: req.TotalStorage,
 		UsedStorage:      0,
+		Labels:           req.Labels,
+		Secret:           secret,
 		Status:           entities.DeviceStatusOnline,
-		LastHeartbeat:    time.Now(),
-		CreatedAt:        time.Now(),
-		UpdatedAt:        time.Now(),
+		StatusChangedAt:  now,
+		LastHeartbeat:    now,
+		CreatedAt:        now,
+		UpdatedAt:        now,
 	}
 
 	createdDevice, err := uc.deviceRepo.Create(ctx, device)
@@ -0,0 +1,75 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RenewCertificateUseCase rotates a device's certificate: it issues one for the key in a new
+// certificate signing request and revokes the one it replaces
+type RenewCertificateUseCase struct {
+	deviceRepo   repository.DeviceRepository
+	authority    repository.CertificateAuthority // nil while mutual TLS is off
+	certificates repository.CertificateRepository
+}
+
+func NewRenewCertificateUseCase(
+	deviceRepo repository.DeviceRepository,
+	authority repository.CertificateAuthority,
+	certificates repository.CertificateRepository,
+) *RenewCertificateUseCase {
+	return &RenewCertificateUseCase{
+		deviceRepo:   deviceRepo,
+		authority:    authority,
+		certificates: certificates,
+	}
+}
+
+func (uc *RenewCertificateUseCase) Execute(
+	ctx context.Context, userID, deviceID, csr string,
+) (*entities.DeviceCertificate, error) {
+	if uc.authority == nil {
+		return nil, ErrTLSDisabled
+	}
+
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	deviceObjectID, err := primitive.ObjectIDFromHex(deviceID)
+	if err != nil {
+		return nil, errors.New("invalid device ID")
+	}
+
+	device, err := uc.deviceRepo.GetByID(ctx, userObjectID, deviceObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, ErrDeviceNotFound
+	}
+
+	previous := *device
+	certificate, err := issueCertificate(uc.authority, device, csr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = uc.deviceRepo.UpdateCertificate(ctx, userObjectID, deviceObjectID, certificate.Serial, certificate.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// The device keeps using the old certificate until it has installed the new one, so the old one
+	// is only revoked once the new one is on record
+	if err = revokeCertificate(ctx, uc.certificates, &previous, revokedSuperseded); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
@@ -0,0 +1,60 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SetDeviceLabelsUseCase replaces the labels label-constrained placement matches a device on
+type SetDeviceLabelsUseCase struct {
+	deviceRepo repository.DeviceRepository
+}
+
+func NewSetDeviceLabelsUseCase(deviceRepo repository.DeviceRepository) *SetDeviceLabelsUseCase {
+	return &SetDeviceLabelsUseCase{
+		deviceRepo: deviceRepo,
+	}
+}
+
+func (uc *SetDeviceLabelsUseCase) Execute(
+	ctx context.Context, userID, deviceID string, labels map[string]string,
+) (*entities.Device, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	deviceObjectID, err := primitive.ObjectIDFromHex(deviceID)
+	if err != nil {
+		return nil, errors.New("invalid device ID")
+	}
+
+	for key := range labels {
+		if key == "" {
+			return nil, ErrInvalidLabels
+		}
+	}
+
+	device, err := uc.deviceRepo.GetByID(ctx, userObjectID, deviceObjectID)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, ErrDeviceNotFound
+	}
+
+	if len(labels) == 0 {
+		labels = nil
+	}
+	if err = uc.deviceRepo.SetLabels(ctx, userObjectID, deviceObjectID, labels); err != nil {
+		return nil, err
+	}
+
+	device.Labels = labels
+	return device, nil
+}
@@ -0,0 +1,98 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EventReplayer returns the events a device gets on connecting to its control channel, for the
+// work that was queued for it while it was not listening
+type EventReplayer interface {
+	ReplayEvents(ctx context.Context, userID, deviceID primitive.ObjectID) ([]*entities.DeviceEvent, error)
+}
+
+// StreamDeviceEventsUseCase opens a device's control channel. The device first gets its settings
+// and the events replayed for it, then every event published for it while it stays connected.
+type StreamDeviceEventsUseCase struct {
+	deviceRepo        repository.DeviceRepository
+	events            repository.DeviceEventBus
+	replayers         []EventReplayer
+	heartbeatInterval time.Duration
+	transferTimeout   time.Duration
+}
+
+func NewStreamDeviceEventsUseCase(
+	deviceRepo repository.DeviceRepository,
+	events repository.DeviceEventBus,
+	heartbeatInterval time.Duration,
+	transferTimeout time.Duration,
+	replayers ...EventReplayer,
+) *StreamDeviceEventsUseCase {
+	return &StreamDeviceEventsUseCase{
+		deviceRepo:        deviceRepo,
+		events:            events,
+		replayers:         replayers,
+		heartbeatInterval: heartbeatInterval,
+		transferTimeout:   transferTimeout,
+	}
+}
+
+// Execute returns the events the device gets right away and the channel of those that follow,
+// which is closed once ctx is done
+func (uc *StreamDeviceEventsUseCase) Execute(
+	ctx context.Context, userID, deviceID string,
+) ([]*entities.DeviceEvent, <-chan *entities.DeviceEvent, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, nil, errors.New("invalid user ID")
+	}
+
+	deviceObjectID, err := primitive.ObjectIDFromHex(deviceID)
+	if err != nil {
+		return nil, nil, errors.New("invalid device ID")
+	}
+
+	device, err := uc.deviceRepo.GetByID(ctx, userObjectID, deviceObjectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if device == nil {
+		return nil, nil, ErrDeviceNotFound
+	}
+
+	// Subscribed before replaying, so nothing published in between is missed
+	live, err := uc.events.Subscribe(ctx, deviceObjectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	backlog := []*entities.DeviceEvent{uc.configEvent(device)}
+	for _, replayer := range uc.replayers {
+		replayed, replayErr := replayer.ReplayEvents(ctx, userObjectID, deviceObjectID)
+		if replayErr != nil {
+			return nil, nil, replayErr
+		}
+		backlog = append(backlog, replayed...)
+	}
+	return backlog, live, nil
+}
+
+// configEvent carries the settings the device server runs with
+func (uc *StreamDeviceEventsUseCase) configEvent(device *entities.Device) *entities.DeviceEvent {
+	return &entities.DeviceEvent{
+		Type:     entities.DeviceEventConfig,
+		DeviceID: device.ID,
+		Data: map[string]any{
+			"name":                       device.Name,
+			"status":                     device.Status,
+			"heartbeat_interval_seconds": int64(uc.heartbeatInterval / time.Second),
+			"transfer_timeout_seconds":   int64(uc.transferTimeout / time.Second),
+		},
+	}
+}
@@ -0,0 +1,77 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
+
+	"go.uber.org/zap"
+)
+
+// SweepHeartbeatsUseCase takes devices that stopped sending heartbeats out of service: offline
+// once offlineAfter has passed without one, failed once failedAfter has. The next heartbeat
+// brings a device back online.
+type SweepHeartbeatsUseCase struct {
+	deviceRepo   repository.DeviceRepository
+	offlineAfter time.Duration
+	failedAfter  time.Duration
+	logger       *zap.Logger
+}
+
+func NewSweepHeartbeatsUseCase(
+	deviceRepo repository.DeviceRepository, offlineAfter, failedAfter time.Duration, logger *zap.Logger,
+) *SweepHeartbeatsUseCase {
+	return &SweepHeartbeatsUseCase{
+		deviceRepo:   deviceRepo,
+		offlineAfter: offlineAfter,
+		failedAfter:  failedAfter,
+		logger:       logger,
+	}
+}
+
+// Run sweeps every interval until ctx is cancelled
+func (uc *SweepHeartbeatsUseCase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := uc.Execute(ctx); err != nil && ctx.Err() == nil {
+			uc.logger.Warn("Heartbeat sweep failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Execute updates the status of every device whose last heartbeat is too old
+func (uc *SweepHeartbeatsUseCase) Execute(ctx context.Context) error {
+	now := time.Now()
+
+	offline, err := uc.deviceRepo.MarkUnresponsive(
+		ctx, []entities.DeviceStatus{entities.DeviceStatusOnline}, entities.DeviceStatusOffline, now.Add(-uc.offlineAfter),
+	)
+	if err != nil {
+		return err
+	}
+
+	failed, err := uc.deviceRepo.MarkUnresponsive(
+		ctx,
+		[]entities.DeviceStatus{entities.DeviceStatusOnline, entities.DeviceStatusOffline},
+		entities.DeviceStatusFailed,
+		now.Add(-uc.failedAfter),
+	)
+	if err != nil {
+		return err
+	}
+
+	if offline > 0 || failed > 0 {
+		uc.logger.Info("Devices stopped sending heartbeats", zap.Int64("offline", offline), zap.Int64("failed", failed))
+	}
+	return nil
+}
@@ -11,6 +11,61 @@ type DeviceRegisterRequest struct {
 	IPAddress    string `json:"ip_address" validate:"required"`
 	Type         string `json:"type" validate:"required"`
 	TotalStorage int64  `json:"total_storage" validate:"required,min=1"`
+	// Labels describe the device to label-constrained placement, such as "zone": "home"
+	Labels map[string]string `json:"labels,omitempty" validate:"max=32"`
+}
+
+// DeviceEnrollRequest is sent by a device agent enrolling itself. Without ip_address, the address
+// the request came from is used.
+type DeviceEnrollRequest struct {
+	Name             string `json:"name" validate:"required"`
+	IPAddress        string `json:"ip_address" validate:"omitempty,ip"`
+	Type             string `json:"type" validate:"required"`
+	TotalStorage     int64  `json:"total_storage" validate:"required,min=1"`
+	AvailableStorage int64  `json:"available_storage" validate:"min=0"`
+	UsedStorage      int64  `json:"used_storage" validate:"min=0"`
+	CSR              string `json:"csr"` // Required when mutual TLS is enabled
+	// Labels replace the device's labels on enrolling again; without them, they are kept
+	Labels map[string]string `json:"labels,omitempty" validate:"max=32"`
+}
+
+// DevicePairRequest is sent by a device agent enrolling itself with a pairing code instead of a user token
+type DevicePairRequest struct {
+	DeviceEnrollRequest
+	Code string `json:"code" validate:"required"`
+}
+
+// PairingCodeResponse is a pairing code for the user to hand to a device
+type PairingCodeResponse struct {
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CertificateRenewRequest carries the certificate signing request for a device's new key
+type CertificateRenewRequest struct {
+	CSR string `json:"csr" validate:"required"`
+}
+
+// CertificateResponse is a certificate issued to a device, with the CA certificate to trust
+type CertificateResponse struct {
+	Certificate   string    `json:"certificate"`
+	CACertificate string    `json:"ca_certificate"`
+	Serial        string    `json:"serial"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+func ToCertificateResponse(certificate *entities.DeviceCertificate) *CertificateResponse {
+	return &CertificateResponse{
+		Certificate:   certificate.CertificatePEM,
+		CACertificate: certificate.CACertificatePEM,
+		Serial:        certificate.Serial,
+		ExpiresAt:     certificate.ExpiresAt,
+	}
+}
+
+// DeviceLabelsRequest replaces a device's labels; an empty set removes them
+type DeviceLabelsRequest struct {
+	Labels map[string]string `json:"labels" validate:"max=32"`
 }
 
 type DeviceHeartbeatRequest struct {
@@ -20,21 +75,29 @@ type DeviceHeartbeatRequest struct {
 }
 
 type DeviceResponse struct {
-	ID               string    `json:"id"`
-	Name             string    `json:"name"`
-	IPAddress        string    `json:"ip_address"`
-	Type             string    `json:"type"`
-	TotalStorage     int64     `json:"total_storage"`
-	AvailableStorage int64     `json:"available_storage"`
-	UsedStorage      int64     `json:"used_storage"`
-	Status           string    `json:"status"`
-	LastHeartbeat    time.Time `json:"last_heartbeat"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	IPAddress        string            `json:"ip_address"`
+	Type             string            `json:"type"`
+	TotalStorage     int64             `json:"total_storage"`
+	AvailableStorage int64             `json:"available_storage"`
+	UsedStorage      int64             `json:"used_storage"`
+	ReservedStorage  int64             `json:"reserved_storage"`
+	Status           string            `json:"status"`
+	StatusChangedAt  *time.Time        `json:"status_changed_at,omitempty"`
+	Draining         bool              `json:"draining"`
+	DrainingSince    *time.Time        `json:"draining_since,omitempty"`
+	LastHeartbeat    time.Time         `json:"last_heartbeat"`
+	LastPlacedAt     *time.Time        `json:"last_placed_at,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+	Labels           map[string]string `json:"labels,omitempty"`
+
+	CertificateExpiresAt *time.Time `json:"certificate_expires_at,omitempty"`
 }
 
 func ToDeviceResponse(device *entities.Device) *DeviceResponse {
-	return &DeviceResponse{
+	response := &DeviceResponse{
 		ID:               device.ID.Hex(),
 		Name:             device.Name,
 		IPAddress:        device.IPAddress,
@@ -42,11 +105,104 @@ func ToDeviceResponse(device *entities.Device) *DeviceResponse {
 		TotalStorage:     device.TotalStorage,
 		AvailableStorage: device.AvailableStorage,
 		UsedStorage:      device.UsedStorage,
+		ReservedStorage:  device.ReservedStorage,
 		Status:           string(device.Status),
+		Draining:         device.Draining(),
+		DrainingSince:    device.DrainingSince,
 		LastHeartbeat:    device.LastHeartbeat,
 		CreatedAt:        device.CreatedAt,
 		UpdatedAt:        device.UpdatedAt,
+		Labels:           device.Labels,
+	}
+
+	// Devices registered before status changes were timestamped have none
+	if !device.StatusChangedAt.IsZero() {
+		response.StatusChangedAt = &device.StatusChangedAt
+	}
+	if !device.LastPlacedAt.IsZero() {
+		response.LastPlacedAt = &device.LastPlacedAt
+	}
+	if !device.CertificateExpiresAt.IsZero() {
+		response.CertificateExpiresAt = &device.CertificateExpiresAt
 	}
+	return response
+}
+
+// DrainProgressResponse is how far emptying a device has got
+type DrainProgressResponse struct {
+	DeviceID       string     `json:"device_id"`
+	Draining       bool       `json:"draining"`
+	DrainingSince  *time.Time `json:"draining_since,omitempty"`
+	RemainingFiles int        `json:"remaining_files"`
+	RemainingBytes int64      `json:"remaining_bytes"`
+	MovingFiles    int        `json:"moving_files"`
+	Empty          bool       `json:"empty"` // The device can be removed without losing files
+}
+
+func ToDrainProgressResponse(progress *entities.DrainProgress) *DrainProgressResponse {
+	return &DrainProgressResponse{
+		DeviceID:       progress.DeviceID.Hex(),
+		Draining:       progress.DrainingSince != nil,
+		DrainingSince:  progress.DrainingSince,
+		RemainingFiles: progress.RemainingFiles,
+		RemainingBytes: progress.RemainingBytes,
+		MovingFiles:    progress.MovingFiles,
+		Empty:          progress.Empty(),
+	}
+}
+
+// RebalancePlanResponse is the set of moves that evens out how full the user's devices are
+type RebalancePlanResponse struct {
+	TargetUtilization float64                 `json:"target_utilization"`
+	MovingFiles       int                     `json:"moving_files"` // Moves planned earlier that are still on their way
+	Devices           []DeviceBalanceResponse `json:"devices"`
+	Moves             []RebalanceMoveResponse `json:"moves"`
+}
+
+type DeviceBalanceResponse struct {
+	DeviceID           string  `json:"device_id"`
+	Name               string  `json:"name"`
+	TotalStorage       int64   `json:"total_storage"`
+	UsedStorage        int64   `json:"used_storage"`
+	Utilization        float64 `json:"utilization"`
+	PlannedUtilization float64 `json:"planned_utilization"` // Once the planned moves and those on their way are done
+}
+
+type RebalanceMoveResponse struct {
+	FileID       string `json:"file_id"`
+	FileName     string `json:"file_name"`
+	Size         int64  `json:"size"`
+	FromDeviceID string `json:"from_device_id"`
+	ToDeviceID   string `json:"to_device_id"`
+}
+
+func ToRebalancePlanResponse(plan *entities.RebalancePlan) *RebalancePlanResponse {
+	response := &RebalancePlanResponse{
+		TargetUtilization: plan.TargetUtilization,
+		MovingFiles:       plan.MovingFiles,
+		Devices:           make([]DeviceBalanceResponse, len(plan.Devices)),
+		Moves:             make([]RebalanceMoveResponse, len(plan.Moves)),
+	}
+	for i, balance := range plan.Devices {
+		response.Devices[i] = DeviceBalanceResponse{
+			DeviceID:           balance.DeviceID.Hex(),
+			Name:               balance.Name,
+			TotalStorage:       balance.TotalStorage,
+			UsedStorage:        balance.UsedStorage,
+			Utilization:        balance.Utilization,
+			PlannedUtilization: balance.PlannedUtilization,
+		}
+	}
+	for i, move := range plan.Moves {
+		response.Moves[i] = RebalanceMoveResponse{
+			FileID:       move.FileID.Hex(),
+			FileName:     move.FileName,
+			Size:         move.Size,
+			FromDeviceID: move.FromDeviceID.Hex(),
+			ToDeviceID:   move.ToDeviceID.Hex(),
+		}
+	}
+	return response
 }
 
 func ToDeviceResponses(devices []*entities.Device) []*DeviceResponse {
@@ -63,6 +219,20 @@ func (r *DeviceRegisterRequest) ToEntity() entities.DeviceRegistrationRequest {
 		IPAddress:    r.IPAddress,
 		Type:         r.Type,
 		TotalStorage: r.TotalStorage,
+		Labels:       r.Labels,
+	}
+}
+
+func (r *DeviceEnrollRequest) ToEntity() entities.DeviceEnrollmentRequest {
+	return entities.DeviceEnrollmentRequest{
+		Name:             r.Name,
+		IPAddress:        r.IPAddress,
+		Type:             r.Type,
+		TotalStorage:     r.TotalStorage,
+		AvailableStorage: r.AvailableStorage,
+		UsedStorage:      r.UsedStorage,
+		CSR:              r.CSR,
+		Labels:           r.Labels,
 	}
 }
 
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"crypto/tls"
+	"errors"
 	"net/http"
+	"time"
 
+	"github.com/manab-pr/nebulo/internal/pki"
 	"github.com/manab-pr/nebulo/modules/auth/middleware"
+	"github.com/manab-pr/nebulo/modules/devices/domain/entities"
 	"github.com/manab-pr/nebulo/modules/devices/domain/usecases"
 	"github.com/manab-pr/nebulo/modules/devices/presentation/http/dto"
 
@@ -11,26 +16,56 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
+// eventKeepAlive is how often an idle control channel is written to
+const eventKeepAlive = 15 * time.Second
+
 type DeviceHandler struct {
-	registerUseCase    *usecases.RegisterDeviceUseCase
-	heartbeatUseCase   *usecases.HeartbeatUseCase
-	listDevicesUseCase *usecases.ListDevicesUseCase
-	deleteUseCase      *usecases.DeleteDeviceUseCase
-	validator          *validator.Validate
+	registerUseCase         *usecases.RegisterDeviceUseCase
+	enrollUseCase           *usecases.EnrollDeviceUseCase
+	heartbeatUseCase        *usecases.HeartbeatUseCase
+	listDevicesUseCase      *usecases.ListDevicesUseCase
+	deleteUseCase           *usecases.DeleteDeviceUseCase
+	renewCertificateUseCase *usecases.RenewCertificateUseCase
+	checkCertificateUseCase *usecases.CheckDeviceCertificateUseCase
+	createPairingUseCase    *usecases.CreatePairingCodeUseCase
+	pairUseCase             *usecases.PairDeviceUseCase
+	streamEventsUseCase     *usecases.StreamDeviceEventsUseCase
+	drainUseCase            *usecases.DrainDeviceUseCase
+	setLabelsUseCase        *usecases.SetDeviceLabelsUseCase
+	rebalanceUseCase        *usecases.RebalanceDevicesUseCase
+	validator               *validator.Validate
 }
 
 func NewDeviceHandler(
 	registerUseCase *usecases.RegisterDeviceUseCase,
+	enrollUseCase *usecases.EnrollDeviceUseCase,
 	heartbeatUseCase *usecases.HeartbeatUseCase,
 	listDevicesUseCase *usecases.ListDevicesUseCase,
 	deleteUseCase *usecases.DeleteDeviceUseCase,
+	renewCertificateUseCase *usecases.RenewCertificateUseCase,
+	checkCertificateUseCase *usecases.CheckDeviceCertificateUseCase,
+	createPairingUseCase *usecases.CreatePairingCodeUseCase,
+	pairUseCase *usecases.PairDeviceUseCase,
+	streamEventsUseCase *usecases.StreamDeviceEventsUseCase,
+	drainUseCase *usecases.DrainDeviceUseCase,
+	setLabelsUseCase *usecases.SetDeviceLabelsUseCase,
+	rebalanceUseCase *usecases.RebalanceDevicesUseCase,
 ) *DeviceHandler {
 	return &DeviceHandler{
-		registerUseCase:    registerUseCase,
-		heartbeatUseCase:   heartbeatUseCase,
-		listDevicesUseCase: listDevicesUseCase,
-		deleteUseCase:      deleteUseCase,
-		validator:          validator.New(),
+		registerUseCase:         registerUseCase,
+		enrollUseCase:           enrollUseCase,
+		heartbeatUseCase:        heartbeatUseCase,
+		listDevicesUseCase:      listDevicesUseCase,
+		deleteUseCase:           deleteUseCase,
+		renewCertificateUseCase: renewCertificateUseCase,
+		checkCertificateUseCase: checkCertificateUseCase,
+		createPairingUseCase:    createPairingUseCase,
+		pairUseCase:             pairUseCase,
+		streamEventsUseCase:     streamEventsUseCase,
+		drainUseCase:            drainUseCase,
+		setLabelsUseCase:        setLabelsUseCase,
+		rebalanceUseCase:        rebalanceUseCase,
+		validator:               validator.New(),
 	}
 }
 
@@ -61,11 +96,237 @@ func (h *DeviceHandler) RegisterDevice(c *gin.Context) {
 
 	response := dto.ToDeviceResponse(device)
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "Device registered successfully",
-		"data":    response,
+		"message":       "Device registered successfully",
+		"data":          response,
+		"device_secret": device.Secret,
+	})
+}
+
+// EnrollDevice handles a device agent registering itself with its user's token
+func (h *DeviceHandler) EnrollDevice(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req dto.DeviceEnrollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.IPAddress == "" {
+		req.IPAddress = c.ClientIP()
+	}
+
+	device, certificate, err := h.enrollUseCase.Execute(c.Request.Context(), userID, req.ToEntity())
+	if errors.Is(err, usecases.ErrCSRRequired) || errors.Is(err, usecases.ErrInvalidCSR) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.respondEnrolled(c, userID, device, certificate)
+}
+
+// CreatePairingCode hands the user a one-time code a device can enroll with in their name
+func (h *DeviceHandler) CreatePairingCode(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	code, pairing, err := h.createPairingUseCase.Execute(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Pairing code created successfully",
+		"data": dto.PairingCodeResponse{
+			Code:      code,
+			ExpiresAt: pairing.ExpiresAt,
+		},
+	})
+}
+
+// PairDevice handles a device agent enrolling itself with a pairing code. It gets the same device
+// token as an enrollment with the user's token, so it never holds a credential that acts as the user.
+func (h *DeviceHandler) PairDevice(c *gin.Context) {
+	var req dto.DevicePairRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.IPAddress == "" {
+		req.IPAddress = c.ClientIP()
+	}
+
+	device, certificate, userID, err := h.pairUseCase.Execute(c.Request.Context(), req.Code, req.ToEntity())
+	switch {
+	case errors.Is(err, usecases.ErrInvalidPairingCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usecases.ErrCSRRequired) || errors.Is(err, usecases.ErrInvalidCSR):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.respondEnrolled(c, userID, device, certificate)
+}
+
+// respondEnrolled issues an enrolled device the token it sends its heartbeats with and returns it
+// along with the secret the main server signs its requests to the device with
+func (h *DeviceHandler) respondEnrolled(
+	c *gin.Context, userID string, device *entities.Device, certificate *entities.DeviceCertificate,
+) {
+	deviceToken, expiresAt, err := middleware.GenerateDeviceToken(userID, device.ID.Hex(), device.TokenGeneration)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue device token"})
+		return
+	}
+
+	response := gin.H{
+		"message":                 "Device enrolled successfully",
+		"data":                    dto.ToDeviceResponse(device),
+		"device_token":            deviceToken,
+		"device_token_expires_at": expiresAt,
+		"device_secret":           device.Secret,
+	}
+	if certificate != nil {
+		response["certificate"] = dto.ToCertificateResponse(certificate)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// RenewDeviceToken issues a device a fresh token of the same generation as the one it called with
+func (h *DeviceHandler) RenewDeviceToken(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	generation, exists := middleware.GetTokenGenerationFromContext(c)
+	if !exists {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only a device token can be renewed"})
+		return
+	}
+
+	deviceToken, expiresAt, err := middleware.GenerateDeviceToken(userID, c.Param("id"), generation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to issue device token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Device token renewed successfully",
+		"data": gin.H{
+			"device_token": deviceToken,
+			"expires_at":   expiresAt,
+		},
 	})
 }
 
+// RenewCertificate issues a device a certificate for a new key and revokes the one it replaces
+func (h *DeviceHandler) RenewCertificate(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	deviceID := c.Param("id")
+
+	var req dto.CertificateRenewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	certificate, err := h.renewCertificateUseCase.Execute(c.Request.Context(), userID, deviceID, req.CSR)
+	switch {
+	case errors.Is(err, usecases.ErrTLSDisabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usecases.ErrInvalidCSR):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usecases.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Certificate renewed successfully",
+		"data":    dto.ToCertificateResponse(certificate),
+	})
+}
+
+// RequireDeviceCertificate makes requests sent with a device token over TLS present that device's
+// certificate. Plain HTTP means mutual TLS is off, and user tokens are not tied to a certificate.
+func (h *DeviceHandler) RequireDeviceCertificate(c *gin.Context) {
+	deviceID, isDevice := middleware.GetDeviceIDFromContext(c)
+	if !isDevice || c.Request.TLS == nil {
+		c.Next()
+		return
+	}
+
+	err := h.checkCertificateUseCase.Execute(c.Request.Context(), deviceID, presentedCertificate(c.Request.TLS))
+	if errors.Is(err, usecases.ErrCertificateRejected) {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "A valid certificate for this device is required"})
+		return
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Next()
+}
+
+// presentedCertificate returns the device certificate the client authenticated with, if any
+func presentedCertificate(state *tls.ConnectionState) *entities.PresentedCertificate {
+	if len(state.PeerCertificates) == 0 || pki.RoleOf(state.PeerCertificates[0]) != pki.RoleDevice {
+		return nil
+	}
+
+	leaf := state.PeerCertificates[0]
+	return &entities.PresentedCertificate{
+		DeviceID:  leaf.Subject.CommonName,
+		Serial:    pki.SerialOf(leaf),
+		ExpiresAt: leaf.NotAfter,
+	}
+}
+
 // Heartbeat handles device heartbeat
 func (h *DeviceHandler) Heartbeat(c *gin.Context) {
 	userID, exists := middleware.GetUserIDFromContext(c)
@@ -85,7 +346,17 @@ func (h *DeviceHandler) Heartbeat(c *gin.Context) {
 		return
 	}
 
+	// A device token only speaks for its own device
+	if deviceID, isDevice := middleware.GetDeviceIDFromContext(c); isDevice && deviceID != req.DeviceID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Device token does not belong to this device"})
+		return
+	}
+
 	err := h.heartbeatUseCase.Execute(c.Request.Context(), userID, req.ToEntity())
+	if errors.Is(err, usecases.ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -96,6 +367,54 @@ func (h *DeviceHandler) Heartbeat(c *gin.Context) {
 	})
 }
 
+// StreamEvents handles a device server's control channel: the device's events as server-sent
+// events, named after their type, for as long as the device stays connected. A comment line every
+// eventKeepAlive lets both ends notice a dead connection.
+func (h *DeviceHandler) StreamEvents(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	backlog, live, err := h.streamEventsUseCase.Execute(ctx, userID, c.Param("id"))
+	if errors.Is(err, usecases.ErrDeviceNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	for _, event := range backlog {
+		c.SSEvent(string(event.Type), event.Data)
+	}
+	c.Writer.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, open := <-live:
+			if !open {
+				return
+			}
+			c.SSEvent(string(event.Type), event.Data)
+		case <-keepAlive.C:
+			if _, writeErr := c.Writer.WriteString(": ping\n\n"); writeErr != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
 // GetDevices handles listing all devices
 func (h *DeviceHandler) GetDevices(c *gin.Context) {
 	userID, exists := middleware.GetUserIDFromContext(c)
@@ -131,7 +450,13 @@ func (h *DeviceHandler) DeleteDevice(c *gin.Context) {
 		return
 	}
 
-	err := h.deleteUseCase.Execute(c.Request.Context(), userID, deviceID)
+	// Forcing it removes a device that still holds files, losing their copies on it
+	force := c.Query("force") == "true"
+	err := h.deleteUseCase.Execute(c.Request.Context(), userID, deviceID, force)
+	if errors.Is(err, usecases.ErrDeviceNotEmpty) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -141,3 +466,150 @@ func (h *DeviceHandler) DeleteDevice(c *gin.Context) {
 		"message": "Device deleted successfully",
 	})
 }
+
+// SetLabels handles replacing a device's labels
+func (h *DeviceHandler) SetLabels(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req dto.DeviceLabelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	device, err := h.setLabelsUseCase.Execute(c.Request.Context(), userID, c.Param("id"), req.Labels)
+	switch {
+	case errors.Is(err, usecases.ErrInvalidLabels):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usecases.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Device labels updated successfully",
+		"data":    dto.ToDeviceResponse(device),
+	})
+}
+
+// StartDrain starts moving a device's files to the user's other devices, so it can be removed
+func (h *DeviceHandler) StartDrain(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	progress, err := h.drainUseCase.Start(c.Request.Context(), userID, c.Param("id"))
+	if !h.respondDrainError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Device is being drained",
+		"data":    dto.ToDrainProgressResponse(progress),
+	})
+}
+
+// GetDrainProgress handles showing what a device still holds
+func (h *DeviceHandler) GetDrainProgress(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	progress, err := h.drainUseCase.Progress(c.Request.Context(), userID, c.Param("id"))
+	if !h.respondDrainError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Drain progress retrieved successfully",
+		"data":    dto.ToDrainProgressResponse(progress),
+	})
+}
+
+// CancelDrain stops draining a device, which takes new files again
+func (h *DeviceHandler) CancelDrain(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	err := h.drainUseCase.Cancel(c.Request.Context(), userID, c.Param("id"))
+	if !h.respondDrainError(c, err) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Device is no longer draining",
+	})
+}
+
+// GetRebalancePlan shows the moves that would rebalance the user's devices, without making them
+func (h *DeviceHandler) GetRebalancePlan(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	plan, err := h.rebalanceUseCase.Plan(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Rebalance plan retrieved successfully",
+		"data":    dto.ToRebalancePlanResponse(plan),
+	})
+}
+
+// Rebalance queues the moves that rebalance the user's devices
+func (h *DeviceHandler) Rebalance(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	plan, err := h.rebalanceUseCase.Start(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Rebalancing started",
+		"data":    dto.ToRebalancePlanResponse(plan),
+	})
+}
+
+// respondDrainError responds to a failed drain request, and reports whether there was no error to respond to
+func (h *DeviceHandler) respondDrainError(c *gin.Context, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, usecases.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return false
+}
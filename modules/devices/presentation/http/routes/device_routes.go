@@ -8,11 +8,37 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupDeviceRoutes(router *gin.RouterGroup, handler *handlers.DeviceHandler) {
+func SetupDeviceRoutes(router *gin.RouterGroup, handler *handlers.DeviceHandler, tokens middleware.DeviceTokenValidator) {
 	devices := router.Group(constants.DeviceBaseRoute)
-	devices.Use(middleware.AuthMiddleware()) // Require authentication for all device routes
-	devices.POST(constants.RegisterDeviceRoute, handler.RegisterDevice)
-	devices.POST(constants.HeartbeatRoute, handler.Heartbeat)
-	devices.GET(constants.GetDevicesRoute, handler.GetDevices)
-	devices.DELETE(constants.DeleteDeviceRoute, handler.DeleteDevice)
+	// Also called by device agents, which must present their certificate when mutual TLS is on
+	devices.POST(constants.HeartbeatRoute, middleware.DeviceAuthMiddleware(tokens), handler.RequireDeviceCertificate, handler.Heartbeat)
+	// Renewal only needs the device token, so a device whose certificate has expired can still get a new one
+	devices.POST(
+		constants.RenewCertificateRoute, middleware.DeviceAuthMiddleware(tokens), middleware.RequireOwnDevice("id"), handler.RenewCertificate,
+	)
+	// Device tokens expire, so agents trade theirs for a fresh one while it is still good
+	devices.POST(
+		constants.RenewDeviceTokenRoute, middleware.DeviceAuthMiddleware(tokens), middleware.RequireOwnDevice("id"), handler.RenewDeviceToken,
+	)
+	// Device servers stay connected here to be told about new work
+	devices.GET(
+		constants.DeviceEventsRoute,
+		middleware.DeviceAuthMiddleware(tokens), middleware.RequireOwnDevice("id"), handler.RequireDeviceCertificate, handler.StreamEvents,
+	)
+	// The pairing code is the credential here; the device gets its own token in return
+	devices.POST(constants.RedeemPairingCodeRoute, handler.PairDevice)
+
+	users := devices.Group("")
+	users.Use(middleware.AuthMiddleware()) // Require authentication for all other device routes
+	users.POST(constants.RegisterDeviceRoute, handler.RegisterDevice)
+	users.POST(constants.EnrollDeviceRoute, handler.EnrollDevice)
+	users.POST(constants.CreatePairingCodeRoute, handler.CreatePairingCode)
+	users.GET(constants.GetDevicesRoute, handler.GetDevices)
+	users.DELETE(constants.DeleteDeviceRoute, handler.DeleteDevice)
+	users.POST(constants.DrainDeviceRoute, handler.StartDrain)
+	users.GET(constants.DrainDeviceRoute, handler.GetDrainProgress)
+	users.DELETE(constants.DrainDeviceRoute, handler.CancelDrain)
+	users.PUT(constants.DeviceLabelsRoute, handler.SetLabels)
+	users.GET(constants.RebalanceDevicesRoute, handler.GetRebalancePlan)
+	users.POST(constants.RebalanceDevicesRoute, handler.Rebalance)
 }
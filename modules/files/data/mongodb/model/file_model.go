@@ -9,50 +9,152 @@ import (
 )
 
 type FileModel struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty"`
-	UserID       primitive.ObjectID `bson:"user_id"`
-	Name         string             `bson:"name"`
-	OriginalName string             `bson:"original_name"`
-	Size         int64              `bson:"size"`
-	MimeType     string             `bson:"mime_type"`
-	Checksum     string             `bson:"checksum"`
-	StoredOn     primitive.ObjectID `bson:"stored_on"`
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	UserID          primitive.ObjectID `bson:"user_id"`
+	Name            string             `bson:"name"`
+	OriginalName    string             `bson:"original_name"`
+	Size            int64              `bson:"size"`
+	MimeType        string             `bson:"mime_type"`
+	Checksum        string             `bson:"checksum"`
+	StoredOn        primitive.ObjectID `bson:"stored_on"`
+	Replicas        []ReplicaModel     `bson:"replicas"`
+	Erasure         *ErasureModel      `bson:"erasure,omitempty"`
+	Shards          []ShardModel       `bson:"shards,omitempty"`
+	Status          string             `bson:"status"`
+	StatusReason    string             `bson:"status_reason,omitempty"`
+	StatusChangedAt time.Time          `bson:"status_changed_at,omitempty"`
+	StoragePath     string             `bson:"storage_path"`
+	CreatedAt       time.Time          `bson:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at"`
+}
+
+type ReplicaModel struct {
+	DeviceID     primitive.ObjectID `bson:"device_id"`
+	Status       string             `bson:"status"`
+	StatusReason string             `bson:"status_reason,omitempty"`
+	Replaces     primitive.ObjectID `bson:"replaces,omitempty"`
+	UpdatedAt    time.Time          `bson:"updated_at"`
+}
+
+type ErasureModel struct {
+	DataShards   int   `bson:"data_shards"`
+	ParityShards int   `bson:"parity_shards"`
+	BlockSize    int   `bson:"block_size"`
+	ShardSize    int64 `bson:"shard_size"`
+}
+
+type ShardModel struct {
+	Index        int                `bson:"index"`
+	DeviceID     primitive.ObjectID `bson:"device_id"`
 	Status       string             `bson:"status"`
-	StoragePath  string             `bson:"storage_path"`
-	CreatedAt    time.Time          `bson:"created_at"`
+	StatusReason string             `bson:"status_reason,omitempty"`
 	UpdatedAt    time.Time          `bson:"updated_at"`
 }
 
 func (f *FileModel) ToEntity() *entities.File {
+	var replicas []entities.Replica
+	for _, replica := range f.Replicas {
+		replicas = append(replicas, entities.Replica{
+			DeviceID:     replica.DeviceID,
+			Status:       entities.ReplicaStatus(replica.Status),
+			StatusReason: replica.StatusReason,
+			Replaces:     replica.Replaces,
+			UpdatedAt:    replica.UpdatedAt,
+		})
+	}
+
+	var erasure *entities.ErasureCoding
+	if f.Erasure != nil {
+		erasure = &entities.ErasureCoding{
+			DataShards:   f.Erasure.DataShards,
+			ParityShards: f.Erasure.ParityShards,
+			BlockSize:    f.Erasure.BlockSize,
+			ShardSize:    f.Erasure.ShardSize,
+		}
+	}
+
+	var shards []entities.Shard
+	for _, shard := range f.Shards {
+		shards = append(shards, entities.Shard{
+			Index:        shard.Index,
+			DeviceID:     shard.DeviceID,
+			Status:       entities.ReplicaStatus(shard.Status),
+			StatusReason: shard.StatusReason,
+			UpdatedAt:    shard.UpdatedAt,
+		})
+	}
+
 	return &entities.File{
-		ID:           f.ID,
-		UserID:       f.UserID,
-		Name:         f.Name,
-		OriginalName: f.OriginalName,
-		Size:         f.Size,
-		MimeType:     f.MimeType,
-		Checksum:     f.Checksum,
-		StoredOn:     f.StoredOn,
-		Status:       entities.FileStatus(f.Status),
-		StoragePath:  f.StoragePath,
-		CreatedAt:    f.CreatedAt,
-		UpdatedAt:    f.UpdatedAt,
+		ID:              f.ID,
+		UserID:          f.UserID,
+		Name:            f.Name,
+		OriginalName:    f.OriginalName,
+		Size:            f.Size,
+		MimeType:        f.MimeType,
+		Checksum:        f.Checksum,
+		StoredOn:        f.StoredOn,
+		Replicas:        replicas,
+		Erasure:         erasure,
+		Shards:          shards,
+		Status:          entities.FileStatus(f.Status),
+		StatusReason:    f.StatusReason,
+		StatusChangedAt: f.StatusChangedAt,
+		StoragePath:     f.StoragePath,
+		CreatedAt:       f.CreatedAt,
+		UpdatedAt:       f.UpdatedAt,
 	}
 }
 
 func FromEntity(file *entities.File) *FileModel {
+	var replicas []ReplicaModel
+	for _, replica := range file.Replicas {
+		replicas = append(replicas, ReplicaModel{
+			DeviceID:     replica.DeviceID,
+			Status:       string(replica.Status),
+			StatusReason: replica.StatusReason,
+			Replaces:     replica.Replaces,
+			UpdatedAt:    replica.UpdatedAt,
+		})
+	}
+
+	var erasure *ErasureModel
+	if file.Erasure != nil {
+		erasure = &ErasureModel{
+			DataShards:   file.Erasure.DataShards,
+			ParityShards: file.Erasure.ParityShards,
+			BlockSize:    file.Erasure.BlockSize,
+			ShardSize:    file.Erasure.ShardSize,
+		}
+	}
+
+	var shards []ShardModel
+	for _, shard := range file.Shards {
+		shards = append(shards, ShardModel{
+			Index:        shard.Index,
+			DeviceID:     shard.DeviceID,
+			Status:       string(shard.Status),
+			StatusReason: shard.StatusReason,
+			UpdatedAt:    shard.UpdatedAt,
+		})
+	}
+
 	return &FileModel{
-		ID:           file.ID,
-		UserID:       file.UserID,
-		Name:         file.Name,
-		OriginalName: file.OriginalName,
-		Size:         file.Size,
-		MimeType:     file.MimeType,
-		Checksum:     file.Checksum,
-		StoredOn:     file.StoredOn,
-		Status:       string(file.Status),
-		StoragePath:  file.StoragePath,
-		CreatedAt:    file.CreatedAt,
-		UpdatedAt:    file.UpdatedAt,
+		ID:              file.ID,
+		UserID:          file.UserID,
+		Name:            file.Name,
+		OriginalName:    file.OriginalName,
+		Size:            file.Size,
+		MimeType:        file.MimeType,
+		Checksum:        file.Checksum,
+		StoredOn:        file.StoredOn,
+		Replicas:        replicas,
+		Erasure:         erasure,
+		Shards:          shards,
+		Status:          string(file.Status),
+		StatusReason:    file.StatusReason,
+		StatusChangedAt: file.StatusChangedAt,
+		StoragePath:     file.StoragePath,
+		CreatedAt:       file.CreatedAt,
+		UpdatedAt:       file.UpdatedAt,
 	}
 }
@@ -10,6 +10,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type MongoFileRepository struct {
@@ -68,7 +69,21 @@ func (r *MongoFileRepository) GetAllByUser(ctx context.Context, userID primitive
 }
 
 func (r *MongoFileRepository) GetByUserAndDeviceID(ctx context.Context, userID, deviceID primitive.ObjectID) ([]*entities.File, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{"stored_on": deviceID, "user_id": userID})
+	// Failed replicas and shards never made it onto the device, so they do not count as files stored there
+	onDevice := bson.M{"$elemMatch": bson.M{
+		"device_id": deviceID,
+		"status":    bson.M{"$ne": string(entities.ReplicaStatusFailed)},
+	}}
+	filter := bson.M{
+		"user_id": userID,
+		"$or": []bson.M{
+			{"stored_on": deviceID, "replicas": bson.M{"$in": bson.A{nil, bson.A{}}}, "erasure": nil},
+			{"replicas": onDevice},
+			{"shards": onDevice},
+		},
+	}
+
+	cursor, err := r.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -100,10 +115,19 @@ func (r *MongoFileRepository) Delete(ctx context.Context, userID, fileID primiti
 }
 
 func (r *MongoFileRepository) UpdateStatus(ctx context.Context, userID, fileID primitive.ObjectID, status entities.FileStatus) error {
+	return r.UpdateStatusWithReason(ctx, userID, fileID, status, "")
+}
+
+func (r *MongoFileRepository) UpdateStatusWithReason(
+	ctx context.Context, userID, fileID primitive.ObjectID, status entities.FileStatus, reason string,
+) error {
+	now := time.Now()
 	update := bson.M{
 		"$set": bson.M{
-			"status":     string(status),
-			"updated_at": time.Now(),
+			"status":            string(status),
+			"status_reason":     reason,
+			"status_changed_at": now,
+			"updated_at":        now,
 		},
 	}
 
@@ -111,6 +135,132 @@ func (r *MongoFileRepository) UpdateStatus(ctx context.Context, userID, fileID p
 	return err
 }
 
+func (r *MongoFileRepository) UpdateReplicaStatus(
+	ctx context.Context, userID, fileID, deviceID primitive.ObjectID, status entities.ReplicaStatus, reason string,
+) error {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"replicas.$.status":        string(status),
+			"replicas.$.status_reason": reason,
+			"replicas.$.updated_at":    now,
+			"updated_at":               now,
+		},
+	}
+
+	filter := bson.M{"_id": fileID, "user_id": userID, "replicas.device_id": deviceID}
+	_, err := r.collection.UpdateOne(ctx, filter, update)
+	return err
+}
+
+func (r *MongoFileRepository) GetPageByStatus(
+	ctx context.Context, afterUserID, afterID primitive.ObjectID, limit int64, statuses ...entities.FileStatus,
+) ([]*entities.File, error) {
+	values := make(bson.A, len(statuses))
+	for i, status := range statuses {
+		values[i] = string(status)
+	}
+
+	filter := bson.M{
+		"status": bson.M{"$in": values},
+		"$or": bson.A{
+			bson.M{"user_id": bson.M{"$gt": afterUserID}},
+			bson.M{"user_id": afterUserID, "_id": bson.M{"$gt": afterID}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "user_id", Value: 1}, {Key: "_id", Value: 1}}).SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []*entities.File
+	for cursor.Next(ctx) {
+		var fileModel model.FileModel
+		if err := cursor.Decode(&fileModel); err != nil {
+			continue
+		}
+		files = append(files, fileModel.ToEntity())
+	}
+
+	return files, nil
+}
+
+func (r *MongoFileRepository) GetAllMoving(ctx context.Context) ([]*entities.File, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"replicas.replaces": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var files []*entities.File
+	for cursor.Next(ctx) {
+		var fileModel model.FileModel
+		if err := cursor.Decode(&fileModel); err != nil {
+			continue
+		}
+		files = append(files, fileModel.ToEntity())
+	}
+
+	return files, nil
+}
+
+func (r *MongoFileRepository) UpdatePlacements(ctx context.Context, file *entities.File) (bool, error) {
+	fileModel := model.FromEntity(file)
+	update := bson.M{
+		"$set": bson.M{
+			"stored_on":         fileModel.StoredOn,
+			"replicas":          fileModel.Replicas,
+			"shards":            fileModel.Shards,
+			"status":            fileModel.Status,
+			"status_reason":     fileModel.StatusReason,
+			"status_changed_at": fileModel.StatusChangedAt,
+			"updated_at":        time.Now(),
+		},
+	}
+
+	// Matching on updated_at leaves the record alone if anything else wrote to it in the meantime
+	filter := bson.M{"_id": file.ID, "user_id": file.UserID, "updated_at": file.UpdatedAt}
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+func (r *MongoFileRepository) RemovePlacements(
+	ctx context.Context, userID, fileID, deviceID primitive.ObjectID,
+) (*entities.File, error) {
+	// A pipeline, as $pull fails on files stored without replicas, whose replicas are null
+	without := func(field string) bson.M {
+		return bson.M{"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$" + field, bson.A{}}},
+			"cond":  bson.M{"$ne": bson.A{"$$this.device_id", deviceID}},
+		}}
+	}
+	update := bson.A{bson.M{"$set": bson.M{
+		"replicas":   without("replicas"),
+		"shards":     without("shards"),
+		"updated_at": time.Now(),
+	}}}
+
+	var fileModel model.FileModel
+	err := r.collection.FindOneAndUpdate(
+		ctx, bson.M{"_id": fileID, "user_id": userID}, update, options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&fileModel)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return fileModel.ToEntity(), nil
+}
+
 func (r *MongoFileRepository) SearchByNameForUser(ctx context.Context, userID primitive.ObjectID, name string) ([]*entities.File, error) {
 	filter := bson.M{
 		"user_id": userID,
@@ -1,40 +1,248 @@
 package entities
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type File struct {
-	ID           primitive.ObjectID `bson:"_id,omitempty"`
-	UserID       primitive.ObjectID `bson:"user_id"` // Links file to specific user
-	Name         string             `bson:"name"`
-	OriginalName string             `bson:"original_name"`
-	Size         int64              `bson:"size"`
-	MimeType     string             `bson:"mime_type"`
-	Checksum     string             `bson:"checksum"`
-	StoredOn     primitive.ObjectID `bson:"stored_on"` // Device ID where file is stored
-	Status       FileStatus         `bson:"status"`
-	StoragePath  string             `bson:"storage_path"` // Path on the device
-	CreatedAt    time.Time          `bson:"created_at"`
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	UserID          primitive.ObjectID `bson:"user_id"` // Links file to specific user
+	Name            string             `bson:"name"`
+	OriginalName    string             `bson:"original_name"`
+	Size            int64              `bson:"size"`
+	MimeType        string             `bson:"mime_type"`
+	Checksum        string             `bson:"checksum"`
+	StoredOn        primitive.ObjectID `bson:"stored_on"`         // Primary device, the first replica that was stored
+	Replicas        []Replica          `bson:"replicas"`          // Every device holding (or meant to hold) a copy
+	Erasure         *ErasureCoding     `bson:"erasure,omitempty"` // Set when the file is stored as erasure-coded shards
+	Shards          []Shard            `bson:"shards,omitempty"`  // Shard placement for erasure-coded files
+	Status          FileStatus         `bson:"status"`
+	StatusReason    string             `bson:"status_reason,omitempty"`     // Why the file is not stored, if it isn't
+	StatusChangedAt time.Time          `bson:"status_changed_at,omitempty"` // When Status last changed
+	StoragePath     string             `bson:"storage_path"`                // Path on the device
+	CreatedAt       time.Time          `bson:"created_at"`
+	UpdatedAt       time.Time          `bson:"updated_at"`
+}
+
+// Replica is one copy of a file's content on a device
+type Replica struct {
+	DeviceID     primitive.ObjectID `bson:"device_id"`
+	Status       ReplicaStatus      `bson:"status"`
+	StatusReason string             `bson:"status_reason,omitempty"`
+	Replaces     primitive.ObjectID `bson:"replaces,omitempty"` // Device whose copy this one takes over from, when draining or rebalancing
+	UpdatedAt    time.Time          `bson:"updated_at"`
+}
+
+// ErasureCoding describes how an erasure-coded file was split. Each stripe of DataShards*BlockSize
+// bytes becomes one block on every shard, with the last stripe zero-padded; parity blocks are
+// computed per stripe, so every shard is ShardSize bytes.
+type ErasureCoding struct {
+	DataShards   int   `bson:"data_shards"`
+	ParityShards int   `bson:"parity_shards"`
+	BlockSize    int   `bson:"block_size"`
+	ShardSize    int64 `bson:"shard_size"`
+}
+
+// Shard is one erasure-coded piece of a file on a device. Indexes below DataShards hold the
+// file's data, the rest hold parity.
+type Shard struct {
+	Index        int                `bson:"index"`
+	DeviceID     primitive.ObjectID `bson:"device_id"`
+	Status       ReplicaStatus      `bson:"status"`
+	StatusReason string             `bson:"status_reason,omitempty"`
 	UpdatedAt    time.Time          `bson:"updated_at"`
 }
 
+type ReplicaStatus string
+
+const (
+	ReplicaStatusPending     ReplicaStatus = "pending"
+	ReplicaStatusStored      ReplicaStatus = "stored"
+	ReplicaStatusFailed      ReplicaStatus = "failed"
+	ReplicaStatusCorrupted   ReplicaStatus = "corrupted"
+	ReplicaStatusUnavailable ReplicaStatus = "unavailable" // Its device is offline or failed; re-verified before it counts again
+	ReplicaStatusLost        ReplicaStatus = "lost"        // Its device was removed, or the copy was missing when re-verified
+)
+
+// AllReplicas returns the file's replicas. Files stored before replication was tracked have a
+// single implicit replica on StoredOn that mirrors the file's own status, until they are deleted.
+func (f *File) AllReplicas() []Replica {
+	if len(f.Replicas) > 0 || f.StoredOn.IsZero() || f.Erasure != nil || f.Status == FileStatusDeleting {
+		return f.Replicas
+	}
+
+	status := ReplicaStatusPending
+	switch f.Status {
+	case FileStatusStored:
+		status = ReplicaStatusStored
+	case FileStatusFailed:
+		status = ReplicaStatusFailed
+	case FileStatusCorrupted:
+		status = ReplicaStatusCorrupted
+	case FileStatusUnavailable:
+		status = ReplicaStatusUnavailable
+	case FileStatusLost:
+		status = ReplicaStatusLost
+	}
+
+	return []Replica{{DeviceID: f.StoredOn, Status: status, UpdatedAt: f.UpdatedAt}}
+}
+
+// Recoverable reports whether enough replicas or shards are stored, or sit on devices that may
+// come back, to read the file
+func (f *File) Recoverable() bool {
+	required, readable := 1, 0
+	statuses := make([]ReplicaStatus, 0, len(f.Shards))
+	if f.Erasure != nil {
+		required = f.Erasure.DataShards
+		for _, shard := range f.Shards {
+			statuses = append(statuses, shard.Status)
+		}
+	} else {
+		for _, replica := range f.AllReplicas() {
+			statuses = append(statuses, replica.Status)
+		}
+	}
+
+	for _, status := range statuses {
+		if status == ReplicaStatusStored || status == ReplicaStatusUnavailable {
+			readable++
+		}
+	}
+	return readable >= required
+}
+
+// Removed reports whether the file was deleted or is being deleted
+func (f *File) Removed() bool {
+	return f.Status == FileStatusDeleted || f.Status == FileStatusDeleting
+}
+
+// StoredReplicas returns the replicas whose content is confirmed on their device
+func (f *File) StoredReplicas() []Replica {
+	var stored []Replica
+	for _, replica := range f.AllReplicas() {
+		if replica.Status == ReplicaStatusStored {
+			stored = append(stored, replica)
+		}
+	}
+	return stored
+}
+
+// StoredShards returns the shards whose content is confirmed on their device
+func (f *File) StoredShards() []Shard {
+	var stored []Shard
+	for _, shard := range f.Shards {
+		if shard.Status == ReplicaStatusStored {
+			stored = append(stored, shard)
+		}
+	}
+	return stored
+}
+
+// ShardObjectID names one of the file's shards on its device
+func (f *File) ShardObjectID(index int) string {
+	return fmt.Sprintf("%s.shard%d", f.ID.Hex(), index)
+}
+
+// PlacementDevices returns every device the file has a replica or shard on, in whatever state
+func (f *File) PlacementDevices() []primitive.ObjectID {
+	var devices []primitive.ObjectID
+	seen := make(map[primitive.ObjectID]bool)
+	for _, replica := range f.AllReplicas() {
+		if !seen[replica.DeviceID] {
+			seen[replica.DeviceID] = true
+			devices = append(devices, replica.DeviceID)
+		}
+	}
+	for _, shard := range f.Shards {
+		if !seen[shard.DeviceID] {
+			seen[shard.DeviceID] = true
+			devices = append(devices, shard.DeviceID)
+		}
+	}
+	return devices
+}
+
+// ObjectIDsOn names the objects the file has, or may have, on the device
+func (f *File) ObjectIDsOn(deviceID primitive.ObjectID) []string {
+	var objectIDs []string
+	for _, replica := range f.AllReplicas() {
+		if replica.DeviceID == deviceID {
+			objectIDs = append(objectIDs, f.ID.Hex())
+			break
+		}
+	}
+	for _, shard := range f.Shards {
+		if shard.DeviceID == deviceID {
+			objectIDs = append(objectIDs, f.ShardObjectID(shard.Index))
+		}
+	}
+	return objectIDs
+}
+
+// StoredBytes is the raw space the file's stored replicas or shards take up across devices
+func (f *File) StoredBytes() int64 {
+	if f.Erasure != nil {
+		return f.Erasure.ShardSize * int64(len(f.StoredShards()))
+	}
+	return f.Size * int64(len(f.StoredReplicas()))
+}
+
 type FileStatus string
 
 const (
-	FileStatusPending   FileStatus = "pending"
-	FileStatusStored    FileStatus = "stored"
-	FileStatusCorrupted FileStatus = "corrupted"
-	FileStatusDeleted   FileStatus = "deleted"
+	FileStatusPending     FileStatus = "pending"
+	FileStatusStored      FileStatus = "stored"
+	FileStatusFailed      FileStatus = "failed"
+	FileStatusCorrupted   FileStatus = "corrupted"
+	FileStatusDeleted     FileStatus = "deleted"
+	FileStatusDeleting    FileStatus = "deleting"    // Waiting for its devices to delete its content
+	FileStatusUnavailable FileStatus = "unavailable" // Too few copies are reachable, but enough may come back with their devices
+	FileStatusLost        FileStatus = "lost"        // Too few copies remain anywhere to ever serve the file again
 )
 
 type StoreFileRequest struct {
 	Name         string `json:"name" validate:"required"`
 	Size         int64  `json:"size" validate:"required,min=1"`
 	MimeType     string `json:"mime_type"`
-	TargetDevice string `json:"target_device,omitempty"` // Optional specific device
+	TargetDevice string `json:"target_device,omitempty"` // Optional specific device, used for the first replica
+	Replicas     int    `json:"replicas,omitempty"`      // Number of copies; zero uses the user's default
+	DataShards   int    `json:"data_shards,omitempty"`   // With ParityShards, stores the file erasure-coded instead
+	ParityShards int    `json:"parity_shards,omitempty"`
+
+	// Placement names the strategy picking the devices; empty uses the user's own, or the server's
+	Placement       string            `json:"placement,omitempty"`
+	PlacementLabels map[string]string `json:"placement_labels,omitempty"` // Labels devices must have, for label_constrained
+}
+
+// Placement strategies for picking the devices a file's copies go to
+const (
+	PlacementMostFreeSpace      = "most_free_space"      // Devices with the most available storage first
+	PlacementWeightedRoundRobin = "weighted_round_robin" // Take turns, in proportion to each device's total storage
+	PlacementLeastRecentlyUsed  = "least_recently_used"  // Devices that were given a file longest ago first
+	PlacementFillFirst          = "fill_first"           // Fill devices one at a time, in the order they were added
+	PlacementLabelConstrained   = "label_constrained"    // Only devices with every placement label, most free space first
+)
+
+// ParsePlacementLabels reads placement labels written as "key=value" pairs separated by commas
+func ParsePlacementLabels(value string) (map[string]string, error) {
+	if strings.TrimSpace(value) == "" {
+		return nil, nil
+	}
+
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, labelValue, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("placement label %q must be written as key=value", pair)
+		}
+		labels[key] = labelValue
+	}
+	return labels, nil
 }
 
 type FileMetadata struct {
@@ -48,3 +256,20 @@ type FileMetadata struct {
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
+
+// ByteRange is an inclusive span of bytes within a file's content
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// StoredObject is what a device reports about an object it holds. Checksum is empty for objects
+// stored before devices recorded checksums.
+type StoredObject struct {
+	Size     int64
+	Checksum string
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"context"
+	"io"
+)
+
+// ContentStagingRepository holds the content of files on the main server while it waits for
+// devices that were offline when it was uploaded, and no device has a copy to transfer it from
+type ContentStagingRepository interface {
+	Create(ctx context.Context, fileID string) error
+	// Append writes content starting at offset, discarding anything previously staged beyond it
+	Append(ctx context.Context, fileID string, offset int64, content io.Reader) (int64, error)
+	Open(ctx context.Context, fileID string) (io.ReadCloser, error)
+	Remove(ctx context.Context, fileID string) error
+}
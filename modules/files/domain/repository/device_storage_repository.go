@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/files/domain/entities"
+)
+
+var (
+	// ErrObjectNotFound is returned when a device does not hold the requested object
+	ErrObjectNotFound = errors.New("object not found on device")
+	// ErrDeviceUnreachable is returned when a device could not be reached at all
+	ErrDeviceUnreachable = errors.New("device unreachable")
+	// ErrDeviceFull is returned when a device has no room left for an object
+	ErrDeviceFull = errors.New("device is out of space")
+	// ErrChecksumMismatch is returned when the object on a device does not have the expected checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+)
+
+// DeviceStorageRepository moves file bytes to and from the device servers that hold them
+type DeviceStorageRepository interface {
+	StoreFile(ctx context.Context, device *deviceEntities.Device, objectID string, content io.Reader) error
+	// ConfirmFile checks that the device holds the object, and that it has checksum unless that is empty
+	ConfirmFile(ctx context.Context, device *deviceEntities.Device, objectID, checksum string) error
+	// OpenFile streams the whole object, or only byteRange when it is not nil
+	OpenFile(ctx context.Context, device *deviceEntities.Device, objectID string, byteRange *entities.ByteRange) (io.ReadCloser, error)
+	// StatFile reports the size and checksum of an object without transferring it
+	StatFile(ctx context.Context, device *deviceEntities.Device, objectID string) (*entities.StoredObject, error)
+	// DeleteFile removes the object from the device, and returns ErrObjectNotFound if the device did not hold it
+	DeleteFile(ctx context.Context, device *deviceEntities.Device, objectID string) error
+}
@@ -16,5 +16,24 @@ type FileRepository interface {
 	Update(ctx context.Context, file *entities.File) error
 	Delete(ctx context.Context, userID, fileID primitive.ObjectID) error
 	UpdateStatus(ctx context.Context, userID, fileID primitive.ObjectID, status entities.FileStatus) error
+	UpdateStatusWithReason(ctx context.Context, userID, fileID primitive.ObjectID, status entities.FileStatus, reason string) error
+	// UpdateReplicaStatus sets the status of the file's replica on deviceID
+	UpdateReplicaStatus(
+		ctx context.Context, userID, fileID, deviceID primitive.ObjectID, status entities.ReplicaStatus, reason string,
+	) error
+	// GetPageByStatus returns up to limit files, of any user, that are in one of the given statuses,
+	// for background jobs. Files are ordered by user and then by ID, and the page starts after the
+	// file afterID of afterUserID; zero IDs start from the beginning.
+	GetPageByStatus(
+		ctx context.Context, afterUserID, afterID primitive.ObjectID, limit int64, statuses ...entities.FileStatus,
+	) ([]*entities.File, error)
+	// GetAllMoving returns every user's files with a replica taking over from another, for background jobs
+	GetAllMoving(ctx context.Context) ([]*entities.File, error)
+	// UpdatePlacements saves the file's status, primary device and the states of its replicas and
+	// shards, unless the record changed since it was read. It reports whether the update was applied.
+	UpdatePlacements(ctx context.Context, file *entities.File) (bool, error)
+	// RemovePlacements drops the file's replicas and shards on deviceID and returns the file as it is
+	// left, or nil if it does not exist
+	RemovePlacements(ctx context.Context, userID, fileID, deviceID primitive.ObjectID) (*entities.File, error)
 	SearchByNameForUser(ctx context.Context, userID primitive.ObjectID, name string) ([]*entities.File, error)
 }
@@ -3,56 +3,173 @@ package usecases
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
 	"github.com/manab-pr/nebulo/modules/files/domain/entities"
 	"github.com/manab-pr/nebulo/modules/files/domain/repository"
+	transferRepository "github.com/manab-pr/nebulo/modules/transfers/domain/repository"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+const (
+	// deleteAttempts is how many times marking a file as deleting is retried when its record changes under it
+	deleteAttempts = 3
+	// deletingReason is the status reason of a file waiting for its devices to delete it
+	deletingReason = "waiting for its devices to delete it"
+)
+
+// DeleteFileUseCase deletes a file's content from every device holding a replica or shard of it,
+// then its record. Online devices are asked to delete it right away, and the deletion is queued
+// for the others. Until the last device has acknowledged, the file stays on record as deleting,
+// with the replicas and shards still to be deleted.
 type DeleteFileUseCase struct {
-	fileRepo repository.FileRepository
+	fileRepo      repository.FileRepository
+	deviceRepo    deviceRepository.DeviceRepository
+	deviceStorage repository.DeviceStorageRepository
+	staging       repository.ContentStagingRepository
+	deletions     transferRepository.DeletionEnqueuer
 }
 
-func NewDeleteFileUseCase(fileRepo repository.FileRepository) *DeleteFileUseCase {
+func NewDeleteFileUseCase(
+	fileRepo repository.FileRepository,
+	deviceRepo deviceRepository.DeviceRepository,
+	deviceStorage repository.DeviceStorageRepository,
+	staging repository.ContentStagingRepository,
+	deletions transferRepository.DeletionEnqueuer,
+) *DeleteFileUseCase {
 	return &DeleteFileUseCase{
-		fileRepo: fileRepo,
+		fileRepo:      fileRepo,
+		deviceRepo:    deviceRepo,
+		deviceStorage: deviceStorage,
+		staging:       staging,
+		deletions:     deletions,
 	}
 }
 
-func (uc *DeleteFileUseCase) Execute(ctx context.Context, userID, fileID string) error {
+// Execute deletes the file and reports whether it is gone, or still waiting for devices to delete
+// it. A device that fails does not hold up the others; its error is returned once they are done.
+// Deleting a file that is already being deleted tries its remaining devices again.
+func (uc *DeleteFileUseCase) Execute(ctx context.Context, userID, fileID string) (bool, error) {
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		return errors.New("invalid user ID")
+		return false, errors.New("invalid user ID")
 	}
 
 	fileObjectID, err := primitive.ObjectIDFromHex(fileID)
 	if err != nil {
-		return errors.New("invalid file ID")
+		return false, errors.New("invalid file ID")
 	}
 
-	// Check if file exists and belongs to user
-	file, err := uc.fileRepo.GetByID(ctx, userObjectID, fileObjectID)
+	file, err := uc.markDeleting(ctx, userObjectID, fileObjectID)
 	if err != nil {
-		return err
+		return false, err
 	}
-
 	if file == nil {
-		return errors.New("file not found or does not belong to you")
+		return false, ErrFileNotFound
 	}
 
-	// TODO: Send delete request to the device where file is stored
-	// For now, we'll just mark it as deleted and remove from database
-	err = uc.fileRepo.UpdateStatus(ctx, userObjectID, fileObjectID, entities.FileStatusDeleted)
-	if err != nil {
-		return err
+	remaining := file
+	var firstErr error
+	for _, deviceID := range file.PlacementDevices() {
+		deleted, deleteErr := uc.deleteFrom(ctx, file, deviceID)
+		if deleteErr != nil {
+			if firstErr == nil {
+				firstErr = deleteErr
+			}
+			continue
+		}
+		if !deleted {
+			continue
+		}
+
+		left, removeErr := uc.fileRepo.RemovePlacements(ctx, userObjectID, fileObjectID, deviceID)
+		if removeErr != nil {
+			if firstErr == nil {
+				firstErr = removeErr
+			}
+			continue
+		}
+		if left == nil {
+			return true, nil // Its last queued deletion finished in the meantime
+		}
+		remaining = left
+	}
+	if firstErr != nil {
+		return false, firstErr
+	}
+
+	// Every device deleted the file or has its deletion queued, so content still staged on the
+	// main server for offline devices is not needed anymore
+	if err = uc.staging.Remove(ctx, file.ID.Hex()); err != nil {
+		return false, err
+	}
+
+	if len(remaining.PlacementDevices()) > 0 {
+		return false, nil
+	}
+	return true, uc.fileRepo.Delete(ctx, userObjectID, fileObjectID)
+}
+
+// markDeleting moves the file to the deleting state and returns it, or nil if it does not exist.
+// Its replicas are written out, so that those of files stored before replicas were tracked stay
+// on record until they are deleted.
+func (uc *DeleteFileUseCase) markDeleting(ctx context.Context, userID, fileID primitive.ObjectID) (*entities.File, error) {
+	for attempt := 0; attempt < deleteAttempts; attempt++ {
+		file, err := uc.fileRepo.GetByID(ctx, userID, fileID)
+		if err != nil || file == nil {
+			return nil, err
+		}
+		if file.Status == entities.FileStatusDeleting {
+			return file, nil
+		}
+
+		file.Replicas = file.AllReplicas()
+		file.Status = entities.FileStatusDeleting
+		file.StatusReason = deletingReason
+		file.StatusChangedAt = time.Now()
+
+		updated, err := uc.fileRepo.UpdatePlacements(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		if updated {
+			return file, nil
+		}
 	}
+	return nil, fmt.Errorf("file %s kept changing while it was being deleted", fileID.Hex())
+}
 
-	// Remove file record from database
-	err = uc.fileRepo.Delete(ctx, userObjectID, fileObjectID)
+// deleteFrom deletes the file's content from the device if it is online, and queues the deletion
+// otherwise or if the device could not delete it. It reports whether the content is gone.
+func (uc *DeleteFileUseCase) deleteFrom(ctx context.Context, file *entities.File, deviceID primitive.ObjectID) (bool, error) {
+	device, err := uc.deviceRepo.GetByID(ctx, file.UserID, deviceID)
 	if err != nil {
-		return err
+		return false, err
+	}
+	if device == nil {
+		return true, nil // The content went with the device when it was removed
 	}
 
+	if device.Status == deviceEntities.DeviceStatusOnline {
+		if err = uc.deleteObjects(ctx, device, file.ObjectIDsOn(deviceID)); err == nil {
+			return true, nil
+		}
+	}
+
+	return false, uc.deletions.Execute(ctx, file.UserID, file.ID, deviceID)
+}
+
+// deleteObjects deletes the objects from the device. Objects it does not hold count as deleted.
+func (uc *DeleteFileUseCase) deleteObjects(ctx context.Context, device *deviceEntities.Device, objectIDs []string) error {
+	for _, objectID := range objectIDs {
+		err := uc.deviceStorage.DeleteFile(ctx, device, objectID)
+		if err != nil && !errors.Is(err, repository.ErrObjectNotFound) {
+			return err
+		}
+	}
 	return nil
 }
@@ -0,0 +1,101 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/files/domain/repository"
+)
+
+var errAllReplicasFailed = errors.New("every replica upload failed")
+
+// pipeSet holds one pipe per device upload. A pipe whose device stopped reading is dropped, so
+// one failing device does not abort the others.
+type pipeSet struct {
+	writers []*io.PipeWriter
+	failed  []bool
+}
+
+func (s *pipeSet) writeTo(i int, p []byte) {
+	if s.failed[i] {
+		return
+	}
+	if _, err := s.writers[i].Write(p); err != nil {
+		s.failed[i] = true
+	}
+}
+
+// live counts the pipes still accepting data
+func (s *pipeSet) live() int {
+	count := 0
+	for _, failed := range s.failed {
+		if !failed {
+			count++
+		}
+	}
+	return count
+}
+
+// fanOutWriter copies each write to every pipe in the set
+type fanOutWriter struct {
+	pipes *pipeSet
+}
+
+func (w *fanOutWriter) Write(p []byte) (int, error) {
+	for i := range w.pipes.writers {
+		w.pipes.writeTo(i, p)
+	}
+
+	if w.pipes.live() == 0 {
+		return 0, errAllReplicasFailed
+	}
+	return len(p), nil
+}
+
+// streamToDevices uploads objectIDs[i] to devices[i], all at once, with produce writing the
+// content into the pipes. It returns each device's result in the order of devices. The slowest
+// device sets the pace for the whole upload.
+func streamToDevices(
+	ctx context.Context, deviceStorage repository.DeviceStorageRepository,
+	devices []*deviceEntities.Device, objectIDs []string, produce func(pipes *pipeSet) error,
+) []error {
+	results := make([]error, len(devices))
+	pipes := &pipeSet{
+		writers: make([]*io.PipeWriter, len(devices)),
+		failed:  make([]bool, len(devices)),
+	}
+
+	var wg sync.WaitGroup
+	for i, device := range devices {
+		reader, writer := io.Pipe()
+		pipes.writers[i] = writer
+
+		wg.Add(1)
+		go func(i int, device *deviceEntities.Device) {
+			defer wg.Done()
+			results[i] = deviceStorage.StoreFile(ctx, device, objectIDs[i], reader)
+			// Unblock the producer if the device returned without consuming the whole stream
+			reader.Close()
+		}(i, device)
+	}
+
+	produceErr := produce(pipes)
+	for _, writer := range pipes.writers {
+		// A nil error ends each device's stream cleanly; anything else fails every upload still running
+		writer.CloseWithError(produceErr)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// replicate produces the same content for every pipe
+func replicate(content io.Reader) func(pipes *pipeSet) error {
+	return func(pipes *pipeSet) error {
+		_, err := io.Copy(&fanOutWriter{pipes: pipes}, content)
+		return err
+	}
+}
@@ -0,0 +1,112 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/manab-pr/nebulo/internal/erasure"
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/files/domain/entities"
+)
+
+// openShards opens enough stored shards to rebuild the requested content, preferring data shards
+// on online devices since those need no decoding. Shards on unreachable devices are skipped, so
+// reads keep working with up to ParityShards devices offline.
+func (uc *DownloadFileUseCase) openShards(
+	ctx context.Context, file *entities.File, byteRange *entities.ByteRange,
+) (io.ReadCloser, error) {
+	codec, err := erasure.NewCodec(file.Erasure.DataShards, file.Erasure.ParityShards)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := uc.shardDevices(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) < codec.DataShards() {
+		return nil, fmt.Errorf("%w: only %d of the %d shards needed are stored on existing devices",
+			ErrFileUnavailable, len(candidates), codec.DataShards())
+	}
+
+	// Every stripe covers BlockSize bytes of each shard, so a byte range maps onto whole stripes
+	blockSize := int64(file.Erasure.BlockSize)
+	stripeBytes := int64(codec.DataShards()) * blockSize
+	var shardRange *entities.ByteRange
+	skip, length := int64(0), file.Size
+	if byteRange != nil {
+		firstStripe, lastStripe := byteRange.Start/stripeBytes, byteRange.End/stripeBytes
+		shardRange = &entities.ByteRange{Start: firstStripe * blockSize, End: (lastStripe+1)*blockSize - 1}
+		skip, length = byteRange.Start-firstStripe*stripeBytes, byteRange.Length()
+	}
+
+	sources := make([]io.ReadCloser, codec.TotalShards())
+	opened := 0
+	for _, candidate := range candidates {
+		if opened == codec.DataShards() {
+			break
+		}
+
+		source, openErr := uc.deviceStorage.OpenFile(ctx, candidate.device, file.ShardObjectID(candidate.index), shardRange)
+		if openErr != nil {
+			err = openErr
+			continue
+		}
+		sources[candidate.index] = source
+		opened++
+	}
+
+	reader := newShardReader(codec, sources, file.Erasure.BlockSize, skip, length)
+	if opened < codec.DataShards() {
+		reader.Close()
+		return nil, fmt.Errorf("%w: only %d of the %d shards needed could be opened: %v",
+			ErrDeviceUnreachable, opened, codec.DataShards(), err)
+	}
+
+	if byteRange != nil || file.Checksum == "" {
+		return reader, nil
+	}
+
+	markCorrupted := func() {
+		// The mismatch cannot be pinned on one shard, so the file as a whole is flagged
+		_ = uc.fileRepo.UpdateStatusWithReason(
+			context.WithoutCancel(ctx), file.UserID, file.ID,
+			entities.FileStatusCorrupted, "checksum mismatch while rebuilding from shards",
+		)
+	}
+	return newVerifyingReader(reader, file.Checksum, markCorrupted), nil
+}
+
+type shardCandidate struct {
+	index  int
+	device *deviceEntities.Device
+}
+
+// shardDevices returns the stored shards whose device still exists, ordered with shards on online
+// devices first and, within that, data shards before parity shards
+func (uc *DownloadFileUseCase) shardDevices(ctx context.Context, file *entities.File) ([]shardCandidate, error) {
+	var candidates []shardCandidate
+	for _, shard := range file.StoredShards() {
+		device, err := uc.deviceRepo.GetByID(ctx, file.UserID, shard.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		if device != nil {
+			candidates = append(candidates, shardCandidate{index: shard.Index, device: device})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		iOnline := candidates[i].device.Status == deviceEntities.DeviceStatusOnline
+		jOnline := candidates[j].device.Status == deviceEntities.DeviceStatusOnline
+		if iOnline != jOnline {
+			return iOnline
+		}
+		return candidates[i].index < candidates[j].index
+	})
+
+	return candidates, nil
+}
@@ -0,0 +1,137 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	"github.com/manab-pr/nebulo/modules/files/domain/entities"
+	"github.com/manab-pr/nebulo/modules/files/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type DownloadFileUseCase struct {
+	fileRepo      repository.FileRepository
+	deviceRepo    deviceRepository.DeviceRepository
+	deviceStorage repository.DeviceStorageRepository
+}
+
+func NewDownloadFileUseCase(
+	fileRepo repository.FileRepository,
+	deviceRepo deviceRepository.DeviceRepository,
+	deviceStorage repository.DeviceStorageRepository,
+) *DownloadFileUseCase {
+	return &DownloadFileUseCase{
+		fileRepo:      fileRepo,
+		deviceRepo:    deviceRepo,
+		deviceStorage: deviceStorage,
+	}
+}
+
+// Execute returns the file if its content can currently be downloaded
+func (uc *DownloadFileUseCase) Execute(ctx context.Context, userID, fileID string) (*entities.File, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	fileObjectID, err := primitive.ObjectIDFromHex(fileID)
+	if err != nil {
+		return nil, errors.New("invalid file ID")
+	}
+
+	file, err := uc.fileRepo.GetByID(ctx, userObjectID, fileObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if file == nil {
+		return nil, errors.New("file not found or does not belong to you")
+	}
+
+	if file.Status != entities.FileStatusStored {
+		return nil, fmt.Errorf("%w: status is %s", ErrFileUnavailable, file.Status)
+	}
+
+	return file, nil
+}
+
+// OpenContent streams the file's content, or only byteRange when it is not nil, from a device that
+// holds a stored replica, falling back to the next replica when a device cannot be reached. Online
+// devices are tried first. Erasure-coded files are rebuilt from any DataShards of their shards.
+// A full download verifies the checksum as it is consumed and fails with ErrChecksumMismatch at
+// the end of a corrupted stream, flagging that replica as corrupted; partial content cannot be
+// verified. The caller must close the reader.
+func (uc *DownloadFileUseCase) OpenContent(
+	ctx context.Context, file *entities.File, byteRange *entities.ByteRange,
+) (io.ReadCloser, error) {
+	if file.Erasure != nil {
+		return uc.openShards(ctx, file, byteRange)
+	}
+
+	devices, err := uc.replicaDevices(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(devices) == 0 {
+		return nil, fmt.Errorf("%w: no device holding a copy exists", ErrFileUnavailable)
+	}
+
+	var content io.ReadCloser
+	for _, device := range devices {
+		content, err = uc.deviceStorage.OpenFile(ctx, device, file.ID.Hex(), byteRange)
+		if err != nil {
+			continue
+		}
+
+		if byteRange != nil || file.Checksum == "" {
+			return content, nil
+		}
+		return newVerifyingReader(content, file.Checksum, uc.corruptionMarker(ctx, file, device.ID)), nil
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrDeviceUnreachable, err)
+}
+
+// replicaDevices returns the devices holding stored replicas of the file, online devices first
+func (uc *DownloadFileUseCase) replicaDevices(ctx context.Context, file *entities.File) ([]*deviceEntities.Device, error) {
+	var online, others []*deviceEntities.Device
+	for _, replica := range file.StoredReplicas() {
+		device, err := uc.deviceRepo.GetByID(ctx, file.UserID, replica.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case device == nil:
+			continue
+		case device.Status == deviceEntities.DeviceStatusOnline:
+			online = append(online, device)
+		default:
+			// A stale status should not rule out a device that might still answer
+			others = append(others, device)
+		}
+	}
+
+	return append(online, others...), nil
+}
+
+// corruptionMarker returns the callback that flags a replica whose content failed verification.
+// The file itself is only marked corrupted when no other stored replica remains.
+func (uc *DownloadFileUseCase) corruptionMarker(ctx context.Context, file *entities.File, deviceID primitive.ObjectID) func() {
+	return func() {
+		// Best effort: the download has already failed, so a failed status update changes nothing for the caller
+		updateCtx := context.WithoutCancel(ctx)
+		reason := "checksum mismatch while downloading from device"
+
+		_ = uc.fileRepo.UpdateReplicaStatus(updateCtx, file.UserID, file.ID, deviceID, entities.ReplicaStatusCorrupted, reason)
+		if len(file.StoredReplicas()) <= 1 {
+			_ = uc.fileRepo.UpdateStatusWithReason(updateCtx, file.UserID, file.ID, entities.FileStatusCorrupted, reason)
+		}
+	}
+}
@@ -0,0 +1,13 @@
+package usecases
+
+import "errors"
+
+var (
+	ErrFileNotFound       = errors.New("file not found or does not belong to you")
+	ErrFileTooLarge       = errors.New("file exceeds maximum allowed size")
+	ErrLargerThanDeclared = errors.New("file is larger than its declared size")
+	ErrFileUnavailable    = errors.New("file is not currently available")
+	ErrDeviceUnreachable  = errors.New("device holding the file could not be reached")
+	ErrChecksumMismatch   = errors.New("file content does not match its checksum")
+	ErrUnknownPlacement   = errors.New("unknown placement strategy")
+)
@@ -0,0 +1,214 @@
+package usecases
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/files/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// weightUnit is the share of a device's total storage worth one turn in weighted round robin
+	weightUnit = 1 << 30
+	// turnRetention is how long weighted round robin remembers a device after it was last among the
+	// candidates; a device not offered for that long is taken to have left the fleet
+	turnRetention = 24 * time.Hour
+)
+
+// PlacementStrategy picks the devices a file's copies or shards go to
+type PlacementStrategy interface {
+	// Place picks up to count of the candidates, in order of preference. Every candidate can take
+	// a copy and has room for it; labels are the placement labels the upload asked for.
+	Place(candidates []*deviceEntities.Device, count int, labels map[string]string) []*deviceEntities.Device
+}
+
+// PlacementRecorder is implemented by strategies that remember where copies went. Place leaves
+// what they remember alone, so picks that could not take the copy after all are not counted; each
+// copy placed is recorded with Placed instead.
+type PlacementRecorder interface {
+	// Placed records that a copy went to device, picked among candidates
+	Placed(candidates []*deviceEntities.Device, device *deviceEntities.Device)
+}
+
+// NewPlacementStrategies returns the built-in placement strategies by name
+func NewPlacementStrategies() map[string]PlacementStrategy {
+	return map[string]PlacementStrategy{
+		entities.PlacementMostFreeSpace:      MostFreeSpacePlacement{},
+		entities.PlacementWeightedRoundRobin: NewWeightedRoundRobinPlacement(),
+		entities.PlacementLeastRecentlyUsed:  LeastRecentlyUsedPlacement{},
+		entities.PlacementFillFirst:          FillFirstPlacement{},
+		entities.PlacementLabelConstrained:   LabelConstrainedPlacement{},
+	}
+}
+
+// MostFreeSpacePlacement spreads files out by picking the devices with the most available storage
+type MostFreeSpacePlacement struct{}
+
+func (MostFreeSpacePlacement) Place(
+	candidates []*deviceEntities.Device, count int, _ map[string]string,
+) []*deviceEntities.Device {
+	return firstOrdered(candidates, count, func(a, b *deviceEntities.Device) bool {
+		return a.AvailableStorage > b.AvailableStorage
+	})
+}
+
+// LeastRecentlyUsedPlacement picks the devices that were given a file longest ago, so uploads take
+// turns across devices; devices never given one come first, those with more free space breaking ties
+type LeastRecentlyUsedPlacement struct{}
+
+func (LeastRecentlyUsedPlacement) Place(
+	candidates []*deviceEntities.Device, count int, _ map[string]string,
+) []*deviceEntities.Device {
+	return firstOrdered(candidates, count, func(a, b *deviceEntities.Device) bool {
+		if !a.LastPlacedAt.Equal(b.LastPlacedAt) {
+			return a.LastPlacedAt.Before(b.LastPlacedAt)
+		}
+		return a.AvailableStorage > b.AvailableStorage
+	})
+}
+
+// FillFirstPlacement fills devices one at a time, in the order they were added, moving on to the
+// next once one has no room left
+type FillFirstPlacement struct{}
+
+func (FillFirstPlacement) Place(
+	candidates []*deviceEntities.Device, count int, _ map[string]string,
+) []*deviceEntities.Device {
+	return firstOrdered(candidates, count, func(a, b *deviceEntities.Device) bool {
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID.Hex() < b.ID.Hex()
+	})
+}
+
+// LabelConstrainedPlacement only picks devices that have every placement label, with the same
+// value, and among those the ones with the most available storage. Without labels, any device will do.
+type LabelConstrainedPlacement struct{}
+
+func (LabelConstrainedPlacement) Place(
+	candidates []*deviceEntities.Device, count int, labels map[string]string,
+) []*deviceEntities.Device {
+	matching := make([]*deviceEntities.Device, 0, len(candidates))
+	for _, device := range candidates {
+		if hasLabels(device, labels) {
+			matching = append(matching, device)
+		}
+	}
+	return MostFreeSpacePlacement{}.Place(matching, count, labels)
+}
+
+// WeightedRoundRobinPlacement has devices take turns, each getting turns in proportion to its total
+// storage. Turns are spread out rather than taken in a row (smooth weighted round robin), and
+// carried over from one upload to the next within this server. A device only uses up its turn once
+// a copy is placed on it. Devices that have not been among the candidates for turnRetention are
+// forgotten.
+type WeightedRoundRobinPlacement struct {
+	mu      sync.Mutex
+	current map[primitive.ObjectID]int64     // How far each device has come towards its next turn
+	offered map[primitive.ObjectID]time.Time // When each device was last among the candidates
+}
+
+func NewWeightedRoundRobinPlacement() *WeightedRoundRobinPlacement {
+	return &WeightedRoundRobinPlacement{
+		current: make(map[primitive.ObjectID]int64),
+		offered: make(map[primitive.ObjectID]time.Time),
+	}
+}
+
+func (p *WeightedRoundRobinPlacement) Place(
+	candidates []*deviceEntities.Device, count int, _ map[string]string,
+) []*deviceEntities.Device {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, device := range candidates {
+		p.offered[device.ID] = now
+	}
+	p.forgetGone(now)
+
+	// Turns are taken on a copy, and only for real once Placed
+	current := make(map[primitive.ObjectID]int64, len(candidates))
+	for _, device := range candidates {
+		current[device.ID] = p.current[device.ID]
+	}
+
+	remaining := append([]*deviceEntities.Device(nil), candidates...)
+	picked := make([]*deviceEntities.Device, 0, count)
+	for len(picked) < count && len(remaining) > 0 {
+		next := 0
+		for i, device := range remaining {
+			current[device.ID] += turnWeight(device)
+			if current[device.ID] > current[remaining[next].ID] {
+				next = i
+			}
+		}
+
+		current[remaining[next].ID] -= totalWeight(remaining)
+		picked = append(picked, remaining[next])
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+	return picked
+}
+
+// Placed has device take its turn among candidates
+func (p *WeightedRoundRobinPlacement) Placed(candidates []*deviceEntities.Device, device *deviceEntities.Device) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, candidate := range candidates {
+		p.current[candidate.ID] += turnWeight(candidate)
+	}
+	p.current[device.ID] -= totalWeight(candidates)
+}
+
+// turnWeight is how many turns the device gets per round, at least one
+func turnWeight(device *deviceEntities.Device) int64 {
+	return max(device.TotalStorage/weightUnit, 1)
+}
+
+// totalWeight is how many turns there are per round among the devices
+func totalWeight(devices []*deviceEntities.Device) int64 {
+	var total int64
+	for _, device := range devices {
+		total += turnWeight(device)
+	}
+	return total
+}
+
+// forgetGone drops the turns of devices that were last offered more than turnRetention before now
+func (p *WeightedRoundRobinPlacement) forgetGone(now time.Time) {
+	for id, offeredAt := range p.offered {
+		if now.Sub(offeredAt) > turnRetention {
+			delete(p.offered, id)
+			delete(p.current, id)
+		}
+	}
+}
+
+// firstOrdered returns the first count of the candidates once sorted by less, keeping the order
+// they came in for ties
+func firstOrdered(
+	candidates []*deviceEntities.Device, count int, less func(a, b *deviceEntities.Device) bool,
+) []*deviceEntities.Device {
+	ordered := append([]*deviceEntities.Device(nil), candidates...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return less(ordered[i], ordered[j])
+	})
+	return ordered[:min(count, len(ordered))]
+}
+
+// hasLabels reports whether the device has every one of the labels, with the same value
+func hasLabels(device *deviceEntities.Device, labels map[string]string) bool {
+	for key, value := range labels {
+		if deviceValue, ok := device.Labels[key]; !ok || deviceValue != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package usecases
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const gib = 1 << 30
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// device returns an online device with available of total bytes free
+func device(name string, available, total int64) *deviceEntities.Device {
+	return &deviceEntities.Device{
+		ID:               primitive.NewObjectID(),
+		Name:             name,
+		Status:           deviceEntities.DeviceStatusOnline,
+		AvailableStorage: available,
+		TotalStorage:     total,
+		CreatedAt:        epoch,
+	}
+}
+
+// names returns the names of the devices, in order
+func names(devices []*deviceEntities.Device) []string {
+	named := make([]string, len(devices))
+	for i, d := range devices {
+		named[i] = d.Name
+	}
+	return named
+}
+
+type placementCase struct {
+	name       string
+	candidates []*deviceEntities.Device
+	count      int
+	labels     map[string]string
+	want       []string
+}
+
+func runPlacementCases(t *testing.T, strategy PlacementStrategy, tests []placementCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates := slices.Clone(tt.candidates)
+			got := names(strategy.Place(candidates, tt.count, tt.labels))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Place() = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(candidates, tt.candidates) {
+				t.Error("Place() reordered the candidates it was given")
+			}
+		})
+	}
+}
+
+func TestMostFreeSpacePlacement(t *testing.T) {
+	small, large, medium := device("small", 10, 100), device("large", 30, 100), device("medium", 20, 100)
+	tied := device("tied", 20, 100)
+
+	runPlacementCases(t, MostFreeSpacePlacement{}, []placementCase{
+		{name: "empty fleet", count: 2, want: []string{}},
+		{name: "most free first", candidates: []*deviceEntities.Device{small, large, medium}, count: 2, want: []string{"large", "medium"}},
+		{name: "fewer candidates than copies", candidates: []*deviceEntities.Device{small, large}, count: 3, want: []string{"large", "small"}},
+		{name: "ties keep their order", candidates: []*deviceEntities.Device{medium, tied, small}, count: 2, want: []string{"medium", "tied"}},
+		{name: "no copies", candidates: []*deviceEntities.Device{small, large}, count: 0, want: []string{}},
+	})
+}
+
+func TestLeastRecentlyUsedPlacement(t *testing.T) {
+	never, roomy := device("never", 10, 100), device("never-roomy", 50, 100)
+	old, recent := device("old", 90, 100), device("recent", 90, 100)
+	old.LastPlacedAt = epoch.Add(time.Hour)
+	recent.LastPlacedAt = epoch.Add(2 * time.Hour)
+
+	runPlacementCases(t, LeastRecentlyUsedPlacement{}, []placementCase{
+		{name: "empty fleet", count: 1, want: []string{}},
+		{name: "never used first", candidates: []*deviceEntities.Device{recent, old, never}, count: 2, want: []string{"never", "old"}},
+		{name: "free space breaks ties", candidates: []*deviceEntities.Device{never, roomy}, count: 1, want: []string{"never-roomy"}},
+		{name: "fewer candidates than copies", candidates: []*deviceEntities.Device{recent, old}, count: 3, want: []string{"old", "recent"}},
+	})
+}
+
+func TestFillFirstPlacement(t *testing.T) {
+	first, second, third := device("first", 1, 100), device("second", 90, 100), device("third", 50, 100)
+	second.CreatedAt = epoch.Add(time.Hour)
+	third.CreatedAt = epoch.Add(2 * time.Hour)
+
+	runPlacementCases(t, FillFirstPlacement{}, []placementCase{
+		{name: "empty fleet", count: 1, want: []string{}},
+		{name: "oldest first", candidates: []*deviceEntities.Device{third, second, first}, count: 2, want: []string{"first", "second"}},
+		{name: "full device left out", candidates: []*deviceEntities.Device{third, second}, count: 1, want: []string{"second"}},
+		{name: "fewer candidates than copies", candidates: []*deviceEntities.Device{third}, count: 2, want: []string{"third"}},
+	})
+}
+
+func TestLabelConstrainedPlacement(t *testing.T) {
+	euSSD, euHDD, us := device("eu-ssd", 10, 100), device("eu-hdd", 30, 100), device("us", 90, 100)
+	euSSD.Labels = map[string]string{"region": "eu", "disk": "ssd"}
+	euHDD.Labels = map[string]string{"region": "eu", "disk": "hdd"}
+	us.Labels = map[string]string{"region": "us"}
+	fleet := []*deviceEntities.Device{euSSD, euHDD, us}
+
+	eu, euSSDOnly := map[string]string{"region": "eu"}, map[string]string{"region": "eu", "disk": "ssd"}
+
+	runPlacementCases(t, LabelConstrainedPlacement{}, []placementCase{
+		{name: "empty fleet", count: 1, labels: eu, want: []string{}},
+		{name: "no labels takes any device", candidates: fleet, count: 2, want: []string{"us", "eu-hdd"}},
+		{name: "matching devices by free space", candidates: fleet, count: 2, labels: eu, want: []string{"eu-hdd", "eu-ssd"}},
+		{name: "every label must match", candidates: fleet, count: 2, labels: euSSDOnly, want: []string{"eu-ssd"}},
+		{name: "no device fits", candidates: fleet, count: 1, labels: map[string]string{"region": "ap"}, want: []string{}},
+		{name: "missing label does not match", candidates: fleet, count: 1, labels: map[string]string{"disk": "nvme"}, want: []string{}},
+	})
+}
+
+func TestWeightedRoundRobinPlacement(t *testing.T) {
+	big, small := device("big", gib, 3*gib), device("small", gib, gib)
+
+	runPlacementCases(t, NewWeightedRoundRobinPlacement(), []placementCase{
+		{name: "empty fleet", count: 1, want: []string{}},
+		{name: "a device is picked once per upload", candidates: []*deviceEntities.Device{big, small}, count: 3, want: []string{"big", "small"}},
+	})
+}
+
+// placeOne has the placement pick one of the candidates and records the copy as placed there
+func placeOne(placement *WeightedRoundRobinPlacement, candidates ...*deviceEntities.Device) []*deviceEntities.Device {
+	picked := placement.Place(candidates, 1, nil)
+	for _, device := range picked {
+		placement.Placed(candidates, device)
+	}
+	return picked
+}
+
+func TestWeightedRoundRobinTurnsFollowTotalStorage(t *testing.T) {
+	big, small := device("big", gib, 3*gib), device("small", gib, gib)
+	tiny := device("tiny", 1, 1) // Less than a unit still gets a turn
+	placement := NewWeightedRoundRobinPlacement()
+
+	var got []string
+	for range 8 {
+		got = append(got, names(placeOne(placement, big, small))...)
+	}
+	// Turns are spread out rather than taken in a row
+	want := []string{"big", "big", "small", "big", "big", "big", "small", "big"}
+	if !slices.Equal(got, want) {
+		t.Errorf("turns = %v, want %v", got, want)
+	}
+
+	turns := make(map[string]int)
+	for range 4 {
+		for _, picked := range placeOne(placement, small, tiny) {
+			turns[picked.Name]++
+		}
+	}
+	if turns["small"] != 2 || turns["tiny"] != 2 {
+		t.Errorf("turns of equal weights = %v, want 2 each", turns)
+	}
+}
+
+func TestWeightedRoundRobinTurnsOnlyTakenOncePlaced(t *testing.T) {
+	big, small := device("big", gib, 3*gib), device("small", gib, gib)
+	candidates := []*deviceEntities.Device{big, small}
+	placement := NewWeightedRoundRobinPlacement()
+
+	// Picks that are never placed, say because their reservation was refused, use up no turn
+	for range 3 {
+		if got := names(placement.Place(candidates, 1, nil)); !slices.Equal(got, []string{"big"}) {
+			t.Fatalf("Place() without Placed = %v, want [big]", got)
+		}
+	}
+	if len(placement.current) != 0 {
+		t.Fatalf("Place() took turns: %v", placement.current)
+	}
+
+	// The copy went to small instead: big keeps its turn
+	placement.Placed(candidates, small)
+	if got := names(placement.Place(candidates, 2, nil)); !slices.Equal(got, []string{"big", "small"}) {
+		t.Errorf("Place() after small was placed = %v, want [big small]", got)
+	}
+	if placement.current[big.ID] != 3 || placement.current[small.ID] != -3 {
+		t.Errorf("turns = %v, want big 3 and small -3", placement.current)
+	}
+}
+
+func TestWeightedRoundRobinForgetsGoneDevices(t *testing.T) {
+	kept, gone := device("kept", gib, gib), device("gone", gib, gib)
+	placement := NewWeightedRoundRobinPlacement()
+
+	placeOne(placement, kept, gone)
+	if _, ok := placement.current[gone.ID]; !ok {
+		t.Fatal("device offered to placement has no turn recorded")
+	}
+
+	// Still remembered while it may come back
+	placeOne(placement, kept)
+	if _, ok := placement.current[gone.ID]; !ok {
+		t.Fatal("device forgotten as soon as it was not offered")
+	}
+
+	placement.offered[gone.ID] = time.Now().Add(-turnRetention - time.Minute)
+	placeOne(placement, kept)
+	if _, ok := placement.current[gone.ID]; ok {
+		t.Error("turn of a device gone for longer than turnRetention is still kept")
+	}
+	if _, ok := placement.offered[gone.ID]; ok {
+		t.Error("device gone for longer than turnRetention is still tracked")
+	}
+	if _, ok := placement.current[kept.ID]; !ok {
+		t.Error("turn of a device still offered was dropped")
+	}
+}
@@ -0,0 +1,105 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/files/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// queuedReason is the status reason of a replica waiting for its device to come back
+const queuedReason = "queued until the device is back online"
+
+// partitionOnline splits devices into those that can take a copy now and those that will get it
+// through the transfer queue
+func partitionOnline(devices []*deviceEntities.Device) (online, offline []*deviceEntities.Device) {
+	for _, device := range devices {
+		if device.Status == deviceEntities.DeviceStatusOnline {
+			online = append(online, device)
+		} else {
+			offline = append(offline, device)
+		}
+	}
+	return online, offline
+}
+
+// replicaOn returns the file's replica on the device
+func replicaOn(file *entities.File, deviceID primitive.ObjectID) *entities.Replica {
+	for i := range file.Replicas {
+		if file.Replicas[i].DeviceID == deviceID {
+			return &file.Replicas[i]
+		}
+	}
+	return nil
+}
+
+// stageContent keeps the upload on the main server, for when none of its devices is online. The
+// size and checksum are learned on the way, as they are when streaming to devices.
+func (uc *StoreFileUseCase) stageContent(ctx context.Context, file *entities.File, content io.Reader) error {
+	objectID := file.ID.Hex()
+	upload := newUploadReader(content, uc.maxFileSize, file.Size)
+
+	err := uc.staging.Create(ctx, objectID)
+	if err == nil {
+		_, err = uc.staging.Append(ctx, objectID, 0, upload)
+	}
+	if upload.cutOff != nil {
+		err = upload.cutOff
+	}
+	if err != nil {
+		_ = uc.staging.Remove(context.WithoutCancel(ctx), objectID)
+		return err
+	}
+
+	file.Size = upload.Size()
+	file.Checksum = upload.Checksum()
+	return nil
+}
+
+// queueCopies hands the replicas on offline devices to the transfer queue. The dispatcher copies
+// them from a stored replica, or from the staged content when staged is set; without either there
+// is nothing to copy from, and the replicas fail with the rest. Queued replicas keep the space
+// reserved on their device for the dispatcher to settle. It returns the first error.
+func (uc *StoreFileUseCase) queueCopies(
+	ctx context.Context, file *entities.File, devices []*deviceEntities.Device, staged bool,
+) error {
+	hasSource := staged || len(file.StoredReplicas()) > 0
+
+	var queueErr error
+	queued := 0
+	for _, device := range devices {
+		replica := replicaOn(file, device.ID)
+		replica.UpdatedAt = time.Now()
+
+		if !hasSource {
+			replica.Status = entities.ReplicaStatusFailed
+			replica.StatusReason = "no copy of the file to transfer from"
+			uc.settleReservation(ctx, file.UserID, file.ID, device.ID, false)
+			continue
+		}
+
+		if err := uc.transfers.Execute(ctx, file.UserID, file.ID, device.ID); err != nil {
+			replica.Status = entities.ReplicaStatusFailed
+			replica.StatusReason = fmt.Sprintf("could not queue transfer: %v", err)
+			if queueErr == nil {
+				queueErr = err
+			}
+			uc.settleReservation(ctx, file.UserID, file.ID, device.ID, false)
+			continue
+		}
+
+		replica.StatusReason = queuedReason
+		queued++
+	}
+
+	// Staged content nobody is going to collect is dropped
+	if staged && queued == 0 {
+		_ = uc.staging.Remove(context.WithoutCancel(ctx), file.ID.Hex())
+	}
+	return queueErr
+}
@@ -0,0 +1,194 @@
+package usecases
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/manab-pr/nebulo/internal/erasure"
+)
+
+var errTooFewShardUploads = errors.New("too many shard uploads failed to keep the file recoverable")
+
+// shardSize returns the size of every shard of a file of the given size
+func shardSize(size int64, dataShards, blockSize int) int64 {
+	stripeBytes := int64(dataShards * blockSize)
+	stripes := (size + stripeBytes - 1) / stripeBytes
+	return stripes * int64(blockSize)
+}
+
+// shardWriter erasure-codes a stream stripe by stripe, writing one block of every stripe to
+// each shard's pipe. It fails once fewer shards remain than are needed to rebuild the data.
+type shardWriter struct {
+	codec     *erasure.Codec
+	pipes     *pipeSet
+	blockSize int
+	blocks    [][]byte
+	filled    int // Data bytes buffered for the current stripe
+}
+
+func newShardWriter(codec *erasure.Codec, pipes *pipeSet, blockSize int) *shardWriter {
+	blocks := make([][]byte, codec.TotalShards())
+	for i := range blocks {
+		blocks[i] = make([]byte, blockSize)
+	}
+
+	return &shardWriter{
+		codec:     codec,
+		pipes:     pipes,
+		blockSize: blockSize,
+		blocks:    blocks,
+	}
+}
+
+func (w *shardWriter) Write(p []byte) (int, error) {
+	written := 0
+	stripeBytes := w.codec.DataShards() * w.blockSize
+
+	for len(p) > 0 {
+		block := w.filled / w.blockSize
+		offset := w.filled % w.blockSize
+		n := copy(w.blocks[block][offset:], p)
+
+		w.filled += n
+		written += n
+		p = p[n:]
+
+		if w.filled == stripeBytes {
+			if err := w.flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Close writes out the final, zero-padded stripe
+func (w *shardWriter) Close() error {
+	if w.filled == 0 {
+		return nil
+	}
+
+	for block := w.filled / w.blockSize; block < w.codec.DataShards(); block++ {
+		clear(w.blocks[block][w.filled%w.blockSize:])
+		w.filled = (block + 1) * w.blockSize
+	}
+	return w.flush()
+}
+
+func (w *shardWriter) flush() error {
+	if err := w.codec.Encode(w.blocks); err != nil {
+		return err
+	}
+
+	for i, block := range w.blocks {
+		w.pipes.writeTo(i, block)
+	}
+	w.filled = 0
+
+	if w.pipes.live() < w.codec.DataShards() {
+		return errTooFewShardUploads
+	}
+	return nil
+}
+
+// shardProducer erasure-codes content into the pipes, one pipe per shard
+func shardProducer(codec *erasure.Codec, blockSize int, content io.Reader) func(pipes *pipeSet) error {
+	return func(pipes *pipeSet) error {
+		writer := newShardWriter(codec, pipes, blockSize)
+		if _, err := io.Copy(writer, content); err != nil {
+			return err
+		}
+		return writer.Close()
+	}
+}
+
+// shardReader rebuilds a file's content from the shards it has open, one stripe at a time.
+// sources holds a reader per shard index, nil for shards that are not read; at least
+// DataShards of them must be set. Reading starts skip bytes into the first stripe read and
+// ends after length bytes, cutting off the padding.
+type shardReader struct {
+	codec     *erasure.Codec
+	sources   []io.ReadCloser
+	blockSize int
+	stripe    []byte
+	pending   []byte // Decoded data not yet returned
+	skip      int64
+	remaining int64
+}
+
+func newShardReader(codec *erasure.Codec, sources []io.ReadCloser, blockSize int, skip, length int64) *shardReader {
+	return &shardReader{
+		codec:     codec,
+		sources:   sources,
+		blockSize: blockSize,
+		stripe:    make([]byte, codec.DataShards()*blockSize),
+		skip:      skip,
+		remaining: length,
+	}
+}
+
+func (r *shardReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+
+	for len(r.pending) == 0 {
+		if err := r.nextStripe(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	r.remaining -= int64(n)
+
+	return n, nil
+}
+
+func (r *shardReader) nextStripe() error {
+	shards := make([][]byte, len(r.sources))
+	for i, source := range r.sources {
+		if source == nil {
+			continue
+		}
+
+		block := make([]byte, r.blockSize)
+		if _, err := io.ReadFull(source, block); err != nil {
+			return fmt.Errorf("reading shard %d: %w", i, err)
+		}
+		shards[i] = block
+	}
+
+	if err := r.codec.ReconstructData(shards); err != nil {
+		return err
+	}
+
+	for i := 0; i < r.codec.DataShards(); i++ {
+		copy(r.stripe[i*r.blockSize:], shards[i])
+	}
+
+	r.pending = r.stripe
+	if r.skip > 0 {
+		r.pending = r.pending[r.skip:]
+		r.skip = 0
+	}
+	return nil
+}
+
+func (r *shardReader) Close() error {
+	var closeErr error
+	for _, source := range r.sources {
+		if source == nil {
+			continue
+		}
+		if err := source.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
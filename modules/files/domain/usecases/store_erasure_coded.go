@@ -0,0 +1,165 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/manab-pr/nebulo/internal/erasure"
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	"github.com/manab-pr/nebulo/modules/files/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// shardBlockSize is how much of each shard one stripe covers. Larger blocks mean fewer, bigger
+// writes; the last stripe is padded up to a full block per data shard.
+const shardBlockSize = 64 * 1024
+
+// storeErasureCoded splits the file into req.DataShards data and req.ParityShards parity shards,
+// each on its own device, so the file survives the loss of up to ParityShards devices
+func (uc *StoreFileUseCase) storeErasureCoded(
+	ctx context.Context, userID primitive.ObjectID, req entities.StoreFileRequest, content io.Reader,
+) (*entities.File, error) {
+	if req.Replicas > 1 {
+		return nil, errors.New("replicas cannot be combined with erasure coding")
+	}
+
+	codec, err := erasure.NewCodec(req.DataShards, req.ParityShards)
+	if err != nil {
+		return nil, errors.New("erasure coding needs at least one data and one parity shard")
+	}
+
+	estimatedShardSize := shardSize(req.Size, codec.DataShards(), shardBlockSize)
+	fileID := primitive.NewObjectID()
+	devices, err := uc.selectDevices(ctx, userID, fileID, req, codec.TotalShards(), estimatedShardSize, false)
+	if err != nil {
+		return nil, err
+	}
+
+	file := newFileRecord(fileID, userID, req, devices[0].ID)
+	file.Erasure = &entities.ErasureCoding{
+		DataShards:   codec.DataShards(),
+		ParityShards: codec.ParityShards(),
+		BlockSize:    shardBlockSize,
+		ShardSize:    estimatedShardSize,
+	}
+	file.Shards = make([]entities.Shard, len(devices))
+	for i, device := range devices {
+		file.Shards[i] = entities.Shard{
+			Index:     i,
+			DeviceID:  device.ID,
+			Status:    entities.ReplicaStatusPending,
+			UpdatedAt: file.CreatedAt,
+		}
+	}
+
+	createdFile, err := uc.fileRepo.Create(ctx, file)
+	if err != nil {
+		uc.releaseReservations(ctx, userID, fileID, devices)
+		return nil, err
+	}
+
+	err = uc.shipShards(ctx, createdFile, codec, devices, content)
+	if err != nil {
+		return nil, err
+	}
+
+	return createdFile, nil
+}
+
+// shipShards encodes the stream into shards on the way to the devices and records the outcome per
+// shard, in the same way shipToDevices does for replicas
+func (uc *StoreFileUseCase) shipShards(
+	ctx context.Context, file *entities.File, codec *erasure.Codec, devices []*deviceEntities.Device, content io.Reader,
+) error {
+	upload := newUploadReader(content, uc.maxFileSize, file.Size)
+
+	objectIDs := make([]string, len(devices))
+	for i := range objectIDs {
+		objectIDs[i] = file.ShardObjectID(i)
+	}
+
+	results := streamToDevices(ctx, uc.deviceStorage, devices, objectIDs, shardProducer(codec, shardBlockSize, upload))
+	if upload.cutOff != nil {
+		for i := range results {
+			results[i] = upload.cutOff
+		}
+	}
+
+	var uploadErr error
+	for i, device := range devices {
+		shard := &file.Shards[i]
+		shard.UpdatedAt = time.Now()
+
+		if results[i] != nil {
+			shard.Status = entities.ReplicaStatusFailed
+			shard.StatusReason = fmt.Sprintf("upload to device failed: %v", results[i])
+			if uploadErr == nil {
+				uploadErr = results[i]
+			}
+			uc.settleReservation(ctx, file.UserID, file.ID, device.ID, false)
+			continue
+		}
+
+		file.Size = upload.Size()
+		file.Checksum = upload.Checksum()
+		file.Erasure.ShardSize = shardSize(file.Size, codec.DataShards(), shardBlockSize)
+
+		// The main server does not keep shard checksums; the signed upload already bound each shard's content
+		if err := uc.deviceStorage.ConfirmFile(ctx, device, objectIDs[i], ""); err != nil {
+			shard.StatusReason = fmt.Sprintf("device did not confirm shard: %v", err)
+			continue
+		}
+
+		shard.Status = entities.ReplicaStatusStored
+		shard.StatusReason = ""
+		uc.settleReservation(ctx, file.UserID, file.ID, device.ID, true)
+	}
+
+	return uc.recordShards(ctx, file, uploadErr)
+}
+
+// recordShards derives the file's status from its shards: it is stored once enough shards to
+// rebuild it are confirmed
+func (uc *StoreFileUseCase) recordShards(ctx context.Context, file *entities.File, uploadErr error) error {
+	var stored, pending int
+	var firstStored, firstPending *entities.Shard
+	for i := range file.Shards {
+		shard := &file.Shards[i]
+		switch shard.Status {
+		case entities.ReplicaStatusStored:
+			stored++
+			if firstStored == nil {
+				firstStored = shard
+			}
+		case entities.ReplicaStatusPending:
+			pending++
+			if firstPending == nil {
+				firstPending = shard
+			}
+		}
+	}
+
+	needed := file.Erasure.DataShards
+	switch {
+	case stored >= needed:
+		file.StoredOn = firstStored.DeviceID
+		return uc.setStatus(ctx, file, entities.FileStatusStored, "")
+	case stored+pending >= needed:
+		file.StoredOn = firstPending.DeviceID
+		return uc.setStatus(ctx, file, entities.FileStatusPending, firstPending.StatusReason)
+	}
+
+	if uploadErr == nil {
+		uploadErr = errTooFewShardUploads
+	}
+
+	reason := fmt.Sprintf("upload to device failed: %v", uploadErr)
+	if updateErr := uc.setStatus(ctx, file, entities.FileStatusFailed, reason); updateErr != nil {
+		return updateErr
+	}
+	return fmt.Errorf("upload to device failed: %w", uploadErr)
+}
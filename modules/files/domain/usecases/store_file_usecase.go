@@ -2,110 +2,539 @@ package usecases
 
 import (
 	"context"
-	"crypto/sha256"
 	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"time"
 
 	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
 	"github.com/manab-pr/nebulo/modules/devices/domain/repository"
 	"github.com/manab-pr/nebulo/modules/files/domain/entities"
 	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	transferRepository "github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+	userConstants "github.com/manab-pr/nebulo/modules/users/domain/constants"
+	userRepository "github.com/manab-pr/nebulo/modules/users/domain/repository"
 
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type StoreFileUseCase struct {
-	fileRepo   fileRepository.FileRepository
-	deviceRepo repository.DeviceRepository
+	fileRepo      fileRepository.FileRepository
+	deviceRepo    repository.DeviceRepository
+	userRepo      userRepository.UserRepository
+	deviceStorage fileRepository.DeviceStorageRepository
+	staging       fileRepository.ContentStagingRepository
+	transfers     transferRepository.TransferEnqueuer
+	maxFileSize   int64
+
+	// How long space reserved on a device for a copy is held before the device's own report takes over
+	reservationTTL time.Duration
+
+	placements       map[string]PlacementStrategy // By name
+	defaultPlacement string                       // Used for uploads and users that name no strategy
 }
 
-func NewStoreFileUseCase(fileRepo fileRepository.FileRepository, deviceRepo repository.DeviceRepository) *StoreFileUseCase {
+func NewStoreFileUseCase(
+	fileRepo fileRepository.FileRepository,
+	deviceRepo repository.DeviceRepository,
+	userRepo userRepository.UserRepository,
+	deviceStorage fileRepository.DeviceStorageRepository,
+	staging fileRepository.ContentStagingRepository,
+	transfers transferRepository.TransferEnqueuer,
+	maxFileSize int64,
+	reservationTTL time.Duration,
+	placements map[string]PlacementStrategy,
+	defaultPlacement string,
+) *StoreFileUseCase {
+	if _, ok := placements[defaultPlacement]; !ok {
+		defaultPlacement = entities.PlacementMostFreeSpace
+	}
+
 	return &StoreFileUseCase{
-		fileRepo:   fileRepo,
-		deviceRepo: deviceRepo,
+		fileRepo:      fileRepo,
+		deviceRepo:    deviceRepo,
+		userRepo:      userRepo,
+		deviceStorage: deviceStorage,
+		staging:       staging,
+		transfers:     transfers,
+		maxFileSize:   maxFileSize,
+
+		reservationTTL: reservationTTL,
+
+		placements:       placements,
+		defaultPlacement: defaultPlacement,
 	}
 }
 
+// Execute places a copy of the file on each of req.Replicas distinct devices (the user's default when
+// zero) and streams content to all of them at once, or spreads it over erasure-coded shards when
+// req.DataShards and req.ParityShards are set. req.Size is the size the client declared, which is
+// what gets placed and reserved; the stored size and checksum come from the stream itself, which is
+// cut off once it passes either the declared or the maximum file size, failing every copy and giving
+// their reservations back. A declared size past the maximum is rejected before anything is placed.
+// Replicas meant for offline devices are queued as transfers instead of failing the upload;
+// erasure-coded shards still need every device online.
+// Space for the file is reserved on every device it is placed on until its copy there is stored
+// or has failed, so concurrent uploads cannot count on the same free space.
 func (uc *StoreFileUseCase) Execute(
-	ctx context.Context, userID string, req entities.StoreFileRequest, fileData []byte,
+	ctx context.Context, userID string, req entities.StoreFileRequest, content io.Reader,
 ) (*entities.File, error) {
 	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return nil, errors.New("invalid user ID")
 	}
 
-	var selectedDevice *deviceEntities.Device
+	// Nothing is placed or reserved for an upload bound to be cut off
+	if uc.maxFileSize > 0 && req.Size > uc.maxFileSize {
+		return nil, ErrFileTooLarge
+	}
+
+	if req.DataShards > 0 || req.ParityShards > 0 {
+		return uc.storeErasureCoded(ctx, userObjectID, req, content)
+	}
+
+	replicas, err := uc.replicationFactor(ctx, userID, req.Replicas)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file ID doubles as the object name on the device, so it never needs sanitizing
+	fileID := primitive.NewObjectID()
+
+	devices, err := uc.selectDevices(ctx, userObjectID, fileID, req, replicas, req.Size, true)
+	if err != nil {
+		return nil, err
+	}
+
+	file := newFileRecord(fileID, userObjectID, req, devices[0].ID)
+	file.Replicas = make([]entities.Replica, len(devices))
+	for i, device := range devices {
+		file.Replicas[i] = entities.Replica{DeviceID: device.ID, Status: entities.ReplicaStatusPending, UpdatedAt: file.CreatedAt}
+	}
+
+	createdFile, err := uc.fileRepo.Create(ctx, file)
+	if err != nil {
+		uc.releaseReservations(ctx, userObjectID, fileID, devices)
+		return nil, err
+	}
+
+	online, offline := partitionOnline(devices)
+
+	var uploadErr error
+	staged := false
+	if len(online) > 0 {
+		uploadErr = uc.shipToDevices(ctx, createdFile, online, content)
+	} else {
+		uploadErr = uc.stageContent(ctx, createdFile, content)
+		staged = uploadErr == nil
+	}
+
+	if len(offline) > 0 {
+		if queueErr := uc.queueCopies(ctx, createdFile, offline, staged); uploadErr == nil {
+			uploadErr = queueErr
+		}
+	}
+
+	err = uc.recordReplicas(ctx, createdFile, uploadErr)
+	if err != nil {
+		return nil, err
+	}
+
+	return createdFile, nil
+}
+
+// newFileRecord builds the pending record for a new file whose first copy goes to primary
+func newFileRecord(fileID, userID primitive.ObjectID, req entities.StoreFileRequest, primary primitive.ObjectID) *entities.File {
+	// Generate unique filename
+	uniqueID := uuid.New().String()
+	fileName := fmt.Sprintf("%s_%s", uniqueID, req.Name)
+
+	now := time.Now()
+	return &entities.File{
+		ID:              fileID,
+		UserID:          userID,
+		Name:            fileName,
+		OriginalName:    req.Name,
+		Size:            req.Size,
+		MimeType:        req.MimeType,
+		StoredOn:        primary,
+		Status:          entities.FileStatusPending,
+		StatusChangedAt: now,
+		StoragePath:     fmt.Sprintf("/storage/%s", fileID.Hex()),
+		CreatedAt:       now,
+		UpdatedAt:       now,
+	}
+}
+
+// replicationFactor resolves how many copies to keep: the upload's own request, or the user's default
+func (uc *StoreFileUseCase) replicationFactor(ctx context.Context, userID string, requested int) (int, error) {
+	if requested > userConstants.MaxReplicas {
+		return 0, fmt.Errorf("at most %d replicas are supported", userConstants.MaxReplicas)
+	}
+
+	if requested > 0 {
+		return requested, nil
+	}
+
+	user, err := uc.userRepo.GetUserByID(ctx, userID)
+	if err != nil {
+		return 0, errors.New("failed to load user replication settings")
+	}
+
+	return user.ReplicationFactor(), nil
+}
+
+// selectDevices picks count distinct online devices. A target device, if given, comes first;
+// the placement strategy picks the rest among the devices with at least required bytes available.
+// With queueOffline, offline devices may be picked as well, after every suitable online one, to
+// receive their copy later. Draining devices are never picked. required bytes are reserved for
+// the file on every device picked, and released again if no placement comes of it.
+func (uc *StoreFileUseCase) selectDevices(
+	ctx context.Context,
+	userID, fileID primitive.ObjectID,
+	req entities.StoreFileRequest,
+	count int,
+	required int64,
+	queueOffline bool,
+) ([]*deviceEntities.Device, error) {
+	strategy, labels, err := uc.placementFor(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []*deviceEntities.Device
 
-	// Select target device
 	if req.TargetDevice != "" {
-		// Use specific device
 		deviceID, parseErr := primitive.ObjectIDFromHex(req.TargetDevice)
 		if parseErr != nil {
 			return nil, errors.New("invalid target device ID")
 		}
-		selectedDevice, err = uc.deviceRepo.GetByID(ctx, userObjectID, deviceID)
-		if err != nil || selectedDevice == nil {
+		target, lookupErr := uc.deviceRepo.GetByID(ctx, userID, deviceID)
+		if lookupErr != nil || target == nil {
 			return nil, errors.New("target device not found or does not belong to you")
 		}
-		if selectedDevice.Status != deviceEntities.DeviceStatusOnline {
+		if target.Draining() {
+			return nil, errors.New("target device is draining")
+		}
+		if !canReceive(target, queueOffline) {
 			return nil, errors.New("target device is not online")
 		}
-	} else {
-		// Find an online device with sufficient space
-		onlineDevices, deviceErr := uc.deviceRepo.GetOnlineDevicesByUser(ctx, userObjectID)
-		if deviceErr != nil {
-			return nil, deviceErr
+		reserved, reserveErr := uc.reserve(ctx, userID, fileID, target, required)
+		if reserveErr != nil {
+			return nil, reserveErr
 		}
-		if len(onlineDevices) == 0 {
-			return nil, errors.New("no online devices available")
+		if !reserved {
+			return nil, errors.New("target device does not have enough storage available")
 		}
+		selected = append(selected, target)
+	}
 
-		for _, device := range onlineDevices {
-			if device.AvailableStorage >= req.Size {
-				selectedDevice = device
-				break
+	if len(selected) < count {
+		selected, err = uc.placeRest(ctx, userID, fileID, selected, strategy, labels, count, required, queueOffline)
+	}
+
+	if err == nil {
+		placed := make([]primitive.ObjectID, len(selected))
+		for i, device := range selected {
+			placed[i] = device.ID
+		}
+		err = uc.deviceRepo.MarkPlaced(ctx, userID, placed, time.Now())
+	}
+	if err != nil {
+		uc.releaseReservations(ctx, userID, fileID, selected)
+		return nil, err
+	}
+
+	return selected, nil
+}
+
+// placeRest has the strategy add devices to selected until there are count of them: online
+// devices first, then, with queueOffline, offline ones. A device whose space was taken by another
+// upload in the meantime is passed over and the strategy picks again. The devices selected so far,
+// whose space is reserved, are returned along with any error.
+func (uc *StoreFileUseCase) placeRest(
+	ctx context.Context,
+	userID, fileID primitive.ObjectID,
+	selected []*deviceEntities.Device,
+	strategy PlacementStrategy,
+	labels map[string]string,
+	count int,
+	required int64,
+	queueOffline bool,
+) ([]*deviceEntities.Device, error) {
+	// Find online devices with sufficient space
+	onlineDevices, err := uc.deviceRepo.GetOnlineDevicesByUser(ctx, userID)
+	if err != nil {
+		return selected, err
+	}
+
+	groups := [][]*deviceEntities.Device{onlineDevices}
+	if queueOffline {
+		offlineDevices, offlineErr := uc.offlineDevices(ctx, userID)
+		if offlineErr != nil {
+			return selected, offlineErr
+		}
+		groups = append(groups, offlineDevices)
+	}
+	if len(slices.Concat(groups...)) == 0 {
+		return selected, errors.New("no online devices available")
+	}
+
+	for _, group := range groups {
+		if len(selected) == count {
+			break
+		}
+
+		candidates := make([]*deviceEntities.Device, 0, len(group))
+		for _, device := range group {
+			if len(selected) > 0 && device.ID == selected[0].ID {
+				continue
 			}
+			if device.Draining() {
+				continue // Being emptied to be removed
+			}
+			if device.AvailableStorage >= required {
+				candidates = append(candidates, device)
+			}
+		}
+		if selected, err = uc.reserveRest(ctx, userID, fileID, selected, candidates, strategy, labels, count, required); err != nil {
+			return selected, err
 		}
+	}
 
-		if selectedDevice == nil {
-			return nil, errors.New("no device with sufficient storage available")
+	if len(selected) == 0 {
+		return selected, errors.New("no device with sufficient storage available")
+	}
+	if len(selected) < count {
+		return selected, fmt.Errorf(
+			"%d devices needed but only %d devices have sufficient storage", count, len(selected),
+		)
+	}
+
+	return selected, nil
+}
+
+// reserveRest has the strategy pick among candidates until there are count devices selected,
+// reserving required bytes on each pick. Picks that can no longer take the file are dropped from
+// the candidates before picking again, and only the reserved ones are recorded with a strategy
+// that remembers where copies went.
+func (uc *StoreFileUseCase) reserveRest(
+	ctx context.Context,
+	userID, fileID primitive.ObjectID,
+	selected, candidates []*deviceEntities.Device,
+	strategy PlacementStrategy,
+	labels map[string]string,
+	count int,
+	required int64,
+) ([]*deviceEntities.Device, error) {
+	recorder, records := strategy.(PlacementRecorder)
+	for len(selected) < count && len(candidates) > 0 {
+		picked := strategy.Place(candidates, count-len(selected), labels)
+		if len(picked) == 0 {
+			break
 		}
+
+		for _, device := range picked {
+			reserved, err := uc.reserve(ctx, userID, fileID, device, required)
+			if err != nil {
+				return selected, err
+			}
+			if reserved {
+				selected = append(selected, device)
+				if records {
+					recorder.Placed(candidates, device)
+				}
+			}
+			candidates = slices.DeleteFunc(candidates, func(candidate *deviceEntities.Device) bool {
+				return candidate.ID == device.ID
+			})
+		}
+	}
+	return selected, nil
+}
+
+// reserve takes size bytes off the device's available storage for the file, reporting false if it
+// no longer has them
+func (uc *StoreFileUseCase) reserve(
+	ctx context.Context, userID, fileID primitive.ObjectID, device *deviceEntities.Device, size int64,
+) (bool, error) {
+	return uc.deviceRepo.Reserve(ctx, userID, device.ID, fileID, size, time.Now().Add(uc.reservationTTL))
+}
+
+// releaseReservations gives the space reserved for the file back on each of the devices
+func (uc *StoreFileUseCase) releaseReservations(
+	ctx context.Context, userID, fileID primitive.ObjectID, devices []*deviceEntities.Device,
+) {
+	for _, device := range devices {
+		uc.settleReservation(ctx, userID, fileID, device.ID, false)
 	}
+}
 
-	// Generate unique filename and checksum
-	uniqueID := uuid.New().String()
-	fileName := fmt.Sprintf("%s_%s", uniqueID, req.Name)
-	checksum := fmt.Sprintf("%x", sha256.Sum256(fileData))
-
-	// Create file record
-	file := &entities.File{
-		ID:           primitive.NewObjectID(),
-		UserID:       userObjectID,
-		Name:         fileName,
-		OriginalName: req.Name,
-		Size:         req.Size,
-		MimeType:     req.MimeType,
-		Checksum:     checksum,
-		StoredOn:     selectedDevice.ID,
-		Status:       entities.FileStatusPending,
-		StoragePath:  fmt.Sprintf("/storage/%s", fileName),
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+// settleReservation counts the space reserved for the file on the device as used once its copy is
+// stored, or gives it back otherwise. Should this fail, the reservation still runs out by itself.
+func (uc *StoreFileUseCase) settleReservation(ctx context.Context, userID, fileID, deviceID primitive.ObjectID, stored bool) {
+	// Detach from the request so a client disconnect cannot leave the space held
+	ctx = context.WithoutCancel(ctx)
+
+	if stored {
+		_ = uc.deviceRepo.ConfirmReservation(ctx, userID, deviceID, fileID)
+	} else {
+		_ = uc.deviceRepo.ReleaseReservation(ctx, userID, deviceID, fileID)
 	}
+}
 
-	createdFile, err := uc.fileRepo.Create(ctx, file)
-	if err != nil {
-		return nil, err
+// placementFor resolves the strategy placing the file and the labels it goes by: the upload's
+// own, or else the user's, with the server's default strategy when neither names one
+func (uc *StoreFileUseCase) placementFor(
+	ctx context.Context, userID primitive.ObjectID, req entities.StoreFileRequest,
+) (PlacementStrategy, map[string]string, error) {
+	name, labels := req.Placement, req.PlacementLabels
+	if name == "" || labels == nil {
+		user, err := uc.userRepo.GetUserByID(ctx, userID.Hex())
+		if err != nil {
+			return nil, nil, errors.New("failed to load user placement settings")
+		}
+		if name == "" {
+			name = user.PlacementStrategy
+		}
+		if labels == nil {
+			labels = user.PlacementLabels
+		}
+	}
+	if name == "" {
+		name = uc.defaultPlacement
 	}
 
-	// TODO: Send file to device (this would be done via HTTP call to device's internal server)
-	// For now, we'll mark it as stored immediately
-	err = uc.fileRepo.UpdateStatus(ctx, userObjectID, createdFile.ID, entities.FileStatusStored)
+	strategy, ok := uc.placements[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnknownPlacement, name)
+	}
+	return strategy, labels, nil
+}
+
+// offlineDevices returns the user's offline devices, which can take a copy later
+func (uc *StoreFileUseCase) offlineDevices(ctx context.Context, userID primitive.ObjectID) ([]*deviceEntities.Device, error) {
+	allDevices, err := uc.deviceRepo.GetAllByUser(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	return createdFile, nil
+	var offline []*deviceEntities.Device
+	for _, device := range allDevices {
+		if device.Status == deviceEntities.DeviceStatusOffline {
+			offline = append(offline, device)
+		}
+	}
+	return offline, nil
+}
+
+// canReceive reports whether a device can be given a copy: online devices right away, and offline
+// ones through the transfer queue when queueOffline is set. Failed devices are not expected back.
+func canReceive(device *deviceEntities.Device, queueOffline bool) bool {
+	switch device.Status {
+	case deviceEntities.DeviceStatusOnline:
+		return true
+	case deviceEntities.DeviceStatusOffline:
+		return queueOffline
+	}
+	return false
+}
+
+// shipToDevices streams the file to the given online devices, hashing it on the way, and sets the
+// outcome on each of their replicas. It returns the first upload error.
+func (uc *StoreFileUseCase) shipToDevices(
+	ctx context.Context, file *entities.File, devices []*deviceEntities.Device, content io.Reader,
+) error {
+	objectID := file.ID.Hex()
+	upload := newUploadReader(content, uc.maxFileSize, file.Size)
+
+	objectIDs := make([]string, len(devices))
+	for i := range objectIDs {
+		objectIDs[i] = objectID
+	}
+
+	results := streamToDevices(ctx, uc.deviceStorage, devices, objectIDs, replicate(upload))
+	if upload.cutOff != nil {
+		for i := range results {
+			results[i] = upload.cutOff
+		}
+	}
+
+	var uploadErr error
+	for i, device := range devices {
+		replica := replicaOn(file, device.ID)
+		replica.UpdatedAt = time.Now()
+
+		if results[i] != nil {
+			replica.Status = entities.ReplicaStatusFailed
+			replica.StatusReason = fmt.Sprintf("upload to device failed: %v", results[i])
+			if uploadErr == nil {
+				uploadErr = results[i]
+			}
+			uc.settleReservation(ctx, file.UserID, file.ID, device.ID, false)
+			continue
+		}
+
+		file.Size = upload.Size()
+		file.Checksum = upload.Checksum()
+
+		// An unconfirmed copy may still be on the device, so its reservation is left to run out
+		if err := uc.deviceStorage.ConfirmFile(ctx, device, objectID, file.Checksum); err != nil {
+			replica.StatusReason = fmt.Sprintf("device did not confirm file: %v", err)
+			continue
+		}
+
+		replica.Status = entities.ReplicaStatusStored
+		replica.StatusReason = ""
+		uc.settleReservation(ctx, file.UserID, file.ID, device.ID, true)
+	}
+
+	return uploadErr
+}
+
+// recordReplicas derives the file's status from its replicas and persists both. The file counts
+// as stored once any replica is confirmed. If every replica failed the file is marked failed and
+// an error is returned; otherwise, while replicas are still pending, because they were not
+// confirmed or are queued for offline devices, the file stays pending with the reason attached.
+func (uc *StoreFileUseCase) recordReplicas(ctx context.Context, file *entities.File, uploadErr error) error {
+	var firstPending *entities.Replica
+	for i := range file.Replicas {
+		replica := &file.Replicas[i]
+		switch replica.Status {
+		case entities.ReplicaStatusStored:
+			file.StoredOn = replica.DeviceID
+			return uc.setStatus(ctx, file, entities.FileStatusStored, "")
+		case entities.ReplicaStatusPending:
+			if firstPending == nil {
+				firstPending = replica
+			}
+		}
+	}
+
+	if firstPending != nil {
+		file.StoredOn = firstPending.DeviceID
+		return uc.setStatus(ctx, file, entities.FileStatusPending, firstPending.StatusReason)
+	}
+
+	// Every replica failed; there is no stored size or checksum to keep
+	reason := fmt.Sprintf("upload to device failed: %v", uploadErr)
+	if updateErr := uc.setStatus(ctx, file, entities.FileStatusFailed, reason); updateErr != nil {
+		return updateErr
+	}
+	return fmt.Errorf("upload to device failed: %w", uploadErr)
+}
+
+// setStatus persists the file with its new status, along with any size and checksum learned from the upload
+func (uc *StoreFileUseCase) setStatus(ctx context.Context, file *entities.File, status entities.FileStatus, reason string) error {
+	// Detach from the request so a client disconnect cannot leave the record in a stale state
+	ctx = context.WithoutCancel(ctx)
+
+	if file.Status != status {
+		file.StatusChangedAt = time.Now()
+	}
+	file.Status = status
+	file.StatusReason = reason
+	return uc.fileRepo.Update(ctx, file)
 }
@@ -0,0 +1,60 @@
+package usecases
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// uploadReader hashes and counts an upload while it streams through,
+// failing the stream as soon as it grows past the size limit or the
+// declared size its devices reserved space for
+type uploadReader struct {
+	source   io.Reader
+	hash     hash.Hash
+	size     int64
+	limit    int64
+	declared int64
+	// cutOff is the error the stream was failed with for growing too large
+	cutOff error
+}
+
+func newUploadReader(source io.Reader, limit, declared int64) *uploadReader {
+	return &uploadReader{
+		source:   source,
+		hash:     sha256.New(),
+		limit:    limit,
+		declared: declared,
+	}
+}
+
+func (r *uploadReader) Read(p []byte) (int, error) {
+	if r.cutOff != nil {
+		return 0, r.cutOff
+	}
+
+	n, err := r.source.Read(p)
+	r.size += int64(n)
+
+	switch {
+	case r.limit > 0 && r.size > r.limit:
+		r.cutOff = ErrFileTooLarge
+	case r.declared > 0 && r.size > r.declared:
+		r.cutOff = ErrLargerThanDeclared
+	}
+	if r.cutOff != nil {
+		return n, r.cutOff
+	}
+
+	r.hash.Write(p[:n])
+	return n, err
+}
+
+func (r *uploadReader) Size() int64 {
+	return r.size
+}
+
+func (r *uploadReader) Checksum() string {
+	return fmt.Sprintf("%x", r.hash.Sum(nil))
+}
@@ -0,0 +1,45 @@
+package usecases
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestUploadReaderCutsOffOversizedStreams(t *testing.T) {
+	tests := []struct {
+		name            string
+		content         string
+		limit, declared int64
+		want            error
+	}{
+		{"within both", "hello", 10, 5, nil},
+		{"no limits", "hello", 0, 0, nil},
+		{"past the declared size", "hello", 10, 4, ErrLargerThanDeclared},
+		{"past the maximum size", "hello", 4, 10, ErrFileTooLarge},
+		{"maximum size checked first", "hello", 3, 4, ErrFileTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upload := newUploadReader(strings.NewReader(tt.content), tt.limit, tt.declared)
+			_, err := io.Copy(io.Discard, upload)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("copy: got %v, want %v", err, tt.want)
+			}
+			if !errors.Is(upload.cutOff, tt.want) {
+				t.Errorf("cutOff = %v, want %v", upload.cutOff, tt.want)
+			}
+			if tt.want != nil {
+				if _, err = upload.Read(make([]byte, 1)); !errors.Is(err, tt.want) {
+					t.Errorf("read after cut off: got %v, want %v", err, tt.want)
+				}
+				return
+			}
+			if upload.Size() != int64(len(tt.content)) {
+				t.Errorf("Size() = %d, want %d", upload.Size(), len(tt.content))
+			}
+		})
+	}
+}
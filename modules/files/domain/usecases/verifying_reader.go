@@ -0,0 +1,101 @@
+package usecases
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// verifyingReader hashes a download as it is read and fails with ErrChecksumMismatch instead of
+// io.EOF if the content does not match the expected checksum. It always holds back the last byte
+// it has seen until the checksum is confirmed, so a corrupted stream can never be delivered whole.
+type verifyingReader struct {
+	source     io.ReadCloser
+	hash       hash.Hash
+	expected   string
+	onMismatch func()
+	held       byte
+	hasHeld    bool
+	ahead      [1]byte // Where the next byte is read when p has no room past the held one
+	drained    bool    // The source reached io.EOF
+	err        error
+}
+
+func newVerifyingReader(source io.ReadCloser, expected string, onMismatch func()) *verifyingReader {
+	return &verifyingReader{
+		source:     source,
+		hash:       sha256.New(),
+		expected:   expected,
+		onMismatch: onMismatch,
+	}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	offset := 0
+	if r.hasHeld {
+		p[0] = r.held
+		offset = 1
+		r.hasHeld = false
+	}
+
+	// A one-byte p only fits the held byte, so the byte held back next is read on the side
+	readAhead := offset == len(p)
+	buf := p[offset:]
+	if readAhead {
+		buf = r.ahead[:]
+	}
+
+	n, err := 0, io.EOF
+	if !r.drained {
+		n, err = r.source.Read(buf)
+		r.hash.Write(buf[:n])
+	}
+	total := offset + n
+	if readAhead {
+		total = offset
+		if n > 0 {
+			r.held, r.hasHeld = r.ahead[0], true
+		}
+	}
+
+	switch {
+	case err == io.EOF && r.hasHeld:
+		// The last byte was read ahead; it goes out once the checksum is confirmed on the next call
+		r.drained = true
+		return total, nil
+	case err == io.EOF:
+		r.drained = true
+		if fmt.Sprintf("%x", r.hash.Sum(nil)) != r.expected {
+			r.err = ErrChecksumMismatch
+			if r.onMismatch != nil {
+				r.onMismatch()
+			}
+			return max(total-1, 0), r.err
+		}
+		r.err = io.EOF
+		return total, io.EOF
+	case err != nil:
+		r.err = err
+		return total, err
+	case r.hasHeld:
+		return total, nil
+	case total > 0:
+		r.held = p[total-1]
+		r.hasHeld = true
+		return total - 1, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (r *verifyingReader) Close() error {
+	return r.source.Close()
+}
@@ -0,0 +1,59 @@
+package usecases
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestVerifyingReader(t *testing.T) {
+	const content = "the quick brown fox"
+	checksum := fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+		oneByte  bool
+		want     string
+		wantErr  error
+	}{
+		{"matching", content, checksum, false, content, nil},
+		{"matching, one byte at a time", content, checksum, true, content, nil},
+		{"one byte file, one byte at a time", "x", fmt.Sprintf("%x", sha256.Sum256([]byte("x"))), true, "x", nil},
+		{"empty", "", fmt.Sprintf("%x", sha256.Sum256(nil)), true, "", nil},
+		{"mismatch holds back the last byte", content + "!", checksum, false, content, ErrChecksumMismatch},
+		{"mismatch, one byte at a time", content + "!", checksum, true, content, ErrChecksumMismatch},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mismatches := 0
+			var reader io.Reader = newVerifyingReader(
+				io.NopCloser(strings.NewReader(tt.content)), tt.expected, func() { mismatches++ },
+			)
+			if tt.oneByte {
+				reader = iotest.OneByteReader(reader)
+			}
+
+			got, err := io.ReadAll(reader)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReadAll: got %v, want %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+			wantMismatches := 0
+			if tt.wantErr != nil {
+				wantMismatches = 1
+			}
+			if mismatches != wantMismatches {
+				t.Errorf("onMismatch called %d times, want %d", mismatches, wantMismatches)
+			}
+		})
+	}
+}
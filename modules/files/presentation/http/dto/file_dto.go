@@ -11,32 +11,104 @@ type StoreFileRequest struct {
 	Size         int64  `json:"size" validate:"required,min=1"`
 	MimeType     string `json:"mime_type"`
 	TargetDevice string `json:"target_device,omitempty"`
+	Replicas     int    `json:"replicas,omitempty" validate:"omitempty,min=1,max=10"`
+	DataShards   int    `json:"data_shards,omitempty" validate:"omitempty,min=1,max=16"`
+	ParityShards int    `json:"parity_shards,omitempty" validate:"omitempty,min=1,max=16"`
+
+	Placement       string            `json:"placement,omitempty"`
+	PlacementLabels map[string]string `json:"placement_labels,omitempty" validate:"max=32"`
 }
 
 type FileResponse struct {
-	ID           string    `json:"id"`
-	Name         string    `json:"name"`
-	OriginalName string    `json:"original_name"`
-	Size         int64     `json:"size"`
-	MimeType     string    `json:"mime_type"`
-	StoredOn     string    `json:"stored_on"`
+	ID              string             `json:"id"`
+	Name            string             `json:"name"`
+	OriginalName    string             `json:"original_name"`
+	Size            int64              `json:"size"`
+	MimeType        string             `json:"mime_type"`
+	StoredOn        string             `json:"stored_on"`
+	Replicas        []*ReplicaResponse `json:"replicas"`
+	Erasure         *ErasureResponse   `json:"erasure,omitempty"`
+	Shards          []*ShardResponse   `json:"shards,omitempty"`
+	Status          string             `json:"status"`
+	StatusReason    string             `json:"status_reason,omitempty"`
+	StatusChangedAt *time.Time         `json:"status_changed_at,omitempty"`
+	CreatedAt       time.Time          `json:"created_at"`
+	UpdatedAt       time.Time          `json:"updated_at"`
+}
+
+type ReplicaResponse struct {
+	DeviceID     string    `json:"device_id"`
+	Status       string    `json:"status"`
+	StatusReason string    `json:"status_reason,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+type ErasureResponse struct {
+	DataShards   int   `json:"data_shards"`
+	ParityShards int   `json:"parity_shards"`
+	ShardSize    int64 `json:"shard_size"`
+}
+
+type ShardResponse struct {
+	Index        int       `json:"index"`
+	DeviceID     string    `json:"device_id"`
 	Status       string    `json:"status"`
-	CreatedAt    time.Time `json:"created_at"`
+	StatusReason string    `json:"status_reason,omitempty"`
 	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 func ToFileResponse(file *entities.File) *FileResponse {
-	return &FileResponse{
+	replicas := make([]*ReplicaResponse, 0, len(file.Replicas))
+	for _, replica := range file.AllReplicas() {
+		replicas = append(replicas, &ReplicaResponse{
+			DeviceID:     replica.DeviceID.Hex(),
+			Status:       string(replica.Status),
+			StatusReason: replica.StatusReason,
+			UpdatedAt:    replica.UpdatedAt,
+		})
+	}
+
+	var erasure *ErasureResponse
+	if file.Erasure != nil {
+		erasure = &ErasureResponse{
+			DataShards:   file.Erasure.DataShards,
+			ParityShards: file.Erasure.ParityShards,
+			ShardSize:    file.Erasure.ShardSize,
+		}
+	}
+
+	var shards []*ShardResponse
+	for _, shard := range file.Shards {
+		shards = append(shards, &ShardResponse{
+			Index:        shard.Index,
+			DeviceID:     shard.DeviceID.Hex(),
+			Status:       string(shard.Status),
+			StatusReason: shard.StatusReason,
+			UpdatedAt:    shard.UpdatedAt,
+		})
+	}
+
+	response := &FileResponse{
 		ID:           file.ID.Hex(),
 		Name:         file.Name,
 		OriginalName: file.OriginalName,
 		Size:         file.Size,
 		MimeType:     file.MimeType,
 		StoredOn:     file.StoredOn.Hex(),
+		Replicas:     replicas,
+		Erasure:      erasure,
+		Shards:       shards,
 		Status:       string(file.Status),
+		StatusReason: file.StatusReason,
 		CreatedAt:    file.CreatedAt,
 		UpdatedAt:    file.UpdatedAt,
 	}
+
+	// Files created before status changes were timestamped have none
+	if !file.StatusChangedAt.IsZero() {
+		response.StatusChangedAt = &file.StatusChangedAt
+	}
+	return response
 }
 
 func ToFileResponses(files []*entities.File) []*FileResponse {
@@ -53,5 +125,11 @@ func (r *StoreFileRequest) ToEntity() entities.StoreFileRequest {
 		Size:         r.Size,
 		MimeType:     r.MimeType,
 		TargetDevice: r.TargetDevice,
+		Replicas:     r.Replicas,
+		DataShards:   r.DataShards,
+		ParityShards: r.ParityShards,
+
+		Placement:       r.Placement,
+		PlacementLabels: r.PlacementLabels,
 	}
 }
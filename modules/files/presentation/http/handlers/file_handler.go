@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strconv"
 
 	"github.com/manab-pr/nebulo/modules/auth/middleware"
+	"github.com/manab-pr/nebulo/modules/files/domain/entities"
 	"github.com/manab-pr/nebulo/modules/files/domain/usecases"
 	"github.com/manab-pr/nebulo/modules/files/presentation/http/dto"
 
@@ -13,31 +19,35 @@ import (
 )
 
 const (
-	maxUploadMemoryMB = 32
-	mbShift           = 20 // 1 << 20 = 1MB
+	maxFieldBytes      = 1024 // Upper bound for non-file multipart fields
+	defaultContentType = "application/octet-stream"
 )
 
 type FileHandler struct {
-	storeUseCase  *usecases.StoreFileUseCase
-	getUseCase    *usecases.GetFileUseCase
-	deleteUseCase *usecases.DeleteFileUseCase
-	validator     *validator.Validate
+	storeUseCase    *usecases.StoreFileUseCase
+	getUseCase      *usecases.GetFileUseCase
+	downloadUseCase *usecases.DownloadFileUseCase
+	deleteUseCase   *usecases.DeleteFileUseCase
+	validator       *validator.Validate
 }
 
 func NewFileHandler(
 	storeUseCase *usecases.StoreFileUseCase,
 	getUseCase *usecases.GetFileUseCase,
+	downloadUseCase *usecases.DownloadFileUseCase,
 	deleteUseCase *usecases.DeleteFileUseCase,
 ) *FileHandler {
 	return &FileHandler{
-		storeUseCase:  storeUseCase,
-		getUseCase:    getUseCase,
-		deleteUseCase: deleteUseCase,
-		validator:     validator.New(),
+		storeUseCase:    storeUseCase,
+		getUseCase:      getUseCase,
+		downloadUseCase: downloadUseCase,
+		deleteUseCase:   deleteUseCase,
+		validator:       validator.New(),
 	}
 }
 
-// StoreFile handles file upload and storage
+// StoreFile handles file upload and storage. The multipart body is read as a stream, so any
+// "size", "target_device", "replicas", "data_shards" and "parity_shards" fields must come before the "file" part.
 func (h *FileHandler) StoreFile(c *gin.Context) {
 	userID, exists := middleware.GetUserIDFromContext(c)
 	if !exists {
@@ -45,34 +55,51 @@ func (h *FileHandler) StoreFile(c *gin.Context) {
 		return
 	}
 
-	// Parse multipart form
-	err := c.Request.ParseMultipartForm(maxUploadMemoryMB << mbShift) // 32 MB max memory
+	reader, err := c.Request.MultipartReader()
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse multipart form"})
 		return
 	}
 
-	// Get file from form
-	file, header, err := c.Request.FormFile("file")
+	fields, file, err := readUploadFields(reader)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No file provided"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 	defer file.Close()
 
-	// Read file data
-	fileData, err := io.ReadAll(file)
+	size, err := declaredUploadSize(fields["size"], c.Request.ContentLength)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		c.JSON(http.StatusLengthRequired, gin.H{"error": err.Error()})
+		return
+	}
+
+	var replicas, dataShards, parityShards int
+	for name, value := range map[string]*int{"replicas": &replicas, "data_shards": &dataShards, "parity_shards": &parityShards} {
+		if *value, err = optionalInt(fields[name]); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a whole number"})
+			return
+		}
+	}
+
+	labels, err := entities.ParsePlacementLabels(fields["placement_labels"])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Create store request
 	req := dto.StoreFileRequest{
-		Name:         header.Filename,
-		Size:         int64(len(fileData)),
-		MimeType:     header.Header.Get("Content-Type"),
-		TargetDevice: c.PostForm("target_device"),
+		Name:         file.FileName(),
+		Size:         size,
+		MimeType:     file.Header.Get("Content-Type"),
+		TargetDevice: fields["target_device"],
+		Replicas:     replicas,
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+
+		Placement:       fields["placement"],
+		PlacementLabels: labels,
 	}
 
 	if validationErr := h.validator.Struct(req); validationErr != nil {
@@ -80,15 +107,28 @@ func (h *FileHandler) StoreFile(c *gin.Context) {
 		return
 	}
 
-	storedFile, err := h.storeUseCase.Execute(c.Request.Context(), userID, req.ToEntity(), fileData)
+	storedFile, err := h.storeUseCase.Execute(c.Request.Context(), userID, req.ToEntity(), file)
+	if errors.Is(err, usecases.ErrFileTooLarge) || errors.Is(err, usecases.ErrLargerThanDeclared) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, usecases.ErrUnknownPlacement) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	message := "File stored successfully"
+	if storedFile.Status != entities.FileStatusStored {
+		message = "File uploaded, awaiting device confirmation"
+	}
+
 	response := dto.ToFileResponse(storedFile)
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "File stored successfully",
+		"message": message,
 		"data":    response,
 	})
 }
@@ -120,6 +160,94 @@ func (h *FileHandler) GetFile(c *gin.Context) {
 	})
 }
 
+// DownloadFile streams the file content from the device that holds it. The checksum serves as
+// a strong ETag, and single byte ranges are answered with 206 Partial Content.
+func (h *FileHandler) DownloadFile(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	fileID := c.Param("fileId")
+	if fileID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File ID is required"})
+		return
+	}
+
+	file, err := h.downloadUseCase.Execute(c.Request.Context(), userID, fileID)
+	if err != nil {
+		writeDownloadError(c, err)
+		return
+	}
+
+	etag := ""
+	if file.Checksum != "" {
+		etag = `"` + file.Checksum + `"`
+		c.Header("ETag", etag)
+	}
+	c.Header("Accept-Ranges", "bytes")
+
+	if etag != "" && etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	byteRange, err := requestedRange(c.GetHeader("Range"), c.GetHeader("If-Range"), etag, file.Size)
+	if err != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", file.Size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": err.Error()})
+		return
+	}
+
+	content, err := h.downloadUseCase.OpenContent(c.Request.Context(), file, byteRange)
+	if err != nil {
+		writeDownloadError(c, err)
+		return
+	}
+	defer content.Close()
+
+	contentType := file.MimeType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": file.OriginalName})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", disposition)
+
+	if byteRange != nil {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", byteRange.Start, byteRange.End, file.Size))
+		c.Header("Content-Length", strconv.FormatInt(byteRange.Length(), 10))
+		c.Status(http.StatusPartialContent)
+	} else {
+		c.Header("Content-Length", strconv.FormatInt(file.Size, 10))
+		c.Status(http.StatusOK)
+	}
+
+	// Headers are already sent, so a failure here can only cut the body short. The server
+	// then closes the connection because fewer bytes than Content-Length were written,
+	// which keeps the client from mistaking a corrupted body for a complete download.
+	if _, err = io.Copy(c.Writer, content); err != nil {
+		_ = c.Error(err)
+	}
+}
+
+func writeDownloadError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, usecases.ErrFileUnavailable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, usecases.ErrDeviceUnreachable):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	}
+}
+
 // GetAllFiles handles listing all files
 func (h *FileHandler) GetAllFiles(c *gin.Context) {
 	userID, exists := middleware.GetUserIDFromContext(c)
@@ -141,7 +269,7 @@ func (h *FileHandler) GetAllFiles(c *gin.Context) {
 	})
 }
 
-// DeleteFile handles file deletion
+// DeleteFile handles file deletion. It answers 202 while devices still have to delete the file.
 func (h *FileHandler) DeleteFile(c *gin.Context) {
 	userID, exists := middleware.GetUserIDFromContext(c)
 	if !exists {
@@ -155,13 +283,75 @@ func (h *FileHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	err := h.deleteUseCase.Execute(c.Request.Context(), userID, fileID)
+	deleted, err := h.deleteUseCase.Execute(c.Request.Context(), userID, fileID)
+	if errors.Is(err, usecases.ErrFileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	if !deleted {
+		// Devices that are offline delete their copies once they are back
+		c.JSON(http.StatusAccepted, gin.H{
+			"message": "File is being deleted",
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"message": "File deleted successfully",
 	})
 }
+
+// declaredUploadSize returns the size the client announced for the upload, falling back to the
+// request's Content-Length, which bounds the file size from above
+func declaredUploadSize(sizeField string, contentLength int64) (int64, error) {
+	if sizeField != "" {
+		size, err := strconv.ParseInt(sizeField, 10, 64)
+		if err != nil || size < 1 {
+			return 0, errors.New("invalid size field")
+		}
+		return size, nil
+	}
+
+	if contentLength > 0 {
+		return contentLength, nil
+	}
+
+	return 0, errors.New("file size is required: send a size field or a Content-Length header")
+}
+
+// readUploadFields collects the form fields that precede the "file" part and returns them with that part
+func readUploadFields(reader *multipart.Reader) (map[string]string, *multipart.Part, error) {
+	fields := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, nil, errors.New("no file provided")
+		}
+		if err != nil {
+			return nil, nil, errors.New("failed to parse multipart form")
+		}
+
+		if part.FormName() == "file" {
+			return fields, part, nil
+		}
+
+		value, err := io.ReadAll(io.LimitReader(part, maxFieldBytes))
+		if err != nil {
+			return nil, nil, errors.New("failed to parse multipart form")
+		}
+		fields[part.FormName()] = string(value)
+	}
+}
+
+// optionalInt parses an optional numeric form field, returning zero when it is absent
+func optionalInt(field string) (int, error) {
+	if field == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(field)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/manab-pr/nebulo/modules/files/domain/entities"
+)
+
+const bytesUnitPrefix = "bytes="
+
+var errRangeNotSatisfiable = errors.New("requested range not satisfiable")
+
+// etagMatches reports whether an If-None-Match style header lists the given ETag,
+// using the weak comparison RFC 9110 prescribes for If-None-Match
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// requestedRange resolves the Range and If-Range headers against a file of the given size.
+// It returns nil when the whole file should be served: no range was asked for, If-Range did
+// not match the strong ETag, or the header was malformed or asked for several ranges, which
+// servers may answer with the full content. errRangeNotSatisfiable means the range lies
+// entirely outside the file.
+func requestedRange(rangeHeader, ifRange, etag string, size int64) (*entities.ByteRange, error) {
+	if rangeHeader == "" {
+		return nil, nil
+	}
+
+	// If-Range only accepts strong validators; a date or weak ETag never matches here
+	if ifRange != "" && (etag == "" || ifRange != etag) {
+		return nil, nil
+	}
+
+	spec, ok := strings.CutPrefix(rangeHeader, bytesUnitPrefix)
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		// Suffix range: the final N bytes
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return nil, nil
+		}
+		if suffix == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		return &entities.ByteRange{Start: max(size-suffix, 0), End: size - 1}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		end = min(end, size-1)
+	}
+
+	return &entities.ByteRange{Start: start, End: end}, nil
+}
@@ -13,6 +13,7 @@ func SetupFileRoutes(router *gin.RouterGroup, handler *handlers.FileHandler) {
 	files.Use(middleware.AuthMiddleware()) // Require authentication for all file routes
 	files.POST(constants.StoreFileRoute, handler.StoreFile)
 	files.GET(constants.GetFileRoute, handler.GetFile)
+	files.GET(constants.GetFileContentRoute, handler.DownloadFile)
 	files.GET(constants.GetAllFilesRoute, handler.GetAllFiles)
 	files.DELETE(constants.DeleteFileRoute, handler.DeleteFile)
 }
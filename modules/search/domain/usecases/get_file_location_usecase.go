@@ -3,21 +3,42 @@ package usecases
 import (
 	"context"
 	"errors"
+	"time"
 
 	deviceRepo "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
 	fileRepo "github.com/manab-pr/nebulo/modules/files/domain/repository"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type FileLocationInfo struct {
-	FileID      string `json:"file_id"`
-	FileName    string `json:"file_name"`
-	DeviceID    string `json:"device_id"`
-	DeviceName  string `json:"device_name"`
-	DeviceIP    string `json:"device_ip"`
-	StoragePath string `json:"storage_path"`
-	Status      string `json:"status"`
+	FileID      string             `json:"file_id"`
+	FileName    string             `json:"file_name"`
+	DeviceID    string             `json:"device_id"` // Primary device
+	DeviceName  string             `json:"device_name"`
+	DeviceIP    string             `json:"device_ip"`
+	StoragePath string             `json:"storage_path"`
+	Status      string             `json:"status"`
+	Replicas    []*ReplicaLocation `json:"replicas"`
+	Shards      []*ShardLocation   `json:"shards,omitempty"` // Erasure-coded files only
+	DataShards  int                `json:"data_shards,omitempty"`
+}
+
+// ShardLocation is a ReplicaLocation for one erasure-coded shard
+type ShardLocation struct {
+	Index int `json:"index"`
+	ReplicaLocation
+}
+
+type ReplicaLocation struct {
+	DeviceID     string    `json:"device_id"`
+	DeviceName   string    `json:"device_name,omitempty"` // Empty when the device has since been removed
+	DeviceIP     string    `json:"device_ip,omitempty"`
+	DeviceStatus string    `json:"device_status,omitempty"`
+	Status       string    `json:"status"`
+	StatusReason string    `json:"status_reason,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 type GetFileLocationUseCase struct {
@@ -53,25 +74,64 @@ func (uc *GetFileLocationUseCase) Execute(ctx context.Context, userID, fileID st
 		return nil, errors.New("file not found or does not belong to you")
 	}
 
-	// Get device where file is stored (user-scoped)
-	device, err := uc.deviceRepo.GetByID(ctx, userObjectID, file.StoredOn)
-	if err != nil {
-		return nil, err
-	}
-
-	if device == nil {
-		return nil, errors.New("device not found or does not belong to you")
-	}
-
 	locationInfo := &FileLocationInfo{
 		FileID:      file.ID.Hex(),
 		FileName:    file.OriginalName,
-		DeviceID:    device.ID.Hex(),
-		DeviceName:  device.Name,
-		DeviceIP:    device.IPAddress,
+		DeviceID:    file.StoredOn.Hex(),
 		StoragePath: file.StoragePath,
 		Status:      string(file.Status),
 	}
 
+	// Resolve every replica's and shard's device (user-scoped)
+	for _, replica := range file.AllReplicas() {
+		location, locateErr := uc.locate(ctx, locationInfo, file, replica.DeviceID)
+		if locateErr != nil {
+			return nil, locateErr
+		}
+		location.Status = string(replica.Status)
+		location.StatusReason = replica.StatusReason
+		location.UpdatedAt = replica.UpdatedAt
+		locationInfo.Replicas = append(locationInfo.Replicas, location)
+	}
+
+	for _, shard := range file.Shards {
+		location, locateErr := uc.locate(ctx, locationInfo, file, shard.DeviceID)
+		if locateErr != nil {
+			return nil, locateErr
+		}
+		location.Status = string(shard.Status)
+		location.StatusReason = shard.StatusReason
+		location.UpdatedAt = shard.UpdatedAt
+		locationInfo.Shards = append(locationInfo.Shards, &ShardLocation{Index: shard.Index, ReplicaLocation: *location})
+	}
+
+	if file.Erasure != nil {
+		locationInfo.DataShards = file.Erasure.DataShards
+	}
+
 	return locationInfo, nil
 }
+
+// locate describes the device at deviceID, filling in the primary device details when it is the primary
+func (uc *GetFileLocationUseCase) locate(
+	ctx context.Context, info *FileLocationInfo, file *fileEntities.File, deviceID primitive.ObjectID,
+) (*ReplicaLocation, error) {
+	device, err := uc.deviceRepo.GetByID(ctx, file.UserID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	location := &ReplicaLocation{DeviceID: deviceID.Hex()}
+	if device != nil {
+		location.DeviceName = device.Name
+		location.DeviceIP = device.IPAddress
+		location.DeviceStatus = string(device.Status)
+
+		if device.ID == file.StoredOn {
+			info.DeviceName = device.Name
+			info.DeviceIP = device.IPAddress
+		}
+	}
+
+	return location, nil
+}
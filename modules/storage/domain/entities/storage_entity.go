@@ -8,6 +8,12 @@ type StorageSummary struct {
 	UsedStorage      int64 `json:"used_storage"`
 	AvailableStorage int64 `json:"available_storage"`
 	TotalFiles       int   `json:"total_files"`
+
+	// File data versus the raw space its replicas and erasure-coded shards take on devices
+	FileDataSize       int64   `json:"file_data_size"`
+	FileStorageUsed    int64   `json:"file_storage_used"`
+	RedundancyOverhead int64   `json:"redundancy_overhead"`
+	StorageEfficiency  float64 `json:"storage_efficiency"` // file_data_size / file_storage_used, 0 without files
 }
 
 type DeviceStorageInfo struct {
@@ -66,5 +66,21 @@ func (uc *GetStorageSummaryUseCase) Execute(ctx context.Context, userID string)
 	summary.UsedStorage = usedStorage
 	summary.AvailableStorage = availableStorage
 
+	// A file replicated three times uses three times its size, while k+m shards use (k+m)/k of it
+	// plus padding, so the raw usage is summed per file
+	for _, file := range files {
+		stored := file.StoredBytes()
+		if stored == 0 {
+			continue
+		}
+		summary.FileDataSize += file.Size
+		summary.FileStorageUsed += stored
+	}
+
+	summary.RedundancyOverhead = summary.FileStorageUsed - summary.FileDataSize
+	if summary.FileStorageUsed > 0 {
+		summary.StorageEfficiency = float64(summary.FileDataSize) / float64(summary.FileStorageUsed)
+	}
+
 	return summary, nil
 }
@@ -9,50 +9,113 @@ import (
 )
 
 type TransferModel struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty"`
-	FileID      primitive.ObjectID `bson:"file_id"`
-	DeviceID    primitive.ObjectID `bson:"device_id"`
-	Status      string             `bson:"status"`
-	Priority    int                `bson:"priority"`
-	Retries     int                `bson:"retries"`
-	MaxRetries  int                `bson:"max_retries"`
-	CreatedAt   time.Time          `bson:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at"`
-	StartedAt   *time.Time         `bson:"started_at,omitempty"`
-	CompletedAt *time.Time         `bson:"completed_at,omitempty"`
-	ErrorMsg    string             `bson:"error_msg,omitempty"`
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `bson:"user_id"`
+	FileID        primitive.ObjectID `bson:"file_id"`
+	DeviceID      primitive.ObjectID `bson:"device_id"`
+	Kind          string             `bson:"kind,omitempty"` // Empty for transfers queued before there were deletions
+	Status        string             `bson:"status"`
+	Priority      int                `bson:"priority"`
+	Retries       int                `bson:"retries"`
+	MaxRetries    int                `bson:"max_retries"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at"`
+	StartedAt     *time.Time         `bson:"started_at,omitempty"`
+	CompletedAt   *time.Time         `bson:"completed_at,omitempty"`
+	NextAttemptAt *time.Time         `bson:"next_attempt_at,omitempty"`
+	ErrorMsg      string             `bson:"error_msg,omitempty"`
+	Failure       string             `bson:"failure,omitempty"`
+
+	BandwidthLimit int64 `bson:"bandwidth_limit,omitempty"`
+
+	LeaseOwner     string     `bson:"lease_owner,omitempty"`
+	LeaseExpiresAt *time.Time `bson:"lease_expires_at,omitempty"`
+
+	Progress *ProgressModel `bson:"progress,omitempty"`
+}
+
+type ProgressModel struct {
+	BytesTransferred int64     `bson:"bytes_transferred"`
+	TotalBytes       int64     `bson:"total_bytes"`
+	BytesPerSecond   float64   `bson:"bytes_per_second"`
+	UpdatedAt        time.Time `bson:"updated_at"`
 }
 
 func (t *TransferModel) ToEntity() *entities.Transfer {
-	return &entities.Transfer{
-		ID:          t.ID,
-		FileID:      t.FileID,
-		DeviceID:    t.DeviceID,
-		Status:      entities.TransferStatus(t.Status),
-		Priority:    t.Priority,
-		Retries:     t.Retries,
-		MaxRetries:  t.MaxRetries,
-		CreatedAt:   t.CreatedAt,
-		UpdatedAt:   t.UpdatedAt,
-		StartedAt:   t.StartedAt,
-		CompletedAt: t.CompletedAt,
-		ErrorMsg:    t.ErrorMsg,
+	kind := entities.TransferKind(t.Kind)
+	if kind == "" {
+		kind = entities.TransferKindStore
+	}
+
+	transfer := &entities.Transfer{
+		ID:            t.ID,
+		UserID:        t.UserID,
+		FileID:        t.FileID,
+		DeviceID:      t.DeviceID,
+		Kind:          kind,
+		Status:        entities.TransferStatus(t.Status),
+		Priority:      t.Priority,
+		Retries:       t.Retries,
+		MaxRetries:    t.MaxRetries,
+		CreatedAt:     t.CreatedAt,
+		UpdatedAt:     t.UpdatedAt,
+		StartedAt:     t.StartedAt,
+		CompletedAt:   t.CompletedAt,
+		NextAttemptAt: t.NextAttemptAt,
+		ErrorMsg:      t.ErrorMsg,
+		Failure:       entities.TransferFailure(t.Failure),
+
+		BandwidthLimit: t.BandwidthLimit,
+
+		LeaseOwner:     t.LeaseOwner,
+		LeaseExpiresAt: t.LeaseExpiresAt,
+	}
+	if t.Progress != nil {
+		transfer.Progress = &entities.TransferProgress{
+			BytesTransferred: t.Progress.BytesTransferred,
+			TotalBytes:       t.Progress.TotalBytes,
+			BytesPerSecond:   t.Progress.BytesPerSecond,
+			UpdatedAt:        t.Progress.UpdatedAt,
+		}
 	}
+	return transfer
 }
 
 func FromEntity(transfer *entities.Transfer) *TransferModel {
-	return &TransferModel{
-		ID:          transfer.ID,
-		FileID:      transfer.FileID,
-		DeviceID:    transfer.DeviceID,
-		Status:      string(transfer.Status),
-		Priority:    transfer.Priority,
-		Retries:     transfer.Retries,
-		MaxRetries:  transfer.MaxRetries,
-		CreatedAt:   transfer.CreatedAt,
-		UpdatedAt:   transfer.UpdatedAt,
-		StartedAt:   transfer.StartedAt,
-		CompletedAt: transfer.CompletedAt,
-		ErrorMsg:    transfer.ErrorMsg,
+	transferModel := &TransferModel{
+		ID:            transfer.ID,
+		UserID:        transfer.UserID,
+		FileID:        transfer.FileID,
+		DeviceID:      transfer.DeviceID,
+		Kind:          string(transfer.Kind),
+		Status:        string(transfer.Status),
+		Priority:      transfer.Priority,
+		Retries:       transfer.Retries,
+		MaxRetries:    transfer.MaxRetries,
+		CreatedAt:     transfer.CreatedAt,
+		UpdatedAt:     transfer.UpdatedAt,
+		StartedAt:     transfer.StartedAt,
+		CompletedAt:   transfer.CompletedAt,
+		NextAttemptAt: transfer.NextAttemptAt,
+		ErrorMsg:      transfer.ErrorMsg,
+		Failure:       string(transfer.Failure),
+
+		BandwidthLimit: transfer.BandwidthLimit,
+
+		LeaseOwner:     transfer.LeaseOwner,
+		LeaseExpiresAt: transfer.LeaseExpiresAt,
+	}
+	if transfer.Progress != nil {
+		transferModel.Progress = FromProgressEntity(*transfer.Progress)
+	}
+	return transferModel
+}
+
+func FromProgressEntity(progress entities.TransferProgress) *ProgressModel {
+	return &ProgressModel{
+		BytesTransferred: progress.BytesTransferred,
+		TotalBytes:       progress.TotalBytes,
+		BytesPerSecond:   progress.BytesPerSecond,
+		UpdatedAt:        progress.UpdatedAt,
 	}
 }
@@ -13,6 +13,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// leaseFields are cleared whenever a transfer stops being in progress
+var leaseFields = bson.M{"lease_owner": "", "lease_expires_at": ""}
+
 type MongoTransferRepository struct {
 	collection *mongo.Collection
 }
@@ -35,10 +38,10 @@ func (r *MongoTransferRepository) Create(ctx context.Context, transfer *entities
 	return transfer, nil
 }
 
-func (r *MongoTransferRepository) GetByID(ctx context.Context, id primitive.ObjectID) (*entities.Transfer, error) {
+func (r *MongoTransferRepository) GetByID(ctx context.Context, userID, id primitive.ObjectID) (*entities.Transfer, error) {
 	var transferModel model.TransferModel
 
-	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&transferModel)
+	err := r.collection.FindOne(ctx, bson.M{"_id": id, "user_id": userID}).Decode(&transferModel)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -49,10 +52,14 @@ func (r *MongoTransferRepository) GetByID(ctx context.Context, id primitive.Obje
 	return transferModel.ToEntity(), nil
 }
 
-func (r *MongoTransferRepository) GetPendingByDeviceID(ctx context.Context, deviceID primitive.ObjectID) ([]*entities.Transfer, error) {
+func (r *MongoTransferRepository) GetPendingByDeviceID(
+	ctx context.Context, userID, deviceID primitive.ObjectID, now time.Time,
+) ([]*entities.Transfer, error) {
 	filter := bson.M{
+		"user_id":   userID,
 		"device_id": deviceID,
 		"status":    string(entities.TransferStatusPending),
+		"$or":       notWaiting(now),
 	}
 
 	opts := options.Find().SetSort(bson.D{
@@ -78,7 +85,9 @@ func (r *MongoTransferRepository) GetPendingByDeviceID(ctx context.Context, devi
 	return transfers, nil
 }
 
-func (r *MongoTransferRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status entities.TransferStatus) error {
+func (r *MongoTransferRepository) UpdateStatus(
+	ctx context.Context, userID, id primitive.ObjectID, status entities.TransferStatus,
+) error {
 	update := bson.M{
 		"$set": bson.M{
 			"status":     string(status),
@@ -91,11 +100,13 @@ func (r *MongoTransferRepository) UpdateStatus(ctx context.Context, id primitive
 		update["$set"].(bson.M)["started_at"] = &now
 	}
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id, "user_id": userID}, update)
 	return err
 }
 
-func (r *MongoTransferRepository) CompleteTransfer(ctx context.Context, id primitive.ObjectID, success bool, errorMsg string) error {
+func (r *MongoTransferRepository) CompleteTransfer(
+	ctx context.Context, userID, id primitive.ObjectID, owner string, success bool, errorMsg string,
+) (bool, error) {
 	status := entities.TransferStatusCompleted
 	if !success {
 		status = entities.TransferStatusFailed
@@ -109,19 +120,22 @@ func (r *MongoTransferRepository) CompleteTransfer(ctx context.Context, id primi
 			"completed_at": &now,
 			"error_msg":    errorMsg,
 		},
+		"$unset": leaseFields,
+	}
+	if !success {
+		update["$set"].(bson.M)["failure"] = string(entities.TransferFailureUnknown)
 	}
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
-	return err
+	return r.updateLeased(ctx, userID, id, owner, update)
 }
 
-func (r *MongoTransferRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
-	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+func (r *MongoTransferRepository) Delete(ctx context.Context, userID, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
 	return err
 }
 
-func (r *MongoTransferRepository) GetAll(ctx context.Context) ([]*entities.Transfer, error) {
-	cursor, err := r.collection.Find(ctx, bson.M{})
+func (r *MongoTransferRepository) GetAllByUser(ctx context.Context, userID primitive.ObjectID) ([]*entities.Transfer, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{"user_id": userID})
 	if err != nil {
 		return nil, err
 	}
@@ -139,12 +153,332 @@ func (r *MongoTransferRepository) GetAll(ctx context.Context) ([]*entities.Trans
 	return transfers, nil
 }
 
-func (r *MongoTransferRepository) IncrementRetries(ctx context.Context, id primitive.ObjectID) error {
+func (r *MongoTransferRepository) GetActiveDeletion(
+	ctx context.Context, userID, fileID, deviceID primitive.ObjectID,
+) (*entities.Transfer, error) {
+	filter := bson.M{
+		"user_id":   userID,
+		"file_id":   fileID,
+		"device_id": deviceID,
+		"kind":      string(entities.TransferKindDelete),
+		"status": bson.M{"$in": bson.A{
+			string(entities.TransferStatusPending), string(entities.TransferStatusInProgress),
+		}},
+	}
+
+	var transferModel model.TransferModel
+	err := r.collection.FindOne(ctx, filter).Decode(&transferModel)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return transferModel.ToEntity(), nil
+}
+
+func (r *MongoTransferRepository) GetAllByUserAndStatus(
+	ctx context.Context, userID primitive.ObjectID, status entities.TransferStatus,
+) ([]*entities.Transfer, error) {
+	return r.find(ctx, bson.M{"user_id": userID, "status": string(status)})
+}
+
+func (r *MongoTransferRepository) GetDue(ctx context.Context, now time.Time) ([]*entities.Transfer, error) {
+	filter := bson.M{
+		"status": string(entities.TransferStatusPending),
+		"$or":    notWaiting(now),
+	}
+
+	opts := options.Find().SetSort(bson.D{
+		{Key: "priority", Value: -1},  // Higher priority first
+		{Key: "created_at", Value: 1}, // Older first within same priority
+	})
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var transfers []*entities.Transfer
+	for cursor.Next(ctx) {
+		var transferModel model.TransferModel
+		if err := cursor.Decode(&transferModel); err != nil {
+			continue
+		}
+		transfers = append(transfers, transferModel.ToEntity())
+	}
+
+	return transfers, cursor.Err()
+}
+
+// notWaiting matches transfers that are not waiting out a backoff at now
+func notWaiting(now time.Time) bson.A {
+	return bson.A{
+		bson.M{"next_attempt_at": bson.M{"$exists": false}},
+		bson.M{"next_attempt_at": bson.M{"$lte": now}},
+	}
+}
+
+func (r *MongoTransferRepository) RetryLater(
+	ctx context.Context,
+	userID, id primitive.ObjectID,
+	owner string,
+	at time.Time,
+	failure entities.TransferFailure,
+	errorMsg string,
+) (bool, error) {
 	update := bson.M{
 		"$inc": bson.M{"retries": 1},
-		"$set": bson.M{"updated_at": time.Now()},
+		"$set": bson.M{
+			"status":          string(entities.TransferStatusPending),
+			"next_attempt_at": at,
+			"error_msg":       errorMsg,
+			"failure":         string(failure),
+			"updated_at":      time.Now(),
+		},
+		"$unset": leaseFields,
+	}
+
+	return r.updateLeased(ctx, userID, id, owner, update)
+}
+
+func (r *MongoTransferRepository) Fail(
+	ctx context.Context, userID, id primitive.ObjectID, owner string, failure entities.TransferFailure, errorMsg string,
+) (bool, error) {
+	now := time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"status":       string(entities.TransferStatusFailed),
+			"updated_at":   now,
+			"completed_at": &now,
+			"error_msg":    errorMsg,
+			"failure":      string(failure),
+		},
+		"$unset": bson.M{"lease_owner": "", "lease_expires_at": "", "next_attempt_at": ""},
+	}
+
+	return r.updateLeased(ctx, userID, id, owner, update)
+}
+
+func (r *MongoTransferRepository) Requeue(ctx context.Context, userID, id primitive.ObjectID) (*entities.Transfer, error) {
+	filter := bson.M{"_id": id, "user_id": userID, "status": string(entities.TransferStatusFailed)}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     string(entities.TransferStatusPending),
+			"retries":    0,
+			"updated_at": time.Now(),
+		},
+		"$unset": bson.M{
+			"completed_at":    "",
+			"next_attempt_at": "",
+			"error_msg":       "",
+			"failure":         "",
+			"progress":        "",
+		},
+	}
+
+	var transferModel model.TransferModel
+	err := r.collection.FindOneAndUpdate(
+		ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&transferModel)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return transferModel.ToEntity(), nil
+}
+
+func (r *MongoTransferRepository) Cancel(ctx context.Context, userID, id primitive.ObjectID) (*entities.Transfer, error) {
+	now := time.Now()
+	filter := bson.M{
+		"_id":     id,
+		"user_id": userID,
+		"status": bson.M{"$in": bson.A{
+			string(entities.TransferStatusPending), string(entities.TransferStatusInProgress),
+		}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":       string(entities.TransferStatusCanceled),
+			"updated_at":   now,
+			"completed_at": &now,
+		},
+		"$unset": bson.M{"lease_owner": "", "lease_expires_at": "", "next_attempt_at": ""},
+	}
+
+	var transferModel model.TransferModel
+	err := r.collection.FindOneAndUpdate(ctx, filter, update).Decode(&transferModel)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return transferModel.ToEntity(), nil
+}
+
+func (r *MongoTransferRepository) Discard(ctx context.Context, userID, id primitive.ObjectID) (bool, error) {
+	filter := bson.M{"_id": id, "user_id": userID, "status": string(entities.TransferStatusFailed)}
+
+	result, err := r.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return result.DeletedCount > 0, nil
+}
+
+func (r *MongoTransferRepository) Claim(
+	ctx context.Context, userID, id primitive.ObjectID, owner string, leaseUntil time.Time,
+) (*entities.Transfer, error) {
+	now := time.Now()
+	filter := bson.M{"_id": id, "user_id": userID, "status": string(entities.TransferStatusPending)}
+	update := bson.M{
+		"$set": bson.M{
+			"status":           string(entities.TransferStatusInProgress),
+			"started_at":       &now,
+			"updated_at":       now,
+			"lease_owner":      owner,
+			"lease_expires_at": leaseUntil,
+		},
+		"$unset": bson.M{"progress": ""}, // Left from an earlier attempt
+	}
+
+	var transferModel model.TransferModel
+	err := r.collection.FindOneAndUpdate(
+		ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&transferModel)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return transferModel.ToEntity(), nil
+}
+
+func (r *MongoTransferRepository) UpdateProgress(
+	ctx context.Context,
+	userID, id primitive.ObjectID,
+	owner string,
+	progress entities.TransferProgress,
+	leaseUntil time.Time,
+) (bool, error) {
+	filter := leasedBy(userID, id, owner)
+	update := bson.M{
+		"$set": bson.M{
+			"progress":         model.FromProgressEntity(progress),
+			"lease_expires_at": leaseUntil,
+			"updated_at":       time.Now(),
+		},
 	}
 
-	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, update)
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *MongoTransferRepository) ReleaseLease(ctx context.Context, userID, id primitive.ObjectID, owner string) error {
+	filter := leasedBy(userID, id, owner)
+	update := bson.M{
+		"$set": bson.M{
+			"status":     string(entities.TransferStatusPending),
+			"updated_at": time.Now(),
+		},
+		"$unset": leaseFields,
+	}
+
+	_, err := r.collection.UpdateOne(ctx, filter, update)
 	return err
 }
+
+// updateLeased applies update to the user's transfer while owner holds its lease, reporting whether it did
+func (r *MongoTransferRepository) updateLeased(
+	ctx context.Context, userID, id primitive.ObjectID, owner string, update bson.M,
+) (bool, error) {
+	result, err := r.collection.UpdateOne(ctx, leasedBy(userID, id, owner), update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+// leasedBy matches the user's transfer while it is in progress and leased to owner
+func leasedBy(userID, id primitive.ObjectID, owner string) bson.M {
+	return bson.M{
+		"_id":         id,
+		"user_id":     userID,
+		"status":      string(entities.TransferStatusInProgress),
+		"lease_owner": owner,
+	}
+}
+
+func (r *MongoTransferRepository) ReleaseExpiredLeases(ctx context.Context, now time.Time) (int64, error) {
+	filter := bson.M{
+		"status":           string(entities.TransferStatusInProgress),
+		"lease_expires_at": bson.M{"$lt": now},
+	}
+	update := bson.M{
+		"$inc": bson.M{"retries": 1},
+		"$set": bson.M{
+			"status":     string(entities.TransferStatusPending),
+			"error_msg":  "lease expired before the transfer finished",
+			"failure":    string(entities.TransferFailureTimeout),
+			"updated_at": now,
+		},
+		"$unset": leaseFields,
+	}
+
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
+
+// GetQueuedSince returns every user's pending transfers, including those waiting out a backoff,
+// last updated at or after since, or all of them for a zero since, for background jobs. A queue
+// kept outside Mongo is rebuilt from them.
+func (r *MongoTransferRepository) GetQueuedSince(ctx context.Context, since time.Time) ([]*entities.Transfer, error) {
+	filter := bson.M{"status": string(entities.TransferStatusPending)}
+	if !since.IsZero() {
+		filter["updated_at"] = bson.M{"$gte": since}
+	}
+	return r.find(ctx, filter)
+}
+
+// GetByIDs returns the transfers with the given IDs that still exist, in no particular order and
+// whoever they belong to, for background jobs
+func (r *MongoTransferRepository) GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*entities.Transfer, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	return r.find(ctx, bson.M{"_id": bson.M{"$in": ids}})
+}
+
+func (r *MongoTransferRepository) find(ctx context.Context, filter bson.M) ([]*entities.Transfer, error) {
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var transfers []*entities.Transfer
+	for cursor.Next(ctx) {
+		var transferModel model.TransferModel
+		if decodeErr := cursor.Decode(&transferModel); decodeErr != nil {
+			continue
+		}
+		transfers = append(transfers, transferModel.ToEntity())
+	}
+
+	return transfers, cursor.Err()
+}
@@ -0,0 +1,459 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"github.com/go-redis/redis/v8"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	readyKey         = "nebulo:transfers:ready"   // Sorted set of due transfers, by rank
+	delayedKey       = "nebulo:transfers:delayed" // Sorted set of transfers waiting out a backoff, by next attempt
+	takenKey         = "nebulo:transfers:taken"   // Sorted set of transfers handed to a dispatcher, by when they go back
+	syncedKey        = "nebulo:transfers:synced"  // Set once the queue was rebuilt in full; gone if Redis lost the queue
+	eventsKey        = "nebulo:transfers:events"  // Stream announcing that transfers became ready
+	dispatchersGroup = "dispatchers"
+
+	// priorityWeight sets priorities further apart than any two creation times in seconds, so a
+	// higher priority always ranks first
+	priorityWeight = 1e10
+	maxEvents      = 1000 // Announcements kept in the stream
+	eventBatch     = 16
+	waitBlock      = 5 * time.Second  // How long a read waits for an announcement before checking on its context
+	resyncInterval = 5 * time.Minute  // How often the queue is caught up with the history
+	resyncOverlap  = time.Minute      // How far a catch-up looks back past the last one, for clocks that differ between servers
+	dueBatch       = 64               // Due transfers handed to a dispatcher at a time
+	takenHold      = 30 * time.Second // How long transfers handed to a dispatcher are kept from the others
+)
+
+// takeDue pops the best ranked ready transfers, up to ARGV[1], and keeps them in the taken set
+// until ARGV[2], in one step so no two dispatchers are handed the same transfer
+var takeDue = redis.NewScript(`
+local popped = redis.call('ZPOPMIN', KEYS[1], ARGV[1])
+local members = {}
+for i = 1, #popped, 2 do
+	redis.call('ZADD', KEYS[2], ARGV[2], popped[i])
+	members[#members + 1] = popped[i]
+end
+return members
+`)
+
+// TransferHistory is the durable store every transfer is kept in, behind the queue
+type TransferHistory interface {
+	repository.TransferRepository
+	// GetQueuedSince returns every user's pending transfers, including those waiting out a backoff,
+	// that were last updated at or after since; all of them for a zero since
+	GetQueuedSince(ctx context.Context, since time.Time) ([]*entities.Transfer, error)
+	// GetByIDs returns the transfers with the given IDs that still exist, whoever they belong to
+	GetByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*entities.Transfer, error)
+}
+
+// RedisTransferRepository keeps the queue of pending transfers in Redis, in front of their history
+// in Mongo. Due transfers wait in a sorted set ranked by priority and age, and those waiting out a
+// backoff in one scored by their next attempt. A dispatcher polling for work pops a batch of the
+// best ranked due transfers, which are kept from the other dispatchers for takenHold and go back
+// to the queue if it does not claim them by then. Claiming a transfer takes it out of the queue in
+// one transaction, so a single worker across all servers gets it. Transfers that become ready are
+// announced on a stream read by a consumer group of dispatchers, which wakes one of them up
+// instead of leaving the work to the next poll.
+//
+// Every write goes to the history first, and single transfers and lease renewals are read from
+// it. The queue is rebuilt in full from the history at start and whenever Redis lost it, and
+// caught up with the transfers updated since every resyncInterval and whenever expired leases put
+// transfers back, which also picks up writes that reached Mongo but not Redis.
+type RedisTransferRepository struct {
+	TransferHistory
+	client *redis.Client
+
+	mu       sync.Mutex
+	syncedAt time.Time
+	hasGroup bool
+}
+
+func NewRedisTransferRepository(history TransferHistory, client *redis.Client) *RedisTransferRepository {
+	return &RedisTransferRepository{
+		TransferHistory: history,
+		client:          client,
+	}
+}
+
+func (r *RedisTransferRepository) Create(ctx context.Context, transfer *entities.Transfer) (*entities.Transfer, error) {
+	transfer, err := r.TransferHistory.Create(ctx, transfer)
+	if err != nil {
+		return nil, err
+	}
+
+	// A transfer missing from the queue is added by the next rebuild
+	_ = r.push(ctx, time.Now(), transfer)
+	return transfer, nil
+}
+
+func (r *RedisTransferRepository) UpdateStatus(
+	ctx context.Context, userID, id primitive.ObjectID, status entities.TransferStatus,
+) error {
+	if err := r.TransferHistory.UpdateStatus(ctx, userID, id, status); err != nil {
+		return err
+	}
+	return r.requeue(ctx, id)
+}
+
+func (r *RedisTransferRepository) CompleteTransfer(
+	ctx context.Context, userID, id primitive.ObjectID, owner string, success bool, errorMsg string,
+) (bool, error) {
+	held, err := r.TransferHistory.CompleteTransfer(ctx, userID, id, owner, success, errorMsg)
+	if err != nil || !held {
+		return held, err
+	}
+	return true, r.dequeue(ctx, id.Hex())
+}
+
+func (r *RedisTransferRepository) Delete(ctx context.Context, userID, id primitive.ObjectID) error {
+	if err := r.TransferHistory.Delete(ctx, userID, id); err != nil {
+		return err
+	}
+	return r.dequeue(ctx, id.Hex())
+}
+
+// GetDue hands the dispatcher up to dueBatch of the best ranked due transfers, which no other
+// dispatcher gets for takenHold
+func (r *RedisTransferRepository) GetDue(ctx context.Context, now time.Time) ([]*entities.Transfer, error) {
+	if err := r.sync(ctx, now, false); err != nil {
+		return nil, err
+	}
+
+	// Transfers handed out but left unclaimed, and those whose backoff is over, go to the ready set
+	for _, key := range []string{takenKey, delayedKey} {
+		if err := r.readyUp(ctx, key, now); err != nil {
+			return nil, err
+		}
+	}
+
+	members, err := takeDue.Run(
+		ctx, r.client, []string{readyKey, takenKey}, dueBatch, now.Add(takenHold).UnixMilli(),
+	).StringSlice()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	return r.load(ctx, members)
+}
+
+// ReturnDue puts transfers GetDue handed out back in the queue for any dispatcher to take
+func (r *RedisTransferRepository) ReturnDue(ctx context.Context, transfers []*entities.Transfer) error {
+	return r.push(ctx, time.Now(), transfers...)
+}
+
+// readyUp moves up to dueBatch transfers scored up to now in key to the ready set
+func (r *RedisTransferRepository) readyUp(ctx context.Context, key string, now time.Time) error {
+	members, err := r.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: dueBatch,
+	}).Result()
+	if err != nil || len(members) == 0 {
+		return err
+	}
+
+	transfers, err := r.load(ctx, members)
+	if err != nil {
+		return err
+	}
+	return r.push(ctx, now, transfers...)
+}
+
+func (r *RedisTransferRepository) RetryLater(
+	ctx context.Context,
+	userID, id primitive.ObjectID,
+	owner string,
+	at time.Time,
+	failure entities.TransferFailure,
+	errorMsg string,
+) (bool, error) {
+	held, err := r.TransferHistory.RetryLater(ctx, userID, id, owner, at, failure, errorMsg)
+	if err != nil || !held {
+		return held, err
+	}
+	return true, r.requeue(ctx, id)
+}
+
+func (r *RedisTransferRepository) Fail(
+	ctx context.Context, userID, id primitive.ObjectID, owner string, failure entities.TransferFailure, errorMsg string,
+) (bool, error) {
+	held, err := r.TransferHistory.Fail(ctx, userID, id, owner, failure, errorMsg)
+	if err != nil || !held {
+		return held, err
+	}
+	return true, r.dequeue(ctx, id.Hex())
+}
+
+func (r *RedisTransferRepository) Requeue(ctx context.Context, userID, id primitive.ObjectID) (*entities.Transfer, error) {
+	transfer, err := r.TransferHistory.Requeue(ctx, userID, id)
+	if err != nil || transfer == nil {
+		return transfer, err
+	}
+
+	// As with created transfers, one missing from the queue is added by the next rebuild
+	_ = r.push(ctx, time.Now(), transfer)
+	return transfer, nil
+}
+
+func (r *RedisTransferRepository) Cancel(ctx context.Context, userID, id primitive.ObjectID) (*entities.Transfer, error) {
+	transfer, err := r.TransferHistory.Cancel(ctx, userID, id)
+	if err != nil || transfer == nil {
+		return transfer, err
+	}
+	return transfer, r.dequeue(ctx, id.Hex())
+}
+
+func (r *RedisTransferRepository) Claim(
+	ctx context.Context, userID, id primitive.ObjectID, owner string, leaseUntil time.Time,
+) (*entities.Transfer, error) {
+	if err := r.sync(ctx, time.Now(), false); err != nil {
+		return nil, err
+	}
+
+	member := id.Hex()
+	var fromReady, fromDelayed, fromTaken *redis.IntCmd
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		fromReady = pipe.ZRem(ctx, readyKey, member)
+		fromDelayed = pipe.ZRem(ctx, delayedKey, member)
+		fromTaken = pipe.ZRem(ctx, takenKey, member)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fromReady.Val()+fromDelayed.Val()+fromTaken.Val() == 0 {
+		return nil, nil // Another worker took it out of the queue first
+	}
+
+	transfer, err := r.TransferHistory.Claim(ctx, userID, id, owner, leaseUntil)
+	if err != nil || transfer == nil {
+		// Put it back unless the claim went through after all
+		_ = r.requeue(ctx, id)
+		return nil, err
+	}
+	return transfer, nil
+}
+
+func (r *RedisTransferRepository) ReleaseLease(ctx context.Context, userID, id primitive.ObjectID, owner string) error {
+	if err := r.TransferHistory.ReleaseLease(ctx, userID, id, owner); err != nil {
+		return err
+	}
+	return r.requeue(ctx, id)
+}
+
+func (r *RedisTransferRepository) ReleaseExpiredLeases(ctx context.Context, now time.Time) (int64, error) {
+	released, err := r.TransferHistory.ReleaseExpiredLeases(ctx, now)
+	if err != nil || released == 0 {
+		return released, err
+	}
+	return released, r.sync(ctx, now, true)
+}
+
+// WaitForTransfers reads announcements as one of the dispatchers' consumer group
+func (r *RedisTransferRepository) WaitForTransfers(ctx context.Context, consumer string) error {
+	if err := r.joinGroup(ctx); err != nil {
+		return err
+	}
+
+	var streams []redis.XStream
+	var err error
+	for {
+		streams, err = r.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    dispatchersGroup,
+			Consumer: consumer,
+			Streams:  []string{eventsKey, ">"},
+			Count:    eventBatch,
+			Block:    waitBlock,
+		}).Result()
+		if !errors.Is(err, redis.Nil) {
+			break
+		}
+		if ctx.Err() != nil {
+			return ctx.Err() // Nothing was announced before ctx was done
+		}
+	}
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			// The stream was removed, say by a restart of Redis without persistence
+			r.mu.Lock()
+			r.hasGroup = false
+			r.mu.Unlock()
+		}
+		return err
+	}
+
+	// The announcement has done its job once read; the queue itself holds the work
+	var ids []string
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			ids = append(ids, message.ID)
+		}
+	}
+	return r.client.XAck(ctx, eventsKey, dispatchersGroup, ids...).Err()
+}
+
+func (r *RedisTransferRepository) joinGroup(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.hasGroup {
+		return nil
+	}
+	err := r.client.XGroupCreateMkStream(ctx, eventsKey, dispatchersGroup, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	r.hasGroup = true
+	return nil
+}
+
+// sync catches the queue up with the history when forced or when it last did more than
+// resyncInterval ago. The first time, and whenever Redis lost the queue, it is rebuilt in full.
+func (r *RedisTransferRepository) sync(ctx context.Context, now time.Time, force bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !force && now.Sub(r.syncedAt) < resyncInterval {
+		return nil
+	}
+
+	var since time.Time
+	if !r.syncedAt.IsZero() {
+		kept, err := r.client.Exists(ctx, syncedKey).Result()
+		if err != nil {
+			return err
+		}
+		if kept > 0 {
+			since = r.syncedAt.Add(-resyncOverlap)
+		}
+	}
+
+	transfers, err := r.TransferHistory.GetQueuedSince(ctx, since)
+	if err != nil {
+		return err
+	}
+	if err = r.push(ctx, now, transfers...); err != nil {
+		return err
+	}
+	if since.IsZero() {
+		if err = r.client.Set(ctx, syncedKey, now.UnixMilli(), 0).Err(); err != nil {
+			return err
+		}
+	}
+	r.syncedAt = now
+	return nil
+}
+
+// push adds pending transfers to the set that fits them at now, announcing them if any is ready
+func (r *RedisTransferRepository) push(ctx context.Context, now time.Time, transfers ...*entities.Transfer) error {
+	if len(transfers) == 0 {
+		return nil
+	}
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		ready := false
+		for _, transfer := range transfers {
+			member := transfer.ID.Hex()
+			pipe.ZRem(ctx, takenKey, member)
+			if transfer.NextAttemptAt != nil && transfer.NextAttemptAt.After(now) {
+				pipe.ZRem(ctx, readyKey, member)
+				pipe.ZAdd(ctx, delayedKey, &redis.Z{Score: float64(transfer.NextAttemptAt.UnixMilli()), Member: member})
+				continue
+			}
+
+			pipe.ZRem(ctx, delayedKey, member)
+			pipe.ZAdd(ctx, readyKey, &redis.Z{Score: rank(transfer), Member: member})
+			ready = true
+		}
+
+		if ready {
+			pipe.XAdd(ctx, &redis.XAddArgs{
+				Stream: eventsKey,
+				MaxLen: maxEvents,
+				Approx: true,
+				Values: map[string]interface{}{"at": now.UnixMilli()},
+			})
+		}
+		return nil
+	})
+	return err
+}
+
+// requeue brings a transfer's place in the queue in line with the history
+func (r *RedisTransferRepository) requeue(ctx context.Context, id primitive.ObjectID) error {
+	transfers, err := r.TransferHistory.GetByIDs(ctx, []primitive.ObjectID{id})
+	if err != nil {
+		return err
+	}
+	if len(transfers) == 0 || transfers[0].Status != entities.TransferStatusPending {
+		return r.dequeue(ctx, id.Hex())
+	}
+	return r.push(ctx, time.Now(), transfers[0])
+}
+
+func (r *RedisTransferRepository) dequeue(ctx context.Context, members ...string) error {
+	if len(members) == 0 {
+		return nil
+	}
+
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, member := range members {
+			pipe.ZRem(ctx, readyKey, member)
+			pipe.ZRem(ctx, delayedKey, member)
+			pipe.ZRem(ctx, takenKey, member)
+		}
+		return nil
+	})
+	return err
+}
+
+// load reads the queued transfers from the history, in the queue's order. Transfers that are gone
+// or no longer pending are dropped from the queue.
+func (r *RedisTransferRepository) load(ctx context.Context, members []string) ([]*entities.Transfer, error) {
+	ids := make([]primitive.ObjectID, 0, len(members))
+	var stale []string
+	for _, member := range members {
+		id, err := primitive.ObjectIDFromHex(member)
+		if err != nil {
+			stale = append(stale, member)
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	found, err := r.TransferHistory.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[primitive.ObjectID]*entities.Transfer, len(found))
+	for _, transfer := range found {
+		byID[transfer.ID] = transfer
+	}
+
+	transfers := make([]*entities.Transfer, 0, len(ids))
+	for _, id := range ids {
+		transfer, ok := byID[id]
+		if !ok || transfer.Status != entities.TransferStatusPending {
+			stale = append(stale, id.Hex())
+			continue
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	return transfers, r.dequeue(ctx, stale...)
+}
+
+// rank orders due transfers highest priority first, then oldest first
+func rank(transfer *entities.Transfer) float64 {
+	return float64(transfer.CreatedAt.Unix()) - float64(transfer.Priority)*priorityWeight
+}
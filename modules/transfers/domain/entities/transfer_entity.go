@@ -6,21 +6,89 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Transfer is a copy of a file that is waiting to be written to a device, usually because the
+// device was offline when the file was uploaded, or a deletion of the file waiting to be carried
+// out on a device
 type Transfer struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty"`
-	FileID      primitive.ObjectID `bson:"file_id"`
-	DeviceID    primitive.ObjectID `bson:"device_id"`
-	Status      TransferStatus     `bson:"status"`
-	Priority    int                `bson:"priority"`
-	Retries     int                `bson:"retries"`
-	MaxRetries  int                `bson:"max_retries"`
-	CreatedAt   time.Time          `bson:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at"`
-	StartedAt   *time.Time         `bson:"started_at,omitempty"`
-	CompletedAt *time.Time         `bson:"completed_at,omitempty"`
-	ErrorMsg    string             `bson:"error_msg,omitempty"`
+	ID            primitive.ObjectID `bson:"_id,omitempty"`
+	UserID        primitive.ObjectID `bson:"user_id"` // Owner of the file and the device
+	FileID        primitive.ObjectID `bson:"file_id"`
+	DeviceID      primitive.ObjectID `bson:"device_id"`
+	Kind          TransferKind       `bson:"kind"`
+	Status        TransferStatus     `bson:"status"`
+	Priority      int                `bson:"priority"`
+	Retries       int                `bson:"retries"`
+	MaxRetries    int                `bson:"max_retries"`
+	CreatedAt     time.Time          `bson:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at"`
+	StartedAt     *time.Time         `bson:"started_at,omitempty"`
+	CompletedAt   *time.Time         `bson:"completed_at,omitempty"`
+	NextAttemptAt *time.Time         `bson:"next_attempt_at,omitempty"` // Set while a failed transfer waits out its backoff
+	ErrorMsg      string             `bson:"error_msg,omitempty"`
+	Failure       TransferFailure    `bson:"failure,omitempty"` // What went wrong with the last failed attempt
+
+	// BandwidthLimit caps how many bytes per second the copy is written at; zero leaves it uncapped
+	BandwidthLimit int64 `bson:"bandwidth_limit,omitempty"`
+
+	// An in-progress transfer is leased to whoever claimed it. A lease that runs out, because its
+	// holder crashed or hung, puts the transfer back in the queue.
+	LeaseOwner     string     `bson:"lease_owner,omitempty"`
+	LeaseExpiresAt *time.Time `bson:"lease_expires_at,omitempty"`
+
+	Progress *TransferProgress `bson:"progress,omitempty"` // How far the current or last attempt got
+}
+
+// Finished reports whether the transfer is over, one way or another
+func (t *Transfer) Finished() bool {
+	switch t.Status {
+	case TransferStatusCompleted, TransferStatusFailed, TransferStatusCanceled:
+		return true
+	default:
+		return false
+	}
+}
+
+// TransferProgress is how far an attempt at a transfer has got, as last reported by whoever
+// writes it
+type TransferProgress struct {
+	BytesTransferred int64     `bson:"bytes_transferred"`
+	TotalBytes       int64     `bson:"total_bytes"`
+	BytesPerSecond   float64   `bson:"bytes_per_second"` // Average since the attempt started
+	UpdatedAt        time.Time `bson:"updated_at"`
+}
+
+// NewTransferProgress works out the throughput of an attempt that started at since and has
+// written transferred of total bytes by now
+func NewTransferProgress(transferred, total int64, since, now time.Time) TransferProgress {
+	progress := TransferProgress{
+		BytesTransferred: transferred,
+		TotalBytes:       total,
+		UpdatedAt:        now,
+	}
+	if elapsed := now.Sub(since).Seconds(); elapsed > 0 {
+		progress.BytesPerSecond = float64(transferred) / elapsed
+	}
+	return progress
+}
+
+// ETA is how long the rest of the transfer should take at its throughput so far. It reports false
+// while there is no throughput or size to tell from.
+func (p *TransferProgress) ETA() (time.Duration, bool) {
+	if p.BytesPerSecond <= 0 || p.TotalBytes <= 0 {
+		return 0, false
+	}
+	remaining := max(p.TotalBytes-p.BytesTransferred, 0)
+	return time.Duration(float64(remaining) / p.BytesPerSecond * float64(time.Second)), true
 }
 
+// TransferKind is what a transfer does on its device
+type TransferKind string
+
+const (
+	TransferKindStore  TransferKind = "store"  // Writes a copy of the file
+	TransferKindDelete TransferKind = "delete" // Removes the file's objects
+)
+
 type TransferStatus string
 
 const (
@@ -31,6 +99,25 @@ const (
 	TransferStatusCanceled   TransferStatus = "canceled"
 )
 
+// TransferFailure classifies why an attempt at a transfer failed
+type TransferFailure string
+
+const (
+	TransferFailureDeviceOffline    TransferFailure = "device_offline" // The device could not be reached
+	TransferFailureDeviceGone       TransferFailure = "device_gone"    // The device was removed
+	TransferFailureChecksumMismatch TransferFailure = "checksum_mismatch"
+	TransferFailureDiskFull         TransferFailure = "disk_full"
+	TransferFailureTimeout          TransferFailure = "timeout"
+	TransferFailureSourceMissing    TransferFailure = "source_missing" // The file, or every copy of it, is gone
+	TransferFailureUnknown          TransferFailure = "unknown"
+)
+
+// BulkTransferResult is what a bulk operation did with the transfers it was given
+type BulkTransferResult struct {
+	Done    []string          // IDs of the transfers it went through for
+	Skipped map[string]string // Why each transfer left alone was skipped, by ID
+}
+
 type CompleteTransferRequest struct {
 	TransferID string `json:"transfer_id" validate:"required"`
 	Success    bool   `json:"success"`
@@ -38,9 +125,10 @@ type CompleteTransferRequest struct {
 }
 
 type PendingTransfersResponse struct {
-	ID       string `json:"id"`
-	FileID   string `json:"file_id"`
-	DeviceID string `json:"device_id"`
-	Priority int    `json:"priority"`
-	Retries  int    `json:"retries"`
+	ID       string            `json:"id"`
+	FileID   string            `json:"file_id"`
+	DeviceID string            `json:"device_id"`
+	Priority int               `json:"priority"`
+	Retries  int               `json:"retries"`
+	Progress *TransferProgress `json:"progress,omitempty"`
 }
@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TransferEnqueuer queues a copy of a file for a device, for the transfer dispatcher to write
+type TransferEnqueuer interface {
+	Execute(ctx context.Context, userID, fileID, deviceID primitive.ObjectID) error
+}
+
+// BackgroundTransferEnqueuer queues a copy of a file for a device behind the copies uploads are
+// waiting on, to be written at no more than bandwidthLimit bytes per second
+type BackgroundTransferEnqueuer interface {
+	ExecuteBackground(ctx context.Context, userID, fileID, deviceID primitive.ObjectID, bandwidthLimit int64) error
+}
+
+// DeletionEnqueuer queues the deletion of a file from a device that cannot delete it now, for the
+// transfer dispatcher to carry out once the device is back
+type DeletionEnqueuer interface {
+	Execute(ctx context.Context, userID, fileID, deviceID primitive.ObjectID) error
+}
@@ -2,19 +2,89 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// TransferRepository stores transfers. Every method is scoped to the user owning the transfer,
+// except those for background jobs, which work across users.
 type TransferRepository interface {
 	Create(ctx context.Context, transfer *entities.Transfer) (*entities.Transfer, error)
-	GetByID(ctx context.Context, id primitive.ObjectID) (*entities.Transfer, error)
-	GetPendingByDeviceID(ctx context.Context, deviceID primitive.ObjectID) ([]*entities.Transfer, error)
-	UpdateStatus(ctx context.Context, id primitive.ObjectID, status entities.TransferStatus) error
-	CompleteTransfer(ctx context.Context, id primitive.ObjectID, success bool, errorMsg string) error
-	Delete(ctx context.Context, id primitive.ObjectID) error
-	GetAll(ctx context.Context) ([]*entities.Transfer, error)
-	IncrementRetries(ctx context.Context, id primitive.ObjectID) error
+	GetByID(ctx context.Context, userID, id primitive.ObjectID) (*entities.Transfer, error)
+	GetAllByUser(ctx context.Context, userID primitive.ObjectID) ([]*entities.Transfer, error)
+	GetAllByUserAndStatus(ctx context.Context, userID primitive.ObjectID, status entities.TransferStatus) ([]*entities.Transfer, error)
+	// GetPendingByDeviceID returns the device's pending transfers that are not waiting out a backoff
+	// at now, highest priority and oldest first
+	GetPendingByDeviceID(ctx context.Context, userID, deviceID primitive.ObjectID, now time.Time) ([]*entities.Transfer, error)
+	// GetActiveDeletion returns the pending or in-progress deletion of the file from the device, or
+	// nil if there is none
+	GetActiveDeletion(ctx context.Context, userID, fileID, deviceID primitive.ObjectID) (*entities.Transfer, error)
+	UpdateStatus(ctx context.Context, userID, id primitive.ObjectID, status entities.TransferStatus) error
+	// CompleteTransfer finishes owner's in-progress transfer, successfully or not. It reports false,
+	// changing nothing, once owner no longer holds the lease.
+	CompleteTransfer(ctx context.Context, userID, id primitive.ObjectID, owner string, success bool, errorMsg string) (bool, error)
+	Delete(ctx context.Context, userID, id primitive.ObjectID) error
+	// RetryLater puts owner's failed transfer back to pending, counting the retry, and holds it until
+	// at, all in one update. It reports false once owner no longer holds the lease.
+	RetryLater(
+		ctx context.Context,
+		userID, id primitive.ObjectID,
+		owner string,
+		at time.Time,
+		failure entities.TransferFailure,
+		errorMsg string,
+	) (bool, error)
+	// Fail gives up on owner's transfer, recording what went wrong. It reports false once owner no
+	// longer holds the lease.
+	Fail(
+		ctx context.Context, userID, id primitive.ObjectID, owner string, failure entities.TransferFailure, errorMsg string,
+	) (bool, error)
+	// Requeue atomically moves a failed transfer back to pending with its retries reset and returns
+	// it. It returns nil if the transfer has not failed.
+	Requeue(ctx context.Context, userID, id primitive.ObjectID) (*entities.Transfer, error)
+	// Cancel atomically moves a pending or in-progress transfer to canceled, dropping any lease on
+	// it, and returns it as it was before. It returns nil if the transfer is already over.
+	Cancel(ctx context.Context, userID, id primitive.ObjectID) (*entities.Transfer, error)
+	// Discard deletes a failed transfer and reports false if the transfer has not failed
+	Discard(ctx context.Context, userID, id primitive.ObjectID) (bool, error)
+	// Claim atomically moves a pending transfer to in progress, leased to owner until leaseUntil. It
+	// returns nil if the transfer is no longer pending, for instance because another worker claimed it.
+	Claim(ctx context.Context, userID, id primitive.ObjectID, owner string, leaseUntil time.Time) (*entities.Transfer, error)
+	// UpdateProgress records how far owner's in-progress transfer has got and extends its lease until
+	// leaseUntil. It reports false once the lease is lost.
+	UpdateProgress(
+		ctx context.Context,
+		userID, id primitive.ObjectID,
+		owner string,
+		progress entities.TransferProgress,
+		leaseUntil time.Time,
+	) (bool, error)
+	// ReleaseLease returns owner's in-progress transfer to pending without counting a retry
+	ReleaseLease(ctx context.Context, userID, id primitive.ObjectID, owner string) error
+
+	// GetDue returns every user's pending transfers that are not waiting out a backoff at now,
+	// highest priority and oldest first, for the dispatcher. A queue shared by several dispatchers
+	// may hand each a batch of them instead, kept from the others for a while.
+	GetDue(ctx context.Context, now time.Time) ([]*entities.Transfer, error)
+	// ReleaseExpiredLeases returns every user's in-progress transfers whose lease ran out before now
+	// to pending, counting a retry for each, and returns how many it released
+	ReleaseExpiredLeases(ctx context.Context, now time.Time) (int64, error)
+}
+
+// TransferNotifier is implemented by queues that announce transfers as they become ready, so a
+// dispatcher can start them without waiting for its next poll
+type TransferNotifier interface {
+	// WaitForTransfers blocks until transfers were announced or ctx is done. Every announcement goes
+	// to one of the consumers waiting for it.
+	WaitForTransfers(ctx context.Context, consumer string) error
+}
+
+// DueTransferReturner is implemented by queues that keep the due transfers they hand a dispatcher
+// from the others for a while, so one can give back those it will not get to
+type DueTransferReturner interface {
+	// ReturnDue puts transfers GetDue handed out back in the queue
+	ReturnDue(ctx context.Context, transfers []*entities.Transfer) error
 }
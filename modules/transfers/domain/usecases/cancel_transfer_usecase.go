@@ -4,48 +4,72 @@ import (
 	"context"
 	"errors"
 
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
 	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
 	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 )
 
+// CancelTransferUseCase cancels a transfer that is not over yet. Whoever held its lease loses it
+// and stops writing the copy, whose replica is marked failed and whose reserved space is given back.
 type CancelTransferUseCase struct {
+	transferSettler
 	transferRepo repository.TransferRepository
 }
 
-func NewCancelTransferUseCase(transferRepo repository.TransferRepository) *CancelTransferUseCase {
+func NewCancelTransferUseCase(
+	transferRepo repository.TransferRepository,
+	fileRepo fileRepository.FileRepository,
+	deviceRepo deviceRepository.DeviceRepository,
+	staging fileRepository.ContentStagingRepository,
+	logger *zap.Logger,
+) *CancelTransferUseCase {
 	return &CancelTransferUseCase{
+		transferSettler: transferSettler{
+			fileRepo:   fileRepo,
+			deviceRepo: deviceRepo,
+			staging:    staging,
+			logger:     logger,
+		},
 		transferRepo: transferRepo,
 	}
 }
 
-func (uc *CancelTransferUseCase) Execute(ctx context.Context, transferID string) error {
+func (uc *CancelTransferUseCase) Execute(ctx context.Context, userID, transferID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
 	id, err := primitive.ObjectIDFromHex(transferID)
 	if err != nil {
 		return errors.New("invalid transfer ID")
 	}
 
-	// Check if transfer exists
-	transfer, err := uc.transferRepo.GetByID(ctx, id)
+	// Only pending or in-progress transfers are canceled, along with their lease
+	transfer, err := uc.transferRepo.Cancel(ctx, userObjectID, id)
 	if err != nil {
 		return err
 	}
 
 	if transfer == nil {
-		return errors.New("transfer not found")
-	}
-
-	// Only allow cancellation of pending or in-progress transfers
-	if transfer.Status != entities.TransferStatusPending && transfer.Status != entities.TransferStatusInProgress {
-		return errors.New("cannot cancel completed or failed transfer")
+		existing, getErr := uc.transferRepo.GetByID(ctx, userObjectID, id)
+		if getErr != nil {
+			return getErr
+		}
+		if existing == nil {
+			return ErrTransferNotFound
+		}
+		return ErrTransferFinished
 	}
 
-	// Cancel the transfer
-	err = uc.transferRepo.UpdateStatus(ctx, id, entities.TransferStatusCanceled)
-	if err != nil {
-		return err
+	// A canceled deletion leaves the file being deleted, with its replica on the device
+	if transfer.Kind == entities.TransferKindStore {
+		uc.settle(ctx, transfer, fileEntities.ReplicaStatusFailed, "transfer canceled")
 	}
-
 	return nil
 }
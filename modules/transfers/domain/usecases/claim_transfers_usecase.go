@@ -0,0 +1,70 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ClaimTransfersUseCase leases the transfers due for a device to the device, which writes them
+// itself, reports their progress and completes them before the lease runs out
+type ClaimTransfersUseCase struct {
+	transferRepo repository.TransferRepository
+	lease        time.Duration
+}
+
+func NewClaimTransfersUseCase(transferRepo repository.TransferRepository, lease time.Duration) *ClaimTransfersUseCase {
+	return &ClaimTransfersUseCase{
+		transferRepo: transferRepo,
+		lease:        lease,
+	}
+}
+
+// Execute claims the device's due transfers of kind, or of any kind when it is empty, leaving out
+// any the dispatcher or another claim got to first
+func (uc *ClaimTransfersUseCase) Execute(ctx context.Context, userID, deviceID, kind string) ([]*entities.Transfer, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if kind != "" && kind != string(entities.TransferKindStore) && kind != string(entities.TransferKindDelete) {
+		return nil, ErrInvalidTransferKind
+	}
+
+	id, err := primitive.ObjectIDFromHex(deviceID)
+	if err != nil {
+		return nil, errors.New("invalid device ID")
+	}
+
+	transfers, err := uc.transferRepo.GetPendingByDeviceID(ctx, userObjectID, id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	owner := deviceLeaseOwner(deviceID)
+	claimed := make([]*entities.Transfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		if kind != "" && transfer.Kind != entities.TransferKind(kind) {
+			continue
+		}
+
+		leased, claimErr := uc.transferRepo.Claim(ctx, userObjectID, transfer.ID, owner, time.Now().Add(uc.lease))
+		if claimErr != nil {
+			if len(claimed) > 0 {
+				break // Hand out what was claimed, so its leases do not run out unused
+			}
+			return nil, claimErr
+		}
+		if leased != nil {
+			claimed = append(claimed, leased)
+		}
+	}
+
+	return claimed, nil
+}
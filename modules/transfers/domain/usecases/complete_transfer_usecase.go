@@ -3,56 +3,150 @@ package usecases
 import (
 	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
 	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
 	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 )
 
+// CompleteTransferUseCase records the outcome of a transfer a device claimed to write itself. A
+// copy the device reports as written is only taken as stored once the device confirms it holds
+// the file with its checksum. A failed attempt goes back to the queue, counting a retry, until its
+// retries are used up. The replica, the file and the space reserved for the copy are settled as
+// they are for transfers the dispatcher writes.
 type CompleteTransferUseCase struct {
-	transferRepo repository.TransferRepository
+	transferSettler
+	transferRepo  repository.TransferRepository
+	deviceStorage fileRepository.DeviceStorageRepository
 }
 
-func NewCompleteTransferUseCase(transferRepo repository.TransferRepository) *CompleteTransferUseCase {
+func NewCompleteTransferUseCase(
+	transferRepo repository.TransferRepository,
+	fileRepo fileRepository.FileRepository,
+	deviceRepo deviceRepository.DeviceRepository,
+	deviceStorage fileRepository.DeviceStorageRepository,
+	staging fileRepository.ContentStagingRepository,
+	logger *zap.Logger,
+) *CompleteTransferUseCase {
 	return &CompleteTransferUseCase{
-		transferRepo: transferRepo,
+		transferSettler: transferSettler{
+			fileRepo:   fileRepo,
+			deviceRepo: deviceRepo,
+			staging:    staging,
+			logger:     logger,
+		},
+		transferRepo:  transferRepo,
+		deviceStorage: deviceStorage,
 	}
 }
 
-func (uc *CompleteTransferUseCase) Execute(ctx context.Context, req entities.CompleteTransferRequest) error {
+// Execute completes one of the user's transfers for the device named by deviceID, which must hold
+// the transfer's lease. Anything else, including a transfer the dispatcher is writing, returns
+// ErrTransferNotLeased.
+func (uc *CompleteTransferUseCase) Execute(
+	ctx context.Context, userID, deviceID string, req entities.CompleteTransferRequest,
+) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
 	transferID, err := primitive.ObjectIDFromHex(req.TransferID)
 	if err != nil {
 		return errors.New("invalid transfer ID")
 	}
 
 	// Check if transfer exists
-	transfer, err := uc.transferRepo.GetByID(ctx, transferID)
+	transfer, err := uc.transferRepo.GetByID(ctx, userObjectID, transferID)
 	if err != nil {
 		return err
 	}
 
-	if transfer == nil {
-		return errors.New("transfer not found")
+	if transfer == nil || transfer.DeviceID.Hex() != deviceID {
+		return ErrTransferNotFound
+	}
+
+	owner := deviceLeaseOwner(deviceID)
+	if transfer.Status != entities.TransferStatusInProgress || transfer.LeaseOwner != owner {
+		return ErrTransferNotLeased
+	}
+
+	if req.Success && transfer.Kind != entities.TransferKindDelete {
+		if confirmErr := uc.confirmCopy(ctx, transfer); confirmErr != nil {
+			req.Success = false
+			req.ErrorMsg = confirmErr.Error()
+		}
 	}
 
-	// Complete the transfer
-	err = uc.transferRepo.CompleteTransfer(ctx, transferID, req.Success, req.ErrorMsg)
+	var held bool
+	switch {
+	case req.Success:
+		held, err = uc.transferRepo.CompleteTransfer(ctx, userObjectID, transferID, owner, true, "")
+	case transfer.Retries < transfer.MaxRetries:
+		// Back in the queue right away, with the retry counted in the same update
+		held, err = uc.transferRepo.RetryLater(
+			ctx, userObjectID, transferID, owner, time.Now(), entities.TransferFailureUnknown, req.ErrorMsg,
+		)
+	default:
+		held, err = uc.transferRepo.Fail(ctx, userObjectID, transferID, owner, entities.TransferFailureUnknown, req.ErrorMsg)
+	}
 	if err != nil {
 		return err
 	}
+	if !held {
+		return ErrTransferNotLeased
+	}
 
-	// If failed and under max retries, increment retry count and reset to pending
-	if !req.Success && transfer.Retries < transfer.MaxRetries {
-		err = uc.transferRepo.IncrementRetries(ctx, transferID)
-		if err != nil {
-			return err
-		}
-		err = uc.transferRepo.UpdateStatus(ctx, transferID, entities.TransferStatusPending)
-		if err != nil {
-			return err
-		}
+	uc.settleOutcome(ctx, transfer, req)
+	return nil
+}
+
+// confirmCopy checks that the transfer's device holds the file it reports as written, with the
+// file's checksum
+func (uc *CompleteTransferUseCase) confirmCopy(ctx context.Context, transfer *entities.Transfer) error {
+	file, err := uc.fileRepo.GetByID(ctx, transfer.UserID, transfer.FileID)
+	if err != nil {
+		return err
+	}
+	if file == nil || file.Removed() {
+		return errFileGone
+	}
+
+	device, err := uc.deviceRepo.GetByID(ctx, transfer.UserID, transfer.DeviceID)
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return errors.New("device no longer exists")
 	}
 
+	if err = uc.deviceStorage.ConfirmFile(ctx, device, file.ID.Hex(), file.Checksum); err != nil {
+		return fmt.Errorf("device did not confirm file: %w", err)
+	}
 	return nil
 }
+
+// settleOutcome brings the file along with the recorded outcome of the transfer
+func (uc *CompleteTransferUseCase) settleOutcome(
+	ctx context.Context, transfer *entities.Transfer, req entities.CompleteTransferRequest,
+) {
+	switch {
+	case transfer.Kind == entities.TransferKindDelete:
+		if req.Success {
+			uc.forget(ctx, transfer)
+		}
+	case req.Success:
+		uc.settle(ctx, transfer, fileEntities.ReplicaStatusStored, "")
+	case transfer.Retries < transfer.MaxRetries:
+		uc.awaitRetry(ctx, transfer, "transfer failed on the device, retrying: "+req.ErrorMsg)
+	default:
+		uc.settle(ctx, transfer, fileEntities.ReplicaStatusFailed, "transfer failed on the device: "+req.ErrorMsg)
+	}
+}
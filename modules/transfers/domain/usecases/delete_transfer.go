@@ -0,0 +1,54 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+
+	"go.uber.org/zap"
+)
+
+// delete has the transfer's device delete what it holds of the file. Objects the device does not
+// hold, and files already gone, count as deleted.
+func (uc *DispatchTransfersUseCase) delete(ctx context.Context, transfer *entities.Transfer, device *deviceEntities.Device) error {
+	file, err := uc.fileRepo.GetByID(ctx, transfer.UserID, transfer.FileID)
+	if err != nil {
+		return err
+	}
+	if file == nil {
+		return nil
+	}
+
+	for _, objectID := range file.ObjectIDsOn(transfer.DeviceID) {
+		err = uc.deviceStorage.DeleteFile(ctx, device, objectID)
+		if err != nil && !errors.Is(err, fileRepository.ErrObjectNotFound) {
+			return fmt.Errorf("delete from device failed: %w", err)
+		}
+	}
+	return nil
+}
+
+// forget drops the file's replicas and shards on the device of a finished deletion, and the
+// file's record and any content still staged for it once no device holds any of it
+func (s *transferSettler) forget(ctx context.Context, transfer *entities.Transfer) {
+	file, err := s.fileRepo.RemovePlacements(ctx, transfer.UserID, transfer.FileID, transfer.DeviceID)
+	if err != nil {
+		s.logger.Warn("Failed to record deletion", zap.String("file_id", transfer.FileID.Hex()), zap.Error(err))
+		return
+	}
+	if file == nil || file.Status != fileEntities.FileStatusDeleting || len(file.PlacementDevices()) > 0 {
+		return
+	}
+
+	if err = s.fileRepo.Delete(ctx, file.UserID, file.ID); err != nil {
+		s.logger.Warn("Failed to delete file record", zap.String("file_id", file.ID.Hex()), zap.Error(err))
+	}
+	if err = s.staging.Remove(ctx, file.ID.Hex()); err != nil {
+		s.logger.Warn("Failed to remove staged content", zap.String("file_id", file.ID.Hex()), zap.Error(err))
+	}
+}
@@ -0,0 +1,53 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DiscardTransfersUseCase deletes failed transfers the user has given up on. The replicas they
+// were meant to create stay failed.
+type DiscardTransfersUseCase struct {
+	transferRepo repository.TransferRepository
+}
+
+func NewDiscardTransfersUseCase(transferRepo repository.TransferRepository) *DiscardTransfersUseCase {
+	return &DiscardTransfersUseCase{
+		transferRepo: transferRepo,
+	}
+}
+
+// Execute discards the user's failed transfers with the given IDs, skipping any other
+func (uc *DiscardTransfersUseCase) Execute(
+	ctx context.Context, userID string, transferIDs []string,
+) (*entities.BulkTransferResult, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	result := &entities.BulkTransferResult{Skipped: make(map[string]string)}
+	for _, transferID := range transferIDs {
+		id, parseErr := primitive.ObjectIDFromHex(transferID)
+		if parseErr != nil {
+			result.Skipped[transferID] = "invalid transfer ID"
+			continue
+		}
+
+		discarded, discardErr := uc.transferRepo.Discard(ctx, userObjectID, id)
+		if discardErr != nil {
+			return nil, discardErr
+		}
+		if !discarded {
+			result.Skipped[transferID] = "transfer not found or has not failed"
+			continue
+		}
+		result.Done = append(result.Done, transferID)
+	}
+	return result, nil
+}
@@ -0,0 +1,463 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+const (
+	// maxRetryBackoff caps the exponential backoff between attempts
+	maxRetryBackoff = time.Hour
+	// leaseRenewals is how many times a lease is renewed over its length, so that one slow renewal
+	// does not lose it
+	leaseRenewals = 3
+	// progressInterval is how often the progress of a copy is recorded, renewing its lease
+	progressInterval = 2 * time.Second
+	// notifierRetryDelay is how long to wait before listening for announced transfers again after
+	// failing to
+	notifierRetryDelay = 5 * time.Second
+)
+
+var (
+	errFileGone = errors.New("file no longer exists")
+	errNoSource = errors.New("no stored copy of the file is reachable to copy from")
+	errNoRoom   = errors.New("device no longer has room for the file")
+)
+
+// DispatchTransfersUseCase writes queued copies of files to their devices. Every round it starts
+// the due transfers whose device is online, as long as a worker is free, and copies each from the
+// content staged on the main server or from a device that already holds the file. A failed copy
+// is retried with exponential backoff; once its retries are used up, the transfer and the replica
+// it was meant to create are marked failed, with what went wrong. Transfers for offline devices
+// wait without using up retries, and those for removed, failed or draining devices are moved to
+// another device. Deletions queued for devices that were offline go through the same queue and retries.
+//
+// Transfers are claimed with a lease that is renewed while they are written, along with their
+// progress, so several servers can share the queue; a transfer whose lease runs out is put back by
+// ReapTransferLeasesUseCase.
+type DispatchTransfersUseCase struct {
+	transferSettler
+	transferRepo  repository.TransferRepository
+	deviceStorage fileRepository.DeviceStorageRepository
+	events        deviceRepository.DeviceEventBus
+	retryBackoff  time.Duration
+	lease         time.Duration
+	reservation   time.Duration // How long space reserved on a device a copy moves to is held
+	owner         string        // Identifies this dispatcher on the leases it holds
+	workers       chan struct{} // Holds a token for every transfer being written
+	inFlight      sync.WaitGroup
+}
+
+func NewDispatchTransfersUseCase(
+	transferRepo repository.TransferRepository,
+	fileRepo fileRepository.FileRepository,
+	deviceRepo deviceRepository.DeviceRepository,
+	deviceStorage fileRepository.DeviceStorageRepository,
+	staging fileRepository.ContentStagingRepository,
+	events deviceRepository.DeviceEventBus,
+	workers int,
+	retryBackoff time.Duration,
+	lease time.Duration,
+	reservation time.Duration,
+	logger *zap.Logger,
+) *DispatchTransfersUseCase {
+	return &DispatchTransfersUseCase{
+		transferSettler: transferSettler{
+			fileRepo:   fileRepo,
+			deviceRepo: deviceRepo,
+			staging:    staging,
+			logger:     logger,
+		},
+		transferRepo:  transferRepo,
+		deviceStorage: deviceStorage,
+		events:        events,
+		retryBackoff:  retryBackoff,
+		lease:         lease,
+		reservation:   reservation,
+		owner:         "dispatcher:" + uuid.NewString(),
+		workers:       make(chan struct{}, workers),
+	}
+}
+
+// Run dispatches due transfers every interval until ctx is cancelled, then waits for the
+// transfers being written to wind down. A queue that announces transfers as they become ready
+// starts a round right away.
+func (uc *DispatchTransfersUseCase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer uc.inFlight.Wait()
+
+	announced := make(chan struct{}, 1)
+	if notifier, ok := uc.transferRepo.(repository.TransferNotifier); ok {
+		go uc.listen(ctx, notifier, announced)
+	}
+
+	for {
+		if err := uc.Execute(ctx); err != nil && ctx.Err() == nil {
+			uc.logger.Warn("Transfer dispatch incomplete", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-announced:
+		}
+	}
+}
+
+// listen signals announced every time the queue announces transfers, until ctx is cancelled
+func (uc *DispatchTransfersUseCase) listen(ctx context.Context, notifier repository.TransferNotifier, announced chan<- struct{}) {
+	for ctx.Err() == nil {
+		if err := notifier.WaitForTransfers(ctx, uc.owner); err != nil {
+			if ctx.Err() == nil {
+				uc.logger.Warn("Failed to listen for queued transfers", zap.Error(err))
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(notifierRetryDelay):
+			}
+			continue
+		}
+
+		select {
+		case announced <- struct{}{}:
+		default: // A round is already due
+		}
+	}
+}
+
+// Execute starts the due transfers whose device is online on free workers, without waiting for them
+func (uc *DispatchTransfersUseCase) Execute(ctx context.Context) error {
+	transfers, err := uc.transferRepo.GetDue(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	devices := make(map[primitive.ObjectID]*deviceEntities.Device)
+	var firstErr error
+	for i, transfer := range transfers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		device, ok := devices[transfer.DeviceID]
+		if !ok {
+			device, err = uc.deviceRepo.GetByID(ctx, transfer.UserID, transfer.DeviceID)
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			devices[transfer.DeviceID] = device
+		}
+
+		draining := device != nil && device.Draining() && transfer.Kind == entities.TransferKindStore
+		if device == nil || device.Status == deviceEntities.DeviceStatusFailed || draining {
+			if transfer.Kind == entities.TransferKindDelete && device != nil {
+				continue // A failed device may come back, with the file still on it
+			}
+
+			// Claimed first, so only one server moves it
+			claimed, claimErr := uc.transferRepo.Claim(ctx, transfer.UserID, transfer.ID, uc.owner, time.Now().Add(uc.lease))
+			if claimErr != nil && firstErr == nil {
+				firstErr = claimErr
+			}
+			switch {
+			case claimed == nil:
+			case claimed.Kind == entities.TransferKindDelete:
+				uc.succeed(ctx, claimed) // The file went with the removed device
+			default:
+				uc.replan(ctx, claimed, device)
+			}
+			continue
+		}
+		if device.Status != deviceEntities.DeviceStatusOnline {
+			continue
+		}
+
+		select {
+		case uc.workers <- struct{}{}:
+		default:
+			// Every worker is busy; the rest waits for the next round, here or on another server
+			uc.returnDue(ctx, transfers[i:])
+			return firstErr
+		}
+
+		claimed, claimErr := uc.transferRepo.Claim(ctx, transfer.UserID, transfer.ID, uc.owner, time.Now().Add(uc.lease))
+		if claimErr != nil || claimed == nil {
+			<-uc.workers
+			if claimErr != nil && firstErr == nil {
+				firstErr = claimErr
+			}
+			continue // Nothing to claim means another server got to the transfer first
+		}
+		if claimed.Retries > claimed.MaxRetries {
+			// Its last attempt ran out of its lease without finishing
+			<-uc.workers
+			uc.fail(ctx, claimed, entities.TransferFailureTimeout, claimed.ErrorMsg)
+			continue
+		}
+
+		uc.inFlight.Add(1)
+		go func(transfer *entities.Transfer, device *deviceEntities.Device) {
+			defer uc.inFlight.Done()
+			defer func() { <-uc.workers }()
+			uc.dispatch(ctx, transfer, device)
+		}(claimed, device)
+	}
+
+	return firstErr
+}
+
+// returnDue gives back due transfers this round will not get to, to a queue that keeps them from
+// other dispatchers until then
+func (uc *DispatchTransfersUseCase) returnDue(ctx context.Context, transfers []*entities.Transfer) {
+	returner, ok := uc.transferRepo.(repository.DueTransferReturner)
+	if !ok {
+		return
+	}
+	if err := returner.ReturnDue(ctx, transfers); err != nil {
+		// They go back by themselves once held long enough
+		uc.logger.Warn("Failed to return due transfers to the queue", zap.Error(err))
+	}
+}
+
+// dispatch writes one transfer and records how it went
+func (uc *DispatchTransfersUseCase) dispatch(ctx context.Context, transfer *entities.Transfer, device *deviceEntities.Device) {
+	copyCtx, cancel := context.WithCancel(ctx)
+	meter := newTransferMeter(time.Now())
+	var leaseLost atomic.Bool
+	tracking := make(chan struct{})
+	go func() {
+		defer close(tracking)
+		uc.track(copyCtx, transfer, meter, &leaseLost, cancel)
+	}()
+
+	err := uc.perform(copyCtx, transfer, device, meter)
+	cancel()
+	<-tracking
+
+	// The outcome is recorded even when shutting down
+	recordCtx := context.WithoutCancel(ctx)
+	switch {
+	case leaseLost.Load():
+		// The transfer went back to the queue and may already be written by someone else
+		uc.logger.Warn("Lost the lease on a transfer being written", zap.String("transfer_id", transfer.ID.Hex()))
+	case err == nil:
+		uc.succeed(recordCtx, transfer)
+	case ctx.Err() != nil:
+		// Interrupted by shutdown, which is not the transfer's fault
+		if releaseErr := uc.transferRepo.ReleaseLease(recordCtx, transfer.UserID, transfer.ID, uc.owner); releaseErr != nil {
+			uc.logger.Warn(
+				"Failed to requeue interrupted transfer", zap.String("transfer_id", transfer.ID.Hex()), zap.Error(releaseErr),
+			)
+		}
+	case errors.Is(err, errFileGone) || transfer.Retries >= transfer.MaxRetries:
+		uc.fail(recordCtx, transfer, classifyFailure(err), err.Error())
+	default:
+		uc.retryLater(recordCtx, transfer, err)
+	}
+}
+
+// track records the progress of a copy and renews its lease until ctx is done. Once the lease is
+// lost, it flags lost and calls cancel to stop the copy.
+func (uc *DispatchTransfersUseCase) track(
+	ctx context.Context, transfer *entities.Transfer, meter *transferMeter, lost *atomic.Bool, cancel context.CancelFunc,
+) {
+	ticker := time.NewTicker(min(progressInterval, uc.lease/leaseRenewals))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		held, err := uc.transferRepo.UpdateProgress(
+			ctx, transfer.UserID, transfer.ID, uc.owner, meter.progress(now), now.Add(uc.lease),
+		)
+		if err != nil {
+			// Tried again on the next tick, while the lease still has time left
+			if ctx.Err() == nil {
+				uc.logger.Warn("Failed to renew transfer lease", zap.String("transfer_id", transfer.ID.Hex()), zap.Error(err))
+			}
+			continue
+		}
+		if !held {
+			lost.Store(true)
+			cancel()
+			return
+		}
+	}
+}
+
+// perform carries out the transfer on its device
+func (uc *DispatchTransfersUseCase) perform(
+	ctx context.Context, transfer *entities.Transfer, device *deviceEntities.Device, meter *transferMeter,
+) error {
+	if transfer.Kind == entities.TransferKindDelete {
+		return uc.delete(ctx, transfer, device)
+	}
+	return uc.copy(ctx, transfer, device, meter)
+}
+
+// copy writes the file to the transfer's device, counting the bytes on meter and keeping to the
+// transfer's bandwidth limit, and has the device confirm the checksum
+func (uc *DispatchTransfersUseCase) copy(
+	ctx context.Context, transfer *entities.Transfer, device *deviceEntities.Device, meter *transferMeter,
+) error {
+	file, err := uc.fileRepo.GetByID(ctx, transfer.UserID, transfer.FileID)
+	if err != nil {
+		return err
+	}
+	if file == nil || file.Removed() {
+		return errFileGone
+	}
+	if err = uc.holdReservation(ctx, transfer, file); err != nil {
+		return err
+	}
+
+	source, err := uc.openSource(ctx, file, transfer.DeviceID)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	meter.total.Store(file.Size)
+	objectID := file.ID.Hex()
+	content := throttle(ctx, meter.wrap(source), transfer.BandwidthLimit)
+	if err = uc.deviceStorage.StoreFile(ctx, device, objectID, content); err != nil {
+		return fmt.Errorf("upload to device failed: %w", err)
+	}
+	if err = uc.deviceStorage.ConfirmFile(ctx, device, objectID, file.Checksum); err != nil {
+		return fmt.Errorf("device did not confirm file: %w", err)
+	}
+	return nil
+}
+
+// holdReservation keeps the space for the copy reserved on its device while it is written. A copy
+// that waited in the queue, for instance for its device to come back, may have outlived its
+// reservation; it is then taken again, and the copy waits for another attempt if the device no
+// longer has room.
+func (uc *DispatchTransfersUseCase) holdReservation(
+	ctx context.Context, transfer *entities.Transfer, file *fileEntities.File,
+) error {
+	expiresAt := time.Now().Add(uc.reservation)
+	held, err := uc.deviceRepo.ExtendReservation(ctx, transfer.UserID, transfer.DeviceID, file.ID, expiresAt)
+	if err != nil || held {
+		return err
+	}
+
+	reserved, err := uc.deviceRepo.Reserve(ctx, transfer.UserID, transfer.DeviceID, file.ID, file.Size, expiresAt)
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return errNoRoom
+	}
+	return nil
+}
+
+// openSource opens the file's content staged on the main server or, once that is gone, the copy
+// on an online device other than target
+func (uc *DispatchTransfersUseCase) openSource(
+	ctx context.Context, file *fileEntities.File, target primitive.ObjectID,
+) (io.ReadCloser, error) {
+	staged, err := uc.staging.Open(ctx, file.ID.Hex())
+	if err == nil {
+		return staged, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, replica := range file.StoredReplicas() {
+		if replica.DeviceID == target {
+			continue
+		}
+
+		device, lookupErr := uc.deviceRepo.GetByID(ctx, file.UserID, replica.DeviceID)
+		if lookupErr != nil || device == nil || device.Status != deviceEntities.DeviceStatusOnline {
+			continue
+		}
+
+		content, openErr := uc.deviceStorage.OpenFile(ctx, device, file.ID.Hex(), nil)
+		if openErr == nil {
+			return content, nil
+		}
+	}
+	return nil, errNoSource
+}
+
+func (uc *DispatchTransfersUseCase) succeed(ctx context.Context, transfer *entities.Transfer) {
+	if _, err := uc.transferRepo.CompleteTransfer(ctx, transfer.UserID, transfer.ID, uc.owner, true, ""); err != nil {
+		uc.logger.Warn("Failed to complete transfer", zap.String("transfer_id", transfer.ID.Hex()), zap.Error(err))
+	}
+
+	if transfer.Kind == entities.TransferKindDelete {
+		uc.forget(ctx, transfer)
+		return
+	}
+	uc.settle(ctx, transfer, fileEntities.ReplicaStatusStored, "")
+}
+
+// fail gives up on the transfer, failing the replica it was meant to create. A failed deletion
+// leaves the file being deleted, with its replica on the device, until it is requeued.
+func (uc *DispatchTransfersUseCase) fail(
+	ctx context.Context, transfer *entities.Transfer, failure entities.TransferFailure, reason string,
+) {
+	uc.logger.Warn(
+		"Transfer failed",
+		zap.String("transfer_id", transfer.ID.Hex()),
+		zap.String("failure", string(failure)),
+		zap.String("reason", reason),
+	)
+
+	if _, err := uc.transferRepo.Fail(ctx, transfer.UserID, transfer.ID, uc.owner, failure, reason); err != nil {
+		uc.logger.Warn("Failed to record failed transfer", zap.String("transfer_id", transfer.ID.Hex()), zap.Error(err))
+	}
+	if transfer.Kind == entities.TransferKindDelete {
+		return
+	}
+	uc.settle(ctx, transfer, fileEntities.ReplicaStatusFailed, fmt.Sprintf("transfer failed: %s", reason))
+}
+
+// retryLater puts the transfer back in the queue after a backoff that doubles with every retry
+func (uc *DispatchTransfersUseCase) retryLater(ctx context.Context, transfer *entities.Transfer, cause error) {
+	backoff := uc.retryBackoff
+	for i := 0; i < transfer.Retries && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxRetryBackoff)
+
+	_, err := uc.transferRepo.RetryLater(
+		ctx, transfer.UserID, transfer.ID, uc.owner, time.Now().Add(backoff), classifyFailure(cause), cause.Error(),
+	)
+	if err != nil {
+		uc.logger.Warn("Failed to reschedule transfer", zap.String("transfer_id", transfer.ID.Hex()), zap.Error(err))
+		return
+	}
+	reason := fmt.Sprintf("transfer failed, retry %d of %d in %s: %v", transfer.Retries+1, transfer.MaxRetries, backoff, cause)
+	uc.awaitRetry(ctx, transfer, reason)
+}
@@ -0,0 +1,59 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// EnqueueDeletionUseCase queues the deletion of a file from a device that cannot be reached now,
+// for the dispatcher to carry out once the device is back, and tells the device about it if it is
+// connected to its control channel. A deletion already queued for the device is not queued again.
+type EnqueueDeletionUseCase struct {
+	transferRepo repository.TransferRepository
+	events       deviceRepository.DeviceEventBus
+	maxRetries   int
+}
+
+func NewEnqueueDeletionUseCase(
+	transferRepo repository.TransferRepository, events deviceRepository.DeviceEventBus, maxRetries int,
+) *EnqueueDeletionUseCase {
+	return &EnqueueDeletionUseCase{
+		transferRepo: transferRepo,
+		events:       events,
+		maxRetries:   maxRetries,
+	}
+}
+
+func (uc *EnqueueDeletionUseCase) Execute(ctx context.Context, userID, fileID, deviceID primitive.ObjectID) error {
+	queued, err := uc.transferRepo.GetActiveDeletion(ctx, userID, fileID, deviceID)
+	if err != nil {
+		return err
+	}
+	if queued != nil {
+		return nil
+	}
+
+	now := time.Now()
+	transfer, err := uc.transferRepo.Create(ctx, &entities.Transfer{
+		UserID:     userID,
+		FileID:     fileID,
+		DeviceID:   deviceID,
+		Kind:       entities.TransferKindDelete,
+		Status:     entities.TransferStatusPending,
+		MaxRetries: uc.maxRetries,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		return err
+	}
+
+	announce(ctx, uc.events, transfer)
+	return nil
+}
@@ -0,0 +1,71 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// backgroundPriority ranks copies queued to tidy up storage, such as rebalancing moves, after
+// the copies uploads are waiting on
+const backgroundPriority = -1
+
+// EnqueueTransferUseCase queues a copy of a file for a device that cannot take it now, and tells
+// the device about it if it is connected to its control channel
+type EnqueueTransferUseCase struct {
+	transferRepo repository.TransferRepository
+	events       deviceRepository.DeviceEventBus
+	maxRetries   int
+}
+
+func NewEnqueueTransferUseCase(
+	transferRepo repository.TransferRepository, events deviceRepository.DeviceEventBus, maxRetries int,
+) *EnqueueTransferUseCase {
+	return &EnqueueTransferUseCase{
+		transferRepo: transferRepo,
+		events:       events,
+		maxRetries:   maxRetries,
+	}
+}
+
+func (uc *EnqueueTransferUseCase) Execute(ctx context.Context, userID, fileID, deviceID primitive.ObjectID) error {
+	return uc.enqueue(ctx, userID, fileID, deviceID, 0, 0)
+}
+
+// ExecuteBackground queues a copy that only runs once the transfers queued with Execute have
+// been started, and is written at no more than bandwidthLimit bytes per second (zero is uncapped)
+func (uc *EnqueueTransferUseCase) ExecuteBackground(
+	ctx context.Context, userID, fileID, deviceID primitive.ObjectID, bandwidthLimit int64,
+) error {
+	return uc.enqueue(ctx, userID, fileID, deviceID, backgroundPriority, bandwidthLimit)
+}
+
+func (uc *EnqueueTransferUseCase) enqueue(
+	ctx context.Context, userID, fileID, deviceID primitive.ObjectID, priority int, bandwidthLimit int64,
+) error {
+	now := time.Now()
+	transfer, err := uc.transferRepo.Create(ctx, &entities.Transfer{
+		UserID:     userID,
+		FileID:     fileID,
+		DeviceID:   deviceID,
+		Kind:       entities.TransferKindStore,
+		Status:     entities.TransferStatusPending,
+		Priority:   priority,
+		MaxRetries: uc.maxRetries,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+
+		BandwidthLimit: bandwidthLimit,
+	})
+	if err != nil {
+		return err
+	}
+
+	announce(ctx, uc.events, transfer)
+	return nil
+}
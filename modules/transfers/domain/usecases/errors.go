@@ -0,0 +1,18 @@
+package usecases
+
+import "errors"
+
+var (
+	ErrTransferNotFound = errors.New("transfer not found or does not belong to you")
+
+	// ErrTransferNotLeased is returned for progress reports on and completions of a transfer the
+	// device does not hold, because it is not in progress or went back to the queue
+	ErrTransferNotLeased = errors.New("transfer is not in progress for this device")
+
+	// ErrTransferFinished is returned for cancelling a transfer that is already over
+	ErrTransferFinished = errors.New("cannot cancel completed or failed transfer")
+
+	ErrInvalidTransferStatus  = errors.New("invalid transfer status")
+	ErrInvalidTransferFailure = errors.New("invalid transfer failure")
+	ErrInvalidTransferKind    = errors.New("invalid transfer kind")
+)
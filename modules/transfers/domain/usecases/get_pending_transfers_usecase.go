@@ -3,6 +3,7 @@ package usecases
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
 	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
@@ -10,6 +11,8 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// GetPendingTransfersUseCase lists the transfers due for a device, without claiming them; devices
+// claim the ones they write themselves with ClaimTransfersUseCase
 type GetPendingTransfersUseCase struct {
 	transferRepo repository.TransferRepository
 }
@@ -20,24 +23,16 @@ func NewGetPendingTransfersUseCase(transferRepo repository.TransferRepository) *
 	}
 }
 
-func (uc *GetPendingTransfersUseCase) Execute(ctx context.Context, deviceID string) ([]*entities.Transfer, error) {
-	id, err := primitive.ObjectIDFromHex(deviceID)
+func (uc *GetPendingTransfersUseCase) Execute(ctx context.Context, userID, deviceID string) ([]*entities.Transfer, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
-		return nil, errors.New("invalid device ID")
+		return nil, errors.New("invalid user ID")
 	}
 
-	transfers, err := uc.transferRepo.GetPendingByDeviceID(ctx, id)
+	id, err := primitive.ObjectIDFromHex(deviceID)
 	if err != nil {
-		return nil, err
-	}
-
-	// Update status to in_progress for retrieved transfers
-	for _, transfer := range transfers {
-		err = uc.transferRepo.UpdateStatus(ctx, transfer.ID, entities.TransferStatusInProgress)
-		if err != nil {
-			continue // Log error but continue with other transfers
-		}
+		return nil, errors.New("invalid device ID")
 	}
 
-	return transfers, nil
+	return uc.transferRepo.GetPendingByDeviceID(ctx, userObjectID, id, time.Now())
 }
@@ -0,0 +1,44 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type GetTransferUseCase struct {
+	transferRepo repository.TransferRepository
+}
+
+func NewGetTransferUseCase(transferRepo repository.TransferRepository) *GetTransferUseCase {
+	return &GetTransferUseCase{
+		transferRepo: transferRepo,
+	}
+}
+
+// Execute returns one of the user's transfers, with its progress
+func (uc *GetTransferUseCase) Execute(ctx context.Context, userID, transferID string) (*entities.Transfer, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	id, err := primitive.ObjectIDFromHex(transferID)
+	if err != nil {
+		return nil, errors.New("invalid transfer ID")
+	}
+
+	transfer, err := uc.transferRepo.GetByID(ctx, userObjectID, id)
+	if err != nil {
+		return nil, err
+	}
+	if transfer == nil {
+		return nil, ErrTransferNotFound
+	}
+
+	return transfer, nil
+}
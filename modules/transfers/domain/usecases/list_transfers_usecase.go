@@ -0,0 +1,75 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ListTransfersUseCase lists the user's transfers, optionally only those with a given status and
+// failure. Listing the failed ones gives the transfers left for the user to requeue or discard.
+type ListTransfersUseCase struct {
+	transferRepo repository.TransferRepository
+}
+
+func NewListTransfersUseCase(transferRepo repository.TransferRepository) *ListTransfersUseCase {
+	return &ListTransfersUseCase{
+		transferRepo: transferRepo,
+	}
+}
+
+// Execute lists the user's transfers. An empty status or failure does not filter on it.
+func (uc *ListTransfersUseCase) Execute(ctx context.Context, userID, status, failure string) ([]*entities.Transfer, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if status != "" && !validStatus(entities.TransferStatus(status)) {
+		return nil, ErrInvalidTransferStatus
+	}
+	if failure != "" && !validFailure(entities.TransferFailure(failure)) {
+		return nil, ErrInvalidTransferFailure
+	}
+
+	var transfers []*entities.Transfer
+	if status == "" {
+		transfers, err = uc.transferRepo.GetAllByUser(ctx, userObjectID)
+	} else {
+		transfers, err = uc.transferRepo.GetAllByUserAndStatus(ctx, userObjectID, entities.TransferStatus(status))
+	}
+	if err != nil || failure == "" {
+		return transfers, err
+	}
+
+	var matching []*entities.Transfer
+	for _, transfer := range transfers {
+		if transfer.Failure == entities.TransferFailure(failure) {
+			matching = append(matching, transfer)
+		}
+	}
+	return matching, nil
+}
+
+func validStatus(status entities.TransferStatus) bool {
+	switch status {
+	case entities.TransferStatusPending, entities.TransferStatusInProgress, entities.TransferStatusCompleted,
+		entities.TransferStatusFailed, entities.TransferStatusCanceled:
+		return true
+	}
+	return false
+}
+
+func validFailure(failure entities.TransferFailure) bool {
+	switch failure {
+	case entities.TransferFailureDeviceOffline, entities.TransferFailureDeviceGone, entities.TransferFailureChecksumMismatch,
+		entities.TransferFailureDiskFull, entities.TransferFailureTimeout, entities.TransferFailureSourceMissing,
+		entities.TransferFailureUnknown:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.uber.org/zap"
+)
+
+// ReapTransferLeasesUseCase puts transfers back in the queue once whoever claimed them stopped
+// renewing their lease, because it crashed, hung or lost its connection to the database. Every
+// transfer put back counts as a retry.
+type ReapTransferLeasesUseCase struct {
+	transferRepo repository.TransferRepository
+	logger       *zap.Logger
+}
+
+func NewReapTransferLeasesUseCase(transferRepo repository.TransferRepository, logger *zap.Logger) *ReapTransferLeasesUseCase {
+	return &ReapTransferLeasesUseCase{
+		transferRepo: transferRepo,
+		logger:       logger,
+	}
+}
+
+// Run reaps every interval until ctx is cancelled
+func (uc *ReapTransferLeasesUseCase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := uc.Execute(ctx); err != nil && ctx.Err() == nil {
+			uc.logger.Warn("Transfer lease reaping failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Execute returns every transfer whose lease has run out to the queue
+func (uc *ReapTransferLeasesUseCase) Execute(ctx context.Context) error {
+	released, err := uc.transferRepo.ReleaseExpiredLeases(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if released > 0 {
+		uc.logger.Info("Requeued transfers whose lease expired", zap.Int64("transfers", released))
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package usecases
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+
+	"go.uber.org/zap"
+)
+
+// replanAttempts is how many times moving a replica is retried when the file record changes under it
+const replanAttempts = 3
+
+// replan moves a claimed transfer whose device was removed, has failed or is draining, to the
+// user's device with the most free space that does not hold the file yet and has room for it. The
+// replica on the old device is given up on and a replica on the new one is queued in its place,
+// with the space reserved for it moving along. Without such a device, the transfer fails. gone is
+// nil for a removed device.
+func (uc *DispatchTransfersUseCase) replan(ctx context.Context, transfer *entities.Transfer, gone *deviceEntities.Device) {
+	reason := "device was removed"
+	replicaStatus := fileEntities.ReplicaStatusLost
+	switch {
+	case gone == nil:
+	case gone.Draining():
+		reason = "device is draining"
+		replicaStatus = fileEntities.ReplicaStatusFailed
+	default:
+		reason = "device failed"
+		replicaStatus = fileEntities.ReplicaStatusFailed
+	}
+
+	file, err := uc.fileRepo.GetByID(ctx, transfer.UserID, transfer.FileID)
+	if err != nil {
+		uc.release(ctx, transfer, err)
+		return
+	}
+	if file == nil || file.Removed() {
+		uc.fail(ctx, transfer, entities.TransferFailureSourceMissing, errFileGone.Error())
+		return
+	}
+
+	target, err := uc.replacementFor(ctx, transfer, file)
+	if err != nil {
+		uc.release(ctx, transfer, err)
+		return
+	}
+	if target == nil {
+		uc.fail(ctx, transfer, entities.TransferFailureDeviceGone, reason+" and no other device can take the file")
+		return
+	}
+	reserved, err := uc.deviceRepo.Reserve(ctx, transfer.UserID, target.ID, file.ID, file.Size, time.Now().Add(uc.reservation))
+	if err != nil {
+		uc.release(ctx, transfer, err)
+		return
+	}
+	if !reserved {
+		uc.release(ctx, transfer, fmt.Errorf("device %s no longer has room for the file", target.Name))
+		return
+	}
+
+	now := time.Now()
+	replacement, err := uc.transferRepo.Create(ctx, &entities.Transfer{
+		UserID:     transfer.UserID,
+		FileID:     transfer.FileID,
+		DeviceID:   target.ID,
+		Kind:       entities.TransferKindStore,
+		Status:     entities.TransferStatusPending,
+		Priority:   transfer.Priority,
+		MaxRetries: transfer.MaxRetries,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+
+		BandwidthLimit: transfer.BandwidthLimit,
+	})
+	if err != nil {
+		uc.settleReservation(ctx, transfer, target.ID, false)
+		uc.release(ctx, transfer, err)
+		return
+	}
+
+	moved := fmt.Sprintf("%s, moved to device %s", reason, target.Name)
+	if err = uc.moveReplica(ctx, file, transfer, target, replicaStatus, moved); err != nil {
+		if deleteErr := uc.transferRepo.Delete(ctx, replacement.UserID, replacement.ID); deleteErr != nil {
+			uc.logger.Warn("Failed to drop replacement transfer", zap.String("transfer_id", replacement.ID.Hex()), zap.Error(deleteErr))
+		}
+		uc.settleReservation(ctx, transfer, target.ID, false)
+		uc.release(ctx, transfer, err)
+		return
+	}
+	uc.settleReservation(ctx, transfer, transfer.DeviceID, false)
+
+	announce(ctx, uc.events, replacement)
+
+	if _, err = uc.transferRepo.Fail(ctx, transfer.UserID, transfer.ID, uc.owner, entities.TransferFailureDeviceGone, moved); err != nil {
+		uc.logger.Warn("Failed to record replanned transfer", zap.String("transfer_id", transfer.ID.Hex()), zap.Error(err))
+	}
+	uc.logger.Info(
+		"Moved transfer off a device that is gone",
+		zap.String("transfer_id", transfer.ID.Hex()),
+		zap.String("replacement_id", replacement.ID.Hex()),
+		zap.String("device_id", target.ID.Hex()),
+	)
+}
+
+// replacementFor picks the device a transfer's copy moves to, or nil if none can take it
+func (uc *DispatchTransfersUseCase) replacementFor(
+	ctx context.Context, transfer *entities.Transfer, file *fileEntities.File,
+) (*deviceEntities.Device, error) {
+	if file.Erasure != nil {
+		return nil, nil // Shards are tied to their index and are not queued as transfers
+	}
+
+	devices, err := uc.deviceRepo.GetAllByUser(ctx, transfer.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *deviceEntities.Device
+	for _, device := range devices {
+		if device.ID == transfer.DeviceID || device.Status == deviceEntities.DeviceStatusFailed || device.Draining() {
+			continue
+		}
+		if hasReplicaOn(file, device) || device.AvailableStorage < file.Size {
+			continue
+		}
+		if best == nil || device.AvailableStorage > best.AvailableStorage {
+			best = device
+		}
+	}
+	return best, nil
+}
+
+// moveReplica gives up on the file's replica on the transfer's device, recording status and
+// reason, and adds a pending replica on target
+func (uc *DispatchTransfersUseCase) moveReplica(
+	ctx context.Context,
+	file *fileEntities.File,
+	transfer *entities.Transfer,
+	target *deviceEntities.Device,
+	status fileEntities.ReplicaStatus,
+	reason string,
+) error {
+	for attempt := 0; attempt < replanAttempts; attempt++ {
+		if attempt > 0 {
+			var err error
+			if file, err = uc.fileRepo.GetByID(ctx, transfer.UserID, transfer.FileID); err != nil {
+				return err
+			}
+			if file == nil {
+				return errFileGone
+			}
+		}
+
+		now := time.Now()
+		file.Replicas = file.AllReplicas()
+		for i := range file.Replicas {
+			if file.Replicas[i].DeviceID == transfer.DeviceID {
+				file.Replicas[i].Status = status
+				file.Replicas[i].StatusReason = reason
+				file.Replicas[i].UpdatedAt = now
+			}
+		}
+		file.Replicas = append(file.Replicas, fileEntities.Replica{
+			DeviceID:     target.ID,
+			Status:       fileEntities.ReplicaStatusPending,
+			StatusReason: "queued in place of a device that is gone",
+			UpdatedAt:    now,
+		})
+
+		updated, err := uc.fileRepo.UpdatePlacements(ctx, file)
+		if err != nil {
+			return err
+		}
+		if updated {
+			return nil
+		}
+	}
+	return fmt.Errorf("file %s kept changing while its replica was moved", transfer.FileID.Hex())
+}
+
+// release returns a transfer that could not be replanned to the queue, to be tried again
+func (uc *DispatchTransfersUseCase) release(ctx context.Context, transfer *entities.Transfer, cause error) {
+	uc.logger.Warn("Failed to replan transfer", zap.String("transfer_id", transfer.ID.Hex()), zap.Error(cause))
+
+	if err := uc.transferRepo.ReleaseLease(ctx, transfer.UserID, transfer.ID, uc.owner); err != nil {
+		uc.logger.Warn("Failed to requeue transfer", zap.String("transfer_id", transfer.ID.Hex()), zap.Error(err))
+	}
+}
+
+// hasReplicaOn reports whether the file already has a replica, in whatever state, on the device
+func hasReplicaOn(file *fileEntities.File, device *deviceEntities.Device) bool {
+	for _, replica := range file.AllReplicas() {
+		if replica.DeviceID == device.ID {
+			return true
+		}
+	}
+	return false
+}
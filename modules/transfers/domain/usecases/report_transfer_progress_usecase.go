@@ -0,0 +1,74 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReportTransferProgressUseCase records the progress a device reports on a transfer it claimed
+// from the pending transfers, which also renews the device's lease on it
+type ReportTransferProgressUseCase struct {
+	transferRepo repository.TransferRepository
+	lease        time.Duration
+}
+
+func NewReportTransferProgressUseCase(transferRepo repository.TransferRepository, lease time.Duration) *ReportTransferProgressUseCase {
+	return &ReportTransferProgressUseCase{
+		transferRepo: transferRepo,
+		lease:        lease,
+	}
+}
+
+func (uc *ReportTransferProgressUseCase) Execute(
+	ctx context.Context, userID, deviceID, transferID string, transferred, total int64,
+) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	deviceObjectID, err := primitive.ObjectIDFromHex(deviceID)
+	if err != nil {
+		return errors.New("invalid device ID")
+	}
+
+	id, err := primitive.ObjectIDFromHex(transferID)
+	if err != nil {
+		return errors.New("invalid transfer ID")
+	}
+
+	transfer, err := uc.transferRepo.GetByID(ctx, userObjectID, id)
+	if err != nil {
+		return err
+	}
+	if transfer == nil || transfer.DeviceID != deviceObjectID {
+		return ErrTransferNotFound
+	}
+
+	now := time.Now()
+	started := now
+	if transfer.StartedAt != nil {
+		started = *transfer.StartedAt
+	}
+
+	progress := entities.NewTransferProgress(transferred, total, started, now)
+	held, err := uc.transferRepo.UpdateProgress(ctx, userObjectID, id, deviceLeaseOwner(deviceID), progress, now.Add(uc.lease))
+	if err != nil {
+		return err
+	}
+	if !held {
+		return ErrTransferNotLeased
+	}
+	return nil
+}
+
+// deviceLeaseOwner is who a transfer is leased to while the device claimed it to write itself
+func deviceLeaseOwner(deviceID string) string {
+	return "device:" + deviceID
+}
@@ -0,0 +1,140 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// requeuedReason is the status reason of a replica whose failed transfer was requeued
+const requeuedReason = "transfer requeued"
+
+// RequeueTransfersUseCase puts failed transfers back in the queue with their retries reset. The
+// replicas they were meant to create go back to pending, and so do their files if they had
+// failed for want of any replica. Transfers whose file or device is gone are left alone, as
+// there is nothing to copy or nowhere to copy to, and so are those for draining devices, which
+// take no new files; deletions are requeued as long as their file is still being deleted.
+type RequeueTransfersUseCase struct {
+	transferRepo repository.TransferRepository
+	fileRepo     fileRepository.FileRepository
+	deviceRepo   deviceRepository.DeviceRepository
+	events       deviceRepository.DeviceEventBus
+}
+
+func NewRequeueTransfersUseCase(
+	transferRepo repository.TransferRepository,
+	fileRepo fileRepository.FileRepository,
+	deviceRepo deviceRepository.DeviceRepository,
+	events deviceRepository.DeviceEventBus,
+) *RequeueTransfersUseCase {
+	return &RequeueTransfersUseCase{
+		transferRepo: transferRepo,
+		fileRepo:     fileRepo,
+		deviceRepo:   deviceRepo,
+		events:       events,
+	}
+}
+
+// Execute requeues the user's transfers with the given IDs, skipping those it cannot requeue
+func (uc *RequeueTransfersUseCase) Execute(
+	ctx context.Context, userID string, transferIDs []string,
+) (*entities.BulkTransferResult, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	result := &entities.BulkTransferResult{Skipped: make(map[string]string)}
+	for _, transferID := range transferIDs {
+		skipped, requeueErr := uc.requeue(ctx, userObjectID, transferID)
+		if requeueErr != nil {
+			return nil, requeueErr
+		}
+		if skipped != "" {
+			result.Skipped[transferID] = skipped
+			continue
+		}
+		result.Done = append(result.Done, transferID)
+	}
+	return result, nil
+}
+
+// requeue requeues one transfer, or returns why it was skipped
+func (uc *RequeueTransfersUseCase) requeue(ctx context.Context, userID primitive.ObjectID, transferID string) (string, error) {
+	id, err := primitive.ObjectIDFromHex(transferID)
+	if err != nil {
+		return "invalid transfer ID", nil
+	}
+
+	transfer, err := uc.transferRepo.GetByID(ctx, userID, id)
+	if err != nil {
+		return "", err
+	}
+	if transfer == nil {
+		return ErrTransferNotFound.Error(), nil
+	}
+	if transfer.Status != entities.TransferStatusFailed {
+		return "transfer has not failed", nil
+	}
+
+	file, err := uc.fileRepo.GetByID(ctx, userID, transfer.FileID)
+	if err != nil {
+		return "", err
+	}
+	if transfer.Kind == entities.TransferKindDelete {
+		if file == nil || file.Status != fileEntities.FileStatusDeleting {
+			return "file is not being deleted", nil
+		}
+		return uc.requeueTransfer(ctx, userID, id)
+	}
+	if file == nil || file.Removed() {
+		return errFileGone.Error(), nil
+	}
+
+	// A transfer whose device is gone was already moved to another device, or found none to move to
+	device, err := uc.deviceRepo.GetByID(ctx, userID, transfer.DeviceID)
+	if err != nil {
+		return "", err
+	}
+	if device == nil || device.Status == deviceEntities.DeviceStatusFailed {
+		return "device is gone", nil
+	}
+	if device.Draining() {
+		return "device is draining", nil
+	}
+
+	if skipped, requeueErr := uc.requeueTransfer(ctx, userID, id); skipped != "" || requeueErr != nil {
+		return skipped, requeueErr
+	}
+
+	err = uc.fileRepo.UpdateReplicaStatus(ctx, userID, file.ID, transfer.DeviceID, fileEntities.ReplicaStatusPending, requeuedReason)
+	if err != nil {
+		return "", err
+	}
+	if file.Status == fileEntities.FileStatusFailed {
+		err = uc.fileRepo.UpdateStatusWithReason(ctx, userID, file.ID, fileEntities.FileStatusPending, "")
+	}
+	return "", err
+}
+
+// requeueTransfer puts the transfer back in the queue and announces it, or returns why it was skipped
+func (uc *RequeueTransfersUseCase) requeueTransfer(ctx context.Context, userID, id primitive.ObjectID) (string, error) {
+	requeued, err := uc.transferRepo.Requeue(ctx, userID, id)
+	if err != nil {
+		return "", err
+	}
+	if requeued == nil {
+		return "transfer has not failed", nil // Requeued or discarded in the meantime
+	}
+
+	announce(ctx, uc.events, requeued)
+	return "", nil
+}
@@ -0,0 +1,101 @@
+package usecases
+
+import (
+	"context"
+
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
+)
+
+// transferSettler brings a file along with how a transfer of it went, whoever wrote it: the
+// replica it was meant to create, the file's status, the space reserved on the device and the
+// staged content
+type transferSettler struct {
+	fileRepo   fileRepository.FileRepository
+	deviceRepo deviceRepository.DeviceRepository
+	staging    fileRepository.ContentStagingRepository
+	logger     *zap.Logger
+}
+
+// awaitRetry puts the replica a failed copy was meant to create back to pending, with reason
+func (s *transferSettler) awaitRetry(ctx context.Context, transfer *entities.Transfer, reason string) {
+	if transfer.Kind == entities.TransferKindDelete {
+		return
+	}
+
+	err := s.fileRepo.UpdateReplicaStatus(
+		ctx, transfer.UserID, transfer.FileID, transfer.DeviceID, fileEntities.ReplicaStatusPending, reason,
+	)
+	if err != nil {
+		s.logger.Warn("Failed to update replica", zap.String("file_id", transfer.FileID.Hex()), zap.Error(err))
+	}
+}
+
+// settle records the replica's new status and brings the file along: it is stored once a replica
+// is, and failed once no replica is stored or still on its way. Space reserved for the copy on its
+// device is counted as used or given back. Staged content is dropped once no replica is waiting
+// for it anymore.
+func (s *transferSettler) settle(
+	ctx context.Context, transfer *entities.Transfer, status fileEntities.ReplicaStatus, reason string,
+) {
+	err := s.fileRepo.UpdateReplicaStatus(ctx, transfer.UserID, transfer.FileID, transfer.DeviceID, status, reason)
+	if err != nil {
+		s.logger.Warn("Failed to update replica", zap.String("file_id", transfer.FileID.Hex()), zap.Error(err))
+		return
+	}
+	s.settleReservation(ctx, transfer, transfer.DeviceID, status == fileEntities.ReplicaStatusStored)
+
+	file, err := s.fileRepo.GetByID(ctx, transfer.UserID, transfer.FileID)
+	if err != nil || file == nil {
+		return
+	}
+
+	var stored, pending int
+	for _, replica := range file.AllReplicas() {
+		switch replica.Status {
+		case fileEntities.ReplicaStatusStored:
+			stored++
+		case fileEntities.ReplicaStatusPending:
+			pending++
+		}
+	}
+
+	if file.Status == fileEntities.FileStatusPending {
+		switch {
+		case stored > 0:
+			err = s.fileRepo.UpdateStatusWithReason(ctx, file.UserID, file.ID, fileEntities.FileStatusStored, "")
+		case pending == 0:
+			err = s.fileRepo.UpdateStatusWithReason(ctx, file.UserID, file.ID, fileEntities.FileStatusFailed, reason)
+		}
+		if err != nil {
+			s.logger.Warn("Failed to update file status", zap.String("file_id", file.ID.Hex()), zap.Error(err))
+		}
+	}
+
+	if pending == 0 {
+		if err = s.staging.Remove(ctx, file.ID.Hex()); err != nil {
+			s.logger.Warn("Failed to remove staged content", zap.String("file_id", file.ID.Hex()), zap.Error(err))
+		}
+	}
+}
+
+// settleReservation counts the space reserved for the file's copy on the device as used once the
+// copy is stored, or gives it back otherwise
+func (s *transferSettler) settleReservation(
+	ctx context.Context, transfer *entities.Transfer, deviceID primitive.ObjectID, stored bool,
+) {
+	var err error
+	if stored {
+		err = s.deviceRepo.ConfirmReservation(ctx, transfer.UserID, deviceID, transfer.FileID)
+	} else {
+		err = s.deviceRepo.ReleaseReservation(ctx, transfer.UserID, deviceID, transfer.FileID)
+	}
+	if err != nil {
+		s.logger.Warn("Failed to settle reserved storage", zap.String("device_id", deviceID.Hex()), zap.Error(err))
+	}
+}
@@ -0,0 +1,65 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	deviceEntities "github.com/manab-pr/nebulo/modules/devices/domain/entities"
+	deviceRepository "github.com/manab-pr/nebulo/modules/devices/domain/repository"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TransferEventsUseCase tells a device that connects to its control channel about the transfers
+// and deletions still queued for it, which it may have missed the events for
+type TransferEventsUseCase struct {
+	transferRepo repository.TransferRepository
+}
+
+func NewTransferEventsUseCase(transferRepo repository.TransferRepository) *TransferEventsUseCase {
+	return &TransferEventsUseCase{
+		transferRepo: transferRepo,
+	}
+}
+
+// ReplayEvents returns an event for each of the device's due pending transfers, without claiming them
+func (uc *TransferEventsUseCase) ReplayEvents(
+	ctx context.Context, userID, deviceID primitive.ObjectID,
+) ([]*deviceEntities.DeviceEvent, error) {
+	transfers, err := uc.transferRepo.GetPendingByDeviceID(ctx, userID, deviceID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]*deviceEntities.DeviceEvent, len(transfers))
+	for i, transfer := range transfers {
+		events[i] = transferEvent(transfer)
+	}
+	return events, nil
+}
+
+// announce tells the transfer's device about it over its control channel. A device that misses
+// the event finds the transfer when it polls or connects again.
+func announce(ctx context.Context, events deviceRepository.DeviceEventBus, transfer *entities.Transfer) {
+	_ = events.Publish(ctx, transferEvent(transfer))
+}
+
+// transferEvent describes the transfer to its device. Deletions get their own event type.
+func transferEvent(transfer *entities.Transfer) *deviceEntities.DeviceEvent {
+	eventType := deviceEntities.DeviceEventTransfer
+	if transfer.Kind == entities.TransferKindDelete {
+		eventType = deviceEntities.DeviceEventDelete
+	}
+
+	return &deviceEntities.DeviceEvent{
+		Type:     eventType,
+		DeviceID: transfer.DeviceID,
+		Data: map[string]any{
+			"transfer_id": transfer.ID.Hex(),
+			"file_id":     transfer.FileID.Hex(),
+			"priority":    transfer.Priority,
+		},
+	}
+}
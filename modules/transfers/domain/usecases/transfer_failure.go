@@ -0,0 +1,29 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	fileRepository "github.com/manab-pr/nebulo/modules/files/domain/repository"
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+)
+
+// classifyFailure tells what kind of failure made an attempt at a transfer fail
+func classifyFailure(err error) entities.TransferFailure {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, fileRepository.ErrDeviceFull) || errors.Is(err, errNoRoom):
+		return entities.TransferFailureDiskFull
+	case errors.Is(err, fileRepository.ErrChecksumMismatch):
+		return entities.TransferFailureChecksumMismatch
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		// Checked before unreachable devices, as a connection that timed out is both
+		return entities.TransferFailureTimeout
+	case errors.Is(err, fileRepository.ErrDeviceUnreachable):
+		return entities.TransferFailureDeviceOffline
+	case errors.Is(err, errFileGone) || errors.Is(err, errNoSource):
+		return entities.TransferFailureSourceMissing
+	}
+	return entities.TransferFailureUnknown
+}
@@ -0,0 +1,40 @@
+package usecases
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
+)
+
+// transferMeter counts the bytes of a copy as they are read from its source
+type transferMeter struct {
+	started time.Time
+	total   atomic.Int64
+	sent    atomic.Int64
+}
+
+func newTransferMeter(started time.Time) *transferMeter {
+	return &transferMeter{started: started}
+}
+
+// wrap returns a reader that counts what is read from source
+func (m *transferMeter) wrap(source io.Reader) io.Reader {
+	return &meteredReader{source: source, meter: m}
+}
+
+func (m *transferMeter) progress(now time.Time) entities.TransferProgress {
+	return entities.NewTransferProgress(m.sent.Load(), m.total.Load(), m.started, now)
+}
+
+type meteredReader struct {
+	source io.Reader
+	meter  *transferMeter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	r.meter.sent.Add(int64(n))
+	return n, err
+}
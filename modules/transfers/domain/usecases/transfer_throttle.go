@@ -0,0 +1,49 @@
+package usecases
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// throttledReader reads from source at no more than limit bytes per second on average since it
+// started, waiting between reads when it gets ahead. The wait ends early when ctx is done.
+type throttledReader struct {
+	ctx     context.Context
+	source  io.Reader
+	limit   int64
+	started time.Time
+	read    int64
+}
+
+// throttle returns source as is when limit is not positive
+func throttle(ctx context.Context, source io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return source
+	}
+	return &throttledReader{ctx: ctx, source: source, limit: limit, started: time.Now()}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	// Reads of at most a second's worth keep the waits short and the rate even
+	if int64(len(p)) > r.limit {
+		p = p[:r.limit]
+	}
+
+	n, err := r.source.Read(p)
+	r.read += int64(n)
+
+	due := r.started.Add(time.Duration(float64(r.read) / float64(r.limit) * float64(time.Second)))
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-r.ctx.Done():
+			if err == nil {
+				err = r.ctx.Err()
+			}
+		case <-timer.C:
+		}
+	}
+	return n, err
+}
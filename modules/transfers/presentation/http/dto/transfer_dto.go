@@ -6,14 +6,28 @@ import (
 	"github.com/manab-pr/nebulo/modules/transfers/domain/entities"
 )
 
+const fullPercent = 100
+
 type CompleteTransferRequest struct {
 	TransferID string `json:"transfer_id" validate:"required"`
 	Success    bool   `json:"success"`
 	ErrorMsg   string `json:"error_msg,omitempty"`
 }
 
+// BulkTransfersRequest names the transfers a bulk operation applies to
+type BulkTransfersRequest struct {
+	TransferIDs []string `json:"transfer_ids" validate:"required,min=1,max=500,dive,required"`
+}
+
+// ReportTransferProgressRequest is how far a device has got writing a transfer
+type ReportTransferProgressRequest struct {
+	BytesTransferred int64 `json:"bytes_transferred" validate:"gte=0"`
+	TotalBytes       int64 `json:"total_bytes" validate:"gte=0"`
+}
+
 type TransferResponse struct {
 	ID          string     `json:"id"`
+	Kind        string     `json:"kind"`
 	FileID      string     `json:"file_id"`
 	DeviceID    string     `json:"device_id"`
 	Status      string     `json:"status"`
@@ -25,18 +39,40 @@ type TransferResponse struct {
 	StartedAt   *time.Time `json:"started_at,omitempty"`
 	CompletedAt *time.Time `json:"completed_at,omitempty"`
 	ErrorMsg    string     `json:"error_msg,omitempty"`
+	Failure     string     `json:"failure,omitempty"`
+
+	BandwidthLimit int64                     `json:"bandwidth_limit,omitempty"` // Bytes per second
+	Progress       *TransferProgressResponse `json:"progress,omitempty"`
 }
 
 type PendingTransferResponse struct {
-	ID       string `json:"id"`
-	FileID   string `json:"file_id"`
-	Priority int    `json:"priority"`
-	Retries  int    `json:"retries"`
+	ID       string                    `json:"id"`
+	Kind     string                    `json:"kind"`
+	FileID   string                    `json:"file_id"`
+	Priority int                       `json:"priority"`
+	Retries  int                       `json:"retries"`
+	Progress *TransferProgressResponse `json:"progress,omitempty"`
+}
+
+// BulkTransfersResponse is what a bulk operation did with each of the transfers it was given
+type BulkTransfersResponse struct {
+	Done    []string          `json:"done"`
+	Skipped map[string]string `json:"skipped,omitempty"` // Why each transfer left alone was skipped, by ID
+}
+
+type TransferProgressResponse struct {
+	BytesTransferred int64     `json:"bytes_transferred"`
+	TotalBytes       int64     `json:"total_bytes"`
+	Percent          float64   `json:"percent"`
+	BytesPerSecond   float64   `json:"bytes_per_second"`
+	ETASeconds       *int64    `json:"eta_seconds,omitempty"` // Left out until there is a throughput to tell from
+	UpdatedAt        time.Time `json:"updated_at"`
 }
 
 func ToTransferResponse(transfer *entities.Transfer) *TransferResponse {
 	return &TransferResponse{
 		ID:          transfer.ID.Hex(),
+		Kind:        string(transfer.Kind),
 		FileID:      transfer.FileID.Hex(),
 		DeviceID:    transfer.DeviceID.Hex(),
 		Status:      string(transfer.Status),
@@ -48,18 +84,46 @@ func ToTransferResponse(transfer *entities.Transfer) *TransferResponse {
 		StartedAt:   transfer.StartedAt,
 		CompletedAt: transfer.CompletedAt,
 		ErrorMsg:    transfer.ErrorMsg,
+		Failure:     string(transfer.Failure),
+
+		BandwidthLimit: transfer.BandwidthLimit,
+		Progress:       ToTransferProgressResponse(transfer.Progress),
 	}
 }
 
 func ToPendingTransferResponse(transfer *entities.Transfer) *PendingTransferResponse {
 	return &PendingTransferResponse{
 		ID:       transfer.ID.Hex(),
+		Kind:     string(transfer.Kind),
 		FileID:   transfer.FileID.Hex(),
 		Priority: transfer.Priority,
 		Retries:  transfer.Retries,
+		Progress: ToTransferProgressResponse(transfer.Progress),
 	}
 }
 
+// ToTransferProgressResponse returns nil for transfers that have not reported progress
+func ToTransferProgressResponse(progress *entities.TransferProgress) *TransferProgressResponse {
+	if progress == nil {
+		return nil
+	}
+
+	response := &TransferProgressResponse{
+		BytesTransferred: progress.BytesTransferred,
+		TotalBytes:       progress.TotalBytes,
+		BytesPerSecond:   progress.BytesPerSecond,
+		UpdatedAt:        progress.UpdatedAt,
+	}
+	if progress.TotalBytes > 0 {
+		response.Percent = min(float64(progress.BytesTransferred)/float64(progress.TotalBytes)*fullPercent, fullPercent)
+	}
+	if eta, ok := progress.ETA(); ok {
+		seconds := int64(eta.Round(time.Second) / time.Second)
+		response.ETASeconds = &seconds
+	}
+	return response
+}
+
 func ToTransferResponses(transfers []*entities.Transfer) []*TransferResponse {
 	responses := make([]*TransferResponse, len(transfers))
 	for i, transfer := range transfers {
@@ -68,6 +132,17 @@ func ToTransferResponses(transfers []*entities.Transfer) []*TransferResponse {
 	return responses
 }
 
+func ToBulkTransfersResponse(result *entities.BulkTransferResult) *BulkTransfersResponse {
+	response := &BulkTransfersResponse{
+		Done:    result.Done,
+		Skipped: result.Skipped,
+	}
+	if response.Done == nil {
+		response.Done = []string{}
+	}
+	return response
+}
+
 func ToPendingTransferResponses(transfers []*entities.Transfer) []*PendingTransferResponse {
 	responses := make([]*PendingTransferResponse, len(transfers))
 	for i, transfer := range transfers {
@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"time"
 
 	"github.com/manab-pr/nebulo/modules/auth/middleware"
 	"github.com/manab-pr/nebulo/modules/transfers/domain/usecases"
@@ -11,29 +13,50 @@ import (
 	"github.com/go-playground/validator/v10"
 )
 
+// progressStreamInterval is how often a progress stream checks the transfer for news
+const progressStreamInterval = time.Second
+
 type TransferHandler struct {
-	getPendingUseCase *usecases.GetPendingTransfersUseCase
-	completeUseCase   *usecases.CompleteTransferUseCase
-	cancelUseCase     *usecases.CancelTransferUseCase
-	validator         *validator.Validate
+	getPendingUseCase     *usecases.GetPendingTransfersUseCase
+	claimUseCase          *usecases.ClaimTransfersUseCase
+	getUseCase            *usecases.GetTransferUseCase
+	listUseCase           *usecases.ListTransfersUseCase
+	reportProgressUseCase *usecases.ReportTransferProgressUseCase
+	completeUseCase       *usecases.CompleteTransferUseCase
+	cancelUseCase         *usecases.CancelTransferUseCase
+	requeueUseCase        *usecases.RequeueTransfersUseCase
+	discardUseCase        *usecases.DiscardTransfersUseCase
+	validator             *validator.Validate
 }
 
 func NewTransferHandler(
 	getPendingUseCase *usecases.GetPendingTransfersUseCase,
+	claimUseCase *usecases.ClaimTransfersUseCase,
+	getUseCase *usecases.GetTransferUseCase,
+	listUseCase *usecases.ListTransfersUseCase,
+	reportProgressUseCase *usecases.ReportTransferProgressUseCase,
 	completeUseCase *usecases.CompleteTransferUseCase,
 	cancelUseCase *usecases.CancelTransferUseCase,
+	requeueUseCase *usecases.RequeueTransfersUseCase,
+	discardUseCase *usecases.DiscardTransfersUseCase,
 ) *TransferHandler {
 	return &TransferHandler{
-		getPendingUseCase: getPendingUseCase,
-		completeUseCase:   completeUseCase,
-		cancelUseCase:     cancelUseCase,
-		validator:         validator.New(),
+		getPendingUseCase:     getPendingUseCase,
+		claimUseCase:          claimUseCase,
+		getUseCase:            getUseCase,
+		listUseCase:           listUseCase,
+		reportProgressUseCase: reportProgressUseCase,
+		completeUseCase:       completeUseCase,
+		cancelUseCase:         cancelUseCase,
+		requeueUseCase:        requeueUseCase,
+		discardUseCase:        discardUseCase,
+		validator:             validator.New(),
 	}
 }
 
 // GetPendingTransfers handles getting pending transfers for a device
 func (h *TransferHandler) GetPendingTransfers(c *gin.Context) {
-	_, exists := middleware.GetUserIDFromContext(c)
+	userID, exists := middleware.GetUserIDFromContext(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
@@ -45,7 +68,7 @@ func (h *TransferHandler) GetPendingTransfers(c *gin.Context) {
 		return
 	}
 
-	transfers, err := h.getPendingUseCase.Execute(c.Request.Context(), deviceID)
+	transfers, err := h.getPendingUseCase.Execute(c.Request.Context(), userID, deviceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -58,14 +81,197 @@ func (h *TransferHandler) GetPendingTransfers(c *gin.Context) {
 	})
 }
 
-// CompleteTransfer handles transfer completion confirmation
+// ClaimTransfers leases the transfers due for a device to the device, which carries them out
+// itself, limited to one kind by the kind query parameter when given. Only the device's own token
+// claims them.
+func (h *TransferHandler) ClaimTransfers(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	deviceID, isDevice := middleware.GetDeviceIDFromContext(c)
+	if !isDevice {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only a device claims its transfers"})
+		return
+	}
+
+	transfers, err := h.claimUseCase.Execute(c.Request.Context(), userID, deviceID, c.Query("kind"))
+	if errors.Is(err, usecases.ErrInvalidTransferKind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transfers claimed successfully",
+		"data":    dto.ToPendingTransferResponses(transfers),
+	})
+}
+
+// GetTransfer handles getting a transfer with its progress
+func (h *TransferHandler) GetTransfer(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	transfer, err := h.getUseCase.Execute(c.Request.Context(), userID, c.Param("id"))
+	if errors.Is(err, usecases.ErrTransferNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transfer retrieved successfully",
+		"data":    dto.ToTransferResponse(transfer),
+	})
+}
+
+// GetAllTransfers handles listing the user's transfers, filtered by the status and failure query
+// parameters when given. status=failed lists the transfers left to requeue or discard.
+func (h *TransferHandler) GetAllTransfers(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	transfers, err := h.listUseCase.Execute(c.Request.Context(), userID, c.Query("status"), c.Query("failure"))
+	if errors.Is(err, usecases.ErrInvalidTransferStatus) || errors.Is(err, usecases.ErrInvalidTransferFailure) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transfers retrieved successfully",
+		"data":    dto.ToTransferResponses(transfers),
+	})
+}
+
+// StreamTransferProgress handles following a transfer as server-sent events: a progress event
+// with the transfer every time it changes, until it is finished or the client goes away
+func (h *TransferHandler) StreamTransferProgress(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	transferID := c.Param("id")
+	transfer, err := h.getUseCase.Execute(ctx, userID, transferID)
+	if errors.Is(err, usecases.ErrTransferNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	ticker := time.NewTicker(progressStreamInterval)
+	defer ticker.Stop()
+
+	var sent time.Time
+	for {
+		if !transfer.UpdatedAt.Equal(sent) {
+			c.SSEvent("progress", dto.ToTransferResponse(transfer))
+			c.Writer.Flush()
+			sent = transfer.UpdatedAt
+		}
+		if transfer.Finished() {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		transfer, err = h.getUseCase.Execute(ctx, userID, transferID)
+		if err != nil {
+			return // Gone, or the stream cannot go on; the client can reconnect
+		}
+	}
+}
+
+// ReportTransferProgress handles a device reporting how far it has got writing a transfer
+func (h *TransferHandler) ReportTransferProgress(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	deviceID, isDevice := middleware.GetDeviceIDFromContext(c)
+	if !isDevice {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only devices report transfer progress"})
+		return
+	}
+
+	var req dto.ReportTransferProgressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.reportProgressUseCase.Execute(
+		c.Request.Context(), userID, deviceID, c.Param("id"), req.BytesTransferred, req.TotalBytes,
+	)
+	switch {
+	case errors.Is(err, usecases.ErrTransferNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usecases.ErrTransferNotLeased):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transfer progress recorded",
+	})
+}
+
+// CompleteTransfer handles transfer completion confirmation by the device the transfer is for.
+// Users cancel transfers instead.
 func (h *TransferHandler) CompleteTransfer(c *gin.Context) {
-	_, exists := middleware.GetUserIDFromContext(c)
+	userID, exists := middleware.GetUserIDFromContext(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
 	}
 
+	deviceID, isDevice := middleware.GetDeviceIDFromContext(c)
+	if !isDevice {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the device a transfer is for can complete it"})
+		return
+	}
+
 	var req dto.CompleteTransferRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -77,8 +283,15 @@ func (h *TransferHandler) CompleteTransfer(c *gin.Context) {
 		return
 	}
 
-	err := h.completeUseCase.Execute(c.Request.Context(), req.ToEntity())
-	if err != nil {
+	err := h.completeUseCase.Execute(c.Request.Context(), userID, deviceID, req.ToEntity())
+	switch {
+	case errors.Is(err, usecases.ErrTransferNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usecases.ErrTransferNotLeased):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -90,7 +303,7 @@ func (h *TransferHandler) CompleteTransfer(c *gin.Context) {
 
 // CancelTransfer handles transfer cancellation
 func (h *TransferHandler) CancelTransfer(c *gin.Context) {
-	_, exists := middleware.GetUserIDFromContext(c)
+	userID, exists := middleware.GetUserIDFromContext(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
 		return
@@ -102,8 +315,15 @@ func (h *TransferHandler) CancelTransfer(c *gin.Context) {
 		return
 	}
 
-	err := h.cancelUseCase.Execute(c.Request.Context(), transferID)
-	if err != nil {
+	err := h.cancelUseCase.Execute(c.Request.Context(), userID, transferID)
+	switch {
+	case errors.Is(err, usecases.ErrTransferNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, usecases.ErrTransferFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -112,3 +332,69 @@ func (h *TransferHandler) CancelTransfer(c *gin.Context) {
 		"message": "Transfer canceled successfully",
 	})
 }
+
+// RequeueTransfers handles putting failed transfers back in the queue
+func (h *TransferHandler) RequeueTransfers(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	req, ok := h.bindBulkRequest(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.requeueUseCase.Execute(c.Request.Context(), userID, req.TransferIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transfers requeued",
+		"data":    dto.ToBulkTransfersResponse(result),
+	})
+}
+
+// DiscardTransfers handles deleting failed transfers
+func (h *TransferHandler) DiscardTransfers(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	req, ok := h.bindBulkRequest(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.discardUseCase.Execute(c.Request.Context(), userID, req.TransferIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transfers discarded",
+		"data":    dto.ToBulkTransfersResponse(result),
+	})
+}
+
+// bindBulkRequest reads the transfer IDs of a bulk operation, answering the request if they are
+// missing
+func (h *TransferHandler) bindBulkRequest(c *gin.Context) (*dto.BulkTransfersRequest, bool) {
+	var req dto.BulkTransfersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &req, true
+}
@@ -8,10 +8,28 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupTransferRoutes(router *gin.RouterGroup, handler *handlers.TransferHandler) {
+func SetupTransferRoutes(router *gin.RouterGroup, handler *handlers.TransferHandler, tokens middleware.DeviceTokenValidator) {
 	transfers := router.Group(constants.TransferBaseRoute)
-	transfers.Use(middleware.AuthMiddleware()) // Require authentication for all transfer routes
-	transfers.GET(constants.GetPendingTransfersRoute, handler.GetPendingTransfers)
-	transfers.POST(constants.CompleteTransferRoute, handler.CompleteTransfer)
-	transfers.DELETE(constants.CancelTransferRoute, handler.CancelTransfer)
+	// Device agents fetch their own pending transfers with their device token
+	transfers.GET(
+		constants.GetPendingTransfersRoute,
+		middleware.DeviceAuthMiddleware(tokens), middleware.RequireOwnDevice("deviceId"), handler.GetPendingTransfers,
+	)
+	// claim the ones they write themselves, and report progress on and complete them
+	transfers.POST(
+		constants.ClaimTransfersRoute,
+		middleware.DeviceAuthMiddleware(tokens), middleware.RequireOwnDevice("deviceId"), handler.ClaimTransfers,
+	)
+	transfers.POST(constants.TransferProgressRoute, middleware.DeviceAuthMiddleware(tokens), handler.ReportTransferProgress)
+	transfers.POST(constants.CompleteTransferRoute, middleware.DeviceAuthMiddleware(tokens), handler.CompleteTransfer)
+
+	users := transfers.Group("")
+	users.Use(middleware.AuthMiddleware()) // Require authentication for all other transfer routes
+	users.GET(constants.GetAllTransfersRoute, handler.GetAllTransfers)
+	users.GET(constants.GetTransferRoute, handler.GetTransfer)
+	users.GET(constants.TransferProgressRoute, handler.StreamTransferProgress)
+	users.DELETE(constants.CancelTransferRoute, handler.CancelTransfer)
+	// Failed transfers are requeued or discarded in bulk
+	users.POST(constants.RequeueTransfersRoute, handler.RequeueTransfers)
+	users.POST(constants.DiscardTransfersRoute, handler.DiscardTransfers)
 }
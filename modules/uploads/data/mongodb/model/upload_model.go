@@ -0,0 +1,52 @@
+package model
+
+import (
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/uploads/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UploadModel struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"`
+	Length    int64              `bson:"length"`
+	Offset    int64              `bson:"offset"`
+	Metadata  map[string]string  `bson:"metadata,omitempty"`
+	Status    string             `bson:"status"`
+	FileID    primitive.ObjectID `bson:"file_id,omitempty"`
+	ErrorMsg  string             `bson:"error_msg,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+func (u *UploadModel) ToEntity() *entities.Upload {
+	return &entities.Upload{
+		ID:        u.ID,
+		UserID:    u.UserID,
+		Length:    u.Length,
+		Offset:    u.Offset,
+		Metadata:  u.Metadata,
+		Status:    entities.UploadStatus(u.Status),
+		FileID:    u.FileID,
+		ErrorMsg:  u.ErrorMsg,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+	}
+}
+
+func FromEntity(upload *entities.Upload) *UploadModel {
+	return &UploadModel{
+		ID:        upload.ID,
+		UserID:    upload.UserID,
+		Length:    upload.Length,
+		Offset:    upload.Offset,
+		Metadata:  upload.Metadata,
+		Status:    string(upload.Status),
+		FileID:    upload.FileID,
+		ErrorMsg:  upload.ErrorMsg,
+		CreatedAt: upload.CreatedAt,
+		UpdatedAt: upload.UpdatedAt,
+	}
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/uploads/data/mongodb/model"
+	"github.com/manab-pr/nebulo/modules/uploads/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type MongoUploadRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoUploadRepository(db *mongo.Database) *MongoUploadRepository {
+	return &MongoUploadRepository{
+		collection: db.Collection("uploads"),
+	}
+}
+
+func (r *MongoUploadRepository) Create(ctx context.Context, upload *entities.Upload) (*entities.Upload, error) {
+	uploadModel := model.FromEntity(upload)
+
+	result, err := r.collection.InsertOne(ctx, uploadModel)
+	if err != nil {
+		return nil, err
+	}
+
+	upload.ID = result.InsertedID.(primitive.ObjectID)
+	return upload, nil
+}
+
+func (r *MongoUploadRepository) GetByID(ctx context.Context, userID, uploadID primitive.ObjectID) (*entities.Upload, error) {
+	var uploadModel model.UploadModel
+
+	err := r.collection.FindOne(ctx, bson.M{"_id": uploadID, "user_id": userID}).Decode(&uploadModel)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return uploadModel.ToEntity(), nil
+}
+
+func (r *MongoUploadRepository) UpdateOffset(ctx context.Context, userID, uploadID primitive.ObjectID, from, to int64) (bool, error) {
+	filter := bson.M{
+		"_id":     uploadID,
+		"user_id": userID,
+		"offset":  from,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"offset":     to,
+			"updated_at": time.Now(),
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == 1, nil
+}
+
+func (r *MongoUploadRepository) Complete(ctx context.Context, userID, uploadID, fileID primitive.ObjectID) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":     string(entities.UploadStatusCompleted),
+			"file_id":    fileID,
+			"updated_at": time.Now(),
+		},
+		"$unset": bson.M{"error_msg": ""},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": uploadID, "user_id": userID}, update)
+	return err
+}
+
+func (r *MongoUploadRepository) SetError(ctx context.Context, userID, uploadID primitive.ObjectID, errorMsg string) error {
+	update := bson.M{
+		"$set": bson.M{
+			"error_msg":  errorMsg,
+			"updated_at": time.Now(),
+		},
+	}
+
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": uploadID, "user_id": userID}, update)
+	return err
+}
+
+func (r *MongoUploadRepository) Delete(ctx context.Context, userID, uploadID primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": uploadID, "user_id": userID})
+	return err
+}
+
+func (r *MongoUploadRepository) GetIdleSince(ctx context.Context, before time.Time, limit int64) ([]*entities.Upload, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}}).SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, bson.M{"updated_at": bson.M{"$lt": before}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var uploads []*entities.Upload
+	for cursor.Next(ctx) {
+		var uploadModel model.UploadModel
+		if err := cursor.Decode(&uploadModel); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, uploadModel.ToEntity())
+	}
+
+	return uploads, cursor.Err()
+}
+
+func (r *MongoUploadRepository) DeleteIdle(
+	ctx context.Context, userID, uploadID primitive.ObjectID, before time.Time,
+) (bool, error) {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": uploadID, "user_id": userID, "updated_at": bson.M{"$lt": before}})
+	if err != nil {
+		return false, err
+	}
+
+	return result.DeletedCount == 1, nil
+}
@@ -0,0 +1,82 @@
+package staging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stagingDirPerm  = 0750
+	stagingFilePerm = 0600
+	stagingSuffix   = ".upload"
+)
+
+// FilesystemStaging keeps in-progress uploads as files in a local directory
+type FilesystemStaging struct {
+	dir string
+}
+
+func NewFilesystemStaging(dir string) *FilesystemStaging {
+	return &FilesystemStaging{
+		dir: dir,
+	}
+}
+
+// path maps an upload ID to its staging file. Upload IDs are ObjectID hex strings generated
+// by the server, so they are safe to use as file names.
+func (s *FilesystemStaging) path(uploadID string) string {
+	return filepath.Join(s.dir, filepath.Base(uploadID)+stagingSuffix)
+}
+
+func (s *FilesystemStaging) Create(_ context.Context, uploadID string) error {
+	if err := os.MkdirAll(s.dir, stagingDirPerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(s.path(uploadID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, stagingFilePerm)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (s *FilesystemStaging) Append(_ context.Context, uploadID string, offset int64, content io.Reader) (int64, error) {
+	file, err := os.OpenFile(s.path(uploadID), os.O_WRONLY, stagingFilePerm)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// Drop anything a previous, unacknowledged write left past the recorded offset
+	if err = file.Truncate(offset); err != nil {
+		return 0, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	written, err := io.Copy(file, content)
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	return written, err
+}
+
+func (s *FilesystemStaging) Truncate(_ context.Context, uploadID string, size int64) error {
+	return os.Truncate(s.path(uploadID), size)
+}
+
+func (s *FilesystemStaging) Open(_ context.Context, uploadID string) (io.ReadCloser, error) {
+	return os.Open(s.path(uploadID))
+}
+
+func (s *FilesystemStaging) Remove(_ context.Context, uploadID string) error {
+	err := os.Remove(s.path(uploadID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,54 @@
+package entities
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Upload struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `bson:"user_id"` // Links upload to specific user
+	Length    int64              `bson:"length"`
+	Offset    int64              `bson:"offset"`
+	Metadata  map[string]string  `bson:"metadata,omitempty"`
+	Status    UploadStatus       `bson:"status"`
+	FileID    primitive.ObjectID `bson:"file_id,omitempty"` // File created once the upload completes
+	ErrorMsg  string             `bson:"error_msg,omitempty"`
+	CreatedAt time.Time          `bson:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at"`
+}
+
+type UploadStatus string
+
+const (
+	UploadStatusInProgress UploadStatus = "in_progress"
+	UploadStatusCompleted  UploadStatus = "completed"
+)
+
+// Metadata keys understood when an upload is handed off for storage
+const (
+	MetadataFilename        = "filename"
+	MetadataFiletype        = "filetype"
+	MetadataTargetDevice    = "target_device"
+	MetadataReplicas        = "replicas"
+	MetadataDataShards      = "data_shards"
+	MetadataParityShards    = "parity_shards"
+	MetadataPlacement       = "placement"
+	MetadataPlacementLabels = "placement_labels"
+)
+
+type CreateUploadRequest struct {
+	Length   int64
+	Metadata map[string]string
+}
+
+type AppendUploadRequest struct {
+	Offset   int64
+	Checksum *UploadChecksum // Optional checksum of this chunk
+}
+
+type UploadChecksum struct {
+	Algorithm string
+	Sum       []byte
+}
@@ -0,0 +1,16 @@
+package repository
+
+import (
+	"context"
+	"io"
+)
+
+// StagingRepository holds the bytes of uploads that are still in progress
+type StagingRepository interface {
+	Create(ctx context.Context, uploadID string) error
+	// Append writes content starting at offset, discarding anything previously staged beyond it
+	Append(ctx context.Context, uploadID string, offset int64, content io.Reader) (int64, error)
+	Truncate(ctx context.Context, uploadID string, size int64) error
+	Open(ctx context.Context, uploadID string) (io.ReadCloser, error)
+	Remove(ctx context.Context, uploadID string) error
+}
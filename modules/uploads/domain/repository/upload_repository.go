@@ -0,0 +1,24 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/uploads/domain/entities"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UploadRepository interface {
+	Create(ctx context.Context, upload *entities.Upload) (*entities.Upload, error)
+	GetByID(ctx context.Context, userID, uploadID primitive.ObjectID) (*entities.Upload, error)
+	// UpdateOffset moves the offset from one value to another, reporting false if it was no longer at from
+	UpdateOffset(ctx context.Context, userID, uploadID primitive.ObjectID, from, to int64) (bool, error)
+	Complete(ctx context.Context, userID, uploadID, fileID primitive.ObjectID) error
+	SetError(ctx context.Context, userID, uploadID primitive.ObjectID, errorMsg string) error
+	Delete(ctx context.Context, userID, uploadID primitive.ObjectID) error
+	// GetIdleSince returns up to limit uploads of every user that have not changed since before, for background jobs
+	GetIdleSince(ctx context.Context, before time.Time, limit int64) ([]*entities.Upload, error)
+	// DeleteIdle deletes the upload if it still has not changed since before, reporting whether it did
+	DeleteIdle(ctx context.Context, userID, uploadID primitive.ObjectID, before time.Time) (bool, error)
+}
@@ -0,0 +1,246 @@
+package usecases
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1" // #nosec G505 - SHA-1 is the one algorithm the tus checksum extension requires
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"time"
+
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	"github.com/manab-pr/nebulo/modules/uploads/domain/entities"
+	"github.com/manab-pr/nebulo/modules/uploads/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const defaultUploadName = "upload"
+
+// SupportedChecksumAlgorithms lists the chunk checksum algorithms accepted by AppendUploadUseCase
+var SupportedChecksumAlgorithms = []string{"sha1", "sha256"}
+
+// FileStorer places a finished upload on a device, as StoreFileUseCase does for direct uploads
+type FileStorer interface {
+	Execute(ctx context.Context, userID string, req fileEntities.StoreFileRequest, content io.Reader) (*fileEntities.File, error)
+}
+
+type AppendUploadUseCase struct {
+	uploadRepo repository.UploadRepository
+	staging    repository.StagingRepository
+	fileStorer FileStorer
+	locks      *UploadLocks
+}
+
+func NewAppendUploadUseCase(
+	uploadRepo repository.UploadRepository, staging repository.StagingRepository, fileStorer FileStorer, locks *UploadLocks,
+) *AppendUploadUseCase {
+	return &AppendUploadUseCase{
+		uploadRepo: uploadRepo,
+		staging:    staging,
+		fileStorer: fileStorer,
+		locks:      locks,
+	}
+}
+
+// Execute appends a chunk at req.Offset. Data received before an interrupted request is kept
+// unless the chunk carries a checksum. Once every byte has arrived the upload is handed off for
+// storage; if that fails, the staged data is kept and an empty append at the final offset retries it.
+func (uc *AppendUploadUseCase) Execute(
+	ctx context.Context, userID, uploadID string, req entities.AppendUploadRequest, content io.Reader,
+) (*entities.Upload, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	uploadObjectID, err := primitive.ObjectIDFromHex(uploadID)
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	if !uc.locks.TryLock(uploadObjectID) {
+		return nil, ErrUploadLocked
+	}
+	defer uc.locks.Unlock(uploadObjectID)
+
+	upload, err := uc.uploadRepo.GetByID(ctx, userObjectID, uploadObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload == nil {
+		return nil, ErrUploadNotFound
+	}
+
+	if req.Offset != upload.Offset {
+		return upload, ErrOffsetMismatch
+	}
+
+	if upload.Offset < upload.Length {
+		err = uc.appendChunk(ctx, upload, req.Checksum, content)
+		if err != nil {
+			return upload, err
+		}
+	}
+
+	if upload.Offset == upload.Length && upload.Status != entities.UploadStatusCompleted {
+		err = uc.handOff(ctx, upload)
+		if err != nil {
+			return upload, err
+		}
+	}
+
+	return upload, nil
+}
+
+func (uc *AppendUploadUseCase) appendChunk(
+	ctx context.Context, upload *entities.Upload, checksum *entities.UploadChecksum, content io.Reader,
+) error {
+	var hasher hash.Hash
+	if checksum != nil {
+		hasher = newChecksumHash(checksum.Algorithm)
+		if hasher == nil {
+			return ErrUnsupportedChecksum
+		}
+	}
+
+	// Read one byte past the remaining length to detect chunks that overrun the upload
+	remaining := upload.Length - upload.Offset
+	var reader io.Reader = io.LimitReader(content, remaining+1)
+	if hasher != nil {
+		reader = io.TeeReader(reader, hasher)
+	}
+
+	uploadID := upload.ID.Hex()
+	written, writeErr := uc.staging.Append(ctx, uploadID, upload.Offset, reader)
+
+	// Anything that cannot be kept is cut off again so the staged data matches the recorded offset
+	var rejectErr error
+	switch {
+	case written > remaining:
+		rejectErr = ErrUploadTooLarge
+	case hasher != nil && writeErr != nil:
+		rejectErr = writeErr
+	case hasher != nil && !bytes.Equal(hasher.Sum(nil), checksum.Sum):
+		rejectErr = ErrChecksumMismatch
+	}
+	if rejectErr != nil {
+		if truncateErr := uc.staging.Truncate(context.WithoutCancel(ctx), uploadID, upload.Offset); truncateErr != nil {
+			return truncateErr
+		}
+		return rejectErr
+	}
+
+	if written > 0 {
+		// Detach from the request so bytes that made it to disk are recorded even if the client went away
+		moved, err := uc.uploadRepo.UpdateOffset(
+			context.WithoutCancel(ctx), upload.UserID, upload.ID, upload.Offset, upload.Offset+written,
+		)
+		if err != nil {
+			return err
+		}
+		if !moved {
+			return ErrOffsetMismatch
+		}
+		upload.Offset += written
+		upload.UpdatedAt = time.Now()
+	}
+
+	return writeErr
+}
+
+func (uc *AppendUploadUseCase) handOff(ctx context.Context, upload *entities.Upload) error {
+	uploadID := upload.ID.Hex()
+
+	content, err := uc.staging.Open(ctx, uploadID)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	name := upload.Metadata[entities.MetadataFilename]
+	if name == "" {
+		name = fmt.Sprintf("%s-%s", defaultUploadName, uploadID)
+	}
+
+	req := fileEntities.StoreFileRequest{
+		Name:         name,
+		Size:         upload.Length,
+		MimeType:     upload.Metadata[entities.MetadataFiletype],
+		TargetDevice: upload.Metadata[entities.MetadataTargetDevice],
+	}
+	err = applyLayoutMetadata(upload.Metadata, &req)
+	if err != nil {
+		return err
+	}
+
+	file, err := uc.fileStorer.Execute(ctx, upload.UserID.Hex(), req, content)
+	if err != nil {
+		upload.ErrorMsg = err.Error()
+		if setErr := uc.uploadRepo.SetError(context.WithoutCancel(ctx), upload.UserID, upload.ID, upload.ErrorMsg); setErr != nil {
+			return setErr
+		}
+		return fmt.Errorf("%w: %v", ErrUploadHandoffFailed, err)
+	}
+
+	err = uc.uploadRepo.Complete(context.WithoutCancel(ctx), upload.UserID, upload.ID, file.ID)
+	if err != nil {
+		return err
+	}
+
+	upload.Status = entities.UploadStatusCompleted
+	upload.FileID = file.ID
+	upload.ErrorMsg = ""
+
+	// The staged copy is no longer needed once the file lives on a device
+	_ = uc.staging.Remove(context.WithoutCancel(ctx), uploadID)
+
+	return nil
+}
+
+func newChecksumHash(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha1":
+		return sha1.New() // #nosec G401 - required by the tus checksum extension
+	case "sha256":
+		return sha256.New()
+	default:
+		return nil
+	}
+}
+
+// applyLayoutMetadata copies the optional replica and shard counts and placement from upload metadata into req
+func applyLayoutMetadata(metadata map[string]string, req *fileEntities.StoreFileRequest) error {
+	labels, err := fileEntities.ParsePlacementLabels(metadata[entities.MetadataPlacementLabels])
+	if err != nil {
+		return err
+	}
+	req.Placement = metadata[entities.MetadataPlacement]
+	req.PlacementLabels = labels
+
+	counts := map[string]*int{
+		entities.MetadataReplicas:     &req.Replicas,
+		entities.MetadataDataShards:   &req.DataShards,
+		entities.MetadataParityShards: &req.ParityShards,
+	}
+
+	for key, count := range counts {
+		value, ok := metadata[key]
+		if !ok {
+			continue
+		}
+
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return fmt.Errorf("%s metadata must be a positive whole number", key)
+		}
+		*count = parsed
+	}
+
+	return nil
+}
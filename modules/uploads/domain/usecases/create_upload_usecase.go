@@ -0,0 +1,73 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	fileEntities "github.com/manab-pr/nebulo/modules/files/domain/entities"
+	"github.com/manab-pr/nebulo/modules/uploads/domain/entities"
+	"github.com/manab-pr/nebulo/modules/uploads/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CreateUploadUseCase struct {
+	uploadRepo  repository.UploadRepository
+	staging     repository.StagingRepository
+	maxFileSize int64
+}
+
+func NewCreateUploadUseCase(
+	uploadRepo repository.UploadRepository, staging repository.StagingRepository, maxFileSize int64,
+) *CreateUploadUseCase {
+	return &CreateUploadUseCase{
+		uploadRepo:  uploadRepo,
+		staging:     staging,
+		maxFileSize: maxFileSize,
+	}
+}
+
+func (uc *CreateUploadUseCase) Execute(ctx context.Context, userID string, req entities.CreateUploadRequest) (*entities.Upload, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	if req.Length < 1 {
+		return nil, errors.New("upload length must be at least 1 byte")
+	}
+
+	if uc.maxFileSize > 0 && req.Length > uc.maxFileSize {
+		return nil, ErrUploadTooLarge
+	}
+
+	// Catch bad replica or shard counts now rather than after the whole file has been uploaded
+	if err = applyLayoutMetadata(req.Metadata, &fileEntities.StoreFileRequest{}); err != nil {
+		return nil, err
+	}
+
+	upload := &entities.Upload{
+		ID:        primitive.NewObjectID(),
+		UserID:    userObjectID,
+		Length:    req.Length,
+		Offset:    0,
+		Metadata:  req.Metadata,
+		Status:    entities.UploadStatusInProgress,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	err = uc.staging.Create(ctx, upload.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	createdUpload, err := uc.uploadRepo.Create(ctx, upload)
+	if err != nil {
+		_ = uc.staging.Remove(ctx, upload.ID.Hex())
+		return nil, err
+	}
+
+	return createdUpload, nil
+}
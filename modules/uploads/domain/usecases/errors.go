@@ -0,0 +1,13 @@
+package usecases
+
+import "errors"
+
+var (
+	ErrUploadNotFound      = errors.New("upload not found or does not belong to you")
+	ErrUploadTooLarge      = errors.New("upload exceeds maximum allowed size")
+	ErrUploadLocked        = errors.New("upload is being written by another request")
+	ErrOffsetMismatch      = errors.New("upload offset does not match")
+	ErrUnsupportedChecksum = errors.New("unsupported checksum algorithm")
+	ErrChecksumMismatch    = errors.New("chunk does not match its checksum")
+	ErrUploadHandoffFailed = errors.New("upload completed but could not be stored")
+)
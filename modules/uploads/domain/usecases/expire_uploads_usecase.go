@@ -0,0 +1,100 @@
+package usecases
+
+import (
+	"context"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/uploads/domain/repository"
+
+	"go.uber.org/zap"
+)
+
+// expireBatch is how many idle uploads are looked up at a time
+const expireBatch = 100
+
+// ExpireUploadsUseCase discards uploads that have gone expiry without a chunk, along with the data
+// staged for them. Files that completed uploads created are kept. Uploads a request is writing to
+// are left for the next sweep.
+type ExpireUploadsUseCase struct {
+	uploadRepo repository.UploadRepository
+	staging    repository.StagingRepository
+	locks      *UploadLocks
+	expiry     time.Duration
+	logger     *zap.Logger
+}
+
+func NewExpireUploadsUseCase(
+	uploadRepo repository.UploadRepository,
+	staging repository.StagingRepository,
+	locks *UploadLocks,
+	expiry time.Duration,
+	logger *zap.Logger,
+) *ExpireUploadsUseCase {
+	return &ExpireUploadsUseCase{
+		uploadRepo: uploadRepo,
+		staging:    staging,
+		locks:      locks,
+		expiry:     expiry,
+		logger:     logger,
+	}
+}
+
+// Run expires idle uploads every interval until ctx is cancelled
+func (uc *ExpireUploadsUseCase) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := uc.Execute(ctx); err != nil && ctx.Err() == nil {
+			uc.logger.Warn("Upload expiry sweep failed", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Execute discards every upload that has not changed for longer than the expiry
+func (uc *ExpireUploadsUseCase) Execute(ctx context.Context) error {
+	before := time.Now().Add(-uc.expiry)
+
+	expired := 0
+	for {
+		uploads, err := uc.uploadRepo.GetIdleSince(ctx, before, expireBatch)
+		if err != nil {
+			return err
+		}
+
+		skipped := 0
+		for _, upload := range uploads {
+			if !uc.locks.TryLock(upload.ID) {
+				skipped++
+				continue
+			}
+
+			// The record goes first, so a chunk that arrived in the meantime keeps its upload
+			deleted, deleteErr := uc.uploadRepo.DeleteIdle(ctx, upload.UserID, upload.ID, before)
+			if deleteErr == nil && deleted {
+				deleteErr = uc.staging.Remove(ctx, upload.ID.Hex())
+				expired++
+			}
+			uc.locks.Unlock(upload.ID)
+			if deleteErr != nil {
+				return deleteErr
+			}
+		}
+
+		// Another batch only while this one was full of uploads that are gone now
+		if len(uploads) < expireBatch || skipped > 0 {
+			break
+		}
+	}
+
+	if expired > 0 {
+		uc.logger.Info("Expired idle uploads", zap.Int("count", expired))
+	}
+	return nil
+}
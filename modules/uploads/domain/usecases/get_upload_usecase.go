@@ -0,0 +1,44 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	"github.com/manab-pr/nebulo/modules/uploads/domain/entities"
+	"github.com/manab-pr/nebulo/modules/uploads/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type GetUploadUseCase struct {
+	uploadRepo repository.UploadRepository
+}
+
+func NewGetUploadUseCase(uploadRepo repository.UploadRepository) *GetUploadUseCase {
+	return &GetUploadUseCase{
+		uploadRepo: uploadRepo,
+	}
+}
+
+func (uc *GetUploadUseCase) Execute(ctx context.Context, userID, uploadID string) (*entities.Upload, error) {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, errors.New("invalid user ID")
+	}
+
+	uploadObjectID, err := primitive.ObjectIDFromHex(uploadID)
+	if err != nil {
+		return nil, ErrUploadNotFound
+	}
+
+	upload, err := uc.uploadRepo.GetByID(ctx, userObjectID, uploadObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if upload == nil {
+		return nil, ErrUploadNotFound
+	}
+
+	return upload, nil
+}
@@ -0,0 +1,61 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+
+	"github.com/manab-pr/nebulo/modules/uploads/domain/repository"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TerminateUploadUseCase struct {
+	uploadRepo repository.UploadRepository
+	staging    repository.StagingRepository
+	locks      *UploadLocks
+}
+
+func NewTerminateUploadUseCase(
+	uploadRepo repository.UploadRepository, staging repository.StagingRepository, locks *UploadLocks,
+) *TerminateUploadUseCase {
+	return &TerminateUploadUseCase{
+		uploadRepo: uploadRepo,
+		staging:    staging,
+		locks:      locks,
+	}
+}
+
+// Execute discards the upload and any data staged for it. The file created by a completed upload is
+// kept. An upload a chunk is being written to returns ErrUploadLocked.
+func (uc *TerminateUploadUseCase) Execute(ctx context.Context, userID, uploadID string) error {
+	userObjectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return errors.New("invalid user ID")
+	}
+
+	uploadObjectID, err := primitive.ObjectIDFromHex(uploadID)
+	if err != nil {
+		return ErrUploadNotFound
+	}
+
+	if !uc.locks.TryLock(uploadObjectID) {
+		return ErrUploadLocked
+	}
+	defer uc.locks.Unlock(uploadObjectID)
+
+	upload, err := uc.uploadRepo.GetByID(ctx, userObjectID, uploadObjectID)
+	if err != nil {
+		return err
+	}
+
+	if upload == nil {
+		return ErrUploadNotFound
+	}
+
+	err = uc.staging.Remove(ctx, uploadObjectID.Hex())
+	if err != nil {
+		return err
+	}
+
+	return uc.uploadRepo.Delete(ctx, userObjectID, uploadObjectID)
+}
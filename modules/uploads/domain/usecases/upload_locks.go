@@ -0,0 +1,39 @@
+package usecases
+
+import (
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// UploadLocks keeps requests that write to or discard the same upload from running at once. An
+// upload is only tracked while a request holds it, so uploads that are terminated, fail their
+// hand-off or are abandoned leave nothing behind.
+type UploadLocks struct {
+	mu   sync.Mutex
+	held map[primitive.ObjectID]struct{}
+}
+
+func NewUploadLocks() *UploadLocks {
+	return &UploadLocks{held: make(map[primitive.ObjectID]struct{})}
+}
+
+// TryLock takes the upload, reporting false if another request holds it
+func (l *UploadLocks) TryLock(uploadID primitive.ObjectID) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.held[uploadID]; ok {
+		return false
+	}
+	l.held[uploadID] = struct{}{}
+	return true
+}
+
+// Unlock gives the upload back
+func (l *UploadLocks) Unlock(uploadID primitive.ObjectID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.held, uploadID)
+}
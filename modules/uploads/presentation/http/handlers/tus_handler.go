@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manab-pr/nebulo/modules/auth/middleware"
+	"github.com/manab-pr/nebulo/modules/uploads/domain/entities"
+	"github.com/manab-pr/nebulo/modules/uploads/domain/usecases"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tus 1.0 protocol headers and values
+const (
+	tusVersion         = "1.0.0"
+	tusExtensions      = "creation,termination,checksum,expiration"
+	tusContentType     = "application/offset+octet-stream"
+	headerTusResumable = "Tus-Resumable"
+	headerTusVersion   = "Tus-Version"
+	headerTusExtension = "Tus-Extension"
+	headerTusMaxSize   = "Tus-Max-Size"
+	headerTusChecksums = "Tus-Checksum-Algorithm"
+	headerUploadLength = "Upload-Length"
+	headerUploadOffset = "Upload-Offset"
+	headerUploadMeta   = "Upload-Metadata"
+	headerUploadSum    = "Upload-Checksum"
+	headerUploadExpiry = "Upload-Expires"
+	headerFileID       = "Upload-File-Id" // Non-standard: the stored file once the upload completes
+
+	// StatusChecksumMismatch is the tus checksum extension's response to a chunk that fails verification
+	StatusChecksumMismatch = 460
+)
+
+type TusHandler struct {
+	createUseCase    *usecases.CreateUploadUseCase
+	getUseCase       *usecases.GetUploadUseCase
+	appendUseCase    *usecases.AppendUploadUseCase
+	terminateUseCase *usecases.TerminateUploadUseCase
+	maxFileSize      int64
+	expiry           time.Duration // How long an upload may go without a chunk before it is discarded
+}
+
+func NewTusHandler(
+	createUseCase *usecases.CreateUploadUseCase,
+	getUseCase *usecases.GetUploadUseCase,
+	appendUseCase *usecases.AppendUploadUseCase,
+	terminateUseCase *usecases.TerminateUploadUseCase,
+	maxFileSize int64,
+	expiry time.Duration,
+) *TusHandler {
+	return &TusHandler{
+		createUseCase:    createUseCase,
+		getUseCase:       getUseCase,
+		appendUseCase:    appendUseCase,
+		terminateUseCase: terminateUseCase,
+		maxFileSize:      maxFileSize,
+		expiry:           expiry,
+	}
+}
+
+// RequireTusResumable rejects requests that do not speak the supported tus version
+func RequireTusResumable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header(headerTusResumable, tusVersion)
+
+		if c.GetHeader(headerTusResumable) != tusVersion {
+			c.Header(headerTusVersion, tusVersion)
+			c.AbortWithStatusJSON(http.StatusPreconditionFailed, gin.H{"error": "Unsupported tus version"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Options advertises the server's tus capabilities
+func (h *TusHandler) Options(c *gin.Context) {
+	c.Header(headerTusResumable, tusVersion)
+	c.Header(headerTusVersion, tusVersion)
+	c.Header(headerTusExtension, tusExtensions)
+	c.Header(headerTusChecksums, strings.Join(usecases.SupportedChecksumAlgorithms, ","))
+	if h.maxFileSize > 0 {
+		c.Header(headerTusMaxSize, strconv.FormatInt(h.maxFileSize, 10))
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// CreateUpload handles the creation extension: it reserves an upload and returns its URL
+func (h *TusHandler) CreateUpload(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	length, err := strconv.ParseInt(c.GetHeader(headerUploadLength), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Valid Upload-Length header is required"})
+		return
+	}
+
+	metadata, err := parseMetadata(c.GetHeader(headerUploadMeta))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	upload, err := h.createUseCase.Execute(c.Request.Context(), userID, entities.CreateUploadRequest{
+		Length:   length,
+		Metadata: metadata,
+	})
+	if errors.Is(err, usecases.ErrUploadTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Location", path.Join(c.Request.URL.Path, upload.ID.Hex()))
+	h.writeExpiry(c, upload)
+	c.Status(http.StatusCreated)
+}
+
+// GetUploadOffset reports how much of an upload the server has received
+func (h *TusHandler) GetUploadOffset(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+
+	upload, err := h.getUseCase.Execute(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		// HEAD responses carry no body, so only the status is meaningful
+		c.Status(uploadErrorStatus(err))
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	h.writeUploadHeaders(c, upload)
+	c.Header(headerUploadLength, strconv.FormatInt(upload.Length, 10))
+	c.Status(http.StatusOK)
+}
+
+// AppendUpload handles PATCH requests that append a chunk at the given offset
+func (h *TusHandler) AppendUpload(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	if c.ContentType() != tusContentType {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + tusContentType})
+		return
+	}
+
+	offset, err := strconv.ParseInt(c.GetHeader(headerUploadOffset), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Valid Upload-Offset header is required"})
+		return
+	}
+
+	req := entities.AppendUploadRequest{Offset: offset}
+	if header := c.GetHeader(headerUploadSum); header != "" {
+		req.Checksum, err = parseChecksum(header)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	upload, err := h.appendUseCase.Execute(c.Request.Context(), userID, c.Param("id"), req, c.Request.Body)
+	if upload != nil {
+		h.writeUploadHeaders(c, upload)
+	}
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// TerminateUpload handles the termination extension
+func (h *TusHandler) TerminateUpload(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	err := h.terminateUseCase.Execute(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *TusHandler) writeUploadHeaders(c *gin.Context, upload *entities.Upload) {
+	c.Header(headerUploadOffset, strconv.FormatInt(upload.Offset, 10))
+	if upload.Status == entities.UploadStatusCompleted {
+		c.Header(headerFileID, upload.FileID.Hex())
+	}
+	h.writeExpiry(c, upload)
+}
+
+// writeExpiry tells the client when an unfinished upload is discarded unless another chunk arrives
+func (h *TusHandler) writeExpiry(c *gin.Context, upload *entities.Upload) {
+	if upload.Status != entities.UploadStatusCompleted {
+		c.Header(headerUploadExpiry, upload.UpdatedAt.Add(h.expiry).UTC().Format(http.TimeFormat))
+	}
+}
+
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, usecases.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, usecases.ErrOffsetMismatch):
+		return http.StatusConflict
+	case errors.Is(err, usecases.ErrUploadLocked):
+		return http.StatusLocked
+	case errors.Is(err, usecases.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, usecases.ErrUnsupportedChecksum):
+		return http.StatusBadRequest
+	case errors.Is(err, usecases.ErrChecksumMismatch):
+		return StatusChecksumMismatch
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// parseMetadata decodes an Upload-Metadata header: comma-separated keys, each optionally
+// followed by a space and a base64-encoded value
+func parseMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("invalid Upload-Metadata header")
+		}
+
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("invalid Upload-Metadata value for " + key)
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata, nil
+}
+
+// parseChecksum decodes an Upload-Checksum header of the form "<algorithm> <base64 digest>"
+func parseChecksum(header string) (*entities.UploadChecksum, error) {
+	algorithm, encoded, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, errors.New("invalid Upload-Checksum header")
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid Upload-Checksum header")
+	}
+
+	return &entities.UploadChecksum{Algorithm: algorithm, Sum: sum}, nil
+}
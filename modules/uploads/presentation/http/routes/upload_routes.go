@@ -0,0 +1,22 @@
+package routes
+
+import (
+	"github.com/manab-pr/nebulo/internal/constants"
+	"github.com/manab-pr/nebulo/modules/auth/middleware"
+	"github.com/manab-pr/nebulo/modules/uploads/presentation/http/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetupUploadRoutes(router *gin.RouterGroup, handler *handlers.TusHandler) {
+	uploads := router.Group(constants.UploadBaseRoute)
+	uploads.OPTIONS(constants.CreateUploadRoute, handler.Options) // Capability discovery needs no authentication
+
+	tus := uploads.Group("")
+	tus.Use(middleware.AuthMiddleware()) // Require authentication for all other upload routes
+	tus.Use(handlers.RequireTusResumable())
+	tus.POST(constants.CreateUploadRoute, handler.CreateUpload)
+	tus.HEAD(constants.UploadRoute, handler.GetUploadOffset)
+	tus.PATCH(constants.UploadRoute, handler.AppendUpload)
+	tus.DELETE(constants.UploadRoute, handler.TerminateUpload)
+}
@@ -9,38 +9,47 @@ import (
 )
 
 type UserModel struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty"`
-	PhoneNumber string             `bson:"phone_number"`
-	Name        string             `bson:"name"`
-	IsVerified  bool               `bson:"is_verified"`
-	OTP         string             `bson:"otp,omitempty"`
-	OTPExpiry   time.Time          `bson:"otp_expiry,omitempty"`
-	CreatedAt   time.Time          `bson:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at"`
+	ID                primitive.ObjectID `bson:"_id,omitempty"`
+	PhoneNumber       string             `bson:"phone_number"`
+	Name              string             `bson:"name"`
+	IsVerified        bool               `bson:"is_verified"`
+	OTP               string             `bson:"otp,omitempty"`
+	OTPExpiry         time.Time          `bson:"otp_expiry,omitempty"`
+	DefaultReplicas   int                `bson:"default_replicas,omitempty"`
+	PlacementStrategy string             `bson:"placement_strategy,omitempty"`
+	PlacementLabels   map[string]string  `bson:"placement_labels,omitempty"`
+	CreatedAt         time.Time          `bson:"created_at"`
+	UpdatedAt         time.Time          `bson:"updated_at"`
 }
 
 func (m *UserModel) ToEntity() *entities.User {
 	return &entities.User{
-		ID:          m.ID,
-		PhoneNumber: m.PhoneNumber,
-		Name:        m.Name,
-		IsVerified:  m.IsVerified,
-		OTP:         m.OTP,
-		OTPExpiry:   m.OTPExpiry,
-		CreatedAt:   m.CreatedAt,
-		UpdatedAt:   m.UpdatedAt,
+		ID:                m.ID,
+		PhoneNumber:       m.PhoneNumber,
+		Name:              m.Name,
+		IsVerified:        m.IsVerified,
+		OTP:               m.OTP,
+		OTPExpiry:         m.OTPExpiry,
+		DefaultReplicas:   m.DefaultReplicas,
+		PlacementStrategy: m.PlacementStrategy,
+		PlacementLabels:   m.PlacementLabels,
+		CreatedAt:         m.CreatedAt,
+		UpdatedAt:         m.UpdatedAt,
 	}
 }
 
 func FromEntity(user *entities.User) *UserModel {
 	return &UserModel{
-		ID:          user.ID,
-		PhoneNumber: user.PhoneNumber,
-		Name:        user.Name,
-		IsVerified:  user.IsVerified,
-		OTP:         user.OTP,
-		OTPExpiry:   user.OTPExpiry,
-		CreatedAt:   user.CreatedAt,
-		UpdatedAt:   user.UpdatedAt,
+		ID:                user.ID,
+		PhoneNumber:       user.PhoneNumber,
+		Name:              user.Name,
+		IsVerified:        user.IsVerified,
+		OTP:               user.OTP,
+		OTPExpiry:         user.OTPExpiry,
+		DefaultReplicas:   user.DefaultReplicas,
+		PlacementStrategy: user.PlacementStrategy,
+		PlacementLabels:   user.PlacementLabels,
+		CreatedAt:         user.CreatedAt,
+		UpdatedAt:         user.UpdatedAt,
 	}
 }
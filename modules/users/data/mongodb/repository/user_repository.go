@@ -102,3 +102,54 @@ func (r *userRepository) ClearOTP(ctx context.Context, phoneNumber string) error
 	)
 	return err
 }
+
+func (r *userRepository) UpdateDefaultReplicas(ctx context.Context, userID string, replicas int) error {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.UpdateOne(
+		ctx,
+		bson.M{"_id": objectID},
+		bson.M{
+			"$set": bson.M{
+				"default_replicas": replicas,
+				"updated_at":       time.Now(),
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *userRepository) UpdatePlacement(ctx context.Context, userID, strategy string, labels map[string]string) error {
+	objectID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+
+	set := bson.M{"updated_at": time.Now()}
+	if strategy != "" {
+		set["placement_strategy"] = strategy
+	}
+	if labels != nil {
+		set["placement_labels"] = labels
+	}
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
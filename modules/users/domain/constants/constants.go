@@ -5,4 +5,13 @@ const (
 	OTPMinValue          = 100000
 	OTPMaxValue          = 999999
 	IndexTimeoutSeconds  = 10
+
+	// Replication defaults for files stored by the user
+	DefaultReplicas = 1
+	MaxReplicas     = 10
 )
+
+// PlacementStrategies are the placement strategies a user can choose for their uploads
+var PlacementStrategies = []string{
+	"most_free_space", "weighted_round_robin", "least_recently_used", "fill_first", "label_constrained",
+}
@@ -3,18 +3,32 @@ package entities
 import (
 	"time"
 
+	"github.com/manab-pr/nebulo/modules/users/domain/constants"
+
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type User struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty"`
-	PhoneNumber string             `bson:"phone_number"`
-	Name        string             `bson:"name"`
-	IsVerified  bool               `bson:"is_verified"`
-	OTP         string             `bson:"otp,omitempty"`
-	OTPExpiry   time.Time          `bson:"otp_expiry,omitempty"`
-	CreatedAt   time.Time          `bson:"created_at"`
-	UpdatedAt   time.Time          `bson:"updated_at"`
+	ID              primitive.ObjectID `bson:"_id,omitempty"`
+	PhoneNumber     string             `bson:"phone_number"`
+	Name            string             `bson:"name"`
+	IsVerified      bool               `bson:"is_verified"`
+	OTP             string             `bson:"otp,omitempty"`
+	OTPExpiry       time.Time          `bson:"otp_expiry,omitempty"`
+	DefaultReplicas int                `bson:"default_replicas,omitempty"` // Copies per file unless an upload overrides it
+	// Placement strategy and labels for the user's uploads that do not name their own; empty uses the server's
+	PlacementStrategy string            `bson:"placement_strategy,omitempty"`
+	PlacementLabels   map[string]string `bson:"placement_labels,omitempty"`
+	CreatedAt         time.Time         `bson:"created_at"`
+	UpdatedAt         time.Time         `bson:"updated_at"`
+}
+
+// ReplicationFactor returns the user's default number of copies per file
+func (u *User) ReplicationFactor() int {
+	if u.DefaultReplicas < 1 {
+		return constants.DefaultReplicas
+	}
+	return u.DefaultReplicas
 }
 
 type RegisterRequest struct {
@@ -39,10 +53,20 @@ type AuthResponse struct {
 	ExpiresAt   int64  `json:"expires_at"`
 }
 
+// UpdateSettingsRequest changes the settings it has a value for and leaves the others as they are
+type UpdateSettingsRequest struct {
+	DefaultReplicas   int               `json:"default_replicas,omitempty" validate:"omitempty,min=1,max=10"`
+	PlacementStrategy string            `json:"placement_strategy,omitempty"`
+	PlacementLabels   map[string]string `json:"placement_labels,omitempty" validate:"max=32"`
+}
+
 type UserProfile struct {
-	ID          string    `json:"id"`
-	PhoneNumber string    `json:"phone_number"`
-	Name        string    `json:"name"`
-	IsVerified  bool      `json:"is_verified"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID                string            `json:"id"`
+	PhoneNumber       string            `json:"phone_number"`
+	Name              string            `json:"name"`
+	IsVerified        bool              `json:"is_verified"`
+	DefaultReplicas   int               `json:"default_replicas"`
+	PlacementStrategy string            `json:"placement_strategy,omitempty"`
+	PlacementLabels   map[string]string `json:"placement_labels,omitempty"`
+	CreatedAt         time.Time         `json:"created_at"`
 }
@@ -14,4 +14,8 @@ type UserRepository interface {
 	UpdateUser(ctx context.Context, user *entities.User) error
 	UpdateOTP(ctx context.Context, phoneNumber, otp string, expiry time.Time) error
 	ClearOTP(ctx context.Context, phoneNumber string) error
+	UpdateDefaultReplicas(ctx context.Context, userID string, replicas int) error
+	// UpdatePlacement sets the user's placement strategy, unless it is empty, and placement labels,
+	// unless they are nil
+	UpdatePlacement(ctx context.Context, userID, strategy string, labels map[string]string) error
 }
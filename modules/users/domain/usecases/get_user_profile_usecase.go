@@ -24,10 +24,13 @@ func (uc *GetUserProfileUseCase) Execute(ctx context.Context, userID string) (*e
 	}
 
 	return &entities.UserProfile{
-		ID:          user.ID.Hex(),
-		PhoneNumber: user.PhoneNumber,
-		Name:        user.Name,
-		IsVerified:  user.IsVerified,
-		CreatedAt:   user.CreatedAt,
+		ID:                user.ID.Hex(),
+		PhoneNumber:       user.PhoneNumber,
+		Name:              user.Name,
+		IsVerified:        user.IsVerified,
+		DefaultReplicas:   user.ReplicationFactor(),
+		PlacementStrategy: user.PlacementStrategy,
+		PlacementLabels:   user.PlacementLabels,
+		CreatedAt:         user.CreatedAt,
 	}, nil
 }
@@ -0,0 +1,48 @@
+package usecases
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/manab-pr/nebulo/modules/users/domain/constants"
+	"github.com/manab-pr/nebulo/modules/users/domain/entities"
+	"github.com/manab-pr/nebulo/modules/users/domain/repository"
+)
+
+// ErrUnknownPlacementStrategy is returned for placement strategies the server does not have
+var ErrUnknownPlacementStrategy = errors.New("unknown placement strategy")
+
+type UpdateUserSettingsUseCase struct {
+	userRepo repository.UserRepository
+}
+
+func NewUpdateUserSettingsUseCase(userRepo repository.UserRepository) *UpdateUserSettingsUseCase {
+	return &UpdateUserSettingsUseCase{
+		userRepo: userRepo,
+	}
+}
+
+func (uc *UpdateUserSettingsUseCase) Execute(
+	ctx context.Context, userID string, req *entities.UpdateSettingsRequest,
+) (*entities.UserProfile, error) {
+	if req.PlacementStrategy != "" && !slices.Contains(constants.PlacementStrategies, req.PlacementStrategy) {
+		return nil, ErrUnknownPlacementStrategy
+	}
+
+	if req.DefaultReplicas > 0 {
+		err := uc.userRepo.UpdateDefaultReplicas(ctx, userID, req.DefaultReplicas)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req.PlacementStrategy != "" || req.PlacementLabels != nil {
+		err := uc.userRepo.UpdatePlacement(ctx, userID, req.PlacementStrategy, req.PlacementLabels)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return NewGetUserProfileUseCase(uc.userRepo).Execute(ctx, userID)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -17,6 +18,7 @@ type UserHandler struct {
 	loginUseCase          *usecases.LoginUserUseCase
 	verifyOTPUseCase      *usecases.VerifyOTPUseCase
 	getUserProfileUseCase *usecases.GetUserProfileUseCase
+	updateSettingsUseCase *usecases.UpdateUserSettingsUseCase
 	validator             *validator.Validate
 }
 
@@ -25,12 +27,14 @@ func NewUserHandler(
 	loginUseCase *usecases.LoginUserUseCase,
 	verifyOTPUseCase *usecases.VerifyOTPUseCase,
 	getUserProfileUseCase *usecases.GetUserProfileUseCase,
+	updateSettingsUseCase *usecases.UpdateUserSettingsUseCase,
 ) *UserHandler {
 	return &UserHandler{
 		registerUseCase:       registerUseCase,
 		loginUseCase:          loginUseCase,
 		verifyOTPUseCase:      verifyOTPUseCase,
 		getUserProfileUseCase: getUserProfileUseCase,
+		updateSettingsUseCase: updateSettingsUseCase,
 		validator:             validator.New(),
 	}
 }
@@ -152,3 +156,48 @@ func (h *UserHandler) GetProfile(c *gin.Context) {
 
 	c.JSON(http.StatusOK, profile)
 }
+
+func (h *UserHandler) UpdateSettings(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, dto.ErrorResponse{
+			Error: "User not authenticated",
+		})
+		return
+	}
+
+	var req entities.UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "Invalid request body",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := h.validator.Struct(&req); err != nil {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "Validation failed",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	profile, err := h.updateSettingsUseCase.Execute(c.Request.Context(), userID, &req)
+	if errors.Is(err, usecases.ErrUnknownPlacementStrategy) {
+		c.JSON(http.StatusBadRequest, dto.ErrorResponse{
+			Error:   "Validation failed",
+			Message: err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, dto.ErrorResponse{
+			Error:   "Failed to update settings",
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
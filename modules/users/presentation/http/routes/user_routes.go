@@ -17,4 +17,5 @@ func SetupUserRoutes(router *gin.RouterGroup, userHandler *handlers.UserHandler)
 	userGroup := router.Group("/users")
 	userGroup.Use(middleware.AuthMiddleware())
 	userGroup.GET(constants.ProfileRoute, userHandler.GetProfile)
+	userGroup.PUT(constants.SettingsRoute, userHandler.UpdateSettings)
 }